@@ -1,15 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -17,12 +20,16 @@ import (
 	watchman "github.com/renderedtext/go-watchman"
 	api "github.com/semaphoreci/agent/pkg/api"
 	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/debugsession"
 	"github.com/semaphoreci/agent/pkg/eventlogger"
 	jobs "github.com/semaphoreci/agent/pkg/jobs"
 	"github.com/semaphoreci/agent/pkg/kubernetes"
 	listener "github.com/semaphoreci/agent/pkg/listener"
 	server "github.com/semaphoreci/agent/pkg/server"
+	shell "github.com/semaphoreci/agent/pkg/shell"
 	slices "github.com/semaphoreci/agent/pkg/slices"
+	"github.com/semaphoreci/agent/pkg/tracing"
+	"github.com/semaphoreci/agent/pkg/validation"
 	log "github.com/sirupsen/logrus"
 	pflag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -65,6 +72,12 @@ func main() {
 		RunServer(httpClient, logfile)
 	case "run":
 		RunSingleJob(httpClient)
+	case "validate":
+		RunValidate()
+	case "logs":
+		RunLogs()
+	case "attach":
+		RunAttach()
 	case "version":
 		fmt.Println(VERSION)
 	}
@@ -140,6 +153,28 @@ func RunListener(httpClient *http.Client, logfile io.Writer) {
 		fmt.Sprintf("Timeout for the pod to be ready, in seconds. Default is %d.", config.DefaultKubernetesPodStartTimeout),
 	)
 	_ = pflag.String(config.KubernetesDefaultImage, "", "Default image to use in Kubernetes executor if no containers are specified in the job request")
+	_ = pflag.StringSlice(config.TestReportPaths, []string{}, "Glob patterns for JUnit XML reports to summarize after the job commands finish")
+	_ = pflag.Bool(config.UploadTestReports, false, "Upload the test reports found as job artifacts")
+	_ = pflag.StringSlice(config.WebhookURLs, []string{}, "URLs to send job lifecycle webhooks to")
+	_ = pflag.String(config.WebhookSecret, "", "Secret used to sign the webhook payloads with HMAC-SHA256")
+	_ = pflag.Bool(config.WebhookCommandEvents, false, "Also send a webhook when each command finishes")
+	_ = pflag.Bool(config.MaskSecrets, false, "Mask the values of the job environment variables and injected files in the job output")
+	_ = pflag.Int(config.ResourceSamplingInterval, 0, "Interval, in seconds, to sample the resource usage of the job processes. Disabled by default.")
+	_ = pflag.Bool(config.CompressLogs, false, "Send the job logs to the API gzip-compressed. If the API does not accept it, the logs are sent uncompressed.")
+	_ = pflag.Int(config.StopGracePeriod, config.DefaultStopGracePeriod, "The grace period, in seconds, for the job processes to exit after SIGTERM when a job is stopped, before they are killed. Zero kills them right away.")
+	_ = pflag.String(config.TracingEndpoint, "", "OTLP/HTTP endpoint to send traces of the jobs to, e.g. http://localhost:4318. The trace context is exported to the job commands as TRACEPARENT. Disabled by default.")
+	_ = pflag.StringSlice(config.LogSinks, []string{}, "Extra destinations for the job logs, besides the one in the job request: file:///<dir>, http(s)://<url> or s3+http(s)://<host>/<bucket>/<prefix>. A sink that fails does not fail the job.")
+	_ = pflag.Bool(config.SeparateOutputStreams, false, "Run the job commands with pipes instead of a PTY, so the output events tell stdout and stderr apart. Environment variables and the working directory carry over between commands, but shell functions and options don't. Only supported on Linux, with the shell executor.")
+	_ = pflag.String(config.Shell, "", "The shell used for the job commands: bash, zsh, sh or fish, or a path to one of them. Jobs can also choose it, in their request. Defaults to bash. Not supported on Windows.")
+	_ = pflag.Int64(config.MaxCommandOutputBytes, 0, "The maximum number of bytes of output logged for each job command. The rest is dropped, with a notice. Disabled by default.")
+	_ = pflag.Int64(config.MaxJobOutputBytes, 0, "The maximum number of bytes of output logged for all the job commands. The rest is dropped, with a notice. Disabled by default.")
+	_ = pflag.Int64(config.OutputBytesPerSecond, 0, "The maximum number of bytes of command output logged per second. The output over it is dropped, with a notice of how much was dropped. Disabled by default.")
+	_ = pflag.Int64(config.OutputLinesPerSecond, 0, "The maximum number of lines of command output logged per second. The output over it is dropped, with a notice of how much was dropped. Disabled by default.")
+	_ = pflag.Int(config.DebugOnFailureTimeout, 0, "Keep the job paused for this long, in seconds, after a command fails, so 'agent attach <job-id>' can open a shell in the job environment. Jobs can also ask for it with the SEMAPHORE_DEBUG_ON_FAILURE_TIMEOUT environment variable. Disabled by default. Not supported on Windows.")
+	_ = pflag.Int(config.TerminalColumns, 0, "The width, in columns, of the terminal the job commands run in. Jobs can also set it with the SEMAPHORE_TERMINAL_COLUMNS environment variable. Zero keeps the default size. Not supported on Windows.")
+	_ = pflag.Int(config.TerminalRows, 0, "The height, in rows, of the terminal the job commands run in. Jobs can also set it with the SEMAPHORE_TERMINAL_ROWS environment variable. Zero keeps the default size. Not supported on Windows.")
+	_ = pflag.String(config.TerminalType, "", "The TERM of the terminal the job commands run in, e.g. xterm-256color. Jobs can also set TERM in their environment. Empty keeps the default. Not supported on Windows.")
+	_ = pflag.Bool(config.NoColor, false, "Export NO_COLOR=1, and the variables other tools use to disable colors, to the job commands. Jobs can also turn it on or off with the SEMAPHORE_NO_COLOR environment variable.")
 
 	pflag.Parse()
 
@@ -230,6 +265,23 @@ func RunListener(httpClient *http.Client, logfile io.Writer) {
 		KubernetesPodStartTimeoutSeconds: viper.GetInt(config.KubernetesPodStartTimeout),
 		KubernetesLabels:                 kubernetesLabels,
 		KubernetesDefaultImage:           viper.GetString(config.KubernetesDefaultImage),
+		TestReportPaths:                  viper.GetStringSlice(config.TestReportPaths),
+		UploadTestReports:                viper.GetBool(config.UploadTestReports),
+		WebhookURLs:                      viper.GetStringSlice(config.WebhookURLs),
+		WebhookSecret:                    viper.GetString(config.WebhookSecret),
+		WebhookCommandEvents:             viper.GetBool(config.WebhookCommandEvents),
+		MaskSecrets:                      viper.GetBool(config.MaskSecrets),
+		ResourceSamplingInterval:         viper.GetInt(config.ResourceSamplingInterval),
+		StopGracePeriod:                  viper.GetInt(config.StopGracePeriod),
+		CompressLogs:                     viper.GetBool(config.CompressLogs),
+		LogSinks:                         viper.GetStringSlice(config.LogSinks),
+		TracingEndpoint:                  viper.GetString(config.TracingEndpoint),
+		Shell:                            viper.GetString(config.Shell),
+		SeparateOutputStreams:            viper.GetBool(config.SeparateOutputStreams),
+		OutputLimits:                     outputLimitsFromConfig(),
+		DebugOnFailureTimeout:            viper.GetInt(config.DebugOnFailureTimeout),
+		Terminal:                         terminalFromConfig(),
+		NoColor:                          viper.GetBool(config.NoColor),
 	}
 
 	go func() {
@@ -282,6 +334,88 @@ func validateConfiguration() {
 			config.ValidUploadJobLogsCondition,
 		)
 	}
+
+	validateLogSinks(viper.GetStringSlice(config.LogSinks))
+	validateShell(viper.GetString(config.Shell))
+	validateSeparateOutputStreams(viper.GetBool(config.SeparateOutputStreams))
+	validateOutputLimits(outputLimitsFromConfig())
+	validateDebugOnFailureTimeout(viper.GetInt(config.DebugOnFailureTimeout))
+	validateTerminal(
+		viper.GetInt(config.TerminalColumns),
+		viper.GetInt(config.TerminalRows),
+		viper.GetString(config.TerminalType),
+	)
+}
+
+func terminalFromConfig() shell.Terminal {
+	return shell.Terminal{
+		Columns: uint16(viper.GetInt(config.TerminalColumns)),
+		Rows:    uint16(viper.GetInt(config.TerminalRows)),
+		Type:    viper.GetString(config.TerminalType),
+	}
+}
+
+func validateTerminal(columns, rows int, terminalType string) {
+	if columns < 0 || columns > math.MaxUint16 || rows < 0 || rows > math.MaxUint16 {
+		log.Fatalf("--%s and --%s must be between 0 and %d", config.TerminalColumns, config.TerminalRows, math.MaxUint16)
+	}
+
+	if (columns > 0 || rows > 0 || terminalType != "") && runtime.GOOS == "windows" {
+		log.Fatalf("--%s, --%s and --%s are not supported on Windows", config.TerminalColumns, config.TerminalRows, config.TerminalType)
+	}
+}
+
+func validateDebugOnFailureTimeout(seconds int) {
+	if seconds < 0 {
+		log.Fatalf("--%s can't be negative", config.DebugOnFailureTimeout)
+	}
+
+	if seconds > 0 && runtime.GOOS == "windows" {
+		log.Fatalf("--%s is not supported on Windows", config.DebugOnFailureTimeout)
+	}
+}
+
+func outputLimitsFromConfig() eventlogger.OutputLimits {
+	return eventlogger.OutputLimits{
+		CommandBytes:   viper.GetInt64(config.MaxCommandOutputBytes),
+		JobBytes:       viper.GetInt64(config.MaxJobOutputBytes),
+		BytesPerSecond: viper.GetInt64(config.OutputBytesPerSecond),
+		LinesPerSecond: viper.GetInt64(config.OutputLinesPerSecond),
+	}
+}
+
+func validateOutputLimits(limits eventlogger.OutputLimits) {
+	if err := limits.Validate(); err != nil {
+		log.Fatalf("Error parsing the output limits: %v", err)
+	}
+}
+
+func validateLogSinks(sinks []string) {
+	for _, sink := range sinks {
+		if _, err := eventlogger.ParseSink(sink); err != nil {
+			log.Fatalf("Error parsing --%s: %v", config.LogSinks, err)
+		}
+	}
+}
+
+func validateSeparateOutputStreams(enabled bool) {
+	if enabled && runtime.GOOS != "linux" {
+		log.Fatalf("--%s is only supported on Linux", config.SeparateOutputStreams)
+	}
+}
+
+func validateShell(executable string) {
+	if executable == "" {
+		return
+	}
+
+	if runtime.GOOS == "windows" {
+		log.Fatalf("--%s is not supported on Windows - PowerShell is always used", config.Shell)
+	}
+
+	if _, err := shell.FindDialect(executable); err != nil {
+		log.Fatalf("Error parsing --%s: %v", config.Shell, err)
+	}
 }
 
 func getAgentName() string {
@@ -378,6 +512,22 @@ func RunServer(httpClient *http.Client, logfile io.Writer) {
 	preJobHookPath := pflag.String(config.PreJobHookPath, "", "The path to a pre-job hook script")
 	files := pflag.StringSlice(config.Files, []string{}, "Inject files into container, when using docker compose executor")
 	exposeKvmDevice := pflag.Bool(config.ExposeKvmDevice, true, "Expose /dev/kvm device, when using docker compose executor")
+	maskSecrets := pflag.Bool(config.MaskSecrets, false, "Mask the values of the job environment variables and injected files in the job output")
+	resourceSamplingInterval := pflag.Int(config.ResourceSamplingInterval, 0, "Interval, in seconds, to sample the resource usage of the job processes. Disabled by default.")
+	stopGracePeriod := pflag.Int(config.StopGracePeriod, config.DefaultStopGracePeriod, "The grace period, in seconds, for the job processes to exit after SIGTERM when a job is stopped, before they are killed. Zero kills them right away.")
+	tracingEndpoint := pflag.String(config.TracingEndpoint, "", "OTLP/HTTP endpoint to send traces of the jobs to, e.g. http://localhost:4318. The trace context is exported to the job commands as TRACEPARENT. Disabled by default.")
+	logSinks := pflag.StringSlice(config.LogSinks, []string{}, "Extra destinations for the job logs, besides the one in the job request: file:///<dir>, http(s)://<url> or s3+http(s)://<host>/<bucket>/<prefix>. A sink that fails does not fail the job.")
+	separateOutputStreams := pflag.Bool(config.SeparateOutputStreams, false, "Run the job commands with pipes instead of a PTY, so the output events tell stdout and stderr apart. Environment variables and the working directory carry over between commands, but shell functions and options don't. Only supported on Linux, with the shell executor.")
+	jobShell := pflag.String(config.Shell, "", "The shell used for the job commands: bash, zsh, sh or fish, or a path to one of them. Jobs can also choose it, in their request. Defaults to bash. Not supported on Windows.")
+	maxCommandOutputBytes := pflag.Int64(config.MaxCommandOutputBytes, 0, "The maximum number of bytes of output logged for each job command. The rest is dropped, with a notice. Disabled by default.")
+	maxJobOutputBytes := pflag.Int64(config.MaxJobOutputBytes, 0, "The maximum number of bytes of output logged for all the job commands. The rest is dropped, with a notice. Disabled by default.")
+	outputBytesPerSecond := pflag.Int64(config.OutputBytesPerSecond, 0, "The maximum number of bytes of command output logged per second. The output over it is dropped, with a notice of how much was dropped. Disabled by default.")
+	outputLinesPerSecond := pflag.Int64(config.OutputLinesPerSecond, 0, "The maximum number of lines of command output logged per second. The output over it is dropped, with a notice of how much was dropped. Disabled by default.")
+	debugOnFailureTimeout := pflag.Int(config.DebugOnFailureTimeout, 0, "Keep the job paused for this long, in seconds, after a command fails, so 'agent attach <job-id>' can open a shell in the job environment. Jobs can also ask for it with the SEMAPHORE_DEBUG_ON_FAILURE_TIMEOUT environment variable. Disabled by default. Not supported on Windows.")
+	terminalColumns := pflag.Int(config.TerminalColumns, 0, "The width, in columns, of the terminal the job commands run in. Jobs can also set it with the SEMAPHORE_TERMINAL_COLUMNS environment variable. Zero keeps the default size. Not supported on Windows.")
+	terminalRows := pflag.Int(config.TerminalRows, 0, "The height, in rows, of the terminal the job commands run in. Jobs can also set it with the SEMAPHORE_TERMINAL_ROWS environment variable. Zero keeps the default size. Not supported on Windows.")
+	terminalType := pflag.String(config.TerminalType, "", "The TERM of the terminal the job commands run in, e.g. xterm-256color. Jobs can also set TERM in their environment. Empty keeps the default. Not supported on Windows.")
+	noColor := pflag.Bool(config.NoColor, false, "Export NO_COLOR=1, and the variables other tools use to disable colors, to the job commands. Jobs can also turn it on or off with the SEMAPHORE_NO_COLOR environment variable.")
 
 	pflag.Parse()
 
@@ -398,6 +548,30 @@ func RunServer(httpClient *http.Client, logfile io.Writer) {
 		log.Fatalf("Error parsing --files: %v", err)
 	}
 
+	validateLogSinks(*logSinks)
+	validateShell(*jobShell)
+	validateSeparateOutputStreams(*separateOutputStreams)
+
+	outputLimits := eventlogger.OutputLimits{
+		CommandBytes:   *maxCommandOutputBytes,
+		JobBytes:       *maxJobOutputBytes,
+		BytesPerSecond: *outputBytesPerSecond,
+		LinesPerSecond: *outputLinesPerSecond,
+	}
+
+	validateOutputLimits(outputLimits)
+	validateDebugOnFailureTimeout(*debugOnFailureTimeout)
+	validateTerminal(*terminalColumns, *terminalRows, *terminalType)
+
+	var tracer *tracing.Tracer
+	if *tracingEndpoint != "" {
+		tracer = tracing.NewTracer(tracing.Config{
+			Endpoint:   *tracingEndpoint,
+			UserAgent:  HTTPUserAgent,
+			Attributes: map[string]string{"service.version": VERSION},
+		})
+	}
+
 	log.Infof("Callback retry attempts: %d", *callbackRetryAttempts)
 
 	server.NewServer(server.ServerConfig{
@@ -414,21 +588,50 @@ func RunServer(httpClient *http.Client, logfile io.Writer) {
 		FileInjections:        fileInjections,
 		CallbackRetryAttempts: *callbackRetryAttempts,
 		ExposeKvmDevice:       *exposeKvmDevice,
+		MaskSecrets:           *maskSecrets,
+
+		ResourceSamplingInterval: time.Duration(*resourceSamplingInterval) * time.Second,
+		StopGracePeriod:          time.Duration(*stopGracePeriod) * time.Second,
+		LogSinks:                 *logSinks,
+		Tracer:                   tracer,
+		Shell:                    *jobShell,
+		SeparateOutputStreams:    *separateOutputStreams,
+		OutputLimits:             outputLimits,
+		DebugOnFailureTimeout:    time.Duration(*debugOnFailureTimeout) * time.Second,
+		NoColor:                  *noColor,
+		Terminal: shell.Terminal{
+			Columns: uint16(*terminalColumns),
+			Rows:    uint16(*terminalRows),
+			Type:    *terminalType,
+		},
 	}).Serve()
 }
 
 func RunSingleJob(httpClient *http.Client) {
-	request, err := api.NewRequestFromYamlFile(os.Args[2])
+	resume := pflag.Bool("resume", false, "Record a checkpoint after each successful command, and resume from the first failed command on the next run")
+	pflag.Parse()
+
+	jobFile := pflag.Arg(1)
+	request, err := api.NewRequestFromYamlFile(jobFile)
 
 	if err != nil {
 		panic(err)
 	}
 
+	checkpointDir := ""
+	if *resume {
+		checkpointDir, err = jobs.CheckpointDirFor(jobFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	job, err := jobs.NewJobWithOptions(&jobs.JobOptions{
 		Request:         request,
 		Client:          httpClient,
 		ExposeKvmDevice: true,
 		FileInjections:  []config.FileInjection{},
+		CheckpointDir:   checkpointDir,
 	})
 
 	if err != nil {
@@ -440,6 +643,134 @@ func RunSingleJob(httpClient *http.Client) {
 	job.Run()
 }
 
+func RunValidate() {
+	configFile := pflag.String(config.ConfigFile, "", "Agent configuration file to validate")
+	allowedImages := pflag.StringSlice(config.KubernetesAllowedImages, []string{}, "List of regexes for allowed images to use for the Kubernetes executor")
+	pflag.Parse()
+
+	jobFile := pflag.Arg(1)
+	if jobFile == "" && *configFile == "" {
+		fmt.Println("Usage: agent validate [--config-file <path>] [--kubernetes-allowed-images <regexes>] [job-request-path]")
+		os.Exit(1)
+	}
+
+	problems := 0
+	if *configFile != "" {
+		problems += validateFile(*configFile, validation.ValidateConfig)
+	}
+
+	if jobFile != "" {
+		imageValidator, err := kubernetes.NewImageValidator(*allowedImages)
+		if err != nil {
+			fmt.Printf("Error parsing --%s: %v\n", config.KubernetesAllowedImages, err)
+			os.Exit(1)
+		}
+
+		problems += validateFile(jobFile, func(content []byte) []validation.Problem {
+			return validation.ValidateJobRequest(content, validation.JobRequestOptions{
+				FileName:       jobFile,
+				ImageValidator: imageValidator,
+			})
+		})
+	}
+
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
+
+// Prints all the problems found in the file, and returns how many there were.
+func validateFile(path string, validate func([]byte) []validation.Problem) int {
+	// #nosec
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("%s: %v\n", path, err)
+		return 1
+	}
+
+	problems := validate(content)
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", path)
+		return 0
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("%s:%s\n", path, problem)
+	}
+
+	return len(problems)
+}
+
+func RunAttach() {
+	pflag.Parse()
+
+	jobID := pflag.Arg(1)
+	if jobID == "" {
+		fmt.Fprintln(os.Stderr, "Usage: agent attach <job-id>")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Attaching to job %s - exit the shell or press %s to detach and resume the job.\r\n", jobID, debugsession.DetachKeyName)
+	err := debugsession.Attach(debugsession.SocketPath(jobID), os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error attaching to job %s: %v\n", jobID, err)
+		os.Exit(1)
+	}
+}
+
+func RunLogs() {
+	format := pflag.String("format", eventlogger.RenderFormatText, fmt.Sprintf("Output format, one of %s", strings.Join(eventlogger.RenderFormats, ", ")))
+	stripANSI := pflag.Bool("strip-ansi", false, "Remove colors and other escape sequences from the plain text")
+	output := pflag.String("output", "", "File to write to. By default, stdout is used")
+	title := pflag.String("title", "", "Title of the HTML page and the cast. By default, the name of the events file is used")
+	width := pflag.Int("width", eventlogger.DefaultCastWidth, "Terminal width for the cast")
+	height := pflag.Int("height", eventlogger.DefaultCastHeight, "Terminal height for the cast")
+	pflag.Parse()
+
+	eventsFile := pflag.Arg(2)
+	if pflag.Arg(1) != "render" || eventsFile == "" {
+		fmt.Fprintln(os.Stderr, "Usage: agent logs render [--format text|html|cast] [--strip-ansi] [--output <path>] <events-path>")
+		os.Exit(1)
+	}
+
+	if *title == "" {
+		*title = filepath.Base(eventsFile)
+	}
+
+	writer := os.Stdout
+	if *output != "" {
+		// #nosec
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", *output, err)
+			os.Exit(1)
+		}
+
+		defer file.Close()
+		writer = file
+	}
+
+	// The backend is only used to go over the events in the file.
+	source, _ := eventlogger.NewFileBackend(eventsFile, eventlogger.DefaultMaxSizeInBytes)
+	bufferedWriter := bufio.NewWriter(writer)
+	err := eventlogger.Render(source, bufferedWriter, eventlogger.RenderOptions{
+		Format:    *format,
+		StripANSI: *stripANSI,
+		Title:     *title,
+		Width:     *width,
+		Height:    *height,
+	})
+
+	if err == nil {
+		err = bufferedWriter.Flush()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering %s: %v\n", eventsFile, err)
+		os.Exit(1)
+	}
+}
+
 func panicHandler(output string) {
 	log.Printf("Child agent process panicked:\n\n%s\n", output)
 	os.Exit(1)
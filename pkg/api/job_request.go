@@ -84,6 +84,15 @@ type Logger struct {
 	URL            string `json:"url" yaml:"url"`
 	Token          string `json:"token" yaml:"token"`
 	MaxSizeInBytes int    `json:"max_size_in_bytes" yaml:"max_size_in_bytes"`
+
+	// How much of the end of a log bigger than MaxSizeInBytes is kept.
+	// By default, a quarter of MaxSizeInBytes.
+	TailSizeInBytes int `json:"tail_size_in_bytes" yaml:"tail_size_in_bytes"`
+}
+
+type TestReports struct {
+	Paths  []string `json:"paths" yaml:"paths"`
+	Upload bool     `json:"upload" yaml:"upload"`
 }
 
 type PublicKey string
@@ -107,6 +116,12 @@ type JobRequest struct {
 	Files     []File    `json:"files" yaml:"file"`
 	Callbacks Callbacks `json:"callbacks" yaml:"callbacks"`
 	Logger    Logger    `json:"logger" yaml:"logger"`
+
+	TestReports TestReports `json:"test_reports" yaml:"test_reports"`
+
+	// The shell used for the commands, with the shell executor.
+	// Empty means the one configured in the agent.
+	Shell string `json:"shell" yaml:"shell"`
 }
 
 func (j *JobRequest) FindEnvVar(varName string) (string, error) {
@@ -29,9 +29,32 @@ const (
 	KubernetesPodStartTimeout  = "kubernetes-pod-start-timeout"
 	KubernetesLabels           = "kubernetes-labels"
 	KubernetesDefaultImage     = "kubernetes-default-image"
+	TestReportPaths            = "test-report-paths"
+	UploadTestReports          = "upload-test-reports"
+	WebhookURLs                = "webhook-urls"
+	WebhookSecret              = "webhook-secret"
+	WebhookCommandEvents       = "webhook-command-events"
+	MaskSecrets                = "mask-secrets"
+	ResourceSamplingInterval   = "resource-sampling-interval"
+	StopGracePeriod            = "stop-grace-period"
+	CompressLogs               = "compress-logs"
+	LogSinks                   = "log-sinks"
+	TracingEndpoint            = "tracing-endpoint"
+	Shell                      = "shell"
+	SeparateOutputStreams      = "separate-output-streams"
+	MaxCommandOutputBytes      = "max-command-output-bytes"
+	MaxJobOutputBytes          = "max-job-output-bytes"
+	OutputBytesPerSecond       = "output-bytes-per-second"
+	OutputLinesPerSecond       = "output-lines-per-second"
+	DebugOnFailureTimeout      = "debug-on-failure-timeout"
+	TerminalColumns            = "terminal-columns"
+	TerminalRows               = "terminal-rows"
+	TerminalType               = "terminal-type"
+	NoColor                    = "no-color"
 )
 
 const DefaultKubernetesPodStartTimeout = 300
+const DefaultStopGracePeriod = 10
 
 type ImagePullPolicy string
 
@@ -87,6 +110,28 @@ var ValidConfigKeys = []string{
 	KubernetesPodStartTimeout,
 	KubernetesLabels,
 	KubernetesDefaultImage,
+	TestReportPaths,
+	UploadTestReports,
+	WebhookURLs,
+	WebhookSecret,
+	WebhookCommandEvents,
+	MaskSecrets,
+	ResourceSamplingInterval,
+	StopGracePeriod,
+	CompressLogs,
+	LogSinks,
+	TracingEndpoint,
+	Shell,
+	SeparateOutputStreams,
+	MaxCommandOutputBytes,
+	MaxJobOutputBytes,
+	OutputBytesPerSecond,
+	OutputLinesPerSecond,
+	DebugOnFailureTimeout,
+	TerminalColumns,
+	TerminalRows,
+	TerminalType,
+	NoColor,
 }
 
 type HostEnvVar struct {
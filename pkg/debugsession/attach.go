@@ -0,0 +1,82 @@
+package debugsession
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/term"
+)
+
+/*
+ * Connects the terminal to the shell of a paused job, until the shell exits,
+ * or the detach key is typed. The terminal is put in raw mode,
+ * so everything else, including Ctrl-C, goes to the shell.
+ */
+func Attach(socketPath string, stdin *os.File, stdout io.Writer) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("no paused job to attach to at %s: %v", socketPath, err)
+	}
+
+	defer conn.Close()
+
+	size := hello{Rows: DefaultRows, Cols: DefaultCols}
+	fd := int(stdin.Fd())
+	if term.IsTerminal(fd) {
+		if cols, rows, err := term.GetSize(fd); err == nil {
+			size = hello{Rows: uint16(rows), Cols: uint16(cols)}
+		}
+
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("error putting the terminal in raw mode: %v", err)
+		}
+
+		defer func() { _ = term.Restore(fd, state) }()
+	}
+
+	err = json.NewEncoder(conn).Encode(size)
+	if err != nil {
+		return fmt.Errorf("error starting the session: %v", err)
+	}
+
+	var detached atomic.Bool
+	go func() {
+		copyUntilDetached(conn, stdin)
+		detached.Store(true)
+		_ = conn.Close()
+	}()
+
+	_, err = io.Copy(stdout, conn)
+	if detached.Load() {
+		return nil
+	}
+
+	return err
+}
+
+func copyUntilDetached(writer io.Writer, reader io.Reader) {
+	buffer := make([]byte, 1024)
+	for {
+		n, err := reader.Read(buffer)
+		if index := bytes.IndexByte(buffer[:n], DetachKey); index >= 0 {
+			_, _ = writer.Write(buffer[:index])
+			return
+		}
+
+		if n > 0 {
+			if _, err := writer.Write(buffer[:n]); err != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package debugsession
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	pty "github.com/creack/pty"
+	log "github.com/sirupsen/logrus"
+)
+
+// The longest a job can ask to be paused for, through its environment.
+const MaxTimeout = time.Hour
+
+// Typed in the attached session, it detaches from the job.
+const DetachKey = 0x1d
+
+const DetachKeyName = "Ctrl-]"
+
+// Used when the client is not a terminal.
+const DefaultRows = 24
+const DefaultCols = 80
+
+// The first line the client sends, before the session input.
+type hello struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// Used for jobs without an ID, like the ones run locally.
+const DefaultJobID = "job"
+
+// Where the agent listens for a client, while the job is paused.
+// Only the user running the agent can connect to it.
+func SocketPath(jobID string) string {
+	if jobID == "" {
+		jobID = DefaultJobID
+	}
+
+	return filepath.Join(os.TempDir(), "semaphore-agent-debug", jobID+".sock")
+}
+
+type Options struct {
+	SocketPath string
+	Timeout    time.Duration
+
+	// The interactive shell for the client, in the job environment.
+	NewShell func() (*exec.Cmd, error)
+}
+
+/*
+ * Waits for a client to attach, and runs a shell for it, until it detaches.
+ * Only one client attaches: when it detaches, or when the timeout is over,
+ * the session is over. Returns whether a client attached.
+ */
+func Serve(ctx context.Context, options Options) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	err := os.MkdirAll(filepath.Dir(options.SocketPath), 0700)
+	if err != nil {
+		return false, fmt.Errorf("error creating directory for %s: %v", options.SocketPath, err)
+	}
+
+	err = checkSocketDir(filepath.Dir(options.SocketPath))
+	if err != nil {
+		return false, fmt.Errorf("refusing to listen on %s: %v", options.SocketPath, err)
+	}
+
+	// A socket left behind by an agent which didn't exit cleanly.
+	_ = os.Remove(options.SocketPath)
+
+	listener, err := listen(options.SocketPath)
+	if err != nil {
+		return false, fmt.Errorf("error listening on %s: %v", options.SocketPath, err)
+	}
+
+	defer os.Remove(options.SocketPath)
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	log.Infof("Waiting for a debug session on %s for %v", options.SocketPath, options.Timeout)
+	conn, err := listener.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("error accepting connection on %s: %v", options.SocketPath, err)
+	}
+
+	defer conn.Close()
+
+	log.Info("Debug session attached")
+	err = serveSession(ctx, conn, options.NewShell)
+	log.Info("Debug session detached")
+	return true, err
+}
+
+func serveSession(ctx context.Context, conn net.Conn, newShell func() (*exec.Cmd, error)) error {
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("error reading the session size: %v", err)
+	}
+
+	size := hello{}
+	err = json.Unmarshal(line, &size)
+	if err != nil {
+		return fmt.Errorf("error parsing the session size: %v", err)
+	}
+
+	if size.Rows == 0 || size.Cols == 0 {
+		size = hello{Rows: DefaultRows, Cols: DefaultCols}
+	}
+
+	cmd, err := newShell()
+	if err != nil {
+		fmt.Fprintf(conn, "Error starting the debug shell: %v\r\n", err)
+		return err
+	}
+
+	tty, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+	if err != nil {
+		fmt.Fprintf(conn, "Error starting the debug shell: %v\r\n", err)
+		return err
+	}
+
+	defer tty.Close()
+
+	// Whichever ends first: the client detaching, or the shell exiting.
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(tty, reader)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(conn, tty)
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		fmt.Fprint(conn, "\r\n[agent] The debug session timed out - resuming the job\r\n")
+	}
+
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	return nil
+}
@@ -0,0 +1,172 @@
+package debugsession
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type serveResult struct {
+	attached bool
+	err      error
+}
+
+func startServing(t *testing.T, ctx context.Context, timeout time.Duration) (string, chan serveResult) {
+	socketDir := filepath.Join(t.TempDir(), "debug")
+	require.NoError(t, os.Mkdir(socketDir, 0700))
+	socketPath := filepath.Join(socketDir, "job.sock")
+	results := make(chan serveResult, 1)
+	go func() {
+		attached, err := Serve(ctx, Options{
+			SocketPath: socketPath,
+			Timeout:    timeout,
+			NewShell: func() (*exec.Cmd, error) {
+				cmd := exec.Command("sh")
+				cmd.Env = append(os.Environ(), "GREETING=hello from the job")
+				return cmd, nil
+			},
+		})
+
+		results <- serveResult{attached: attached, err: err}
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return socketPath, results
+}
+
+func attach(t *testing.T, socketPath, input string) string {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	defer reader.Close()
+
+	go func() {
+		_, _ = writer.WriteString(input)
+	}()
+
+	defer writer.Close()
+
+	var output bytes.Buffer
+	require.NoError(t, Attach(socketPath, reader, &output))
+	return output.String()
+}
+
+func Test__DebugSession__ShellExits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	socketPath, results := startServing(t, context.Background(), 10*time.Second)
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	output := attach(t, socketPath, "echo \"$GREETING\" | tr a-z A-Z\nexit\n")
+	assert.Contains(t, output, "HELLO FROM THE JOB")
+
+	result := <-results
+	assert.NoError(t, result.err)
+	assert.True(t, result.attached)
+
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test__DebugSession__Detach(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	socketPath, results := startServing(t, context.Background(), 10*time.Second)
+
+	// The shell is still running when the client detaches.
+	output := attach(t, socketPath, "echo attached | tr a-z A-Z\n"+string([]byte{DetachKey})+"echo not sent\n")
+	assert.NotContains(t, output, "not sent")
+
+	result := <-results
+	assert.NoError(t, result.err)
+	assert.True(t, result.attached)
+}
+
+func Test__DebugSession__Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	socketPath, results := startServing(t, context.Background(), 200*time.Millisecond)
+
+	result := <-results
+	assert.NoError(t, result.err)
+	assert.False(t, result.attached)
+
+	err := Attach(socketPath, os.Stdin, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "no paused job to attach to")
+}
+
+func Test__DebugSession__Cancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, results := startServing(t, ctx, time.Hour)
+	cancel()
+
+	select {
+	case result := <-results:
+		assert.NoError(t, result.err)
+		assert.False(t, result.attached)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session was not cancelled")
+	}
+}
+
+func Test__DebugSession__RefusesDirectoryOthersCanAccess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	socketDir := filepath.Join(t.TempDir(), "shared")
+	require.NoError(t, os.Mkdir(socketDir, 0700))
+	require.NoError(t, os.Chmod(socketDir, 0777))
+
+	attached, err := Serve(context.Background(), Options{
+		SocketPath: filepath.Join(socketDir, "job.sock"),
+		Timeout:    time.Second,
+	})
+
+	assert.False(t, attached)
+	assert.ErrorContains(t, err, "has mode 777, instead of 700")
+	assert.NoFileExists(t, filepath.Join(socketDir, "job.sock"))
+}
+
+func Test__DebugSession__SocketIsOnlyForTheAgentUser(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socketPath, _ := startServing(t, ctx, time.Hour)
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func Test__SocketPath(t *testing.T) {
+	assert.Equal(t, filepath.Join(os.TempDir(), "semaphore-agent-debug", "abc.sock"), SocketPath("abc"))
+	assert.Equal(t, filepath.Join(os.TempDir(), "semaphore-agent-debug", "job.sock"), SocketPath(""))
+}
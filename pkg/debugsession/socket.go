@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package debugsession
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The socket directory is shared with other users, through the temporary
+// directory, so it is only used if nobody else can get into it.
+func checkSocketDir(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s has mode %o, instead of 700", path, info.Mode().Perm())
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("error finding the owner of %s", path)
+	}
+
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by user %d, instead of %d", path, stat.Uid, os.Getuid())
+	}
+
+	return nil
+}
+
+// The socket is created without access for other users, instead of being
+// restricted after it is already listening. The umask is for the whole
+// process, so it is only changed while the socket is created.
+func listen(path string) (net.Listener, error) {
+	oldMask := syscall.Umask(0177)
+	defer syscall.Umask(oldMask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package debugsession
+
+import "net"
+
+func checkSocketDir(path string) error {
+	return nil
+}
+
+func listen(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -18,3 +18,4 @@ type CloseOptions struct {
 var _ Backend = (*FileBackend)(nil)
 var _ Backend = (*HTTPBackend)(nil)
 var _ Backend = (*InMemoryBackend)(nil)
+var _ Backend = (*S3Backend)(nil)
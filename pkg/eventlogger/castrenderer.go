@@ -0,0 +1,122 @@
+package eventlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// See https://docs.asciinema.org/manual/asciicast/v2/.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Terminals need a carriage return to go back to the start of the line.
+var castNewlines = strings.NewReplacer("\r\n", "\r\n", "\n", "\r\n")
+
+/*
+ * An asciinema v2 cast, which replays the job output with the timing it had,
+ * from the timestamps of the events. The header is only written with the first event,
+ * since the cast starts when the job does.
+ */
+func RenderCast(source EventSource, writer io.Writer, options RenderOptions) error {
+	header := castHeader{
+		Version: 2,
+		Width:   options.Width,
+		Height:  options.Height,
+		Title:   options.Title,
+		Env:     map[string]string{"TERM": "xterm-256color", "SHELL": "/bin/bash"},
+	}
+
+	if header.Width <= 0 {
+		header.Width = DefaultCastWidth
+	}
+
+	if header.Height <= 0 {
+		header.Height = DefaultCastHeight
+	}
+
+	var startedAt time.Time
+	elapsed := 0.0
+	headerWritten := false
+
+	writeLine := func(v interface{}) error {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(writer, "%s\n", line); err != nil {
+			return fmt.Errorf("error writing to output: %v", err)
+		}
+
+		return nil
+	}
+
+	writeHeader := func(event *renderedEvent) error {
+		if headerWritten {
+			return nil
+		}
+
+		headerWritten = true
+		if event != nil {
+			startedAt = event.time()
+			header.Timestamp = startedAt.Unix()
+		}
+
+		return writeLine(header)
+	}
+
+	output := func(event *renderedEvent, data string) error {
+		if err := writeHeader(event); err != nil {
+			return err
+		}
+
+		// The clock may go backwards, but the cast cannot.
+		elapsed = math.Max(elapsed, event.time().Sub(startedAt).Seconds())
+		return writeLine([]interface{}{math.Round(elapsed*1e6) / 1e6, "o", castNewlines.Replace(data)})
+	}
+
+	err := forEachEvent(source, func(event *renderedEvent) error {
+		switch event.Event {
+		case "job_started":
+			return writeHeader(event)
+		case "cmd_started":
+			return output(event, fmt.Sprintf("\x1b[1;33m$ %s\x1b[0m\n", event.Directive))
+		case "cmd_output":
+			return output(event, event.Output)
+		case "section_started":
+			return output(event, fmt.Sprintf("\x1b[1m--- %s\x1b[0m\n", event.Name))
+		case "annotation":
+			annotation := AnnotationEvent{}
+			if err := event.decode(&annotation); err != nil {
+				return err
+			}
+
+			return output(event, annotationColor(annotation.Level)+formatAnnotation(annotation)+"\x1b[0m")
+		case "cmd_finished":
+			if event.ExitCode != 0 {
+				return output(event, fmt.Sprintf("\x1b[31mexit code %d\x1b[0m\n", event.ExitCode))
+			}
+		case "job_finished":
+			return output(event, fmt.Sprintf("\nJob %s\n", event.Result))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Even empty logs are valid casts.
+	return writeHeader(nil)
+}
@@ -8,15 +8,25 @@ import (
 	"time"
 
 	api "github.com/semaphoreci/agent/pkg/api"
+	"github.com/semaphoreci/agent/pkg/s3"
+	log "github.com/sirupsen/logrus"
 )
 
 const LoggerMethodPull = "pull"
 const LoggerMethodPush = "push"
+const LoggerMethodS3 = "s3"
 
 type LoggerOptions struct {
 	Request        *api.JobRequest
 	RefreshTokenFn func() (string, error)
 	UserAgent      string
+
+	// Only used by the push logger.
+	CompressLogs bool
+
+	// Extra destinations for the logs, see Sink.
+	// Reads still come from the backend for the request's logger method.
+	Sinks []string
 }
 
 func CreateLogger(options LoggerOptions) (*Logger, error) {
@@ -24,29 +34,81 @@ func CreateLogger(options LoggerOptions) (*Logger, error) {
 		return nil, fmt.Errorf("request is required")
 	}
 
+	var backend Backend
+	var err error
+
 	switch options.Request.Logger.Method {
 	case LoggerMethodPull:
-		return Default(options.Request)
+		backend, err = newPullBackend(options.Request)
 	case LoggerMethodPush:
-		return DefaultHTTP(options)
+		backend, err = newPushBackend(options)
+	case LoggerMethodS3:
+		backend, err = newS3Backend(options.Request)
 	default:
 		return nil, fmt.Errorf("unknown logger type")
 	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.Sinks) > 0 {
+		backend = NewMultiBackend(backend, createSinks(options)...)
+	}
+
+	return openLogger(backend)
+}
+
+// A sink that cannot be created is left out, instead of failing the job.
+func createSinks(options LoggerOptions) []NamedBackend {
+	sinks := []NamedBackend{}
+	for _, spec := range options.Sinks {
+		sink, err := ParseSink(spec)
+		if err != nil {
+			log.Errorf("Error creating log sink: %v", err)
+			continue
+		}
+
+		backend, err := sink.NewBackend(options.Request, options.UserAgent)
+		if err != nil {
+			log.Errorf("Error creating log sink %s: %v", sink, err)
+			continue
+		}
+
+		sinks = append(sinks, NamedBackend{Name: sink.String(), Backend: backend})
+	}
+
+	return sinks
 }
 
 func Default(request *api.JobRequest) (*Logger, error) {
-	path := filepath.Join(os.TempDir(), fmt.Sprintf("job_log_%d.json", time.Now().UnixNano()))
+	backend, err := newPullBackend(request)
+	if err != nil {
+		return nil, err
+	}
 
-	maxSize := DefaultMaxSizeInBytes
-	if request.Logger.MaxSizeInBytes > 0 {
-		maxSize = request.Logger.MaxSizeInBytes
+	return openLogger(backend)
+}
+
+func DefaultHTTP(options LoggerOptions) (*Logger, error) {
+	backend, err := newPushBackend(options)
+	if err != nil {
+		return nil, err
 	}
 
-	backend, err := NewFileBackend(path, maxSize)
+	return openLogger(backend)
+}
+
+func DefaultS3(request *api.JobRequest) (*Logger, error) {
+	backend, err := newS3Backend(request)
 	if err != nil {
 		return nil, err
 	}
 
+	return openLogger(backend)
+}
+
+func openLogger(backend Backend) (*Logger, error) {
 	logger, err := NewLogger(backend)
 	if err != nil {
 		return nil, err
@@ -60,7 +122,25 @@ func Default(request *api.JobRequest) (*Logger, error) {
 	return logger, nil
 }
 
-func DefaultHTTP(options LoggerOptions) (*Logger, error) {
+func newPullBackend(request *api.JobRequest) (Backend, error) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("job_log_%d.json", time.Now().UnixNano()))
+	backend, err := NewFileBackendWithTrimming(path, maxSizeInBytes(request), request.Logger.TailSizeInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func maxSizeInBytes(request *api.JobRequest) int {
+	if request.Logger.MaxSizeInBytes > 0 {
+		return request.Logger.MaxSizeInBytes
+	}
+
+	return DefaultMaxSizeInBytes
+}
+
+func newPushBackend(options LoggerOptions) (Backend, error) {
 	request := options.Request
 	if request.Logger.URL == "" {
 		return nil, errors.New("HTTP logger needs a URL")
@@ -76,24 +156,58 @@ func DefaultHTTP(options LoggerOptions) (*Logger, error) {
 		RefreshTokenFn:        options.RefreshTokenFn,
 		UserAgent:             options.UserAgent,
 		LinesPerRequest:       MaxLinesPerRequest,
+		BytesPerRequest:       DefaultBytesPerRequest,
 		FlushTimeoutInSeconds: DefaultFlushTimeoutInSeconds,
+		Compression:           options.CompressLogs,
+		MaxSizeInBytes:        request.Logger.MaxSizeInBytes,
+		TailSizeInBytes:       request.Logger.TailSizeInBytes,
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	logger, err := NewLogger(backend)
+	return backend, nil
+}
+
+/*
+ * The request's logger URL points to the bucket, and to an optional prefix,
+ * e.g. https://s3.us-east-1.amazonaws.com/my-bucket/job-logs.
+ * The credentials and region come from the same environment variables used by the AWS CLI.
+ */
+func newS3Backend(request *api.JobRequest) (Backend, error) {
+	if request.Logger.URL == "" {
+		return nil, errors.New("S3 logger needs a URL")
+	}
+
+	credentials, err := s3.CredentialsFromEnv()
 	if err != nil {
 		return nil, err
 	}
 
-	err = logger.Open()
+	client, prefix, err := s3.NewClientFromURL(request.Logger.URL, credentials, s3.RegionFromEnv())
 	if err != nil {
 		return nil, err
 	}
 
-	return logger, nil
+	backend, err := NewS3Backend(defaultS3BackendConfig(client, prefix, request.JobID))
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func defaultS3BackendConfig(client *s3.Client, prefix, jobID string) S3BackendConfig {
+	return S3BackendConfig{
+		Client:                    client,
+		Prefix:                    prefix,
+		JobID:                     jobID,
+		PartSize:                  DefaultS3PartSize,
+		UploadIntervalInSeconds:   DefaultS3UploadIntervalInSeconds,
+		FlushAttempts:             DefaultFlushTimeoutInSeconds / 5,
+		DelayBetweenFlushAttempts: 5 * time.Second,
+	}
 }
 
 func DefaultTestLogger() (*Logger, *InMemoryBackend) {
@@ -0,0 +1,168 @@
+package eventlogger
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	DirectiveGroup    = "group"
+	DirectiveEndGroup = "endgroup"
+	DirectiveNotice   = "notice"
+	DirectiveWarning  = "warning"
+	DirectiveError    = "error"
+)
+
+// Lines longer than this are not held back waiting for their end,
+// even if they look like the beginning of a directive.
+const MaxDirectiveLength = 4096
+
+// A directive found in the command output, in the form:
+// ::<command> <key>=<value>,<key>=<value>::<message>
+type Directive struct {
+	Command    string
+	Properties map[string]string
+	Message    string
+}
+
+// A piece of command output: either some text, or a directive.
+type OutputPart struct {
+	Text      string
+	Directive *Directive
+}
+
+/*
+ * Finds the directives in the command output, and removes them from it.
+ * See docs/log-directives.md for the syntax.
+ *
+ * Directives must be on a line of their own, and output reaches the logger in chunks,
+ * so a line that could be a directive is held back until its end arrives,
+ * or until Flush() is called, when the command finishes.
+ * Everything else is passed through as soon as it arrives, in the same chunks.
+ */
+type DirectiveParser struct {
+	mutex       sync.Mutex
+	pending     string
+	atLineStart bool
+}
+
+func NewDirectiveParser() *DirectiveParser {
+	return &DirectiveParser{atLineStart: true}
+}
+
+func (p *DirectiveParser) Parse(output string) []OutputPart {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	output = p.pending + output
+	p.pending = ""
+
+	parts := []OutputPart{}
+	text := strings.Builder{}
+	flushText := func() {
+		if text.Len() > 0 {
+			parts = append(parts, OutputPart{Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for len(output) > 0 {
+		if p.atLineStart && couldBeDirective(output) {
+			end := strings.IndexByte(output, '\n')
+			if end < 0 {
+				if len(output) <= MaxDirectiveLength {
+					p.pending = output
+					break
+				}
+			} else if directive := parseDirective(output[:end]); directive != nil {
+				flushText()
+				parts = append(parts, OutputPart{Directive: directive})
+				output = output[end+1:]
+				continue
+			}
+		}
+
+		end := strings.IndexByte(output, '\n')
+		if end < 0 {
+			text.WriteString(output)
+			p.atLineStart = false
+			break
+		}
+
+		text.WriteString(output[:end+1])
+		output = output[end+1:]
+		p.atLineStart = true
+	}
+
+	flushText()
+	return parts
+}
+
+// Returns what was held back, and starts over for the next command.
+func (p *DirectiveParser) Flush() []OutputPart {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pending := p.pending
+	p.pending = ""
+	p.atLineStart = true
+
+	if pending == "" {
+		return []OutputPart{}
+	}
+
+	if directive := parseDirective(pending); directive != nil {
+		return []OutputPart{{Directive: directive}}
+	}
+
+	return []OutputPart{{Text: pending}}
+}
+
+func couldBeDirective(output string) bool {
+	return strings.HasPrefix(output, "::") || output == ":"
+}
+
+func parseDirective(line string) *Directive {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasPrefix(line, "::") {
+		return nil
+	}
+
+	header, message, found := strings.Cut(line[2:], "::")
+	if !found {
+		return nil
+	}
+
+	command, properties, _ := strings.Cut(header, " ")
+	switch command {
+	case DirectiveGroup, DirectiveEndGroup, DirectiveNotice, DirectiveWarning, DirectiveError:
+	default:
+		return nil
+	}
+
+	directive := &Directive{
+		Command:    command,
+		Properties: map[string]string{},
+		Message:    unescapeDirectiveMessage(message),
+	}
+
+	for _, property := range strings.Split(properties, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(property), "=")
+		if found && key != "" {
+			directive.Properties[key] = unescapeDirectiveProperty(value)
+		}
+	}
+
+	return directive
+}
+
+var messageUnescaper = strings.NewReplacer("%0D", "\r", "%0A", "\n", "%25", "%")
+var propertyUnescaper = strings.NewReplacer("%0D", "\r", "%0A", "\n", "%3A", ":", "%2C", ",", "%25", "%")
+
+func unescapeDirectiveMessage(message string) string {
+	return messageUnescaper.Replace(message)
+}
+
+func unescapeDirectiveProperty(value string) string {
+	return propertyUnescaper.Replace(value)
+}
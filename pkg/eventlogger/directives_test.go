@@ -0,0 +1,73 @@
+package eventlogger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test__DirectiveParser(t *testing.T) {
+	t.Run("output without directives is not changed", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{{Text: "hello\nworld"}}, parser.Parse("hello\nworld"))
+		assert.Equal(t, []OutputPart{{Text: " :: not a directive\n"}}, parser.Parse(" :: not a directive\n"))
+		assert.Equal(t, []OutputPart{}, parser.Flush())
+	})
+
+	t.Run("directives are removed from the output", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{
+			{Text: "before\n"},
+			{Directive: &Directive{Command: DirectiveGroup, Properties: map[string]string{}, Message: "Install"}},
+			{Text: "installing\n"},
+			{Directive: &Directive{Command: DirectiveEndGroup, Properties: map[string]string{}, Message: ""}},
+		}, parser.Parse("before\n::group::Install\r\ninstalling\n::endgroup::\n"))
+	})
+
+	t.Run("annotation properties are parsed", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{
+			{Directive: &Directive{
+				Command:    DirectiveWarning,
+				Properties: map[string]string{"file": "a:b.js", "line": "10", "col": "5", "title": "1,2"},
+				Message:    "100% done\nreally",
+			}},
+		}, parser.Parse("::warning file=a%3Ab.js,line=10,col=5,title=1%2C2::100%25 done%0Areally\n"))
+	})
+
+	t.Run("unknown directives are kept in the output", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{{Text: "::set-output name=a::b\n"}}, parser.Parse("::set-output name=a::b\n"))
+		assert.Equal(t, []OutputPart{{Text: "::std::vector\n"}}, parser.Parse("::std::vector\n"))
+	})
+
+	t.Run("directives split across chunks are held back", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{{Text: "hello\n"}}, parser.Parse("hello\n:"))
+		assert.Equal(t, []OutputPart{}, parser.Parse(":err"))
+		assert.Equal(t, []OutputPart{
+			{Directive: &Directive{Command: DirectiveError, Properties: map[string]string{}, Message: "failed"}},
+			{Text: "bye\n"},
+		}, parser.Parse("or::failed\nbye\n"))
+	})
+
+	t.Run("directives are only recognized at the start of a line", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{{Text: "no newline "}}, parser.Parse("no newline "))
+		assert.Equal(t, []OutputPart{{Text: "::error::not a directive\n"}}, parser.Parse("::error::not a directive\n"))
+	})
+
+	t.Run("directive without a newline is parsed on flush", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{}, parser.Parse("::endgroup::"))
+		assert.Equal(t, []OutputPart{
+			{Directive: &Directive{Command: DirectiveEndGroup, Properties: map[string]string{}, Message: ""}},
+		}, parser.Flush())
+	})
+
+	t.Run("held back output that is not a directive is flushed", func(t *testing.T) {
+		parser := NewDirectiveParser()
+		assert.Equal(t, []OutputPart{}, parser.Parse("::not-a-directive"))
+		assert.Equal(t, []OutputPart{{Text: "::not-a-directive"}}, parser.Flush())
+	})
+}
@@ -1,34 +1,146 @@
 package eventlogger
 
+import (
+	"github.com/semaphoreci/agent/pkg/resources"
+	"github.com/semaphoreci/agent/pkg/testreports"
+)
+
+/*
+ * Reported in the job_started event, and incremented when the events change.
+ *
+ * Version 2 added millisecond timestamps to all the events,
+ * and the millisecond timings of the commands to cmd_finished.
+ * The seconds fields from version 1 are still there, for compatibility.
+ */
+const SchemaVersion = 2
+
 type JobStartedEvent struct {
-	Event     string `json:"event"`
-	Timestamp int    `json:"timestamp"`
+	Event         string `json:"event"`
+	Timestamp     int    `json:"timestamp"`
+	TimestampMs   int64  `json:"timestamp_ms,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
 }
 
 type JobFinishedEvent struct {
-	Event     string `json:"event"`
-	Timestamp int    `json:"timestamp"`
-	Result    string `json:"result"`
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+	Result      string `json:"result"`
 }
 
 type CommandStartedEvent struct {
-	Event     string `json:"event"`
-	Timestamp int    `json:"timestamp"`
-	Directive string `json:"directive"`
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+	Directive   string `json:"directive"`
 }
 
 type CommandOutputEvent struct {
-	Event     string `json:"event"`
-	Timestamp int    `json:"timestamp"`
-	Output    string `json:"output"`
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+	Output      string `json:"output"`
+
+	// Only commands run with separate output streams have a stream:
+	// shell.StreamStdout or shell.StreamStderr.
+	Stream string `json:"stream,omitempty"`
 }
 
 type CommandFinishedEvent struct {
-	Event     string `json:"event"`
-	Timestamp int    `json:"timestamp"`
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
 
 	Directive  string `json:"directive"`
 	ExitCode   int    `json:"exit_code"`
 	StartedAt  int    `json:"started_at"`
 	FinishedAt int    `json:"finished_at"`
+
+	StartedAtMs  int64 `json:"started_at_ms,omitempty"`
+	FinishedAtMs int64 `json:"finished_at_ms,omitempty"`
+
+	// Measured with a monotonic clock, from cmd_started to cmd_finished,
+	// so it is not affected by changes to the system clock.
+	DurationMs int64 `json:"duration_ms"`
+
+	// Only there if output was dropped by the output limits.
+	OutputThrottling *OutputThrottling `json:"output_throttling,omitempty"`
+}
+
+type OutputThrottling struct {
+	DroppedBytes        int64 `json:"dropped_bytes"`
+	DroppedLines        int64 `json:"dropped_lines"`
+	ThrottledSeconds    int   `json:"throttled_seconds"`
+	CommandLimitReached bool  `json:"command_limit_reached"`
+	JobLimitReached     bool  `json:"job_limit_reached"`
+}
+
+type SectionStartedEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+	Name        string `json:"name"`
+}
+
+type SectionFinishedEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+	Name        string `json:"name"`
+}
+
+type AnnotationEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+	Level       string `json:"level"`
+	Message     string `json:"message"`
+	Title       string `json:"title,omitempty"`
+	File        string `json:"file,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Column      int    `json:"column,omitempty"`
+}
+
+type TestSummaryEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+
+	testreports.Summary
+}
+
+type JobSummaryEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+
+	Phases    []PhaseSummary   `json:"phases"`
+	Commands  []CommandSummary `json:"commands"`
+	Resources *resources.Usage `json:"resources,omitempty"`
+}
+
+type JobResourcesEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms,omitempty"`
+
+	resources.Usage
+}
+
+type JobOutputsEvent struct {
+	Event       string            `json:"event"`
+	Timestamp   int               `json:"timestamp"`
+	TimestampMs int64             `json:"timestamp_ms,omitempty"`
+	Outputs     map[string]string `json:"outputs"`
+}
+
+type PhaseSummary struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type CommandSummary struct {
+	Directive  string `json:"directive"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
 }
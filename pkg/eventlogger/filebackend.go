@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,24 +15,81 @@ import (
 // This default value is used when the job request doesn't specify a limit.
 const DefaultMaxSizeInBytes = 16777216
 
+// We keep the offset of every lineIndexInterval-th line in the file.
+const lineIndexInterval = 1000
+
+/*
+ * Reads seek straight to the closest indexed line before the one they start from,
+ * instead of scanning the file from the beginning, which gets slow for big logs,
+ * since they are read many times while the job runs.
+ */
 type FileBackend struct {
 	path           string
 	file           *os.File
 	maxSizeInBytes int
+
+	// Log sinks keep the file around after the job is done.
+	keepFile bool
+
+	// With head-and-tail trimming, the file has what readers get,
+	// and the complete log goes to a second file, which is the one iterated on,
+	// e.g. to upload the job log as an artifact.
+	trimmer  *headAndTailTrimmer
+	fullFile *os.File
+
+	// Protects the index, which is updated on every write.
+	mutex       sync.Mutex
+	lineCount   int
+	size        int64
+	lineOffsets []int64
 }
 
 func NewFileBackend(path string, maxSizeInBytes int) (*FileBackend, error) {
 	return &FileBackend{path: path, maxSizeInBytes: maxSizeInBytes}, nil
 }
 
+/*
+ * Same as NewFileBackend(), but the logs read from the backend are trimmed
+ * to the first maxSizeInBytes-tailSizeInBytes bytes and the last tailSizeInBytes of output.
+ * See headAndTailTrimmer.
+ */
+func NewFileBackendWithTrimming(path string, maxSizeInBytes, tailSizeInBytes int) (*FileBackend, error) {
+	if maxSizeInBytes <= 0 {
+		return nil, fmt.Errorf("max size must be positive")
+	}
+
+	tailSizeInBytes = tailSizeFor(maxSizeInBytes, tailSizeInBytes)
+	return &FileBackend{
+		path:           path,
+		maxSizeInBytes: maxSizeInBytes,
+		trimmer:        newHeadAndTailTrimmer(maxSizeInBytes, tailSizeInBytes),
+	}, nil
+}
+
 func (l *FileBackend) Open() error {
 	file, err := os.Create(l.path)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	l.file = file
 
+	if l.trimmer != nil {
+		fullFile, err := os.Create(l.fullPath())
+		if err != nil {
+			return err
+		}
+
+		l.fullFile = fullFile
+		l.trimmer.reset()
+	}
+
+	l.mutex.Lock()
+	l.lineCount = 0
+	l.size = 0
+	l.lineOffsets = []int64{}
+	l.mutex.Unlock()
+
 	return nil
 }
 
@@ -42,28 +100,125 @@ func (l *FileBackend) Write(event interface{}) error {
 	}
 	jsonBytes = append(jsonBytes, '\n')
 
-	_, err = l.file.Write(jsonBytes)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.trimmer == nil {
+		return l.appendLine(jsonBytes)
+	}
+
+	if _, err := l.fullFile.Write(jsonBytes); err != nil {
+		return err
+	}
+
+	output, _ := event.(*CommandOutputEvent)
+	for _, line := range l.trimmer.add(jsonBytes, output) {
+		if err := l.appendLine(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Must be called with the mutex held.
+func (l *FileBackend) appendLine(jsonBytes []byte) error {
+	n, err := l.file.Write(jsonBytes)
 	if err != nil {
+		// A partially written line would shift all the offsets after it,
+		// so we keep the index consistent with what is in the file.
+		l.size += int64(n)
 		return err
 	}
 
+	if l.lineCount%lineIndexInterval == 0 {
+		l.lineOffsets = append(l.lineOffsets, l.size)
+	}
+
+	l.lineCount++
+	l.size += int64(n)
+
 	log.Debugf("%s", jsonBytes)
 
 	return nil
 }
 
+// Makes the output held back by the trimmer available to readers.
+// Usually, that happens when the job_finished event is written.
+func (l *FileBackend) flushHeldEvents() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.trimmer == nil || l.file == nil {
+		return nil
+	}
+
+	for _, line := range l.trimmer.flush() {
+		if err := l.appendLine(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The number of bytes of output left out of the trimmed log.
+func (l *FileBackend) omittedBytes() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.trimmer == nil {
+		return 0
+	}
+
+	return l.trimmer.omittedBytes
+}
+
+func (l *FileBackend) fullPath() string {
+	return l.path + ".full"
+}
+
+// The number of events written, and the size of the file with them.
+func (l *FileBackend) Position() (int, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lineCount, l.size
+}
+
 func (l *FileBackend) Close() error {
 	return l.CloseWithOptions(CloseOptions{})
 }
 
 func (l *FileBackend) CloseWithOptions(options CloseOptions) error {
+	// Nothing to clean up if the file could not be created.
+	if l.file == nil {
+		return nil
+	}
+
+	if err := l.flushHeldEvents(); err != nil {
+		log.Errorf("Error writing held back events to %s: %v", l.file.Name(), err)
+	}
+
 	err := l.file.Close()
 	if err != nil {
 		log.Errorf("Error closing file %s: %v\n", l.file.Name(), err)
 		return err
 	}
 
-	if options.OnClose != nil {
+	if l.fullFile != nil {
+		if err := l.fullFile.Close(); err != nil {
+			log.Errorf("Error closing file %s: %v\n", l.fullFile.Name(), err)
+			return err
+		}
+
+		defer l.removeFullFile()
+	}
+
+	if options.OnClose != nil && l.trimmer != nil {
+		omittedBytes := l.omittedBytes()
+		log.Debugf("Log trimmed with head and tail - %d bytes of output omitted", omittedBytes)
+		options.OnClose(omittedBytes > 0)
+	} else if options.OnClose != nil {
 		fileInfo, err := os.Stat(l.file.Name())
 		if err != nil {
 			log.Errorf("Couldn't stat file '%s': %v", l.file.Name(), err)
@@ -80,6 +235,10 @@ func (l *FileBackend) CloseWithOptions(options CloseOptions) error {
 		}
 	}
 
+	if l.keepFile {
+		return nil
+	}
+
 	log.Debugf("Removing %s\n", l.file.Name())
 	if err := os.Remove(l.file.Name()); err != nil {
 		log.Errorf("Error removing logger file %s: %v\n", l.file.Name(), err)
@@ -89,10 +248,26 @@ func (l *FileBackend) CloseWithOptions(options CloseOptions) error {
 	return nil
 }
 
+func (l *FileBackend) removeFullFile() {
+	if l.keepFile {
+		return
+	}
+
+	if err := os.Remove(l.fullFile.Name()); err != nil {
+		log.Errorf("Error removing logger file %s: %v\n", l.fullFile.Name(), err)
+	}
+}
+
+// Goes over the complete log, even if it is trimmed for readers.
 func (l *FileBackend) Iterate(fn func([]byte) error) error {
-	fd, err := os.OpenFile(l.path, os.O_RDONLY, os.ModePerm)
+	path := l.path
+	if l.trimmer != nil {
+		path = l.fullPath()
+	}
+
+	fd, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("error opening file '%s': %v", l.path, err)
+		return fmt.Errorf("error opening file '%s': %v", path, err)
 	}
 
 	defer fd.Close()
@@ -112,14 +287,44 @@ func (l *FileBackend) Iterate(fn func([]byte) error) error {
 }
 
 func (l *FileBackend) Read(startingLineNumber, maxLines int, writer io.Writer) (int, error) {
+	return l.ReadWithMaxBytes(startingLineNumber, maxLines, 0, writer)
+}
+
+/*
+ * Same as Read(), but also stops before the lines streamed go over maxBytes.
+ * Zero means there is no limit. The first line is always streamed,
+ * even if it is bigger than maxBytes, so the reader does not get stuck on it.
+ */
+func (l *FileBackend) ReadWithMaxBytes(startingLineNumber, maxLines, maxBytes int, writer io.Writer) (int, error) {
+	// Events written after this point are left for the next read.
+	l.mutex.Lock()
+	lineCount := l.lineCount
+	size := l.size
+	lineNumber := min(startingLineNumber, lineCount)
+	offset := int64(0)
+	if index := lineNumber / lineIndexInterval; index < len(l.lineOffsets) {
+		offset = l.lineOffsets[index]
+		lineNumber = index * lineIndexInterval
+	}
+	l.mutex.Unlock()
+
+	if startingLineNumber >= lineCount {
+		return lineCount, nil
+	}
+
 	fd, err := os.OpenFile(l.path, os.O_RDONLY, os.ModePerm)
 	if err != nil {
 		return startingLineNumber, err
 	}
 
-	reader := bufio.NewReader(fd)
-	lineNumber := 0
+	if _, err := fd.Seek(offset, io.SeekStart); err != nil {
+		_ = fd.Close()
+		return startingLineNumber, err
+	}
+
+	reader := bufio.NewReader(io.LimitReader(fd, size-offset))
 	linesStreamed := 0
+	bytesStreamed := 0
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -138,10 +343,15 @@ func (l *FileBackend) Read(startingLineNumber, maxLines int, writer io.Writer) (
 			continue
 		}
 
+		if maxBytes > 0 && linesStreamed > 0 && bytesStreamed+len(line) > maxBytes {
+			break
+		}
+
 		// Otherwise, we advance to the next line and stream the current line.
 		lineNumber++
 		fmt.Fprint(writer, line)
 		linesStreamed++
+		bytesStreamed += len(line)
 
 		// if we have streamed the number of lines we want, we stop.
 		if linesStreamed == maxLines {
@@ -3,6 +3,7 @@ package eventlogger
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test__LogsArePushedToFile(t *testing.T) {
@@ -41,7 +43,7 @@ func Test__LogsArePushedToFile(t *testing.T) {
 		fmt.Sprintf(`{"event":"job_started","timestamp":%d}`, timestamp),
 		fmt.Sprintf(`{"event":"cmd_started","timestamp":%d,"directive":"echo hello"}`, timestamp),
 		fmt.Sprintf(`{"event":"cmd_output","timestamp":%d,"output":"hello\n"}`, timestamp),
-		fmt.Sprintf(`{"event":"cmd_finished","timestamp":%d,"directive":"echo hello","exit_code":0,"started_at":%d,"finished_at":%d}`, timestamp, timestamp, timestamp),
+		fmt.Sprintf(`{"event":"cmd_finished","timestamp":%d,"directive":"echo hello","exit_code":0,"started_at":%d,"finished_at":%d,"duration_ms":0}`, timestamp, timestamp, timestamp),
 		fmt.Sprintf(`{"event":"job_finished","timestamp":%d,"result":"passed"}`, timestamp),
 		"", // newline at the end of the file
 	}, logs)
@@ -79,7 +81,7 @@ func Test__ReadDoesNotIncludeDoubleNewlines(t *testing.T) {
 		fmt.Sprintf(`{"event":"job_started","timestamp":%d}`, timestamp),
 		fmt.Sprintf(`{"event":"cmd_started","timestamp":%d,"directive":"echo hello"}`, timestamp),
 		fmt.Sprintf(`{"event":"cmd_output","timestamp":%d,"output":"hello\n"}`, timestamp),
-		fmt.Sprintf(`{"event":"cmd_finished","timestamp":%d,"directive":"echo hello","exit_code":0,"started_at":%d,"finished_at":%d}`, timestamp, timestamp, timestamp),
+		fmt.Sprintf(`{"event":"cmd_finished","timestamp":%d,"directive":"echo hello","exit_code":0,"started_at":%d,"finished_at":%d,"duration_ms":0}`, timestamp, timestamp, timestamp),
 		fmt.Sprintf(`{"event":"job_finished","timestamp":%d,"result":"passed"}`, timestamp),
 		"", // newline at the end of the file
 	}, logs)
@@ -131,3 +133,217 @@ func Test__CloseWithOptions(t *testing.T) {
 		assert.False(t, logsWereTrimmed)
 	})
 }
+
+func Test__ReadStartsFromAnyLine(t *testing.T) {
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("logs_%d.json", time.Now().UnixNano()))
+	fileBackend, err := NewFileBackend(tmpFileName, DefaultMaxSizeInBytes)
+	require.Nil(t, err)
+	require.Nil(t, fileBackend.Open())
+
+	// enough lines to go through a few entries in the line index
+	lineCount := 3*lineIndexInterval + 10
+	for i := 0; i < lineCount; i++ {
+		require.Nil(t, fileBackend.Write(&CommandOutputEvent{Event: "cmd_output", Output: fmt.Sprintf("line %d", i)}))
+	}
+
+	for _, start := range []int{0, 1, lineIndexInterval - 1, lineIndexInterval, 2*lineIndexInterval + 5, lineCount - 1} {
+		buffer := bytes.NewBuffer([]byte{})
+		next, err := fileBackend.Read(start, 3, buffer)
+		require.NoError(t, err)
+
+		expected := ""
+		for i := start; i < min(start+3, lineCount); i++ {
+			expected += fmt.Sprintf(`{"event":"cmd_output","timestamp":0,"output":"line %d"}`+"\n", i)
+		}
+
+		assert.Equal(t, expected, buffer.String())
+		assert.Equal(t, min(start+3, lineCount), next)
+	}
+
+	// reading past the end returns the number of lines
+	buffer := bytes.NewBuffer([]byte{})
+	next, err := fileBackend.Read(lineCount+10, 3, buffer)
+	require.NoError(t, err)
+	assert.Empty(t, buffer.String())
+	assert.Equal(t, lineCount, next)
+
+	require.NoError(t, fileBackend.Close())
+}
+
+func Test__ReadWhileEventsAreWritten(t *testing.T) {
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("logs_%d.json", time.Now().UnixNano()))
+	fileBackend, err := NewFileBackend(tmpFileName, DefaultMaxSizeInBytes)
+	require.Nil(t, err)
+	require.Nil(t, fileBackend.Open())
+
+	lineCount := 5 * lineIndexInterval
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < lineCount; i++ {
+			_ = fileBackend.Write(&CommandOutputEvent{Event: "cmd_output", Output: fmt.Sprintf("line %d", i)})
+		}
+
+		done <- true
+	}()
+
+	// Every line read must be complete, and in order.
+	lines := []string{}
+	startFrom := 0
+	writing := true
+	for writing || startFrom < lineCount {
+		select {
+		case <-done:
+			writing = false
+		default:
+		}
+
+		buffer := bytes.NewBuffer([]byte{})
+		next, err := fileBackend.Read(startFrom, 100, buffer)
+		require.NoError(t, err)
+
+		batch := strings.Split(buffer.String(), "\n")
+		require.Equal(t, "", batch[len(batch)-1])
+		require.Len(t, batch[:len(batch)-1], next-startFrom)
+		lines = append(lines, batch[:len(batch)-1]...)
+		startFrom = next
+	}
+
+	require.Len(t, lines, lineCount)
+	for i, line := range lines {
+		require.Equal(t, fmt.Sprintf(`{"event":"cmd_output","timestamp":0,"output":"line %d"}`, i), line)
+	}
+
+	require.NoError(t, fileBackend.Close())
+}
+
+/*
+ * Reading the last batch of a big log should take about
+ * as long as reading the first one, since we do not scan the whole file.
+ */
+func Benchmark__FileBackendRead__FirstLines(b *testing.B) {
+	benchmarkFileBackendRead(b, func(lineCount int) int { return 0 })
+}
+
+func Benchmark__FileBackendRead__LastLines(b *testing.B) {
+	benchmarkFileBackendRead(b, func(lineCount int) int { return lineCount - MaxLinesPerRequest })
+}
+
+func benchmarkFileBackendRead(b *testing.B, startFrom func(lineCount int) int) {
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("logs_%d.json", time.Now().UnixNano()))
+	fileBackend, err := NewFileBackend(tmpFileName, DefaultMaxSizeInBytes)
+	require.Nil(b, err)
+	require.Nil(b, fileBackend.Open())
+
+	// Around 100MB of logs.
+	lineCount := 1000000
+	output := strings.Repeat("a", 80)
+	for i := 0; i < lineCount; i++ {
+		require.Nil(b, fileBackend.Write(&CommandOutputEvent{Event: "cmd_output", Output: output}))
+	}
+
+	start := startFrom(lineCount)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		next, err := fileBackend.Read(start, MaxLinesPerRequest, io.Discard)
+		require.NoError(b, err)
+		require.Equal(b, start+MaxLinesPerRequest, next)
+	}
+
+	b.StopTimer()
+	require.NoError(b, fileBackend.Close())
+}
+
+func Test__FileBackend__HeadAndTailTrimming(t *testing.T) {
+	tmpFileName := filepath.Join(t.TempDir(), "logs.json")
+	fileBackend, err := NewFileBackendWithTrimming(tmpFileName, 1400, 800)
+	require.NoError(t, err)
+	require.NoError(t, fileBackend.Open())
+
+	timestamp := 1700000000
+	startCommand := func(directive string, outputs int) {
+		require.NoError(t, fileBackend.Write(&CommandStartedEvent{Timestamp: timestamp, Event: "cmd_started", Directive: directive}))
+		for i := 1; i <= outputs; i++ {
+			output := fmt.Sprintf("%s %02d\n", directive, i)
+			require.NoError(t, fileBackend.Write(&CommandOutputEvent{Timestamp: timestamp, Event: "cmd_output", Output: output}))
+		}
+	}
+
+	finishCommand := func(directive string) {
+		require.NoError(t, fileBackend.Write(&CommandFinishedEvent{Timestamp: timestamp, Event: "cmd_finished", Directive: directive}))
+	}
+
+	require.NoError(t, fileBackend.Write(&JobStartedEvent{Timestamp: timestamp, Event: "job_started"}))
+	startCommand("first", 20)
+	finishCommand("first")
+	startCommand("second", 20)
+
+	// Once the head is full, only the output is held back,
+	// so readers following the log still see the commands as they start.
+	live := readSimplifiedEvents(t, fileBackend)
+	assert.Equal(t, "directive: second", live[len(live)-1])
+
+	finishCommand("second")
+	startCommand("third", 3)
+	finishCommand("third")
+	require.NoError(t, fileBackend.Write(&JobFinishedEvent{Timestamp: timestamp, Event: "job_finished", Result: "failed"}))
+
+	// The windows get smaller as the tail is used up, but each command keeps how it ended.
+	assert.Equal(t, []string{
+		"job_started",
+		"directive: first",
+		"first 01\n", "first 02\n", "first 03\n", "first 04\n",
+		"first 05\n", "first 06\n", "first 07\n",
+		"\n... 18 bytes omitted ...\n",
+		"first 10\n", "first 11\n", "first 12\n", "first 13\n", "first 14\n", "first 15\n",
+		"first 16\n", "first 17\n", "first 18\n", "first 19\n", "first 20\n",
+		"Exit Code: 0",
+		"directive: second",
+		"\n... 190 bytes omitted ...\n",
+		"second 20\n",
+		"Exit Code: 0",
+		"directive: third",
+		"\n... 18 bytes omitted ...\n",
+		"third 03\n",
+		"Exit Code: 0",
+		"job_finished: failed",
+	}, readSimplifiedEvents(t, fileBackend))
+
+	// The complete log is still available, e.g. for the job log artifact.
+	events := 0
+	require.NoError(t, fileBackend.Iterate(func(b []byte) error { events++; return nil }))
+	assert.Equal(t, 51, events)
+
+	trimmed := false
+	require.NoError(t, fileBackend.CloseWithOptions(CloseOptions{OnClose: func(b bool) { trimmed = b }}))
+	assert.True(t, trimmed)
+	assert.NoFileExists(t, tmpFileName)
+	assert.NoFileExists(t, fileBackend.fullPath())
+}
+
+func Test__FileBackend__NothingIsTrimmedForSmallLogs(t *testing.T) {
+	tmpFileName := filepath.Join(t.TempDir(), "logs.json")
+	fileBackend, err := NewFileBackendWithTrimming(tmpFileName, DefaultMaxSizeInBytes, 0)
+	require.NoError(t, err)
+	require.NoError(t, fileBackend.Open())
+
+	generateLogEvents(t, 10, fileBackend)
+	assert.Len(t, readSimplifiedEvents(t, fileBackend), 14)
+
+	trimmed := true
+	require.NoError(t, fileBackend.CloseWithOptions(CloseOptions{OnClose: func(b bool) { trimmed = b }}))
+	assert.False(t, trimmed)
+}
+
+func readSimplifiedEvents(t *testing.T, backend Backend) []string {
+	buf := bytes.NewBuffer([]byte{})
+	_, err := backend.Read(0, 1000000, buf)
+	require.NoError(t, err)
+
+	objects, err := TransformToObjects(strings.Split(strings.TrimSpace(buf.String()), "\n"))
+	require.NoError(t, err)
+
+	simplified, err := SimplifyLogEvents(objects, SimplifyOptions{IncludeOutput: true})
+	require.NoError(t, err)
+	return simplified
+}
@@ -0,0 +1,247 @@
+package eventlogger
+
+import (
+	"html"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+ * A self-contained HTML page, with a collapsible section for each command.
+ * Failed commands are expanded, so the failure is the first thing readers see.
+ * Colors from the command output become styles.
+ */
+func RenderHTML(source EventSource, writer io.Writer, title string) error {
+	page := htmlPage{Title: title}
+	if page.Title == "" {
+		page.Title = "Job log"
+	}
+
+	var current *htmlCommand
+	var output strings.Builder
+	finishCommand := func() {
+		if current != nil {
+			current.Output = template.HTML(ansiToHTML(output.String())) // #nosec G203 - escaped by ansiToHTML
+			page.Commands = append(page.Commands, *current)
+			current = nil
+			output.Reset()
+		}
+	}
+
+	// Output outside of a command, e.g. from an incomplete log, gets a command without a directive.
+	ensureCommand := func() {
+		if current == nil {
+			current = &htmlCommand{}
+		}
+	}
+
+	err := forEachEvent(source, func(event *renderedEvent) error {
+		switch event.Event {
+		case "cmd_started":
+			finishCommand()
+			current = &htmlCommand{Directive: event.Directive}
+		case "cmd_output":
+			ensureCommand()
+			output.WriteString(event.Output)
+		case "section_started":
+			ensureCommand()
+			output.WriteString("\x1b[1m--- " + event.Name + "\x1b[22m\n")
+		case "annotation":
+			annotation := AnnotationEvent{}
+			if err := event.decode(&annotation); err != nil {
+				return err
+			}
+
+			ensureCommand()
+			output.WriteString(annotationColor(annotation.Level) + formatAnnotation(annotation) + "\x1b[0m")
+		case "cmd_finished":
+			ensureCommand()
+			current.Finished = true
+			current.ExitCode = event.ExitCode
+			current.Duration = event.duration().String()
+			finishCommand()
+		case "job_finished":
+			finishCommand()
+			page.Result = event.Result
+		case "test_summary":
+			summary := TestSummaryEvent{}
+			if err := event.decode(&summary); err != nil {
+				return err
+			}
+
+			page.Summaries += formatTestSummary(summary.Summary)
+		case "job_summary":
+			summary := JobSummaryEvent{}
+			if err := event.decode(&summary); err != nil {
+				return err
+			}
+
+			page.Summaries += formatJobSummary(summary)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	finishCommand()
+	return htmlTemplate.Execute(writer, page)
+}
+
+type htmlPage struct {
+	Title     string
+	Result    string
+	Commands  []htmlCommand
+	Summaries string
+}
+
+type htmlCommand struct {
+	Directive string
+	Output    template.HTML
+	Finished  bool
+	ExitCode  int
+	Duration  string
+}
+
+func annotationColor(level string) string {
+	switch level {
+	case "error":
+		return "\x1b[31m"
+	case "warning":
+		return "\x1b[33m"
+	default:
+		return "\x1b[36m"
+	}
+}
+
+type ansiStyle struct {
+	bold       bool
+	foreground int
+}
+
+/*
+ * Escapes the output, and turns the SGR sequences for bold and the 16 basic colors into spans.
+ * All the other escape sequences are dropped.
+ */
+func ansiToHTML(s string) string {
+	var b strings.Builder
+	style := ansiStyle{foreground: -1}
+	spanOpen := false
+	position := 0
+
+	for _, match := range ansiEscapeSequence.FindAllStringIndex(s, -1) {
+		b.WriteString(html.EscapeString(s[position:match[0]]))
+		position = match[1]
+
+		sequence := s[match[0]:match[1]]
+		if !strings.HasPrefix(sequence, "\x1b[") || !strings.HasSuffix(sequence, "m") {
+			continue
+		}
+
+		style = style.apply(strings.TrimSuffix(strings.TrimPrefix(sequence, "\x1b["), "m"))
+		if spanOpen {
+			b.WriteString("</span>")
+			spanOpen = false
+		}
+
+		if classes := style.classes(); classes != "" {
+			b.WriteString(`<span class="` + classes + `">`)
+			spanOpen = true
+		}
+	}
+
+	b.WriteString(html.EscapeString(s[position:]))
+	if spanOpen {
+		b.WriteString("</span>")
+	}
+
+	return b.String()
+}
+
+func (s ansiStyle) apply(parameters string) ansiStyle {
+	codes := strings.Split(parameters, ";")
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			code = 0
+		}
+
+		switch {
+		case code == 0:
+			s = ansiStyle{foreground: -1}
+		case code == 1:
+			s.bold = true
+		case code == 22:
+			s.bold = false
+		case code >= 30 && code <= 37:
+			s.foreground = code - 30
+		case code >= 90 && code <= 97:
+			s.foreground = code - 90 + 8
+		case code == 39:
+			s.foreground = -1
+
+		// 256 colors and true colors are not supported, so we skip their arguments.
+		case code == 38 || code == 48:
+			if i+1 < len(codes) && codes[i+1] == "5" {
+				i += 2
+			} else if i+1 < len(codes) && codes[i+1] == "2" {
+				i += 4
+			}
+		}
+	}
+
+	return s
+}
+
+func (s ansiStyle) classes() string {
+	classes := []string{}
+	if s.bold {
+		classes = append(classes, "bold")
+	}
+
+	if s.foreground >= 0 {
+		classes = append(classes, "fg"+strconv.Itoa(s.foreground))
+	}
+
+	return strings.Join(classes, " ")
+}
+
+var htmlTemplate = template.Must(template.New("log").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { background: #1e1e1e; color: #d4d4d4; font-family: sans-serif; margin: 2em; }
+h1 { font-size: 1.4em; }
+.result { font-weight: bold; }
+.result.passed { color: #23d18b; }
+.result.failed, .result.stopped { color: #f14c4c; }
+details { border-left: 4px solid #3b3b3b; margin: 0.4em 0; padding-left: 0.6em; }
+details.passed { border-color: #23d18b; }
+details.failed { border-color: #f14c4c; }
+summary { cursor: pointer; font-family: monospace; white-space: pre-wrap; }
+.meta { color: #8c8c8c; float: right; margin-left: 1em; }
+pre { font-family: monospace; white-space: pre-wrap; word-break: break-all; margin: 0.4em 0; }
+.bold { font-weight: bold; }
+.fg0 { color: #555555; } .fg1 { color: #cd3131; } .fg2 { color: #0dbc79; } .fg3 { color: #e5e510; }
+.fg4 { color: #2472c8; } .fg5 { color: #bc3fbc; } .fg6 { color: #11a8cd; } .fg7 { color: #e5e5e5; }
+.fg8 { color: #666666; } .fg9 { color: #f14c4c; } .fg10 { color: #23d18b; } .fg11 { color: #f5f543; }
+.fg12 { color: #3b8eea; } .fg13 { color: #d670d6; } .fg14 { color: #29b8db; } .fg15 { color: #ffffff; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Result}}<p class="result {{.Result}}">Job {{.Result}}</p>
+{{end}}{{range .Commands}}<details class="{{if not .Finished}}unfinished{{else if eq .ExitCode 0}}passed{{else}}failed{{end}}"{{if and .Finished (ne .ExitCode 0)}} open{{end}}>
+<summary><span class="meta">{{if .Finished}}exit code {{.ExitCode}} · {{.Duration}}{{else}}not finished{{end}}</span>{{.Directive}}</summary>
+<pre>{{.Output}}</pre>
+</details>
+{{end}}{{if .Summaries}}<pre class="summaries">{{.Summaries}}</pre>
+{{end}}</body>
+</html>
+`))
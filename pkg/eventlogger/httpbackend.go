@@ -2,6 +2,7 @@ package eventlogger
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -20,16 +21,27 @@ const (
 	MaxLinesPerRequest           = 2000
 	MaxFlushTimeoutInSeconds     = 900
 	DefaultFlushTimeoutInSeconds = 60
+	DefaultBytesPerRequest       = 1024 * 1024
 )
 
 type HTTPBackend struct {
 	client      *http.Client
-	fileBackend FileBackend
+	fileBackend *FileBackend
 	startFrom   int
 	config      HTTPBackendConfig
 	stop        bool
 	flush       bool
 	useArtifact bool
+
+	// Compression is disabled if the server does not accept it.
+	compress  bool
+	rawBytes  int64
+	sentBytes int64
+
+	// The API rejected the logs, so only their end is sent.
+	rejected          bool
+	tailSizeInBytes   int
+	rejectedTailBytes int64
 }
 
 type HTTPBackendConfig struct {
@@ -39,8 +51,25 @@ type HTTPBackendConfig struct {
 	LinesPerRequest       int
 	FlushTimeoutInSeconds int
 	RefreshTokenFn        func() (string, error)
+
+	// Zero means the requests are only limited by the number of lines.
+	BytesPerRequest int
+
+	// Sends the requests with a gzip-compressed body,
+	// unless the server responds with a 415 to them.
+	Compression bool
+
+	// Logs bigger than this keep their beginning and their end, see NewFileBackendWithTrimming().
+	// Zero means the logs are sent until the API rejects them,
+	// and then only the end of the logs is sent, when the job finishes.
+	MaxSizeInBytes  int
+	TailSizeInBytes int
 }
 
+// How much output is sent with the end of the logs, after the API rejects them,
+// unless the config says otherwise. It is halved every time the API rejects it too.
+const DefaultRejectedTailSizeInBytes = 262144
+
 func NewHTTPBackend(config HTTPBackendConfig) (*HTTPBackend, error) {
 	if config.LinesPerRequest <= 0 || config.LinesPerRequest > MaxLinesPerRequest {
 		return nil, fmt.Errorf("config.LinesPerRequest must be between 1 and %d", MaxLinesPerRequest)
@@ -50,12 +79,27 @@ func NewHTTPBackend(config HTTPBackendConfig) (*HTTPBackend, error) {
 		return nil, fmt.Errorf("config.FlushTimeoutInSeconds must be between 1 and %d", MaxFlushTimeoutInSeconds)
 	}
 
+	if config.BytesPerRequest < 0 {
+		return nil, fmt.Errorf("config.BytesPerRequest cannot be negative")
+	}
+
+	if config.MaxSizeInBytes < 0 {
+		return nil, fmt.Errorf("config.MaxSizeInBytes cannot be negative")
+	}
+
 	path := filepath.Join(os.TempDir(), fmt.Sprintf("job_log_%d.json", time.Now().UnixNano()))
 
-	// The API will instruct the HTTP backend when to stop
+	// Without a max size, the API will instruct the HTTP backend when to stop
 	// streaming logs due to their size hitting the limits.
 	// We don't need to impose any limits on the underlying file backend.
-	fileBackend, err := NewFileBackend(path, math.MaxInt32)
+	var fileBackend *FileBackend
+	var err error
+	if config.MaxSizeInBytes > 0 {
+		fileBackend, err = NewFileBackendWithTrimming(path, config.MaxSizeInBytes, config.TailSizeInBytes)
+	} else {
+		fileBackend, err = NewFileBackend(path, math.MaxInt32)
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -64,9 +108,10 @@ func NewHTTPBackend(config HTTPBackendConfig) (*HTTPBackend, error) {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		fileBackend: *fileBackend,
+		fileBackend: fileBackend,
 		startFrom:   0,
 		config:      config,
+		compress:    config.Compression,
 	}
 
 	go httpBackend.push()
@@ -97,8 +142,8 @@ func (l *HTTPBackend) push() {
 
 		/*
 		 * Check if streaming is necessary. There are three cases where it isn't necessary anymore:
-		 *   1. The job has exhausted the amount of log space it has available.
-		 *      The API will reject all subsequent attempts, so we just stop trying.
+		 *   1. The job has exhausted the amount of log space it has available,
+		 *      and the end of the logs was already pushed, or it didn't fit either.
 		 *   2. The job is finished and all the logs were already pushed.
 		 *   3. The job is finished, not all logs were pushed, but we gave up because it was taking too long.
 		 */
@@ -125,7 +170,7 @@ func (l *HTTPBackend) push() {
 		}
 	}
 
-	log.Info("Stopped pushing logs.")
+	log.Infof("Stopped pushing logs - %s.", formatTransferredBytes(l.rawBytes, l.sentBytes))
 }
 
 /*
@@ -154,7 +199,25 @@ func (l *HTTPBackend) delay() time.Duration {
 
 func (l *HTTPBackend) newRequest() error {
 	buffer := bytes.NewBuffer([]byte{})
-	nextStartFrom, err := l.fileBackend.Read(l.startFrom, l.config.LinesPerRequest, buffer)
+	var nextStartFrom int
+	var err error
+
+	/*
+	 * Once the API rejects the logs, only their end is sent, in a single request,
+	 * with the events other than output, and the last output before each of them.
+	 * We only know what the end is when the job is done.
+	 */
+	if l.rejected {
+		if !l.flush {
+			log.Infof("No more space available for logs - waiting for the job to finish to push their end")
+			return nil
+		}
+
+		nextStartFrom, err = l.fileBackend.readTail(l.startFrom, l.tailSizeInBytes, buffer)
+	} else {
+		nextStartFrom, err = l.fileBackend.ReadWithMaxBytes(l.startFrom, l.config.LinesPerRequest, l.config.BytesPerRequest, buffer)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -175,15 +238,33 @@ func (l *HTTPBackend) newRequest() error {
 		return nil
 	}
 
-	log.Infof("Pushing next batch of logs with %d log events...", (nextStartFrom - l.startFrom))
+	rawBytes := int64(buffer.Len())
+	compressed := l.compress
+	body := buffer.Bytes()
+	if compressed {
+		body, err = gzipCompress(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Pushing next batch of logs with %d log events - %s...", (nextStartFrom - l.startFrom), formatTransferredBytes(rawBytes, int64(len(body))))
 	url := fmt.Sprintf("%s?start_from=%d", l.config.URL, l.startFrom)
-	request, err := http.NewRequest("POST", url, buffer)
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
 	request.Header.Set("Content-Type", "text/plain")
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", l.config.Token))
+	if compressed {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	// Log sinks may not need a token.
+	if l.config.Token != "" {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", l.config.Token))
+	}
+
 	request.Header.Set("User-Agent", l.config.UserAgent)
 	response, err := l.client.Do(request)
 	if err != nil {
@@ -196,14 +277,49 @@ func (l *HTTPBackend) newRequest() error {
 	// just update the index and move on.
 	case http.StatusOK:
 		l.startFrom = nextStartFrom
+		l.rawBytes += rawBytes
+		l.sentBytes += int64(len(body))
+		if l.rejected {
+			log.Infof("Pushed the end of the logs - stopping")
+			l.stop = true
+		}
+
 		return nil
 
+	// The server does not accept compressed logs.
+	// We stop compressing them, and the same batch is sent again in the next request.
+	case http.StatusUnsupportedMediaType:
+		if !compressed {
+			return fmt.Errorf("request to %s failed: %s", url, response.Status)
+		}
+
+		l.compress = false
+		return fmt.Errorf("%s does not accept compressed logs - sending them uncompressed from now on", l.config.URL)
+
 	// No more space is available for this job's logs.
-	// The API will keep rejecting the requests if we keep sending them, so just stop.
+	// The API will keep rejecting big requests, but the end of the logs may still fit,
+	// so we try with less and less output, until it does, or there's no output left to leave out.
 	case http.StatusUnprocessableEntity:
-		l.stop = true
 		l.useArtifact = true
-		return errors.New("no more space available for logs - stopping")
+		if !l.rejected {
+			l.rejected = true
+			l.tailSizeInBytes = DefaultRejectedTailSizeInBytes
+			if l.config.TailSizeInBytes > 0 {
+				l.tailSizeInBytes = l.config.TailSizeInBytes
+			}
+
+			return errors.New("no more space available for logs - only their end will be pushed, when the job finishes")
+		}
+
+		// Once the output left out doesn't make it any smaller, there's nothing else to try.
+		if l.tailSizeInBytes == 0 || (l.rejectedTailBytes > 0 && rawBytes >= l.rejectedTailBytes) {
+			l.stop = true
+			return errors.New("no space available for the end of the logs either - stopping")
+		}
+
+		l.rejectedTailBytes = rawBytes
+		l.tailSizeInBytes = min(l.tailSizeInBytes, int(rawBytes)) / 2
+		return fmt.Errorf("no space available for the end of the logs - trying again with %s of output", formatBytes(int64(l.tailSizeInBytes)))
 
 	// The token issued for the agent expired.
 	// Try to refresh the token and try again.
@@ -229,6 +345,10 @@ func (l *HTTPBackend) CloseWithOptions(options CloseOptions) error {
 	 * We wait for them to be flushed for a period of time (60s).
 	 * If they are not yet completely flushed after that period of time, we give up.
 	 */
+	if err := l.fileBackend.flushHeldEvents(); err != nil {
+		log.Errorf("Error writing held back log events: %v", err)
+	}
+
 	l.flush = true
 
 	log.Printf("Waiting for all logs to be flushed...")
@@ -247,7 +367,7 @@ func (l *HTTPBackend) CloseWithOptions(options CloseOptions) error {
 	})
 
 	if options.OnClose != nil {
-		options.OnClose(l.useArtifact)
+		options.OnClose(l.useArtifact || l.fileBackend.omittedBytes() > 0)
 	}
 
 	if err != nil {
@@ -261,3 +381,22 @@ func (l *HTTPBackend) CloseWithOptions(options CloseOptions) error {
 func (l *HTTPBackend) Close() error {
 	return l.CloseWithOptions(CloseOptions{})
 }
+
+func gzipCompress(data []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer([]byte{})
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("error compressing logs: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing logs: %v", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// e.g. "120.0KiB of logs sent as 15.2KiB"
+func formatTransferredBytes(rawBytes, sentBytes int64) string {
+	return fmt.Sprintf("%s of logs sent as %s", formatBytes(rawBytes), formatBytes(sentBytes))
+}
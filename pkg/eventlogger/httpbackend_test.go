@@ -82,6 +82,19 @@ func Test__ArgumentsMustBeValid(t *testing.T) {
 		assert.Nil(t, backend)
 		assert.ErrorContains(t, err, "must be between 1 and 900")
 	})
+	t.Run("BytesPerRequest cannot be negative", func(t *testing.T) {
+		backend, err := NewHTTPBackend(HTTPBackendConfig{
+			URL:                   "whatever",
+			Token:                 "token",
+			RefreshTokenFn:        func() (string, error) { return "", nil },
+			LinesPerRequest:       MaxLinesPerRequest,
+			FlushTimeoutInSeconds: DefaultFlushTimeoutInSeconds,
+			BytesPerRequest:       -1,
+		})
+
+		assert.Nil(t, backend)
+		assert.ErrorContains(t, err, "cannot be negative")
+	})
 }
 
 func Test__LogsArePushedToHTTPEndpoint(t *testing.T) {
@@ -240,6 +253,86 @@ func Test__ExecutesOnCloseCallback(t *testing.T) {
 	mockServer.Close()
 }
 
+func Test__EndOfLogsIsPushedAfterTheyAreRejected(t *testing.T) {
+	mockServer := testsupport.NewLoghubMockServer()
+	mockServer.Init()
+	mockServer.SetMaxSizeForLogs(55)
+	mockServer.RejectBatchesOverMaxSize = true
+
+	httpBackend, err := NewHTTPBackend(HTTPBackendConfig{
+		URL:                   mockServer.URL(),
+		Token:                 "token",
+		RefreshTokenFn:        func() (string, error) { return "", nil },
+		LinesPerRequest:       10,
+		FlushTimeoutInSeconds: 10,
+		UserAgent:             fmt.Sprintf("SemaphoreAgent/%s", testsupport.AgentVersionExpected),
+		TailSizeInBytes:       1000,
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, httpBackend.Open())
+
+	generateLogEvents(t, 100, httpBackend)
+
+	trimmed := false
+	_ = httpBackend.CloseWithOptions(CloseOptions{OnClose: func(b bool) { trimmed = b }})
+	assert.True(t, trimmed)
+
+	eventObjects, err := TransformToObjects(mockServer.GetLogs())
+	assert.Nil(t, err)
+
+	simplifiedEvents, err := SimplifyLogEvents(eventObjects, SimplifyOptions{IncludeOutput: true})
+	assert.Nil(t, err)
+
+	// The 50 events accepted before the API rejected the logs,
+	// and the end of the logs, with the output halved until it fits.
+	assert.Len(t, simplifiedEvents, 54)
+	assert.Equal(t, []string{"job_started", "directive: echo hello", "hello\n"}, simplifiedEvents[:3])
+	assert.Equal(t, []string{"hello\n", "Exit Code: 0", "job_finished: passed"}, simplifiedEvents[len(simplifiedEvents)-3:])
+	assert.Regexp(t, `^\n\.\.\. \d+ bytes omitted \.\.\.\n$`, simplifiedEvents[50])
+
+	mockServer.Close()
+}
+
+func Test__LogsAreTrimmedWithHeadAndTail(t *testing.T) {
+	mockServer := testsupport.NewLoghubMockServer()
+	mockServer.Init()
+
+	httpBackend, err := NewHTTPBackend(HTTPBackendConfig{
+		URL:                   mockServer.URL(),
+		Token:                 "token",
+		RefreshTokenFn:        func() (string, error) { return "", nil },
+		LinesPerRequest:       MaxLinesPerRequest,
+		FlushTimeoutInSeconds: 10,
+		UserAgent:             fmt.Sprintf("SemaphoreAgent/%s", testsupport.AgentVersionExpected),
+		MaxSizeInBytes:        2000,
+		TailSizeInBytes:       500,
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, httpBackend.Open())
+
+	generateLogEvents(t, 100, httpBackend)
+
+	trimmed := false
+	_ = httpBackend.CloseWithOptions(CloseOptions{OnClose: func(b bool) { trimmed = b }})
+	assert.True(t, trimmed)
+
+	eventObjects, err := TransformToObjects(mockServer.GetLogs())
+	assert.Nil(t, err)
+
+	simplifiedEvents, err := SimplifyLogEvents(eventObjects, SimplifyOptions{IncludeOutput: true})
+	assert.Nil(t, err)
+
+	// the structure of the log and its end are kept
+	assert.Equal(t, []string{"job_started", "directive: echo hello", "hello\n"}, simplifiedEvents[:3])
+	assert.Contains(t, simplifiedEvents, "\n... 432 bytes omitted ...\n")
+	assert.Equal(t, []string{"hello\n", "Exit Code: 0", "job_finished: passed"}, simplifiedEvents[len(simplifiedEvents)-3:])
+	assert.Less(t, len(simplifiedEvents), 100)
+
+	mockServer.Close()
+}
+
 func Test__TokenIsRefreshed(t *testing.T) {
 	mockServer := testsupport.NewLoghubMockServer()
 	mockServer.Init()
@@ -284,6 +377,103 @@ func Test__TokenIsRefreshed(t *testing.T) {
 	mockServer.Close()
 }
 
+func Test__RequestsAreCappedAtBytesPerRequest(t *testing.T) {
+	mockServer := testsupport.NewLoghubMockServer()
+	mockServer.Init()
+
+	httpBackend, err := NewHTTPBackend(HTTPBackendConfig{
+		URL:                   mockServer.URL(),
+		Token:                 "token",
+		RefreshTokenFn:        func() (string, error) { return "", nil },
+		LinesPerRequest:       MaxLinesPerRequest,
+		BytesPerRequest:       256,
+		FlushTimeoutInSeconds: DefaultFlushTimeoutInSeconds,
+		UserAgent:             fmt.Sprintf("SemaphoreAgent/%s", testsupport.AgentVersionExpected),
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, httpBackend.Open())
+
+	generateLogEvents(t, 10, httpBackend)
+	_ = httpBackend.Close()
+
+	assert.Greater(t, len(mockServer.BatchBytesUsed), 1)
+	for _, batchBytes := range mockServer.BatchBytesUsed {
+		assert.LessOrEqual(t, batchBytes, 256)
+	}
+
+	assert.Len(t, mockServer.GetLogs(), 14)
+	mockServer.Close()
+}
+
+func Test__LogsAreCompressed(t *testing.T) {
+	mockServer := testsupport.NewLoghubMockServer()
+	mockServer.Init()
+
+	httpBackend, err := NewHTTPBackend(HTTPBackendConfig{
+		URL:                   mockServer.URL(),
+		Token:                 "token",
+		RefreshTokenFn:        func() (string, error) { return "", nil },
+		LinesPerRequest:       MaxLinesPerRequest,
+		FlushTimeoutInSeconds: DefaultFlushTimeoutInSeconds,
+		UserAgent:             fmt.Sprintf("SemaphoreAgent/%s", testsupport.AgentVersionExpected),
+		Compression:           true,
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, httpBackend.Open())
+
+	generateLogEvents(t, 100, httpBackend)
+	_ = httpBackend.Close()
+
+	assert.Greater(t, mockServer.CompressedRequests, 0)
+	assert.Len(t, mockServer.GetLogs(), 104)
+	assert.Less(t, httpBackend.sentBytes, httpBackend.rawBytes)
+	mockServer.Close()
+}
+
+func Test__CompressionIsDisabledIfServerDoesNotAcceptIt(t *testing.T) {
+	mockServer := testsupport.NewLoghubMockServer()
+	mockServer.RejectCompression = true
+	mockServer.Init()
+
+	httpBackend, err := NewHTTPBackend(HTTPBackendConfig{
+		URL:                   mockServer.URL(),
+		Token:                 "token",
+		RefreshTokenFn:        func() (string, error) { return "", nil },
+		LinesPerRequest:       MaxLinesPerRequest,
+		FlushTimeoutInSeconds: DefaultFlushTimeoutInSeconds,
+		UserAgent:             fmt.Sprintf("SemaphoreAgent/%s", testsupport.AgentVersionExpected),
+		Compression:           true,
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, httpBackend.Open())
+
+	generateLogEvents(t, 1, httpBackend)
+	_ = httpBackend.Close()
+
+	eventObjects, err := TransformToObjects(mockServer.GetLogs())
+	assert.Nil(t, err)
+
+	simplifiedEvents, err := SimplifyLogEvents(eventObjects, SimplifyOptions{IncludeOutput: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"job_started",
+
+		"directive: echo hello",
+		"hello\n",
+		"Exit Code: 0",
+
+		"job_finished: passed",
+	}, simplifiedEvents)
+
+	assert.Zero(t, mockServer.CompressedRequests)
+	assert.False(t, httpBackend.compress)
+	mockServer.Close()
+}
+
 func generateLogEventsWithOutputGenerator(t testing.TB, outputEventsCount int, backend Backend, outputGenerator func() string) {
 	timestamp := int(time.Now().Unix())
 
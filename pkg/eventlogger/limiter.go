@@ -0,0 +1,216 @@
+package eventlogger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+ * Limits on the command output, so a command which floods it
+ * can't use the whole log budget, or slow down the agent sending it.
+ * Zero means no limit.
+ */
+type OutputLimits struct {
+	CommandBytes   int64
+	JobBytes       int64
+	BytesPerSecond int64
+	LinesPerSecond int64
+}
+
+func (l OutputLimits) Enabled() bool {
+	return l.CommandBytes > 0 || l.JobBytes > 0 || l.BytesPerSecond > 0 || l.LinesPerSecond > 0
+}
+
+func (l OutputLimits) Validate() error {
+	if l.CommandBytes < 0 || l.JobBytes < 0 || l.BytesPerSecond < 0 || l.LinesPerSecond < 0 {
+		return fmt.Errorf("output limits can't be negative")
+	}
+
+	return nil
+}
+
+// A part of the output let through by the limiter, or a notice about the output dropped.
+type limitedOutput struct {
+	Text   string
+	Notice bool
+}
+
+/*
+ * The output over the per-command and per-job caps is dropped, with a notice when the cap is reached.
+ * The output over the rate limits is dropped until the second is over,
+ * and a notice with how much was dropped is written when the next second starts.
+ * The output is still read from the command while it is dropped, so the command is never blocked.
+ */
+type outputLimiter struct {
+	limits OutputLimits
+	now    func() time.Time
+
+	jobBytes     int64
+	commandBytes int64
+	capReached   bool
+
+	windowStart        time.Time
+	windowBytes        int64
+	windowLines        int64
+	windowDroppedBytes int64
+	windowDroppedLines int64
+
+	throttling OutputThrottling
+}
+
+func newOutputLimiter(limits OutputLimits) *outputLimiter {
+	return &outputLimiter{limits: limits, now: time.Now}
+}
+
+func (l *outputLimiter) StartCommand() {
+	l.commandBytes = 0
+	l.capReached = false
+	l.throttling = OutputThrottling{}
+}
+
+func (l *outputLimiter) Limit(output string) []limitedOutput {
+	parts := []limitedOutput{}
+
+	now := l.now()
+	if now.Sub(l.windowStart) >= time.Second {
+		parts = l.closeWindow(parts)
+		l.windowStart = now
+	}
+
+	capEnd := cutOutput(output, remaining(l.limits.CommandBytes, l.commandBytes), -1)
+	capEnd = cutOutput(output[:capEnd], remaining(l.limits.JobBytes, l.jobBytes), -1)
+
+	rateEnd := cutOutput(
+		output[:capEnd],
+		remaining(l.limits.BytesPerSecond, l.windowBytes),
+		remaining(l.limits.LinesPerSecond, l.windowLines),
+	)
+
+	allowed := output[:rateEnd]
+	if allowed != "" {
+		parts = append(parts, limitedOutput{Text: allowed})
+	}
+
+	l.commandBytes += int64(len(allowed))
+	l.jobBytes += int64(len(allowed))
+	l.windowBytes += int64(len(allowed))
+	l.windowLines += int64(strings.Count(allowed, "\n"))
+
+	if rateEnd < capEnd {
+		l.windowDroppedBytes += int64(capEnd - rateEnd)
+		l.windowDroppedLines += int64(strings.Count(output[rateEnd:capEnd], "\n"))
+	}
+
+	if capEnd < len(output) {
+		l.throttling.DroppedBytes += int64(len(output) - capEnd)
+		l.throttling.DroppedLines += int64(strings.Count(output[capEnd:], "\n"))
+		if !l.capReached {
+			l.capReached = true
+			parts = append(parts, limitedOutput{Text: l.reachCap(), Notice: true})
+		}
+	}
+
+	return parts
+}
+
+// Returns the notices for the output dropped in the command,
+// and what was dropped, if anything.
+func (l *outputLimiter) FinishCommand() ([]limitedOutput, *OutputThrottling) {
+	parts := l.closeWindow([]limitedOutput{})
+	l.windowStart = time.Time{}
+
+	if l.capReached {
+		parts = append(parts, limitedOutput{
+			Notice: true,
+			Text: fmt.Sprintf(
+				"\n[agent] %s (%d lines) of output over the limits were dropped\n",
+				formatBytes(l.throttling.DroppedBytes),
+				l.throttling.DroppedLines,
+			),
+		})
+	}
+
+	if l.throttling.DroppedBytes == 0 {
+		return parts, nil
+	}
+
+	throttling := l.throttling
+	return parts, &throttling
+}
+
+func (l *outputLimiter) closeWindow(parts []limitedOutput) []limitedOutput {
+	l.windowBytes = 0
+	l.windowLines = 0
+	if l.windowDroppedBytes == 0 {
+		return parts
+	}
+
+	notice := fmt.Sprintf(
+		"\n[agent] Output over the rate limit - %s (%d lines) dropped in the last second\n",
+		formatBytes(l.windowDroppedBytes),
+		l.windowDroppedLines,
+	)
+
+	l.throttling.DroppedBytes += l.windowDroppedBytes
+	l.throttling.DroppedLines += l.windowDroppedLines
+	l.throttling.ThrottledSeconds++
+	l.windowDroppedBytes = 0
+	l.windowDroppedLines = 0
+	return append(parts, limitedOutput{Text: notice, Notice: true})
+}
+
+func (l *outputLimiter) reachCap() string {
+	if l.limits.CommandBytes > 0 && l.commandBytes >= l.limits.CommandBytes {
+		l.throttling.CommandLimitReached = true
+		return fmt.Sprintf("\n[agent] Output limit of %s per command reached - dropping the rest of the output\n", formatBytes(l.limits.CommandBytes))
+	}
+
+	l.throttling.JobLimitReached = true
+	return fmt.Sprintf("\n[agent] Output limit of %s per job reached - dropping the rest of the output\n", formatBytes(l.limits.JobBytes))
+}
+
+// A negative result means there's no limit.
+func remaining(limit, used int64) int64 {
+	if limit <= 0 {
+		return -1
+	}
+
+	return max(limit-used, 0)
+}
+
+// The length of the beginning of the output with at most the given bytes and lines,
+// without cutting a character in half. A negative limit means there's no limit.
+func cutOutput(output string, maxBytes, maxLines int64) int {
+	end := len(output)
+	if maxBytes >= 0 && int64(end) > maxBytes {
+		end = int(maxBytes)
+		for end > 0 && !utf8.RuneStart(output[end]) {
+			end--
+		}
+	}
+
+	if maxLines < 0 {
+		return end
+	}
+
+	lines := int64(0)
+	for i := 0; i < end; i++ {
+		if output[i] != '\n' {
+			continue
+		}
+
+		lines++
+		if lines == maxLines {
+			return i + 1
+		}
+	}
+
+	// Without any lines left, not even a partial line goes through.
+	if maxLines == 0 {
+		return 0
+	}
+
+	return end
+}
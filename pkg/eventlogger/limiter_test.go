@@ -0,0 +1,136 @@
+package eventlogger
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__OutputLimiter__RateLimits(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newOutputLimiter(OutputLimits{LinesPerSecond: 2, BytesPerSecond: 100})
+	limiter.now = func() time.Time { return now }
+	limiter.StartCommand()
+
+	assert.Equal(t, []limitedOutput{{Text: "one\ntwo\n"}}, limiter.Limit("one\ntwo\nthree\nfour"))
+	assert.Empty(t, limiter.Limit("more\n"))
+
+	// The notice for the second before goes first.
+	now = now.Add(time.Second)
+	assert.Equal(t, []limitedOutput{
+		{Text: "\n[agent] Output over the rate limit - 15B (2 lines) dropped in the last second\n", Notice: true},
+		{Text: "five\n"},
+	}, limiter.Limit("five\n"))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, []limitedOutput{{Text: strings.Repeat("x", 100)}}, limiter.Limit(strings.Repeat("x", 150)))
+
+	notices, throttling := limiter.FinishCommand()
+	assert.Equal(t, []limitedOutput{
+		{Text: "\n[agent] Output over the rate limit - 50B (0 lines) dropped in the last second\n", Notice: true},
+	}, notices)
+
+	assert.Equal(t, &OutputThrottling{DroppedBytes: 65, DroppedLines: 2, ThrottledSeconds: 2}, throttling)
+
+	// Nothing is reported for the next command, if nothing is dropped.
+	limiter.StartCommand()
+	assert.Equal(t, []limitedOutput{{Text: "six\n"}}, limiter.Limit("six\n"))
+	notices, throttling = limiter.FinishCommand()
+	assert.Empty(t, notices)
+	assert.Nil(t, throttling)
+}
+
+func Test__OutputLimiter__Caps(t *testing.T) {
+	limiter := newOutputLimiter(OutputLimits{CommandBytes: 10, JobBytes: 15})
+
+	limiter.StartCommand()
+	assert.Equal(t, []limitedOutput{{Text: "12345\n"}}, limiter.Limit("12345\n"))
+	assert.Equal(t, []limitedOutput{
+		{Text: "6789"},
+		{Text: "\n[agent] Output limit of 10B per command reached - dropping the rest of the output\n", Notice: true},
+	}, limiter.Limit("67890\n"))
+	assert.Empty(t, limiter.Limit("more\n"))
+
+	notices, throttling := limiter.FinishCommand()
+	assert.Equal(t, []limitedOutput{
+		{Text: "\n[agent] 7B (2 lines) of output over the limits were dropped\n", Notice: true},
+	}, notices)
+
+	assert.Equal(t, &OutputThrottling{DroppedBytes: 7, DroppedLines: 2, CommandLimitReached: true}, throttling)
+
+	// The job cap is shared by all the commands.
+	limiter.StartCommand()
+	assert.Equal(t, []limitedOutput{
+		{Text: "abcde"},
+		{Text: "\n[agent] Output limit of 15B per job reached - dropping the rest of the output\n", Notice: true},
+	}, limiter.Limit("abcdef\n"))
+
+	_, throttling = limiter.FinishCommand()
+	require.NotNil(t, throttling)
+	assert.True(t, throttling.JobLimitReached)
+	assert.False(t, throttling.CommandLimitReached)
+}
+
+func Test__CutOutput(t *testing.T) {
+	assert.Equal(t, 4, cutOutput("one\ntwo\n", -1, 1))
+	assert.Equal(t, 0, cutOutput("one", -1, 0))
+	assert.Equal(t, 3, cutOutput("one", -1, 1))
+	assert.Equal(t, 2, cutOutput("one\ntwo\n", 2, 1))
+
+	// Characters are not cut in half.
+	assert.Equal(t, 1, cutOutput("aé", 2, -1))
+}
+
+func Test__LogWithOutputLimits(t *testing.T) {
+	backend, _ := NewInMemoryBackend()
+	logger, _ := NewLogger(backend)
+	logger.SetOutputLimits(OutputLimits{CommandBytes: 6})
+
+	logger.LogCommandStarted("yes")
+	logger.LogCommandOutput("y\ny\ny\ny\n")
+	logger.LogCommandFinished("yes", 0, 0, 0)
+
+	events, err := backend.SimplifiedEvents(true, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"directive: yes",
+		"y\ny\ny\n",
+		"\n[agent] Output limit of 6B per command reached - dropping the rest of the output\n",
+		"\n[agent] 2B (1 lines) of output over the limits were dropped\n",
+		"Exit Code: 0",
+	}, events)
+
+	finished := backend.Events[len(backend.Events)-1].(*CommandFinishedEvent)
+	assert.Equal(t, &OutputThrottling{DroppedBytes: 2, DroppedLines: 1, CommandLimitReached: true}, finished.OutputThrottling)
+}
+
+func Test__LogWithOutputLimits__MasksBeforeLimiting(t *testing.T) {
+	backend, _ := NewInMemoryBackend()
+	logger, _ := NewLogger(backend)
+	logger.AddMaskedValues("supersecretvalue")
+	logger.SetOutputLimits(OutputLimits{CommandBytes: 10})
+
+	// Without masking first, the cap would fall inside the secret.
+	logger.LogCommandStarted("echo")
+	logger.LogCommandOutput("abcdef super")
+	logger.LogCommandOutput("secretvalue\n")
+	logger.LogCommandFinished("echo", 0, 0, 0)
+
+	events, err := backend.SimplifiedEvents(true, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"directive: echo",
+		"abcdef ",
+		MaskReplacement,
+		"\n[agent] Output limit of 10B per command reached - dropping the rest of the output\n",
+		"\n[agent] 1B (1 lines) of output over the limits were dropped\n",
+		"Exit Code: 0",
+	}, events)
+
+	for _, event := range events {
+		assert.NotContains(t, event, "sup")
+	}
+}
@@ -2,20 +2,58 @@ package eventlogger
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/semaphoreci/agent/pkg/resources"
+	"github.com/semaphoreci/agent/pkg/testreports"
 	log "github.com/sirupsen/logrus"
 )
 
 type Logger struct {
-	Backend Backend
+	Backend    Backend
+	masker     *Masker
+	directives *DirectiveParser
+	sections   []string
+
+	// The stream of the output held back by the masker and the directive parser.
+	stream string
+
+	// Only there if output limits are set.
+	limiter *outputLimiter
+
+	// Used for the monotonic duration in cmd_finished.
+	commandStartedAt time.Time
 }
 
 func NewLogger(backend Backend) (*Logger, error) {
-	return &Logger{Backend: backend}, nil
+	return &Logger{Backend: backend, directives: NewDirectiveParser()}, nil
+}
+
+/*
+ * From this point on, the values are replaced with a mask in the command output.
+ */
+func (l *Logger) AddMaskedValues(values ...string) {
+	if l.masker == nil {
+		l.masker = NewMasker()
+	}
+
+	l.masker.AddValues(values...)
+}
+
+/*
+ * From this point on, the command output over the limits is dropped.
+ */
+func (l *Logger) SetOutputLimits(limits OutputLimits) {
+	if !limits.Enabled() {
+		l.limiter = nil
+		return
+	}
+
+	l.limiter = newOutputLimiter(limits)
 }
 
 func (l *Logger) Open() error {
@@ -39,31 +77,9 @@ func (l *Logger) GeneratePlainTextFileIn(directory string) (string, error) {
 	defer tmpFile.Close()
 
 	bufferedWriter := bufio.NewWriterSize(tmpFile, 64*1024)
-	err = l.Backend.Iterate(func(event []byte) error {
-		var object map[string]interface{}
-		err := json.Unmarshal(event, &object)
-		if err != nil {
-			return fmt.Errorf("error unmarshaling log event '%s': %v", string(event), err)
-		}
-
-		switch eventType := object["event"].(string); {
-		case eventType == "cmd_started":
-			if _, err := bufferedWriter.WriteString(object["directive"].(string) + "\n"); err != nil {
-				return fmt.Errorf("error writing to output: %v", err)
-			}
-		case eventType == "cmd_output":
-			if _, err := bufferedWriter.WriteString(object["output"].(string)); err != nil {
-				return fmt.Errorf("error writing to output: %v", err)
-			}
-		default:
-			// We can ignore all the other event types here
-		}
-
-		return nil
-	})
-
+	err = RenderPlainText(l.Backend, bufferedWriter, false)
 	if err != nil {
-		return "", fmt.Errorf("error iterating on log backend: %v", err)
+		return "", err
 	}
 
 	err = bufferedWriter.Flush()
@@ -83,9 +99,12 @@ func (l *Logger) GeneratePlainTextFile() (string, error) {
 }
 
 func (l *Logger) LogJobStarted() {
+	now := time.Now()
 	event := &JobStartedEvent{
-		Timestamp: int(time.Now().Unix()),
-		Event:     "job_started",
+		Timestamp:     int(now.Unix()),
+		TimestampMs:   now.UnixMilli(),
+		Event:         "job_started",
+		SchemaVersion: SchemaVersion,
 	}
 
 	err := l.Backend.Write(event)
@@ -95,10 +114,14 @@ func (l *Logger) LogJobStarted() {
 }
 
 func (l *Logger) LogJobFinished(result string) {
+	l.flushCommandOutput()
+
+	now := time.Now()
 	event := &JobFinishedEvent{
-		Timestamp: int(time.Now().Unix()),
-		Event:     "job_finished",
-		Result:    result,
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "job_finished",
+		Result:      result,
 	}
 
 	err := l.Backend.Write(event)
@@ -108,10 +131,19 @@ func (l *Logger) LogJobFinished(result string) {
 }
 
 func (l *Logger) LogCommandStarted(directive string) {
+	l.flushCommandOutput()
+
+	if l.limiter != nil {
+		l.limiter.StartCommand()
+	}
+
+	now := time.Now()
+	l.commandStartedAt = now
 	event := &CommandStartedEvent{
-		Timestamp: int(time.Now().Unix()),
-		Event:     "cmd_started",
-		Directive: directive,
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "cmd_started",
+		Directive:   directive,
 	}
 
 	err := l.Backend.Write(event)
@@ -121,10 +153,133 @@ func (l *Logger) LogCommandStarted(directive string) {
 }
 
 func (l *Logger) LogCommandOutput(output string) {
+	l.LogCommandStreamOutput("", output)
+}
+
+/*
+ * The output held back from the other stream is written first,
+ * so the events are in the same order the output came in.
+ */
+func (l *Logger) LogCommandStreamOutput(stream, output string) {
+	if stream != l.stream {
+		l.flushCommandOutput()
+		l.stream = stream
+	}
+
+	if l.masker != nil {
+		output = l.masker.Mask(output)
+		if output == "" {
+			return
+		}
+	}
+
+	l.limitCommandOutput(output)
+}
+
+// The output is masked before it is limited, so the limits never cut through a secret.
+func (l *Logger) limitCommandOutput(output string) {
+	if l.limiter == nil {
+		l.writeOutputParts(l.directives.Parse(output))
+		return
+	}
+
+	l.writeLimitedOutput(l.limiter.Limit(output))
+}
+
+// The notices go right after the output held back by the directive parser before them.
+func (l *Logger) writeLimitedOutput(parts []limitedOutput) {
+	for _, part := range parts {
+		if part.Notice {
+			l.writeOutputParts(l.directives.Flush())
+			l.writeCommandOutput(part.Text)
+		} else {
+			l.writeOutputParts(l.directives.Parse(part.Text))
+		}
+	}
+}
+
+// Output held back by the masker and the directive parser is written before the next event.
+func (l *Logger) flushCommandOutput() {
+	if l.masker != nil {
+		if output := l.masker.Flush(); output != "" {
+			l.limitCommandOutput(output)
+		}
+	}
+
+	l.writeOutputParts(l.directives.Flush())
+}
+
+func (l *Logger) writeOutputParts(parts []OutputPart) {
+	for _, part := range parts {
+		if part.Directive != nil {
+			l.handleDirective(part.Directive)
+		} else {
+			l.writeCommandOutput(part.Text)
+		}
+	}
+}
+
+func (l *Logger) handleDirective(directive *Directive) {
+	switch directive.Command {
+	case DirectiveGroup:
+		l.sections = append(l.sections, directive.Message)
+		now := time.Now()
+		l.writeEvent("section_started", &SectionStartedEvent{
+			Timestamp:   int(now.Unix()),
+			TimestampMs: now.UnixMilli(),
+			Event:       "section_started",
+			Name:        directive.Message,
+		})
+	case DirectiveEndGroup:
+		// An endgroup without a group is just ignored.
+		if len(l.sections) > 0 {
+			l.finishSection()
+		}
+	default:
+		line, _ := strconv.Atoi(directive.Properties["line"])
+		column, _ := strconv.Atoi(directive.Properties["col"])
+		now := time.Now()
+		l.writeEvent("annotation", &AnnotationEvent{
+			Timestamp:   int(now.Unix()),
+			TimestampMs: now.UnixMilli(),
+			Event:       "annotation",
+			Level:       directive.Command,
+			Message:     directive.Message,
+			Title:       directive.Properties["title"],
+			File:        directive.Properties["file"],
+			Line:        line,
+			Column:      column,
+		})
+	}
+}
+
+func (l *Logger) finishSection() {
+	name := l.sections[len(l.sections)-1]
+	l.sections = l.sections[:len(l.sections)-1]
+	now := time.Now()
+	l.writeEvent("section_finished", &SectionFinishedEvent{
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "section_finished",
+		Name:        name,
+	})
+}
+
+func (l *Logger) writeEvent(name string, event interface{}) {
+	err := l.Backend.Write(event)
+	if err != nil {
+		log.Errorf("Error writing %s log: %v", name, err)
+	}
+}
+
+func (l *Logger) writeCommandOutput(output string) {
+	now := time.Now()
 	event := &CommandOutputEvent{
-		Timestamp: int(time.Now().Unix()),
-		Event:     "cmd_output",
-		Output:    output,
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "cmd_output",
+		Output:      output,
+		Stream:      l.stream,
 	}
 
 	err := l.Backend.Write(event)
@@ -133,14 +288,104 @@ func (l *Logger) LogCommandOutput(output string) {
 	}
 }
 
+func (l *Logger) LogTestSummary(summary testreports.Summary) {
+	now := time.Now()
+	event := &TestSummaryEvent{
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "test_summary",
+		Summary:     summary,
+	}
+
+	err := l.Backend.Write(event)
+	if err != nil {
+		log.Errorf("Error writing test_summary log: %v", err)
+	}
+}
+
+func (l *Logger) LogJobResources(usage resources.Usage) {
+	now := time.Now()
+	event := &JobResourcesEvent{
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "job_resources",
+		Usage:       usage,
+	}
+
+	err := l.Backend.Write(event)
+	if err != nil {
+		log.Errorf("Error writing job_resources log: %v", err)
+	}
+}
+
+func (l *Logger) LogJobOutputs(outputs map[string]string) {
+	now := time.Now()
+	event := &JobOutputsEvent{
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "job_outputs",
+		Outputs:     outputs,
+	}
+
+	err := l.Backend.Write(event)
+	if err != nil {
+		log.Errorf("Error writing job_outputs log: %v", err)
+	}
+}
+
+// The resource usage is nil if it was not sampled.
+func (l *Logger) LogJobSummary(phases []PhaseSummary, commands []CommandSummary, usage *resources.Usage) {
+	now := time.Now()
+	event := &JobSummaryEvent{
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "job_summary",
+		Phases:      phases,
+		Commands:    commands,
+		Resources:   usage,
+	}
+
+	err := l.Backend.Write(event)
+	if err != nil {
+		log.Errorf("Error writing job_summary log: %v", err)
+	}
+}
+
 func (l *Logger) LogCommandFinished(directive string, exitCode int, startedAt int, finishedAt int) {
+	// The output held back by the masker still goes through the limiter.
+	l.flushCommandOutput()
+
+	var throttling *OutputThrottling
+	if l.limiter != nil {
+		var notices []limitedOutput
+		notices, throttling = l.limiter.FinishCommand()
+		l.writeLimitedOutput(notices)
+	}
+
+	// Sections do not go past the command they were started in.
+	for len(l.sections) > 0 {
+		l.finishSection()
+	}
+
+	now := time.Now()
 	event := &CommandFinishedEvent{
-		Timestamp:  int(time.Now().Unix()),
-		Event:      "cmd_finished",
-		Directive:  directive,
-		ExitCode:   exitCode,
-		StartedAt:  startedAt,
-		FinishedAt: finishedAt,
+		Timestamp:   int(now.Unix()),
+		TimestampMs: now.UnixMilli(),
+		Event:       "cmd_finished",
+		Directive:   directive,
+		ExitCode:    exitCode,
+		StartedAt:   startedAt,
+		FinishedAt:  finishedAt,
+
+		FinishedAtMs: now.UnixMilli(),
+
+		OutputThrottling: throttling,
+	}
+
+	if !l.commandStartedAt.IsZero() {
+		event.StartedAtMs = l.commandStartedAt.UnixMilli()
+		event.DurationMs = now.Sub(l.commandStartedAt).Milliseconds()
+		l.commandStartedAt = time.Time{}
 	}
 
 	err := l.Backend.Write(event)
@@ -148,3 +393,118 @@ func (l *Logger) LogCommandFinished(directive string, exitCode int, startedAt in
 		log.Errorf("Error writing cmd_finished log: %v", err)
 	}
 }
+
+func formatTestSummary(summary testreports.Summary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "\nTest results from %d report(s)\n", len(summary.Reports))
+	fmt.Fprintf(
+		&b,
+		"%d tests, %d passed, %d failed, %d errors, %d skipped in %.2fs\n",
+		summary.Total,
+		summary.Passed,
+		summary.Failed,
+		summary.Errors,
+		summary.Skipped,
+		summary.Duration,
+	)
+
+	if len(summary.Slowest) > 0 {
+		b.WriteString("Slowest tests:\n")
+		for _, testCase := range summary.Slowest {
+			fmt.Fprintf(&b, "  %8.2fs %s\n", testCase.Duration, testName(testCase.Classname, testCase.Name))
+		}
+	}
+
+	if len(summary.Failures) > 0 {
+		b.WriteString("Failures:\n")
+		for _, failure := range summary.Failures {
+			fmt.Fprintf(&b, "  %s: %s\n", testName(failure.Classname, failure.Name), failure.Message)
+		}
+	}
+
+	return b.String()
+}
+
+func testName(classname, name string) string {
+	if classname == "" {
+		return name
+	}
+
+	return classname + "." + name
+}
+
+// e.g. "[warning] app.js:10:5: Missing semicolon"
+func formatAnnotation(annotation AnnotationEvent) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "[%s] ", annotation.Level)
+	if annotation.File != "" {
+		b.WriteString(annotation.File)
+		if annotation.Line > 0 {
+			fmt.Fprintf(&b, ":%d", annotation.Line)
+			if annotation.Column > 0 {
+				fmt.Fprintf(&b, ":%d", annotation.Column)
+			}
+		}
+
+		b.WriteString(": ")
+	}
+
+	if annotation.Title != "" {
+		b.WriteString(annotation.Title + ": ")
+	}
+
+	b.WriteString(annotation.Message + "\n")
+	return b.String()
+}
+
+func formatJobSummary(summary JobSummaryEvent) string {
+	var b strings.Builder
+
+	b.WriteString("\nJob summary\n")
+	if len(summary.Phases) > 0 {
+		b.WriteString("Phases:\n")
+		for _, phase := range summary.Phases {
+			fmt.Fprintf(&b, "  %8.2fs %s\n", float64(phase.DurationMs)/1000, phase.Name)
+		}
+	}
+
+	if len(summary.Commands) > 0 {
+		b.WriteString("Commands:\n")
+		for _, command := range summary.Commands {
+			// Multi-line commands would make the summary hard to read.
+			directive := strings.SplitN(command.Directive, "\n", 2)[0]
+			fmt.Fprintf(&b, "  %8.2fs [exit %d] %s\n", float64(command.DurationMs)/1000, command.ExitCode, directive)
+		}
+	}
+
+	if summary.Resources != nil {
+		usage := summary.Resources
+		b.WriteString("Resources:\n")
+		fmt.Fprintf(&b, "  CPU: %.2fs (avg %.1f%%, peak %.1f%%)\n", usage.CPUSeconds, usage.AvgCPUPercent, usage.PeakCPUPercent)
+		fmt.Fprintf(&b, "  Memory: avg %s, peak %s\n", formatBytes(usage.AvgMemoryBytes), formatBytes(usage.PeakMemoryBytes))
+		fmt.Fprintf(&b, "  IO: read %s, written %s\n", formatBytes(usage.IOReadBytes), formatBytes(usage.IOWriteBytes))
+		fmt.Fprintf(&b, "  Processes: peak %d\n", usage.PeakPIDs)
+		if usage.OOMKills > 0 {
+			fmt.Fprintf(&b, "  OOM kills: %d\n", usage.OOMKills)
+		}
+	}
+
+	return b.String()
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
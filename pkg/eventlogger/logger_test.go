@@ -10,6 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/semaphoreci/agent/pkg/resources"
+	"github.com/semaphoreci/agent/pkg/shell"
+	"github.com/semaphoreci/agent/pkg/testreports"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -48,6 +51,177 @@ func Test__GeneratePlainLogs(t *testing.T) {
 	os.Remove(file)
 }
 
+func Test__GeneratePlainLogsWithTestSummary(t *testing.T) {
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("logs_%d.json", time.Now().UnixNano()))
+	backend, _ := NewFileBackend(tmpFileName, DefaultMaxSizeInBytes)
+	assert.Nil(t, backend.Open())
+	logger, _ := NewLogger(backend)
+	generateLogEvents(t, 1, backend)
+	logger.LogTestSummary(testreports.Summary{
+		Reports:  []string{"report.xml"},
+		Total:    3,
+		Passed:   1,
+		Failed:   1,
+		Errors:   1,
+		Duration: 3.5,
+		Slowest: []testreports.TestCase{
+			{Name: "slow", Classname: "A", Duration: 3},
+		},
+		Failures: []testreports.TestFailure{
+			{Name: "fails", Classname: "A", Message: "expected true"},
+			{Name: "errors", Message: "timeout"},
+		},
+	})
+
+	file, err := logger.GeneratePlainTextFile()
+	assert.NoError(t, err)
+
+	bytes, err := os.ReadFile(file)
+	assert.NoError(t, err)
+
+	lines := strings.Split(string(bytes), "\n")
+	assert.Equal(t, []string{
+		"echo hello",
+		"hello",
+		"",
+		"Test results from 1 report(s)",
+		"3 tests, 1 passed, 1 failed, 1 errors, 0 skipped in 3.50s",
+		"Slowest tests:",
+		"      3.00s A.slow",
+		"Failures:",
+		"  A.fails: expected true",
+		"  errors: timeout",
+		"",
+	}, lines)
+
+	assert.NoError(t, logger.Close())
+	os.Remove(file)
+}
+
+func Test__GeneratePlainLogsWithJobSummary(t *testing.T) {
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("logs_%d.json", time.Now().UnixNano()))
+	backend, _ := NewFileBackend(tmpFileName, DefaultMaxSizeInBytes)
+	assert.Nil(t, backend.Open())
+	logger, _ := NewLogger(backend)
+	generateLogEvents(t, 1, backend)
+	logger.LogJobSummary(
+		[]PhaseSummary{
+			{Name: "executor_start", DurationMs: 1500},
+			{Name: "commands", DurationMs: 250},
+		},
+		[]CommandSummary{
+			{Directive: "echo hello", ExitCode: 0, DurationMs: 200},
+			{Directive: "if true; then\n  exit 1\nfi", ExitCode: 1, DurationMs: 50},
+		},
+		&resources.Usage{
+			Samples:         3,
+			CPUSeconds:      1.5,
+			AvgCPUPercent:   60,
+			PeakCPUPercent:  150,
+			AvgMemoryBytes:  512 * 1024,
+			PeakMemoryBytes: 3 * 1024 * 1024,
+			IOReadBytes:     100,
+			IOWriteBytes:    2048,
+			PeakPIDs:        4,
+			OOMKills:        1,
+		},
+	)
+
+	file, err := logger.GeneratePlainTextFile()
+	assert.NoError(t, err)
+
+	bytes, err := os.ReadFile(file)
+	assert.NoError(t, err)
+
+	lines := strings.Split(string(bytes), "\n")
+	assert.Equal(t, []string{
+		"echo hello",
+		"hello",
+		"",
+		"Job summary",
+		"Phases:",
+		"      1.50s executor_start",
+		"      0.25s commands",
+		"Commands:",
+		"      0.20s [exit 0] echo hello",
+		"      0.05s [exit 1] if true; then",
+		"Resources:",
+		"  CPU: 1.50s (avg 60.0%, peak 150.0%)",
+		"  Memory: avg 512.0KiB, peak 3.0MiB",
+		"  IO: read 100B, written 2.0KiB",
+		"  Processes: peak 4",
+		"  OOM kills: 1",
+		"",
+	}, lines)
+
+	assert.NoError(t, logger.Close())
+	os.Remove(file)
+}
+
+func Test__LogDirectives(t *testing.T) {
+	tmpFileName := filepath.Join(os.TempDir(), fmt.Sprintf("logs_%d.json", time.Now().UnixNano()))
+	backend, _ := NewFileBackend(tmpFileName, DefaultMaxSizeInBytes)
+	require.Nil(t, backend.Open())
+	logger, _ := NewLogger(backend)
+
+	logger.LogJobStarted()
+	logger.LogCommandStarted("make")
+	logger.LogCommandOutput("building\n::group::Compiling\n")
+	logger.LogCommandOutput("compiling\n::warning file=main.go,line=10,col=2::unused variable\n")
+	logger.LogCommandOutput("::group::Linking\nlinking\n::error::linking failed")
+	logger.LogCommandFinished("make", 1, 0, 0)
+	logger.LogJobFinished("failed")
+
+	events := []string{}
+	require.NoError(t, backend.Iterate(func(event []byte) error {
+		events = append(events, string(event))
+		return nil
+	}))
+
+	objects, err := TransformToObjects(events)
+	require.NoError(t, err)
+	simplified, err := SimplifyLogEvents(objects, SimplifyOptions{IncludeOutput: true})
+	require.NoError(t, err)
+
+	// open sections are finished along with the command
+	assert.Equal(t, []string{
+		"job_started",
+		"directive: make",
+		"building\n",
+		"section_started: Compiling",
+		"compiling\n",
+		"annotation: [warning] main.go:10:2: unused variable",
+		"section_started: Linking",
+		"linking\n",
+		"annotation: [error] linking failed",
+		"section_finished: Linking",
+		"section_finished: Compiling",
+		"Exit Code: 1",
+		"job_finished: failed",
+	}, simplified)
+
+	file, err := logger.GeneratePlainTextFile()
+	require.NoError(t, err)
+
+	bytes, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"make",
+		"building",
+		"--- Compiling",
+		"compiling",
+		"[warning] main.go:10:2: unused variable",
+		"--- Linking",
+		"linking",
+		"[error] linking failed",
+		"",
+	}, strings.Split(string(bytes), "\n"))
+
+	assert.NoError(t, logger.Close())
+	os.Remove(file)
+}
+
 func Benchmark__GeneratePlainLogs(b *testing.B) {
 	//
 	// We do not want to account for this setup time in our benchmark
@@ -101,3 +275,56 @@ func Benchmark__GeneratePlainLogs(b *testing.B) {
 
 	require.NoError(b, logger.Close())
 }
+
+func Test__MillisecondTimestampsAndDurations(t *testing.T) {
+	backend, _ := NewInMemoryBackend()
+	logger, _ := NewLogger(backend)
+
+	logger.LogJobStarted()
+	logger.LogCommandStarted("sleep 0.05")
+	time.Sleep(50 * time.Millisecond)
+	logger.LogCommandFinished("sleep 0.05", 0, 0, 0)
+
+	require.Len(t, backend.Events, 3)
+
+	jobStarted := backend.Events[0].(*JobStartedEvent)
+	assert.Equal(t, SchemaVersion, jobStarted.SchemaVersion)
+	assert.Equal(t, int64(jobStarted.Timestamp), jobStarted.TimestampMs/1000)
+
+	commandStarted := backend.Events[1].(*CommandStartedEvent)
+	commandFinished := backend.Events[2].(*CommandFinishedEvent)
+	assert.Equal(t, commandStarted.TimestampMs, commandFinished.StartedAtMs)
+	assert.Equal(t, commandFinished.TimestampMs, commandFinished.FinishedAtMs)
+	assert.GreaterOrEqual(t, commandFinished.DurationMs, int64(50))
+	assert.LessOrEqual(t, commandFinished.DurationMs, commandFinished.FinishedAtMs-commandFinished.StartedAtMs+1)
+}
+
+func Test__LogCommandStreamOutput(t *testing.T) {
+	backend, _ := NewInMemoryBackend()
+	logger, _ := NewLogger(backend)
+	logger.AddMaskedValues("secret")
+
+	logger.LogCommandStarted("make")
+	logger.LogCommandStreamOutput(shell.StreamStdout, "building\nwith sec")
+	logger.LogCommandStreamOutput(shell.StreamStderr, "warning: deprecated\n")
+	logger.LogCommandStreamOutput(shell.StreamStdout, "done\n")
+	logger.LogCommandOutput("agent message\n")
+	logger.LogCommandFinished("make", 0, 0, 0)
+
+	outputs := []CommandOutputEvent{}
+	for _, event := range backend.Events {
+		if output, ok := event.(*CommandOutputEvent); ok {
+			outputs = append(outputs, *output)
+		}
+	}
+
+	// The output held back by the masker keeps its stream.
+	require.Len(t, outputs, 5)
+	assert.Equal(t, []string{"building\nwith ", "sec", "warning: deprecated\n", "done\n", "agent message\n"}, []string{
+		outputs[0].Output, outputs[1].Output, outputs[2].Output, outputs[3].Output, outputs[4].Output,
+	})
+
+	assert.Equal(t, []string{shell.StreamStdout, shell.StreamStdout, shell.StreamStderr, shell.StreamStdout, ""}, []string{
+		outputs[0].Stream, outputs[1].Stream, outputs[2].Stream, outputs[3].Stream, outputs[4].Stream,
+	})
+}
@@ -0,0 +1,123 @@
+package eventlogger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Values shorter than this are not masked,
+// since masking things like "true" or "1" would make the logs unreadable.
+const MinMaskedValueLength = 6
+
+const MaskReplacement = "***"
+
+/*
+ * Replaces secret values in the command output with a mask.
+ *
+ * Output reaches the logger in chunks, and a secret value can be split
+ * between two of them. To handle that, the end of a chunk that could be
+ * the beginning of a secret value is held back until the next chunk arrives,
+ * or until Flush() is called, when the command finishes.
+ */
+type Masker struct {
+	mutex      sync.Mutex
+	values     []string
+	known      map[string]bool
+	firstBytes map[byte]bool
+	maxLength  int
+	pending    string
+}
+
+func NewMasker() *Masker {
+	return &Masker{
+		values:     []string{},
+		known:      map[string]bool{},
+		firstBytes: map[byte]bool{},
+	}
+}
+
+func (m *Masker) AddValues(values ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, value := range values {
+		if len(value) < MinMaskedValueLength || m.known[value] {
+			continue
+		}
+
+		m.known[value] = true
+		m.values = append(m.values, value)
+		m.firstBytes[value[0]] = true
+		if len(value) > m.maxLength {
+			m.maxLength = len(value)
+		}
+	}
+
+	// Longer values are replaced first, in case one value contains another.
+	sort.SliceStable(m.values, func(i, j int) bool {
+		return len(m.values[i]) > len(m.values[j])
+	})
+}
+
+func (m *Masker) HasValues() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.values) > 0
+}
+
+// Returns the masked output that is safe to be logged right away.
+func (m *Masker) Mask(output string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.values) == 0 {
+		return output
+	}
+
+	data := m.replace(m.pending + output)
+	holdBack := m.partialMatchLength(data)
+	m.pending = data[len(data)-holdBack:]
+	return data[:len(data)-holdBack]
+}
+
+// Returns whatever was held back, masked.
+func (m *Masker) Flush() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pending := m.pending
+	m.pending = ""
+	return pending
+}
+
+func (m *Masker) replace(data string) string {
+	for _, value := range m.values {
+		data = strings.ReplaceAll(data, value, MaskReplacement)
+	}
+
+	return data
+}
+
+// Finds the longest suffix of data that is the beginning of a secret value.
+func (m *Masker) partialMatchLength(data string) int {
+	maxSuffix := m.maxLength - 1
+	if maxSuffix > len(data) {
+		maxSuffix = len(data)
+	}
+
+	for length := maxSuffix; length > 0; length-- {
+		suffix := data[len(data)-length:]
+		if !m.firstBytes[suffix[0]] {
+			continue
+		}
+
+		for _, value := range m.values {
+			if len(value) > length && strings.HasPrefix(value, suffix) {
+				return length
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,84 @@
+package eventlogger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__Masker(t *testing.T) {
+	t.Run("no values -> output is not changed", func(t *testing.T) {
+		masker := NewMasker()
+		assert.Equal(t, "hello secret123\n", masker.Mask("hello secret123\n"))
+		assert.Equal(t, "", masker.Flush())
+	})
+
+	t.Run("values are masked", func(t *testing.T) {
+		masker := NewMasker()
+		masker.AddValues("secret123", "another-secret")
+		assert.Equal(t, "a *** b ***\n", masker.Mask("a secret123 b another-secret\n"))
+		assert.Equal(t, "", masker.Flush())
+	})
+
+	t.Run("short values are not masked", func(t *testing.T) {
+		masker := NewMasker()
+		masker.AddValues("true", "12345")
+		assert.False(t, masker.HasValues())
+		assert.Equal(t, "true 12345\n", masker.Mask("true 12345\n"))
+	})
+
+	t.Run("longer values are masked first", func(t *testing.T) {
+		masker := NewMasker()
+		masker.AddValues("secret", "secret-and-more")
+		assert.Equal(t, "*** ***\n", masker.Mask("secret-and-more secret\n"))
+	})
+
+	t.Run("values split across chunks are masked", func(t *testing.T) {
+		masker := NewMasker()
+		masker.AddValues("secret123")
+
+		assert.Equal(t, "hello ", masker.Mask("hello sec"))
+		assert.Equal(t, "", masker.Mask("ret"))
+		assert.Equal(t, "*** bye\n", masker.Mask("123 bye\n"))
+		assert.Equal(t, "", masker.Flush())
+	})
+
+	t.Run("partial match that is not a value is flushed", func(t *testing.T) {
+		masker := NewMasker()
+		masker.AddValues("secret123")
+
+		assert.Equal(t, "hello ", masker.Mask("hello secret12"))
+		assert.Equal(t, "secret12", masker.Flush())
+		assert.Equal(t, "secret12X", masker.Mask("secret12X"))
+	})
+}
+
+func Test__LoggerMasksOutput(t *testing.T) {
+	backend, err := NewInMemoryBackend()
+	require.NoError(t, err)
+	logger, err := NewLogger(backend)
+	require.NoError(t, err)
+
+	logger.AddMaskedValues("my-password")
+	logger.LogJobStarted()
+	logger.LogCommandStarted("echo $PASSWORD")
+	logger.LogCommandOutput("the password is my-pa")
+	logger.LogCommandOutput("ssword\n")
+	logger.LogCommandOutput("but my-pa")
+	logger.LogCommandFinished("echo $PASSWORD", 0, 0, 0)
+	logger.LogJobFinished("passed")
+
+	simplified, err := backend.SimplifiedEvents(true, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"job_started",
+		"directive: echo $PASSWORD",
+		"the password is ",
+		"***\n",
+		"but ",
+		"my-pa",
+		"Exit Code: 0",
+		"job_finished: passed",
+	}, simplified)
+}
@@ -0,0 +1,127 @@
+package eventlogger
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ * Writes the events to a primary backend, and to any number of sinks.
+ * Reads only come from the primary backend, and only its errors are returned.
+ * A sink that fails is disabled, and the job carries on with the other ones.
+ */
+type MultiBackend struct {
+	primary Backend
+	sinks   []*multiBackendSink
+}
+
+type multiBackendSink struct {
+	name    string
+	backend Backend
+	failed  atomic.Bool
+}
+
+type NamedBackend struct {
+	Name    string
+	Backend Backend
+}
+
+func NewMultiBackend(primary Backend, sinks ...NamedBackend) *MultiBackend {
+	backend := &MultiBackend{primary: primary}
+	for _, sink := range sinks {
+		backend.sinks = append(backend.sinks, &multiBackendSink{name: sink.Name, backend: sink.Backend})
+	}
+
+	return backend
+}
+
+func (l *MultiBackend) Open() error {
+	if err := l.primary.Open(); err != nil {
+		return err
+	}
+
+	for _, sink := range l.sinks {
+		if err := sink.backend.Open(); err != nil {
+			sink.fail("opening", err)
+		}
+	}
+
+	return nil
+}
+
+func (l *MultiBackend) Write(event interface{}) error {
+	for _, sink := range l.sinks {
+		if sink.failed.Load() {
+			continue
+		}
+
+		if err := sink.backend.Write(event); err != nil {
+			sink.fail("writing to", err)
+		}
+	}
+
+	return l.primary.Write(event)
+}
+
+func (l *MultiBackend) Read(startFrom, maxLines int, writer io.Writer) (int, error) {
+	return l.primary.Read(startFrom, maxLines, writer)
+}
+
+func (l *MultiBackend) Iterate(fn func([]byte) error) error {
+	return l.primary.Iterate(fn)
+}
+
+/*
+ * The sinks are closed first, all at the same time, since some of them
+ * might take a while to flush their logs. The primary backend is closed after them,
+ * and the OnClose callback gets whether any of the backends trimmed the logs.
+ * Sinks that failed before are still closed, so they can clean up after themselves,
+ * but they do not count towards the trimmed signal.
+ */
+func (l *MultiBackend) CloseWithOptions(options CloseOptions) error {
+	var trimmed atomic.Bool
+	var wg sync.WaitGroup
+
+	for _, sink := range l.sinks {
+		wg.Add(1)
+		go func(sink *multiBackendSink) {
+			defer wg.Done()
+
+			err := sink.backend.CloseWithOptions(CloseOptions{
+				OnClose: func(sinkTrimmed bool) {
+					if sinkTrimmed && !sink.failed.Load() {
+						log.Warnf("Logs sent to sink %s were trimmed", sink.name)
+						trimmed.Store(true)
+					}
+				},
+			})
+
+			if err != nil {
+				log.Errorf("Error closing sink %s: %v", sink.name, err)
+			}
+		}(sink)
+	}
+
+	wg.Wait()
+
+	return l.primary.CloseWithOptions(CloseOptions{
+		OnClose: func(primaryTrimmed bool) {
+			if options.OnClose != nil {
+				options.OnClose(primaryTrimmed || trimmed.Load())
+			}
+		},
+	})
+}
+
+func (l *MultiBackend) Close() error {
+	return l.CloseWithOptions(CloseOptions{})
+}
+
+func (s *multiBackendSink) fail(action string, err error) {
+	if s.failed.CompareAndSwap(false, true) {
+		log.Errorf("Error %s sink %s - not sending more logs to it: %v", action, s.name, err)
+	}
+}
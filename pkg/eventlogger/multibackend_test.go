@@ -0,0 +1,173 @@
+package eventlogger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__MultiBackend__EventsAreWrittenToAllBackends(t *testing.T) {
+	primary, _ := NewInMemoryBackend()
+	sink, _ := NewInMemoryBackend()
+
+	backend := NewMultiBackend(primary, NamedBackend{Name: "sink", Backend: sink})
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 2, backend)
+	require.NoError(t, backend.Close())
+
+	assert.Len(t, primary.Events, 6)
+	assert.Equal(t, primary.Events, sink.Events)
+}
+
+func Test__MultiBackend__ReadsComeFromPrimaryBackend(t *testing.T) {
+	primary, _ := NewFileBackend(filepath.Join(t.TempDir(), "job_log.json"), DefaultMaxSizeInBytes)
+	sink := &fakeBackend{content: "from the sink\n"}
+
+	backend := NewMultiBackend(primary, NamedBackend{Name: "sink", Backend: sink})
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 2, backend)
+
+	buf := bytes.NewBuffer([]byte{})
+	next, err := backend.Read(0, 100, buf)
+	require.NoError(t, err)
+	assert.Equal(t, 6, next)
+	assert.NotContains(t, buf.String(), "from the sink")
+
+	iterated := 0
+	require.NoError(t, backend.Iterate(func([]byte) error {
+		iterated++
+		return nil
+	}))
+
+	assert.Equal(t, 6, iterated)
+	assert.Zero(t, sink.reads)
+	require.NoError(t, backend.Close())
+}
+
+func Test__MultiBackend__FailingSinksAreIsolated(t *testing.T) {
+	primary, _ := NewInMemoryBackend()
+	working, _ := NewInMemoryBackend()
+	failsToOpen := &fakeBackend{openErr: errors.New("no space left on device")}
+	failsToWrite := &fakeBackend{writeErr: errors.New("connection refused")}
+
+	backend := NewMultiBackend(primary,
+		NamedBackend{Name: "fails-to-open", Backend: failsToOpen},
+		NamedBackend{Name: "fails-to-write", Backend: failsToWrite},
+		NamedBackend{Name: "working", Backend: working},
+	)
+
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 2, backend)
+	require.NoError(t, backend.Close())
+
+	assert.Len(t, primary.Events, 6)
+	assert.Len(t, working.Events, 6)
+
+	// Failed sinks are not written to anymore, but they are still closed.
+	assert.Zero(t, failsToOpen.writes)
+	assert.Equal(t, 1, failsToWrite.writes)
+	assert.True(t, failsToOpen.closed)
+	assert.True(t, failsToWrite.closed)
+}
+
+func Test__MultiBackend__PrimaryBackendErrorsAreReturned(t *testing.T) {
+	primary := &fakeBackend{writeErr: errors.New("disk full")}
+	sink, _ := NewInMemoryBackend()
+
+	backend := NewMultiBackend(primary, NamedBackend{Name: "sink", Backend: sink})
+	require.NoError(t, backend.Open())
+	assert.ErrorContains(t, backend.Write(&JobStartedEvent{Event: "job_started"}), "disk full")
+	assert.ErrorContains(t, backend.Write(&JobStartedEvent{Event: "job_started"}), "disk full")
+
+	// The sinks still get the events.
+	assert.Len(t, sink.Events, 2)
+}
+
+func Test__MultiBackend__TrimmedSignalIsCombined(t *testing.T) {
+	testCases := []struct {
+		name            string
+		primaryTrimmed  bool
+		sinkTrimmed     bool
+		sinkFailed      bool
+		expectedTrimmed bool
+	}{
+		{name: "nothing trimmed", expectedTrimmed: false},
+		{name: "primary trimmed", primaryTrimmed: true, expectedTrimmed: true},
+		{name: "sink trimmed", sinkTrimmed: true, expectedTrimmed: true},
+		{name: "failed sink trimmed", sinkTrimmed: true, sinkFailed: true, expectedTrimmed: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			primary := &fakeBackend{trimmed: testCase.primaryTrimmed}
+			sink := &fakeBackend{trimmed: testCase.sinkTrimmed}
+			if testCase.sinkFailed {
+				sink.writeErr = errors.New("connection refused")
+			}
+
+			backend := NewMultiBackend(primary, NamedBackend{Name: "sink", Backend: sink})
+			require.NoError(t, backend.Open())
+			require.NoError(t, backend.Write(&JobStartedEvent{Event: "job_started"}))
+
+			calls := 0
+			trimmed := false
+			require.NoError(t, backend.CloseWithOptions(CloseOptions{
+				OnClose: func(b bool) {
+					calls++
+					trimmed = b
+				},
+			}))
+
+			assert.Equal(t, 1, calls)
+			assert.Equal(t, testCase.expectedTrimmed, trimmed)
+		})
+	}
+}
+
+type fakeBackend struct {
+	content  string
+	openErr  error
+	writeErr error
+	trimmed  bool
+	writes   int
+	reads    int
+	closed   bool
+}
+
+func (b *fakeBackend) Open() error {
+	return b.openErr
+}
+
+func (b *fakeBackend) Write(interface{}) error {
+	b.writes++
+	return b.writeErr
+}
+
+func (b *fakeBackend) Read(startFrom, maxLines int, writer io.Writer) (int, error) {
+	b.reads++
+	_, err := io.WriteString(writer, b.content)
+	return startFrom + 1, err
+}
+
+func (b *fakeBackend) Iterate(fn func([]byte) error) error {
+	b.reads++
+	return fn([]byte(b.content))
+}
+
+func (b *fakeBackend) Close() error {
+	return b.CloseWithOptions(CloseOptions{})
+}
+
+func (b *fakeBackend) CloseWithOptions(options CloseOptions) error {
+	b.closed = true
+	if options.OnClose != nil {
+		options.OnClose(b.trimmed)
+	}
+
+	return nil
+}
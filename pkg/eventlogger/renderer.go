@@ -0,0 +1,200 @@
+package eventlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+const (
+	RenderFormatText = "text"
+	RenderFormatHTML = "html"
+	RenderFormatCast = "cast"
+)
+
+var RenderFormats = []string{RenderFormatText, RenderFormatHTML, RenderFormatCast}
+
+const DefaultCastWidth = 120
+const DefaultCastHeight = 40
+
+// Anything with the events of a job log, e.g. a backend, or an archived log file.
+type EventSource interface {
+	Iterate(fn func(event []byte) error) error
+}
+
+type RenderOptions struct {
+	Format string
+
+	// Only used for plain text.
+	// The HTML page turns the colors into styles, and terminals replaying a cast need them.
+	StripANSI bool
+
+	// The title of the HTML page and the cast.
+	Title string
+
+	// The terminal size for the cast.
+	Width  int
+	Height int
+}
+
+/*
+ * Renders a job log, so it can be read or shared outside of Semaphore:
+ * as plain text, as a self-contained HTML page, or as an asciinema v2 cast.
+ */
+func Render(source EventSource, writer io.Writer, options RenderOptions) error {
+	switch options.Format {
+	case RenderFormatText, "":
+		return RenderPlainText(source, writer, options.StripANSI)
+	case RenderFormatHTML:
+		return RenderHTML(source, writer, options.Title)
+	case RenderFormatCast:
+		return RenderCast(source, writer, options)
+	default:
+		return fmt.Errorf("unknown format '%s' - use one of %v", options.Format, RenderFormats)
+	}
+}
+
+/*
+ * The output of the commands, with their directives before it.
+ * Summaries are rendered at the end of the plain-text log,
+ * no matter where they appear in the event log.
+ */
+func RenderPlainText(source EventSource, writer io.Writer, stripANSI bool) error {
+	testSummaries := []TestSummaryEvent{}
+	jobSummaries := []JobSummaryEvent{}
+
+	write := func(s string) error {
+		if stripANSI {
+			s = StripANSI(s)
+		}
+
+		if _, err := io.WriteString(writer, s); err != nil {
+			return fmt.Errorf("error writing to output: %v", err)
+		}
+
+		return nil
+	}
+
+	err := forEachEvent(source, func(event *renderedEvent) error {
+		switch event.Event {
+		case "cmd_started":
+			return write(event.Directive + "\n")
+		case "cmd_output":
+			return write(event.Output)
+		case "section_started":
+			return write(fmt.Sprintf("--- %s\n", event.Name))
+		case "annotation":
+			annotation := AnnotationEvent{}
+			if err := event.decode(&annotation); err != nil {
+				return err
+			}
+
+			return write(formatAnnotation(annotation))
+		case "test_summary":
+			summary := TestSummaryEvent{}
+			if err := event.decode(&summary); err != nil {
+				return err
+			}
+
+			testSummaries = append(testSummaries, summary)
+		case "job_summary":
+			summary := JobSummaryEvent{}
+			if err := event.decode(&summary); err != nil {
+				return err
+			}
+
+			jobSummaries = append(jobSummaries, summary)
+		default:
+			// We can ignore all the other event types here
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, summary := range testSummaries {
+		if err := write(formatTestSummary(summary.Summary)); err != nil {
+			return err
+		}
+	}
+
+	for _, summary := range jobSummaries {
+		if err := write(formatJobSummary(summary)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The fields the renderers use from all the events.
+type renderedEvent struct {
+	Event       string `json:"event"`
+	Timestamp   int    `json:"timestamp"`
+	TimestampMs int64  `json:"timestamp_ms"`
+	Directive   string `json:"directive"`
+	Output      string `json:"output"`
+	ExitCode    int    `json:"exit_code"`
+	StartedAt   int    `json:"started_at"`
+	FinishedAt  int    `json:"finished_at"`
+	DurationMs  int64  `json:"duration_ms"`
+	Name        string `json:"name"`
+	Result      string `json:"result"`
+
+	raw []byte
+}
+
+func forEachEvent(source EventSource, fn func(event *renderedEvent) error) error {
+	err := source.Iterate(func(raw []byte) error {
+		event := renderedEvent{}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return fmt.Errorf("error unmarshaling log event '%s': %v", string(raw), err)
+		}
+
+		event.raw = raw
+		return fn(&event)
+	})
+
+	if err != nil {
+		return fmt.Errorf("error iterating on log events: %v", err)
+	}
+
+	return nil
+}
+
+func (e *renderedEvent) decode(v interface{}) error {
+	if err := json.Unmarshal(e.raw, v); err != nil {
+		return fmt.Errorf("error unmarshaling %s '%s': %v", e.Event, string(e.raw), err)
+	}
+
+	return nil
+}
+
+// Logs from before the schema version 2 only have timestamps in seconds.
+func (e *renderedEvent) time() time.Time {
+	if e.TimestampMs > 0 {
+		return time.UnixMilli(e.TimestampMs)
+	}
+
+	return time.Unix(int64(e.Timestamp), 0)
+}
+
+func (e *renderedEvent) duration() time.Duration {
+	if e.DurationMs > 0 {
+		return time.Duration(e.DurationMs) * time.Millisecond
+	}
+
+	return time.Duration(e.FinishedAt-e.StartedAt) * time.Second
+}
+
+// Control sequences (CSI), operating system commands (OSC), and other escapes.
+var ansiEscapeSequence = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+func StripANSI(s string) string {
+	return ansiEscapeSequence.ReplaceAllString(s, "")
+}
@@ -0,0 +1,110 @@
+package eventlogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var renderedEvents = []string{
+	`{"event":"job_started","timestamp":1700000000,"timestamp_ms":1700000000000,"schema_version":2}`,
+	`{"event":"cmd_started","timestamp":1700000000,"timestamp_ms":1700000000100,"directive":"echo hello"}`,
+	`{"event":"cmd_output","timestamp":1700000000,"timestamp_ms":1700000000200,"output":"\u001b[1;32mhello\u001b[0m <world>\n"}`,
+	`{"event":"cmd_finished","timestamp":1700000000,"timestamp_ms":1700000000300,"directive":"echo hello","exit_code":0,"started_at":1700000000,"finished_at":1700000000,"duration_ms":200}`,
+	`{"event":"cmd_started","timestamp":1700000001,"directive":"make test"}`,
+	`{"event":"annotation","timestamp":1700000001,"level":"error","message":"boom","file":"app.go","line":3}`,
+	`{"event":"cmd_finished","timestamp":1700000003,"directive":"make test","exit_code":2,"started_at":1700000001,"finished_at":1700000003}`,
+	`{"event":"job_finished","timestamp":1700000003,"timestamp_ms":1700000003000,"result":"failed"}`,
+}
+
+func Test__RenderPlainText(t *testing.T) {
+	source := eventsFile(t, renderedEvents)
+
+	output := bytes.NewBuffer([]byte{})
+	require.NoError(t, Render(source, output, RenderOptions{Format: RenderFormatText}))
+	assert.Equal(t, "echo hello\n\x1b[1;32mhello\x1b[0m <world>\nmake test\n[error] app.go:3: boom\n", output.String())
+
+	output.Reset()
+	require.NoError(t, Render(source, output, RenderOptions{Format: RenderFormatText, StripANSI: true}))
+	assert.Equal(t, "echo hello\nhello <world>\nmake test\n[error] app.go:3: boom\n", output.String())
+}
+
+func Test__RenderHTML(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	require.NoError(t, Render(eventsFile(t, renderedEvents), output, RenderOptions{Format: RenderFormatHTML, Title: "Job <1>"}))
+
+	page := output.String()
+	assert.Contains(t, page, "<title>Job &lt;1&gt;</title>")
+	assert.Contains(t, page, `<p class="result failed">Job failed</p>`)
+
+	// output is escaped, and colors become spans
+	assert.Contains(t, page, `<pre><span class="bold fg2">hello</span> &lt;world&gt;`+"\n</pre>")
+
+	// failed commands are expanded, with their exit code and duration
+	assert.Contains(t, page, `<details class="passed">`+"\n"+`<summary><span class="meta">exit code 0 · 200ms</span>echo hello</summary>`)
+	assert.Contains(t, page, `<details class="failed" open>`+"\n"+`<summary><span class="meta">exit code 2 · 2s</span>make test</summary>`)
+	assert.Contains(t, page, `<span class="fg1">[error] app.go:3: boom`)
+}
+
+func Test__RenderCast(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	require.NoError(t, Render(eventsFile(t, renderedEvents), output, RenderOptions{Format: RenderFormatCast, Title: "job"}))
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	header := castHeader{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, castHeader{
+		Version:   2,
+		Width:     DefaultCastWidth,
+		Height:    DefaultCastHeight,
+		Timestamp: 1700000000,
+		Title:     "job",
+		Env:       map[string]string{"TERM": "xterm-256color", "SHELL": "/bin/bash"},
+	}, header)
+
+	events := [][]interface{}{}
+	for _, line := range lines[1:] {
+		event := []interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+
+	assert.Equal(t, [][]interface{}{
+		{0.1, "o", "\x1b[1;33m$ echo hello\x1b[0m\r\n"},
+		{0.2, "o", "\x1b[1;32mhello\x1b[0m <world>\r\n"},
+		{1.0, "o", "\x1b[1;33m$ make test\x1b[0m\r\n"},
+		{1.0, "o", "\x1b[31m[error] app.go:3: boom\r\n\x1b[0m"},
+		{3.0, "o", "\x1b[31mexit code 2\x1b[0m\r\n"},
+		{3.0, "o", "\r\nJob failed\r\n"},
+	}, events)
+}
+
+func Test__RenderEmptyCast(t *testing.T) {
+	output := bytes.NewBuffer([]byte{})
+	require.NoError(t, Render(eventsFile(t, []string{}), output, RenderOptions{Format: RenderFormatCast}))
+	assert.Equal(t, `{"version":2,"width":120,"height":40,"env":{"SHELL":"/bin/bash","TERM":"xterm-256color"}}`+"\n", output.String())
+}
+
+func Test__RenderUnknownFormat(t *testing.T) {
+	err := Render(eventsFile(t, renderedEvents), bytes.NewBuffer([]byte{}), RenderOptions{Format: "pdf"})
+	assert.ErrorContains(t, err, "unknown format 'pdf'")
+}
+
+func Test__StripANSI(t *testing.T) {
+	assert.Equal(t, "red bold title link", StripANSI("\x1b[31mred\x1b[0m \x1b[1mbold\x1b[22m \x1b]0;title\x07title \x1b]8;;https://semaphoreci.com\x1b\\link\x1b]8;;\x1b\\\x1b[2K"))
+}
+
+func eventsFile(t *testing.T, events []string) *FileBackend {
+	path := filepath.Join(t.TempDir(), "events.json")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(events, "\n")+"\n"), 0600))
+
+	backend, err := NewFileBackend(path, DefaultMaxSizeInBytes)
+	require.NoError(t, err)
+	return backend
+}
@@ -0,0 +1,293 @@
+package eventlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/retry"
+	"github.com/semaphoreci/agent/pkg/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// S3 does not accept parts smaller than 5MiB, except for the last one.
+	DefaultS3PartSize                = 5 * 1024 * 1024
+	DefaultS3UploadIntervalInSeconds = 10
+	S3ManifestVersion                = 1
+)
+
+/*
+ * Streams the job log to S3-compatible object storage.
+ * The events are written to a local file first, which is also where reads come from.
+ * While the job runs, every PartSize bytes of it are uploaded as a part of a multipart upload.
+ * When the logger is closed, the rest is uploaded as the last part,
+ * the upload is completed, and a manifest describing the log is uploaded next to it.
+ *
+ * If the upload cannot be completed, the local file is kept,
+ * so the logs are not lost when the storage is unreachable.
+ */
+type S3Backend struct {
+	config      S3BackendConfig
+	fileBackend *FileBackend
+	eventsKey   string
+	manifestKey string
+
+	// The upload state is only touched by the upload loop,
+	// and by CloseWithOptions(), after the loop is stopped.
+	uploadID      string
+	parts         []s3.Part
+	uploadedBytes int64
+	completed     bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+type S3BackendConfig struct {
+	Client *s3.Client
+
+	// The objects are uploaded to <Prefix>/<JobID>/.
+	Prefix string
+	JobID  string
+
+	PartSize                  int
+	UploadIntervalInSeconds   int
+	FlushAttempts             int
+	DelayBetweenFlushAttempts time.Duration
+}
+
+type S3Manifest struct {
+	Version       int    `json:"version"`
+	JobID         string `json:"job_id"`
+	EventsKey     string `json:"events_key"`
+	SchemaVersion int    `json:"schema_version"`
+	Events        int    `json:"events"`
+	Bytes         int64  `json:"bytes"`
+	Parts         int    `json:"parts"`
+	UploadedAt    int64  `json:"uploaded_at"`
+}
+
+func NewS3Backend(config S3BackendConfig) (*S3Backend, error) {
+	if config.Client == nil {
+		return nil, fmt.Errorf("config.Client is required")
+	}
+
+	if config.PartSize <= 0 {
+		return nil, fmt.Errorf("config.PartSize must be greater than 0")
+	}
+
+	if config.UploadIntervalInSeconds <= 0 {
+		return nil, fmt.Errorf("config.UploadIntervalInSeconds must be greater than 0")
+	}
+
+	if config.FlushAttempts <= 0 {
+		return nil, fmt.Errorf("config.FlushAttempts must be greater than 0")
+	}
+
+	filePath := filepath.Join(os.TempDir(), fmt.Sprintf("job_log_%d.json", time.Now().UnixNano()))
+
+	// Nothing is trimmed, so we don't impose any limits on the underlying file backend.
+	fileBackend, err := NewFileBackend(filePath, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Backend{
+		config:      config,
+		fileBackend: fileBackend,
+		eventsKey:   path.Join(config.Prefix, config.JobID, "events.jsonl"),
+		manifestKey: path.Join(config.Prefix, config.JobID, "manifest.json"),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}, nil
+}
+
+func (l *S3Backend) Open() error {
+	if err := l.fileBackend.Open(); err != nil {
+		return err
+	}
+
+	go l.upload()
+	return nil
+}
+
+func (l *S3Backend) Write(event interface{}) error {
+	return l.fileBackend.Write(event)
+}
+
+func (l *S3Backend) Read(startFrom, maxLines int, writer io.Writer) (int, error) {
+	return l.fileBackend.Read(startFrom, maxLines, writer)
+}
+
+func (l *S3Backend) Iterate(fn func([]byte) error) error {
+	return l.fileBackend.Iterate(fn)
+}
+
+func (l *S3Backend) upload() {
+	defer close(l.stopped)
+
+	log.Infof("Logs will be uploaded to %s/%s/%s", l.config.Client.Endpoint, l.config.Client.Bucket, l.eventsKey)
+	ticker := time.NewTicker(time.Duration(l.config.UploadIntervalInSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			// Failed parts are uploaded again in the next tick.
+			if err := l.uploadParts(false); err != nil {
+				log.Errorf("Error uploading logs: %v", err)
+			}
+		}
+	}
+}
+
+/*
+ * Uploads the bytes not uploaded yet, in parts of PartSize bytes.
+ * What is left is only uploaded if this is the last part.
+ */
+func (l *S3Backend) uploadParts(last bool) error {
+	_, size := l.fileBackend.Position()
+
+	for {
+		remaining := size - l.uploadedBytes
+		if remaining == 0 || (!last && remaining < int64(l.config.PartSize)) {
+			return nil
+		}
+
+		if l.uploadID == "" {
+			uploadID, err := l.config.Client.CreateMultipartUpload(l.eventsKey, "application/x-ndjson")
+			if err != nil {
+				return err
+			}
+
+			l.uploadID = uploadID
+		}
+
+		content, err := l.readBytes(l.uploadedBytes, min(remaining, int64(l.config.PartSize)))
+		if err != nil {
+			return err
+		}
+
+		part, err := l.config.Client.UploadPart(l.eventsKey, l.uploadID, len(l.parts)+1, content)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Uploaded part %d of the logs with %s", part.Number, formatBytes(int64(len(content))))
+		l.parts = append(l.parts, *part)
+		l.uploadedBytes += int64(len(content))
+	}
+}
+
+func (l *S3Backend) readBytes(offset, length int64) ([]byte, error) {
+	fd, err := os.Open(l.fileBackend.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	content := make([]byte, length)
+	if _, err := fd.ReadAt(content, offset); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", l.fileBackend.path, err)
+	}
+
+	return content, nil
+}
+
+/*
+ * Uploads what is left, and the manifest.
+ * Logs that fit in a single part are uploaded with a single request,
+ * instead of with a multipart upload.
+ */
+func (l *S3Backend) finish() error {
+	events, size := l.fileBackend.Position()
+
+	if !l.completed {
+		if l.uploadID == "" {
+			content, err := l.readBytes(0, size)
+			if err != nil {
+				return err
+			}
+
+			if err := l.config.Client.PutObject(l.eventsKey, content, "application/x-ndjson"); err != nil {
+				return err
+			}
+
+			l.uploadedBytes = size
+		} else {
+			if err := l.uploadParts(true); err != nil {
+				return err
+			}
+
+			if err := l.config.Client.CompleteMultipartUpload(l.eventsKey, l.uploadID, l.parts); err != nil {
+				return err
+			}
+		}
+
+		l.completed = true
+	}
+
+	manifest, err := json.Marshal(S3Manifest{
+		Version:       S3ManifestVersion,
+		JobID:         l.config.JobID,
+		EventsKey:     l.eventsKey,
+		SchemaVersion: SchemaVersion,
+		Events:        events,
+		Bytes:         size,
+		Parts:         len(l.parts),
+		UploadedAt:    time.Now().Unix(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return l.config.Client.PutObject(l.manifestKey, manifest, "application/json")
+}
+
+func (l *S3Backend) CloseWithOptions(options CloseOptions) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.stopped
+
+	log.Printf("Uploading the rest of the logs...")
+	err := retry.RetryWithConstantWait(retry.RetryOptions{
+		Task:                 "upload logs",
+		MaxAttempts:          l.config.FlushAttempts,
+		DelayBetweenAttempts: l.config.DelayBetweenFlushAttempts,
+		Fn:                   l.finish,
+	})
+
+	// The logs are uploaded as an artifact too, if they could not be uploaded here.
+	if options.OnClose != nil {
+		options.OnClose(err != nil)
+	}
+
+	if err != nil {
+		if l.uploadID != "" && !l.completed {
+			if err := l.config.Client.AbortMultipartUpload(l.eventsKey, l.uploadID); err != nil {
+				log.Errorf("Error aborting upload %s: %v", l.uploadID, err)
+			}
+		}
+
+		log.Errorf("Could not upload logs to %s/%s - keeping them in %s", l.config.Client.Bucket, l.eventsKey, l.fileBackend.path)
+		return l.fileBackend.file.Close()
+	}
+
+	log.Infof("Uploaded logs to %s/%s", l.config.Client.Bucket, l.eventsKey)
+	return l.fileBackend.Close()
+}
+
+func (l *S3Backend) Close() error {
+	return l.CloseWithOptions(CloseOptions{})
+}
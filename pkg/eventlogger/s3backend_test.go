@@ -0,0 +1,198 @@
+package eventlogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/s3"
+	testsupport "github.com/semaphoreci/agent/test/support"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__S3Backend__ArgumentsMustBeValid(t *testing.T) {
+	client := &s3.Client{}
+
+	t.Run("client is required", func(t *testing.T) {
+		backend, err := NewS3Backend(S3BackendConfig{PartSize: 1, UploadIntervalInSeconds: 1, FlushAttempts: 1})
+		assert.Nil(t, backend)
+		assert.ErrorContains(t, err, "config.Client is required")
+	})
+
+	t.Run("part size must be positive", func(t *testing.T) {
+		backend, err := NewS3Backend(S3BackendConfig{Client: client, UploadIntervalInSeconds: 1, FlushAttempts: 1})
+		assert.Nil(t, backend)
+		assert.ErrorContains(t, err, "config.PartSize must be greater than 0")
+	})
+
+	t.Run("upload interval must be positive", func(t *testing.T) {
+		backend, err := NewS3Backend(S3BackendConfig{Client: client, PartSize: 1, FlushAttempts: 1})
+		assert.Nil(t, backend)
+		assert.ErrorContains(t, err, "config.UploadIntervalInSeconds must be greater than 0")
+	})
+
+	t.Run("flush attempts must be positive", func(t *testing.T) {
+		backend, err := NewS3Backend(S3BackendConfig{Client: client, PartSize: 1, UploadIntervalInSeconds: 1})
+		assert.Nil(t, backend)
+		assert.ErrorContains(t, err, "config.FlushAttempts must be greater than 0")
+	})
+}
+
+func Test__S3Backend__SmallLogsAreUploadedWithASingleRequest(t *testing.T) {
+	mockServer := testsupport.NewS3MockServer()
+	mockServer.Init()
+	defer mockServer.Close()
+
+	backend := newTestS3Backend(t, mockServer, DefaultS3PartSize)
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 10, backend)
+
+	// Reads come from the local copy.
+	buf := bytes.NewBuffer([]byte{})
+	next, err := backend.Read(0, 2, buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, next)
+
+	expected := readLocalCopy(t, backend)
+	require.NoError(t, backend.Close())
+
+	assert.Equal(t, []string{"logs/jobs/job-1/events.jsonl", "logs/jobs/job-1/manifest.json"}, mockServer.ObjectPaths())
+	assertS3Object(t, mockServer, "logs/jobs/job-1/events.jsonl", expected)
+	assert.Zero(t, mockServer.PartsUploaded)
+
+	manifest := getS3Manifest(t, mockServer)
+	assert.Equal(t, S3ManifestVersion, manifest.Version)
+	assert.Equal(t, "job-1", manifest.JobID)
+	assert.Equal(t, "jobs/job-1/events.jsonl", manifest.EventsKey)
+	assert.Equal(t, SchemaVersion, manifest.SchemaVersion)
+	assert.Equal(t, 14, manifest.Events)
+	assert.Equal(t, int64(len(expected)), manifest.Bytes)
+	assert.Zero(t, manifest.Parts)
+
+	_, err = os.Stat(backend.fileBackend.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test__S3Backend__LogsAreUploadedInParts(t *testing.T) {
+	mockServer := testsupport.NewS3MockServer()
+	mockServer.MinPartSize = 512
+	mockServer.Init()
+	defer mockServer.Close()
+
+	backend := newTestS3Backend(t, mockServer, 512)
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 100, backend)
+
+	// Wait for the upload loop to pick up the parts already available.
+	require.Eventually(t, func() bool { return mockServer.PendingUploads() == 1 }, 5*time.Second, 100*time.Millisecond)
+	expected := readLocalCopy(t, backend)
+	require.NoError(t, backend.Close())
+
+	assertS3Object(t, mockServer, "logs/jobs/job-1/events.jsonl", expected)
+	assert.Equal(t, 1, mockServer.UploadsCompleted)
+	assert.Zero(t, mockServer.PendingUploads())
+
+	expectedParts := (len(expected) + 511) / 512
+	assert.Equal(t, expectedParts, mockServer.PartsUploaded)
+
+	manifest := getS3Manifest(t, mockServer)
+	assert.Equal(t, expectedParts, manifest.Parts)
+	assert.Equal(t, 104, manifest.Events)
+}
+
+func Test__S3Backend__LocalCopyIsKeptIfUploadFails(t *testing.T) {
+	mockServer := testsupport.NewS3MockServer()
+	mockServer.Init()
+	defer mockServer.Close()
+
+	mockServer.SetUnavailable(true)
+
+	backend := newTestS3Backend(t, mockServer, DefaultS3PartSize)
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 10, backend)
+	expected := readLocalCopy(t, backend)
+
+	useArtifact := false
+	require.NoError(t, backend.CloseWithOptions(CloseOptions{
+		OnClose: func(b bool) { useArtifact = b },
+	}))
+
+	assert.True(t, useArtifact)
+	assert.Empty(t, mockServer.ObjectPaths())
+
+	// #nosec
+	content, err := os.ReadFile(backend.fileBackend.path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+	require.NoError(t, os.Remove(backend.fileBackend.path))
+}
+
+func Test__S3Backend__UploadIsRetried(t *testing.T) {
+	mockServer := testsupport.NewS3MockServer()
+	mockServer.Init()
+	defer mockServer.Close()
+
+	mockServer.SetUnavailable(true)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		mockServer.SetUnavailable(false)
+	}()
+
+	backend := newTestS3Backend(t, mockServer, DefaultS3PartSize)
+	backend.config.FlushAttempts = 20
+	require.NoError(t, backend.Open())
+	generateLogEvents(t, 10, backend)
+	expected := readLocalCopy(t, backend)
+
+	useArtifact := true
+	require.NoError(t, backend.CloseWithOptions(CloseOptions{
+		OnClose: func(b bool) { useArtifact = b },
+	}))
+
+	assert.False(t, useArtifact)
+	assertS3Object(t, mockServer, "logs/jobs/job-1/events.jsonl", expected)
+}
+
+func newTestS3Backend(t *testing.T, mockServer *testsupport.S3MockServer, partSize int) *S3Backend {
+	credentials := s3.Credentials{AccessKeyID: testsupport.S3MockAccessKeyID, SecretAccessKey: "secret"}
+	client, prefix, err := s3.NewClientFromURL(mockServer.URL()+"/logs/jobs", credentials, s3.DefaultRegion)
+	require.NoError(t, err)
+
+	backend, err := NewS3Backend(S3BackendConfig{
+		Client:                    client,
+		Prefix:                    prefix,
+		JobID:                     "job-1",
+		PartSize:                  partSize,
+		UploadIntervalInSeconds:   1,
+		FlushAttempts:             2,
+		DelayBetweenFlushAttempts: 50 * time.Millisecond,
+	})
+
+	require.NoError(t, err)
+	return backend
+}
+
+func readLocalCopy(t *testing.T, backend *S3Backend) []byte {
+	// #nosec
+	content, err := os.ReadFile(backend.fileBackend.path)
+	require.NoError(t, err)
+	return content
+}
+
+func assertS3Object(t *testing.T, mockServer *testsupport.S3MockServer, path string, expected []byte) {
+	object, ok := mockServer.GetObject(path)
+	require.True(t, ok)
+	assert.Equal(t, string(expected), string(object))
+}
+
+func getS3Manifest(t *testing.T, mockServer *testsupport.S3MockServer) S3Manifest {
+	object, ok := mockServer.GetObject("logs/jobs/job-1/manifest.json")
+	require.True(t, ok)
+
+	manifest := S3Manifest{}
+	require.NoError(t, json.Unmarshal(object, &manifest))
+	return manifest
+}
@@ -0,0 +1,116 @@
+package eventlogger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	"github.com/semaphoreci/agent/pkg/s3"
+)
+
+const (
+	SinkTypeFile = "file"
+	SinkTypeHTTP = "http"
+	SinkTypeS3   = "s3"
+)
+
+/*
+ * An extra destination for the job logs, besides the one in the job request.
+ * Sinks are configured with URLs:
+ *   - file:///var/log/jobs: writes the events to /var/log/jobs/<job_id>.jsonl, and keeps the file.
+ *   - http(s)://logs.example.com/jobs: pushes the events to the URL, like the push logger method.
+ *   - s3+http(s)://s3.example.com/bucket/prefix: uploads the events to S3-compatible storage, like the s3 logger method.
+ */
+type Sink struct {
+	Type     string
+	Location string
+}
+
+func ParseSink(spec string) (*Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log sink '%s': %v", spec, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if (u.Host != "" && u.Host != "localhost") || !filepath.IsAbs(u.Path) {
+			return nil, fmt.Errorf("invalid log sink '%s': file sinks need an absolute path", spec)
+		}
+
+		return &Sink{Type: SinkTypeFile, Location: u.Path}, nil
+
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid log sink '%s': no host", spec)
+		}
+
+		return &Sink{Type: SinkTypeHTTP, Location: spec}, nil
+
+	case "s3+http", "s3+https":
+		location := strings.TrimPrefix(spec, "s3+")
+		if _, _, err := s3.NewClientFromURL(location, s3.Credentials{}, s3.DefaultRegion); err != nil {
+			return nil, fmt.Errorf("invalid log sink '%s': %v", spec, err)
+		}
+
+		return &Sink{Type: SinkTypeS3, Location: location}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid log sink '%s': scheme must be file, http, https, s3+http or s3+https", spec)
+	}
+}
+
+func (s *Sink) String() string {
+	return fmt.Sprintf("%s (%s)", s.Location, s.Type)
+}
+
+func (s *Sink) NewBackend(request *api.JobRequest, userAgent string) (Backend, error) {
+	switch s.Type {
+	case SinkTypeFile:
+		name := request.JobID
+		if name == "" {
+			name = fmt.Sprintf("job_%d", time.Now().UnixNano())
+		}
+
+		backend, err := NewFileBackend(filepath.Join(s.Location, name+".jsonl"), math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.keepFile = true
+		return backend, nil
+
+	case SinkTypeHTTP:
+		return NewHTTPBackend(HTTPBackendConfig{
+			URL:                   s.Location,
+			UserAgent:             userAgent,
+			LinesPerRequest:       MaxLinesPerRequest,
+			BytesPerRequest:       DefaultBytesPerRequest,
+			FlushTimeoutInSeconds: DefaultFlushTimeoutInSeconds,
+			RefreshTokenFn: func() (string, error) {
+				return "", errors.New("log sinks do not use tokens")
+			},
+		})
+
+	case SinkTypeS3:
+		credentials, err := s3.CredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+
+		client, prefix, err := s3.NewClientFromURL(s.Location, credentials, s3.RegionFromEnv())
+		if err != nil {
+			return nil, err
+		}
+
+		return NewS3Backend(defaultS3BackendConfig(client, prefix, request.JobID))
+
+	default:
+		return nil, fmt.Errorf("unknown log sink type '%s'", s.Type)
+	}
+}
@@ -0,0 +1,89 @@
+package eventlogger
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	testsupport "github.com/semaphoreci/agent/test/support"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__ParseSink(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		expected *Sink
+		err      string
+	}{
+		{spec: "file:///var/log/jobs", expected: &Sink{Type: SinkTypeFile, Location: "/var/log/jobs"}},
+		{spec: "file://localhost/var/log/jobs", expected: &Sink{Type: SinkTypeFile, Location: "/var/log/jobs"}},
+		{spec: "https://logs.example.com/jobs", expected: &Sink{Type: SinkTypeHTTP, Location: "https://logs.example.com/jobs"}},
+		{spec: "http://localhost:8080", expected: &Sink{Type: SinkTypeHTTP, Location: "http://localhost:8080"}},
+		{spec: "s3+https://s3.example.com/bucket/logs", expected: &Sink{Type: SinkTypeS3, Location: "https://s3.example.com/bucket/logs"}},
+		{spec: "file://relative/path", err: "file sinks need an absolute path"},
+		{spec: "https://", err: "no host"},
+		{spec: "s3+http://localhost:9000", err: "no bucket"},
+		{spec: "/var/log/jobs", err: "scheme must be"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.spec, func(t *testing.T) {
+			sink, err := ParseSink(testCase.spec)
+			if testCase.err != "" {
+				assert.ErrorContains(t, err, testCase.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, sink)
+		})
+	}
+}
+
+func Test__LoggerWithSinks(t *testing.T) {
+	dir := t.TempDir()
+
+	// Sinks do not send a token.
+	mutex := sync.Mutex{}
+	received := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.Empty(t, r.Header.Get("Authorization"))
+		received = append(received, testsupport.FilterEmpty(strings.Split(string(body), "\n"))...)
+	}))
+
+	defer server.Close()
+
+	logger, err := CreateLogger(LoggerOptions{
+		Request: &api.JobRequest{JobID: "job-1", Logger: api.Logger{Method: LoggerMethodPull}},
+		Sinks: []string{
+			"file://" + dir,
+			server.URL,
+
+			// Sinks that cannot be created or opened do not fail the job.
+			"ftp://logs.example.com",
+			"file:///does/not/exist",
+		},
+	})
+
+	require.NoError(t, err)
+	generateLogEvents(t, 2, logger.Backend)
+	require.NoError(t, logger.Close())
+
+	// #nosec
+	content, err := os.ReadFile(filepath.Join(dir, "job-1.jsonl"))
+	require.NoError(t, err)
+	assert.Len(t, testsupport.FilterEmpty(strings.Split(string(content), "\n")), 6)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, received, 6)
+}
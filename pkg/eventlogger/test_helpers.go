@@ -3,6 +3,8 @@ package eventlogger
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 func TransformToObjects(events []string) ([]interface{}, error) {
@@ -25,6 +27,45 @@ func TransformToObjects(events []string) ([]interface{}, error) {
 			objects = append(objects, &CommandOutputEvent{Event: eventType, Output: object["output"].(string)})
 		case eventType == "cmd_finished":
 			objects = append(objects, &CommandFinishedEvent{Event: eventType, ExitCode: int(object["exit_code"].(float64))})
+		case eventType == "section_started":
+			objects = append(objects, &SectionStartedEvent{Event: eventType, Name: object["name"].(string)})
+		case eventType == "section_finished":
+			objects = append(objects, &SectionFinishedEvent{Event: eventType, Name: object["name"].(string)})
+		case eventType == "annotation":
+			annotation := &AnnotationEvent{}
+			if err := json.Unmarshal([]byte(event), annotation); err != nil {
+				return []interface{}{}, err
+			}
+
+			objects = append(objects, annotation)
+		case eventType == "test_summary":
+			summary := &TestSummaryEvent{}
+			if err := json.Unmarshal([]byte(event), summary); err != nil {
+				return []interface{}{}, err
+			}
+
+			objects = append(objects, summary)
+		case eventType == "job_summary":
+			summary := &JobSummaryEvent{}
+			if err := json.Unmarshal([]byte(event), summary); err != nil {
+				return []interface{}{}, err
+			}
+
+			objects = append(objects, summary)
+		case eventType == "job_outputs":
+			outputs := &JobOutputsEvent{}
+			if err := json.Unmarshal([]byte(event), outputs); err != nil {
+				return []interface{}{}, err
+			}
+
+			objects = append(objects, outputs)
+		case eventType == "job_resources":
+			usage := &JobResourcesEvent{}
+			if err := json.Unmarshal([]byte(event), usage); err != nil {
+				return []interface{}{}, err
+			}
+
+			objects = append(objects, usage)
 		}
 	}
 
@@ -67,6 +108,33 @@ func SimplifyLogEvents(events []interface{}, options SimplifyOptions) ([]string,
 			}
 
 			simplified = append(simplified, fmt.Sprintf("Exit Code: %d", e.ExitCode))
+		case *SectionStartedEvent:
+			simplified = append(simplified, "section_started: "+e.Name)
+		case *SectionFinishedEvent:
+			simplified = append(simplified, "section_finished: "+e.Name)
+		case *AnnotationEvent:
+			simplified = append(simplified, "annotation: "+strings.TrimSuffix(formatAnnotation(*e), "\n"))
+		case *TestSummaryEvent:
+			simplified = append(simplified, fmt.Sprintf("test_summary: %d total, %d failed, %d errors", e.Total, e.Failed, e.Errors))
+		case *JobSummaryEvent:
+			// The job summary has timings which change on every run, so we leave it out.
+			continue
+		case *JobOutputsEvent:
+			names := []string{}
+			for name := range e.Outputs {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+			outputs := []string{}
+			for _, name := range names {
+				outputs = append(outputs, fmt.Sprintf("%s=%s", name, e.Outputs[name]))
+			}
+
+			simplified = append(simplified, "job_outputs: "+strings.Join(outputs, ", "))
+		case *JobResourcesEvent:
+			// Same thing for the resource usage.
+			continue
 		default:
 			return []string{}, fmt.Errorf("unknown shell event")
 		}
@@ -0,0 +1,198 @@
+package eventlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/*
+ * Keeps the first headBytes of the log, and the last bytes of output before each event after that.
+ * The end of a log is usually where the failure is, so we don't want to lose it.
+ *
+ * Once the head is full, only cmd_output events are held back, in a rolling window.
+ * All the other events are written right away, so the command structure survives,
+ * and readers following the log don't stop at the head. Before each of them,
+ * the output still in the window is written, after a synthetic cmd_output event
+ * with the number of bytes omitted, if any.
+ *
+ * The output written from the windows uses up tailBytes, so the log stays close to its max size,
+ * but each window keeps at least minWindowBytes, so every command still shows how it ended.
+ */
+type headAndTailTrimmer struct {
+	headBytes int
+	tailBytes int
+
+	written  int
+	trimming bool
+
+	window            []heldEvent
+	windowOutputBytes int
+	windowWritten     int
+
+	// The output dropped from the window since it was last written.
+	marker       *heldEvent
+	omittedBytes int
+}
+
+// A window is never smaller than this fraction of the tail.
+const minWindowDivisor = 8
+
+type heldEvent struct {
+	line        []byte
+	outputBytes int
+	timestamp   int
+	timestampMs int64
+}
+
+func newHeadAndTailTrimmer(maxSizeInBytes, tailSizeInBytes int) *headAndTailTrimmer {
+	return &headAndTailTrimmer{
+		headBytes: maxSizeInBytes - tailSizeInBytes,
+		tailBytes: tailSizeInBytes,
+	}
+}
+
+func (t *headAndTailTrimmer) reset() {
+	*t = headAndTailTrimmer{headBytes: t.headBytes, tailBytes: t.tailBytes}
+}
+
+// Returns the lines to append to the trimmed log, if any.
+// The output is nil for all the events other than cmd_output.
+func (t *headAndTailTrimmer) add(line []byte, output *CommandOutputEvent) [][]byte {
+	if !t.trimming {
+		if t.written+len(line) <= t.headBytes {
+			t.written += len(line)
+			return [][]byte{line}
+		}
+
+		t.trimming = true
+	}
+
+	if output == nil {
+		return append(t.flush(), line)
+	}
+
+	t.window = append(t.window, heldEvent{
+		line:        line,
+		outputBytes: len(output.Output),
+		timestamp:   output.Timestamp,
+		timestampMs: output.TimestampMs,
+	})
+
+	t.windowOutputBytes += len(line)
+	t.trimWindow()
+	return nil
+}
+
+func (t *headAndTailTrimmer) windowBytes() int {
+	return max(t.tailBytes-t.windowWritten, t.tailBytes/minWindowDivisor)
+}
+
+func (t *headAndTailTrimmer) trimWindow() {
+	windowBytes := t.windowBytes()
+	for t.windowOutputBytes > windowBytes {
+		held := t.window[0]
+		t.window = t.window[1:]
+		t.windowOutputBytes -= len(held.line)
+		t.omittedBytes += held.outputBytes
+
+		if t.marker != nil {
+			t.marker.outputBytes += held.outputBytes
+			continue
+		}
+
+		t.marker = &heldEvent{
+			outputBytes: held.outputBytes,
+			timestamp:   held.timestamp,
+			timestampMs: held.timestampMs,
+		}
+	}
+}
+
+// Returns the output held back, after the marker for the output omitted before it.
+func (t *headAndTailTrimmer) flush() [][]byte {
+	lines := [][]byte{}
+	if t.marker != nil {
+		lines = append(lines, omittedOutputLine(*t.marker))
+	}
+
+	for _, held := range t.window {
+		lines = append(lines, held.line)
+	}
+
+	t.windowWritten += t.windowOutputBytes
+	t.window = nil
+	t.windowOutputBytes = 0
+	t.marker = nil
+	return lines
+}
+
+func omittedOutputLine(marker heldEvent) []byte {
+	// Marshaling this struct cannot fail.
+	line, _ := json.Marshal(&CommandOutputEvent{
+		Event:       "cmd_output",
+		Timestamp:   marker.timestamp,
+		TimestampMs: marker.timestampMs,
+		Output:      fmt.Sprintf("\n... %d bytes omitted ...\n", marker.outputBytes),
+	})
+
+	return append(line, '\n')
+}
+
+// A quarter of the log is kept for the tail, unless the job request says otherwise.
+func tailSizeFor(maxSizeInBytes, tailSizeInBytes int) int {
+	if tailSizeInBytes <= 0 || tailSizeInBytes >= maxSizeInBytes {
+		return maxSizeInBytes / 4
+	}
+
+	return tailSizeInBytes
+}
+
+/*
+ * Streams the events from startingLineNumber on, trimmed like the log after its head:
+ * all the events other than output, and the last output before each of them.
+ * Returns the number of the line after the last one read.
+ */
+func (l *FileBackend) readTail(startingLineNumber, tailSizeInBytes int, writer io.Writer) (int, error) {
+	tail := &tailWriter{trimmer: newHeadAndTailTrimmer(tailSizeInBytes, tailSizeInBytes)}
+	nextLineNumber, err := l.Read(startingLineNumber, 0, tail)
+	if err != nil {
+		return startingLineNumber, err
+	}
+
+	if tail.err != nil {
+		return startingLineNumber, tail.err
+	}
+
+	for _, line := range append(tail.lines, tail.trimmer.flush()...) {
+		if _, err := writer.Write(line); err != nil {
+			return startingLineNumber, err
+		}
+	}
+
+	return nextLineNumber, nil
+}
+
+// Gets one event on each write, from FileBackend.Read().
+type tailWriter struct {
+	trimmer *headAndTailTrimmer
+	lines   [][]byte
+	err     error
+}
+
+func (w *tailWriter) Write(data []byte) (int, error) {
+	line := append([]byte{}, data...)
+	event := CommandOutputEvent{}
+	if err := json.Unmarshal(line, &event); err != nil {
+		w.err = fmt.Errorf("error parsing log event: %v", err)
+		return 0, w.err
+	}
+
+	var output *CommandOutputEvent
+	if event.Event == "cmd_output" {
+		output = &event
+	}
+
+	w.lines = append(w.lines, w.trimmer.add(line, output)...)
+	return len(data), nil
+}
@@ -0,0 +1,27 @@
+package executors
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Where the job shell in a container saves its environment for the debug shell.
+const containerDebugEnvPath = "/tmp/.semaphore-agent-debug-env"
+
+/*
+ * The job shell in the container saves its exported variables and working directory,
+ * and the debug shell, started in the same container, loads them, before going interactive.
+ * They go through a file in the container, so no secrets show up in the arguments on the host.
+ */
+func containerDebugShell(run func(string) (string, int), executable string, prefix ...string) (*exec.Cmd, error) {
+	output, code := run(fmt.Sprintf(`(export -p; printf 'cd %%q\n' "$PWD") > %s`, containerDebugEnvPath))
+	if code != 0 {
+		return nil, fmt.Errorf("error saving the job environment: exit code %d - %s", code, output)
+	}
+
+	args := append([]string{}, prefix...)
+	args = append(args, "bash", "-c", fmt.Sprintf("source %s; rm -f %s; exec bash", containerDebugEnvPath, containerDebugEnvPath))
+
+	// #nosec
+	return exec.Command(executable, args...), nil
+}
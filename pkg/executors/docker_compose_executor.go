@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	"github.com/semaphoreci/agent/pkg/config"
 	"github.com/semaphoreci/agent/pkg/docker"
 	eventlogger "github.com/semaphoreci/agent/pkg/eventlogger"
+	"github.com/semaphoreci/agent/pkg/resources"
 	shell "github.com/semaphoreci/agent/pkg/shell"
 	log "github.com/sirupsen/logrus"
 )
@@ -36,12 +38,16 @@ type DockerComposeExecutor struct {
 	exposeKvmDevice           bool
 	fileInjections            []config.FileInjection
 	FailOnMissingFiles        bool
+	imagePullStartedAt        time.Time
+	imagePullDuration         time.Duration
+	terminal                  shell.Terminal
 }
 
 type DockerComposeExecutorOptions struct {
 	ExposeKvmDevice    bool
 	FileInjections     []config.FileInjection
 	FailOnMissingFiles bool
+	Terminal           shell.Terminal
 }
 
 func NewDockerComposeExecutor(request *api.JobRequest, logger *eventlogger.Logger, options DockerComposeExecutorOptions) *DockerComposeExecutor {
@@ -52,6 +58,7 @@ func NewDockerComposeExecutor(request *api.JobRequest, logger *eventlogger.Logge
 		exposeKvmDevice:           options.ExposeKvmDevice,
 		fileInjections:            options.FileInjections,
 		FailOnMissingFiles:        options.FailOnMissingFiles,
+		terminal:                  options.Terminal,
 		dockerComposeManifestPath: "/tmp/docker-compose.yml",
 		tmpDirectory:              "/tmp/agent-temp-directory", // make a better random name
 
@@ -246,10 +253,16 @@ func (e *DockerComposeExecutor) startBashSession() int {
 		"/var/run/docker.sock:/var/run/docker.sock",
 		"-v",
 		fmt.Sprintf("%s:%s:ro", e.tmpDirectory, e.tmpDirectory),
-		e.mainContainerName,
-		"bash",
 	)
 
+	// Docker uses TERM=xterm in the container, unless told otherwise.
+	// The size comes from the PTY docker compose runs in.
+	if e.terminal.Type != "" {
+		args = append(args, "-e", "TERM="+e.terminal.Type)
+	}
+
+	args = append(args, e.mainContainerName, "bash")
+
 	shell, err := shell.NewShellFromExecAndArgs(executable, args, e.tmpDirectory)
 	if err != nil {
 		log.Errorf("Failed to start stateful shell err: %+v", err)
@@ -261,6 +274,10 @@ func (e *DockerComposeExecutor) startBashSession() int {
 		return exitCode
 	}
 
+	shell.Terminal = e.terminal
+
+	// The temporary directory is mounted read-only, so the status channel runs in the container.
+	shell.ChannelCommand = []string{"docker", "exec", "-i", e.mainContainerName}
 	err = shell.Start()
 	if err != nil {
 		log.Errorf("Failed to start stateful shell err: %+v", err)
@@ -531,7 +548,8 @@ func (e *DockerComposeExecutor) injectImagePullSecretsForGCR(envVars []api.EnvVa
 func (e *DockerComposeExecutor) pullDockerImages() int {
 	log.Debug("Pulling docker images")
 	directive := "Pulling docker images..."
-	commandStartedAt := int(time.Now().Unix())
+	pullStartedAt := time.Now()
+	commandStartedAt := int(pullStartedAt.Unix())
 	e.SubmitDockerStats("compose.docker.pull.rate")
 	e.Logger.LogCommandStarted(directive)
 
@@ -593,6 +611,8 @@ func (e *DockerComposeExecutor) pullDockerImages() int {
 
 	log.Infof("Docker pull finished. Exit Code: %d", exitCode)
 
+	e.imagePullStartedAt = pullStartedAt
+	e.imagePullDuration = time.Since(pullStartedAt)
 	commandFinishedAt := int(time.Now().Unix())
 	e.SubmitDockerPullTime(commandFinishedAt - commandStartedAt)
 	e.Logger.LogCommandFinished(directive, exitCode, commandStartedAt, commandFinishedAt)
@@ -600,6 +620,35 @@ func (e *DockerComposeExecutor) pullDockerImages() int {
 	return exitCode
 }
 
+func (e *DockerComposeExecutor) ImagePullStartedAt() time.Time {
+	return e.imagePullStartedAt
+}
+
+func (e *DockerComposeExecutor) ImagePullDuration() time.Duration {
+	return e.imagePullDuration
+}
+
+// The job commands run in the main container, so we sample its cgroup.
+func (e *DockerComposeExecutor) ResourceSource() (resources.Source, error) {
+	// #nosec
+	output, err := exec.Command("docker", "inspect", "-f", "{{.State.Pid}}", e.mainContainerName).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting container %s: %v, %s", e.mainContainerName, err, string(output))
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil || pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", e.mainContainerName)
+	}
+
+	return resources.NewCgroupSourceForPID(pid)
+}
+
+// The debug shell runs in the main container, next to the job shell.
+func (e *DockerComposeExecutor) DebugShell() (*exec.Cmd, error) {
+	return containerDebugShell(e.GetOutputFromCommand, "docker", "exec", "-it", e.mainContainerName)
+}
+
 func (e *DockerComposeExecutor) ExportEnvVars(envVars []api.EnvVar, hostEnvVars []config.HostEnvVar) int {
 	commandStartedAt := int(time.Now().Unix())
 	directive := "Exporting environment variables"
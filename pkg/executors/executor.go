@@ -1,8 +1,12 @@
 package executors
 
 import (
+	"os/exec"
+	"time"
+
 	api "github.com/semaphoreci/agent/pkg/api"
 	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/resources"
 )
 
 type Executor interface {
@@ -17,6 +21,26 @@ type Executor interface {
 	Cleanup() int
 }
 
+// Executors that pull images while starting can report how long the pull took,
+// so it can be told apart from the rest of the executor start in the job summary.
+type ImagePullTimer interface {
+	ImagePullStartedAt() time.Time
+	ImagePullDuration() time.Duration
+}
+
+// Executors that know where the job processes run can have their resource usage sampled.
+// Only available after the executor is started.
+type ResourceSourceProvider interface {
+	ResourceSource() (resources.Source, error)
+}
+
+// Executors that can open an interactive shell in the job environment,
+// with its environment variables and working directory, for a debug session.
+// Only available after the executor is started.
+type DebugShellProvider interface {
+	DebugShell() (*exec.Cmd, error)
+}
+
 type CommandOptions struct {
 	Command string
 	Silent  bool
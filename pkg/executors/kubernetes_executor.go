@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"time"
 
@@ -26,6 +27,7 @@ type KubernetesExecutor struct {
 	imagePullSecret string
 	logger          *eventlogger.Logger
 	Shell           *shell.Shell
+	terminal        shell.Terminal
 
 	// If the executor is stopped before it even starts, we need to cancel it.
 	cancelFunc context.CancelFunc
@@ -35,7 +37,7 @@ type KubernetesExecutor struct {
 	initialEnvironmentExposed bool
 }
 
-func NewKubernetesExecutor(jobRequest *api.JobRequest, logger *eventlogger.Logger, k8sConfig kubernetes.Config) (*KubernetesExecutor, error) {
+func NewKubernetesExecutor(jobRequest *api.JobRequest, logger *eventlogger.Logger, k8sConfig kubernetes.Config, terminal shell.Terminal) (*KubernetesExecutor, error) {
 	clientset, err := kubernetes.NewInClusterClientset()
 	if err != nil {
 		log.Warnf("No in-cluster configuration found - using ~/.kube/config...")
@@ -55,6 +57,7 @@ func NewKubernetesExecutor(jobRequest *api.JobRequest, logger *eventlogger.Logge
 		k8sClient:  k8sClient,
 		jobRequest: jobRequest,
 		logger:     logger,
+		terminal:   terminal,
 	}, nil
 }
 
@@ -151,10 +154,16 @@ func (e *KubernetesExecutor) Start() int {
 		"-c",
 		"main",
 		"--",
-		"bash",
-		"--login",
 	}
 
+	// kubectl exec doesn't pass TERM along.
+	// The size comes from the PTY kubectl runs in.
+	if e.terminal.Type != "" {
+		args = append(args, "env", "TERM="+e.terminal.Type)
+	}
+
+	args = append(args, "bash", "--login")
+
 	shell, err := shell.NewShellFromExecAndArgs(executable, args, os.TempDir())
 	if err != nil {
 		log.Errorf("Failed to create shell: %v", err)
@@ -165,6 +174,10 @@ func (e *KubernetesExecutor) Start() int {
 		return exitCode
 	}
 
+	shell.Terminal = e.terminal
+
+	// The pod doesn't share a folder with the agent, so the status channel runs in it too.
+	shell.ChannelCommand = []string{executable, "exec", "-i", e.podName, "-c", "main", "--"}
 	err = shell.Start()
 	if err != nil {
 		log.Errorf("Failed to start shell err: %+v", err)
@@ -340,6 +353,11 @@ func (e *KubernetesExecutor) InjectFiles(files []api.File) int {
 	return exitCode
 }
 
+// The debug shell runs in the main container of the pod, next to the job shell.
+func (e *KubernetesExecutor) DebugShell() (*exec.Cmd, error) {
+	return containerDebugShell(e.GetOutputFromCommand, "kubectl", "exec", "-it", e.podName, "-c", "main", "--")
+}
+
 func (e *KubernetesExecutor) RunCommand(command string, silent bool, alias string) int {
 	return e.RunCommandWithOptions(CommandOptions{
 		Command: command,
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	api "github.com/semaphoreci/agent/pkg/api"
 	"github.com/semaphoreci/agent/pkg/config"
 	eventlogger "github.com/semaphoreci/agent/pkg/eventlogger"
+	"github.com/semaphoreci/agent/pkg/resources"
 	shell "github.com/semaphoreci/agent/pkg/shell"
 	log "github.com/sirupsen/logrus"
 )
@@ -22,19 +24,45 @@ type ShellExecutor struct {
 	Shell                   *shell.Shell
 	jobRequest              *api.JobRequest
 	tmpDirectory            string
+	shell                   string
+	separateOutputStreams   bool
+	terminal                shell.Terminal
 	hasSSHJumpPoint         bool
 	shouldUpdateBashProfile bool
 	cleanupAfterClose       []string
+	stopGracePeriod         time.Duration
 }
 
-func NewShellExecutor(request *api.JobRequest, logger *eventlogger.Logger, selfHosted bool) *ShellExecutor {
+type ShellExecutorOptions struct {
+	SelfHosted bool
+
+	// How long the job processes have to exit after SIGTERM, before being killed.
+	// Zero means they are killed right away.
+	StopGracePeriod time.Duration
+
+	// Empty means the default shell.
+	Shell string
+
+	// Run the commands with pipes instead of a PTY, to tag their output with its stream.
+	// Only supported on Linux.
+	SeparateOutputStreams bool
+
+	// The size and type of the terminal the commands run in.
+	Terminal shell.Terminal
+}
+
+func NewShellExecutor(request *api.JobRequest, logger *eventlogger.Logger, options ShellExecutorOptions) *ShellExecutor {
 	return &ShellExecutor{
 		Logger:                  logger,
 		jobRequest:              request,
 		tmpDirectory:            os.TempDir(),
-		hasSSHJumpPoint:         !selfHosted,
-		shouldUpdateBashProfile: !selfHosted,
+		shell:                   options.Shell,
+		separateOutputStreams:   options.SeparateOutputStreams,
+		hasSSHJumpPoint:         !options.SelfHosted,
+		shouldUpdateBashProfile: !options.SelfHosted,
 		cleanupAfterClose:       []string{},
+		stopGracePeriod:         options.StopGracePeriod,
+		terminal:                options.Terminal,
 	}
 }
 
@@ -74,13 +102,15 @@ func (e *ShellExecutor) setUpSSHJumpPoint() int {
 }
 
 func (e *ShellExecutor) Start() int {
-	sh, err := shell.NewShell(e.tmpDirectory)
+	sh, err := shell.NewShellFor(e.shell, e.tmpDirectory)
 	if err != nil {
-		log.Debug(sh)
+		log.Errorf("Failed to create shell: %v", err)
 		return 1
 	}
 
 	e.Shell = sh
+	e.Shell.UsePipes = e.separateOutputStreams
+	e.Shell.Terminal = e.terminal
 
 	err = e.Shell.Start()
 	if err != nil {
@@ -132,7 +162,7 @@ func (e *ShellExecutor) ExportEnvVars(envVars []api.EnvVar, hostEnvVars []config
 	 */
 	envFileName := e.envFileName()
 	e.cleanupAfterClose = append(e.cleanupAfterClose, envFileName)
-	err = environment.ToFile(envFileName, func(name string) {
+	err = environment.ToFileFor(e.Shell.Dialect, envFileName, func(name string) {
 		e.Logger.LogCommandOutput(fmt.Sprintf("Exporting %s\n", name))
 	})
 
@@ -151,14 +181,14 @@ func (e *ShellExecutor) ExportEnvVars(envVars []api.EnvVar, hostEnvVars []config
 		return exitCode
 	}
 
-	cmd := fmt.Sprintf("source %s", envFileName)
-	exitCode = e.RunCommand(cmd, true, "")
+	exitCode = e.RunCommand(e.Shell.Dialect.Source(envFileName), true, "")
 	if exitCode != 0 {
 		return exitCode
 	}
 
-	if e.shouldUpdateBashProfile {
-		cmd = fmt.Sprintf("echo 'source %s' >> ~/.bash_profile", envFileName)
+	// The SSH jump point always opens bash, which cannot source the environment file of other shells.
+	if e.shouldUpdateBashProfile && e.Shell.Dialect == shell.Bash {
+		cmd := fmt.Sprintf("echo 'source %s' >> ~/.bash_profile", envFileName)
 		exitCode = e.RunCommand(cmd, true, "")
 		if exitCode != 0 {
 			return exitCode
@@ -265,10 +295,20 @@ func (e *ShellExecutor) RunCommandWithOptions(options CommandOptions) int {
 		directive = options.Alias
 	}
 
-	p := e.Shell.NewProcessWithOutput(options.Command, func(output string) {
-		if !options.Silent {
-			e.Logger.LogCommandOutput(output)
-		}
+	p := e.Shell.NewProcessWithConfig(shell.Config{
+		Command:     options.Command,
+		Shell:       e.Shell,
+		StoragePath: e.Shell.StoragePath,
+		OnOutput: func(output string) {
+			if !options.Silent {
+				e.Logger.LogCommandOutput(output)
+			}
+		},
+		OnStreamOutput: func(stream, output string) {
+			if !options.Silent {
+				e.Logger.LogCommandStreamOutput(stream, output)
+			}
+		},
 	})
 
 	if !options.Silent {
@@ -292,9 +332,56 @@ func (e *ShellExecutor) RunCommandWithOptions(options CommandOptions) int {
 	return p.ExitCode
 }
 
+// The job commands run in the bash session, so we sample its process tree.
+func (e *ShellExecutor) ResourceSource() (resources.Source, error) {
+	if e.Shell != nil && e.Shell.UsePipes {
+		return nil, fmt.Errorf("commands run in a new process each, with separate output streams")
+	}
+
+	if e.Shell == nil || e.Shell.BootCommand == nil || e.Shell.BootCommand.Process == nil {
+		return nil, fmt.Errorf("shell is not running")
+	}
+
+	return resources.NewProcessTreeSource(e.Shell.BootCommand.Process.Pid)
+}
+
+// The job shell saves its environment, and a new one is started with it.
+func (e *ShellExecutor) DebugShell() (*exec.Cmd, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("debug sessions are not supported on Windows")
+	}
+
+	dumpPath := filepath.Join(e.tmpDirectory, "debug-env")
+	defer os.Remove(dumpPath)
+
+	output, code := e.GetOutputFromCommand(fmt.Sprintf("env -0 > %s", dumpPath))
+	if code != 0 {
+		return nil, fmt.Errorf("error saving the job environment: exit code %d - %s", code, output)
+	}
+
+	env, err := shell.CreateEnvironmentFromDump(dumpPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the job environment: %v", err)
+	}
+
+	// The debug shell is started and waited for by the agent, so it is not a job process.
+	env.Remove(shell.ProcessMarkerEnvVar)
+
+	// #nosec
+	cmd := exec.Command(e.Shell.Executable)
+	cmd.Env = env.ToSlice()
+	if cwd, ok := env.Get("PWD"); ok {
+		cmd.Dir = cwd
+	}
+
+	return cmd, nil
+}
+
 func (e *ShellExecutor) Stop() int {
 	log.Debug("Starting the process killing procedure")
 
+	e.Shell.Interrupt(e.stopGracePeriod)
+
 	err := e.Shell.Close()
 	if err != nil {
 		log.Error(err)
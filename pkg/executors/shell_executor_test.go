@@ -434,7 +434,7 @@ func setupShellExecutor(t *testing.T, selfHosted bool) (*ShellExecutor, *eventlo
 	testsupport.SetupTestLogs()
 
 	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
-	e := NewShellExecutor(basicRequest(), testLogger, selfHosted)
+	e := NewShellExecutor(basicRequest(), testLogger, ShellExecutorOptions{SelfHosted: selfHosted})
 
 	assert.Zero(t, e.Prepare())
 	assert.Zero(t, e.Start())
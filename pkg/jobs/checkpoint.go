@@ -0,0 +1,233 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	shell "github.com/semaphoreci/agent/pkg/shell"
+	"github.com/semaphoreci/agent/pkg/tracing"
+	log "github.com/sirupsen/logrus"
+)
+
+const CheckpointDirective = "Resuming from checkpoint"
+
+const (
+	checkpointStateFile = "state.json"
+	checkpointEnvFile   = "env"
+	checkpointPwdFile   = "pwd"
+)
+
+/*
+ * Used by `agent run --resume` to continue a job from its first failed command.
+ * After each successful command, we record how many commands passed,
+ * along with a snapshot of the shell's exported environment and working directory.
+ *
+ * The commands that already passed are hashed, so we only resume
+ * if they did not change. The command that failed, and the ones after it,
+ * can be changed freely between runs.
+ */
+type Checkpoint struct {
+	CommandIndex int    `json:"command_index"`
+	CommandsHash string `json:"commands_hash"`
+}
+
+// The checkpoint state lives in a hidden directory next to the job file.
+func CheckpointDirFor(jobFile string) (string, error) {
+	path, err := filepath.Abs(jobFile)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".checkpoint"), nil
+}
+
+// The environment snapshot relies on the shell running on the same host as the agent,
+// and it is restored with `export` commands, so we need a POSIX shell.
+func checkpointsSupported(request *api.JobRequest, dialect shell.Dialect) bool {
+	return runtime.GOOS != "windows" && request.Executor == executors.ExecutorTypeShell && dialect.POSIX()
+}
+
+func hashCommands(commands []api.Command) string {
+	hash := sha256.New()
+	for _, command := range commands {
+		fmt.Fprintf(hash, "%s\x00%s\x00", command.Directive, command.Alias)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (job *Job) runCommandsWithCheckpoints(commands []api.Command) int {
+	start := 0
+	if checkpoint := job.loadCheckpoint(commands); checkpoint != nil && job.restoreCheckpoint(checkpoint, commands) {
+		start = checkpoint.CommandIndex
+	}
+
+	exitCode := job.runCommandsUntilFirstFailure(commands[start:], func(index int) {
+		job.saveCheckpoint(commands, start+index+1)
+	})
+
+	// Nothing left to resume.
+	if exitCode == 0 && !job.Stopped {
+		if err := os.RemoveAll(job.CheckpointDir); err != nil {
+			log.Errorf("Error removing checkpoint directory %s: %v", job.CheckpointDir, err)
+		}
+	}
+
+	return exitCode
+}
+
+func (job *Job) loadCheckpoint(commands []api.Command) *Checkpoint {
+	// #nosec
+	content, err := os.ReadFile(filepath.Join(job.CheckpointDir, checkpointStateFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Error reading checkpoint: %v", err)
+		}
+
+		return nil
+	}
+
+	checkpoint := Checkpoint{}
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		log.Errorf("Error parsing checkpoint: %v", err)
+		return nil
+	}
+
+	if checkpoint.CommandIndex <= 0 || checkpoint.CommandIndex >= len(commands) {
+		log.Infof("Checkpoint for command %d does not apply to a job with %d commands - ignoring it", checkpoint.CommandIndex, len(commands))
+		return nil
+	}
+
+	if checkpoint.CommandsHash != hashCommands(commands[:checkpoint.CommandIndex]) {
+		log.Infof("Commands changed since the checkpoint was recorded - ignoring it")
+		return nil
+	}
+
+	return &checkpoint
+}
+
+func (job *Job) restoreCheckpoint(checkpoint *Checkpoint, commands []api.Command) bool {
+	startedAt := int(time.Now().Unix())
+	job.Logger.LogCommandStarted(CheckpointDirective)
+
+	// Some variables, like UID, are read-only, so we ignore the errors from sourcing the snapshot.
+	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
+		Command: fmt.Sprintf(
+			"%s 2>/dev/null; cd \"$(cat %s)\"",
+			job.dialect.Source(shellQuote(filepath.Join(job.CheckpointDir, checkpointEnvFile))),
+			shellQuote(filepath.Join(job.CheckpointDir, checkpointPwdFile)),
+		),
+		Silent: true,
+	})
+
+	if exitCode != 0 {
+		job.Logger.LogCommandOutput("Failed to restore the environment from the checkpoint - running all commands.\n")
+		job.Logger.LogCommandFinished(CheckpointDirective, exitCode, startedAt, int(time.Now().Unix()))
+		return false
+	}
+
+	job.Logger.LogCommandOutput(fmt.Sprintf("Skipping %d command(s) that passed in a previous run:\n", checkpoint.CommandIndex))
+	for _, command := range commands[:checkpoint.CommandIndex] {
+		job.Logger.LogCommandOutput(fmt.Sprintf("  %s\n", strings.SplitN(commandName(command), "\n", 2)[0]))
+	}
+
+	job.Logger.LogCommandFinished(CheckpointDirective, 0, startedAt, int(time.Now().Unix()))
+	return true
+}
+
+func (job *Job) saveCheckpoint(commands []api.Command, index int) {
+	if err := os.MkdirAll(job.CheckpointDir, 0700); err != nil {
+		log.Errorf("Error creating checkpoint directory %s: %v", job.CheckpointDir, err)
+		return
+	}
+
+	// The snapshot is taken from inside the shell, and written to a temporary file first,
+	// so an interrupted snapshot does not overwrite the previous one.
+	envFile := filepath.Join(job.CheckpointDir, checkpointEnvFile)
+	pwdFile := filepath.Join(job.CheckpointDir, checkpointPwdFile)
+	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
+		Command: fmt.Sprintf("env -0 > %s && pwd > %s", shellQuote(envFile+".tmp"), shellQuote(pwdFile)),
+		Silent:  true,
+	})
+
+	if exitCode != 0 {
+		log.Errorf("Error taking environment snapshot for checkpoint: exit code %d", exitCode)
+		return
+	}
+
+	if err := job.filterSnapshot(envFile+".tmp", envFile); err != nil {
+		log.Errorf("Error filtering environment snapshot for checkpoint: %v", err)
+		return
+	}
+
+	content, err := json.Marshal(Checkpoint{
+		CommandIndex: index,
+		CommandsHash: hashCommands(commands[:index]),
+	})
+
+	if err != nil {
+		log.Errorf("Error serializing checkpoint: %v", err)
+		return
+	}
+
+	// #nosec
+	if err := os.WriteFile(filepath.Join(job.CheckpointDir, checkpointStateFile), content, 0600); err != nil {
+		log.Errorf("Error writing checkpoint: %v", err)
+	}
+}
+
+/*
+ * The snapshot is taken with `env -0`, so values with newlines or quotes
+ * can't be mistaken for other variables, and written back as `export` commands.
+ *
+ * The variables the agent sets again on every run are left out of the snapshot,
+ * so the resumed run doesn't get the previous run's values for them:
+ * the process marker would hide the job processes from the stop,
+ * and the spans would go to the previous run's trace.
+ */
+func (job *Job) filterSnapshot(from, to string) error {
+	perRun := map[string]bool{
+		shell.ProcessMarkerEnvVar: true,
+		tracing.TraceparentEnvVar: true,
+	}
+
+	for _, envVar := range job.Request.EnvVars {
+		perRun[envVar.Name] = true
+	}
+
+	// #nosec
+	content, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+
+	// Names which are not shell variables, like the ones of exported bash functions, can't be exported back.
+	filtered := strings.Builder{}
+	for _, record := range strings.Split(string(content), "\x00") {
+		name, value, found := strings.Cut(record, "=")
+		if !found || perRun[name] || !variableNameRegex.MatchString(name) {
+			continue
+		}
+
+		fmt.Fprintf(&filtered, "export %s=%s\n", name, shellQuote(value))
+	}
+
+	if err := os.WriteFile(from, []byte(filtered.String()), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(from, to)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
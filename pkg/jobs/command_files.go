@@ -0,0 +1,173 @@
+package jobs
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	log "github.com/sirupsen/logrus"
+)
+
+const EnvFileEnvVar = "SEMAPHORE_ENV_FILE"
+const OutputFileEnvVar = "SEMAPHORE_OUTPUT_FILE"
+
+var variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+/*
+ * Commands can append to these files to communicate with the agent:
+ *   - Variables appended to the env file are exported into all the commands after it, epilogues included.
+ *   - Values appended to the output file are reported back in a job_outputs event at the end of the job.
+ *
+ * Both use the same format: a NAME=value per line, or, for multi-line values:
+ *
+ *   NAME<<DELIMITER
+ *   first line
+ *   second line
+ *   DELIMITER
+ */
+func (job *Job) exportCommandFiles() {
+	envFile := newJobFile(EnvFileEnvVar, "env")
+	if exitCode := job.exportJobFile(envFile); exitCode != 0 {
+		log.Errorf("Error exporting %s: exit code %d", EnvFileEnvVar, exitCode)
+	} else {
+		job.envFile = envFile
+	}
+
+	outputFile := newJobFile(OutputFileEnvVar, "output")
+	if exitCode := job.exportJobFile(outputFile); exitCode != 0 {
+		log.Errorf("Error exporting %s: exit code %d", OutputFileEnvVar, exitCode)
+	} else {
+		job.outputFile = outputFile
+	}
+}
+
+// Exports the variables appended to the env file by the last command.
+func (job *Job) applyEnvFile() {
+	if job.envFile == nil {
+		return
+	}
+
+	content, err := job.consumeJobFile(job.envFile)
+	if err != nil {
+		log.Errorf("Error reading %s: %v", EnvFileEnvVar, err)
+		return
+	}
+
+	variables, err := parseCommandFile(content)
+	if err != nil {
+		log.Errorf("Error parsing %s: %v", EnvFileEnvVar, err)
+	}
+
+	if len(variables) == 0 {
+		return
+	}
+
+	commands := []string{}
+	for _, variable := range variables {
+		commands = append(commands, exportVariableCommand(variable.Name, variable.Value))
+	}
+
+	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
+		Command: strings.Join(commands, "\n"),
+		Silent:  true,
+	})
+
+	if exitCode != 0 {
+		log.Errorf("Error exporting variables from %s: exit code %d", EnvFileEnvVar, exitCode)
+	}
+}
+
+func (job *Job) logOutputs() {
+	if job.outputFile == nil {
+		return
+	}
+
+	content, err := job.readJobFile(job.outputFile)
+	if err != nil {
+		log.Errorf("Error reading %s: %v", OutputFileEnvVar, err)
+		return
+	}
+
+	variables, err := parseCommandFile(content)
+	if err != nil {
+		log.Errorf("Error parsing %s: %v", OutputFileEnvVar, err)
+	}
+
+	if len(variables) == 0 {
+		return
+	}
+
+	// If an output is written more than once, the last value wins.
+	outputs := map[string]string{}
+	for _, variable := range variables {
+		outputs[variable.Name] = variable.Value
+	}
+
+	job.Logger.LogJobOutputs(outputs)
+}
+
+func exportVariableCommand(name, value string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("$env:%s = '%s'", name, strings.ReplaceAll(value, "'", "''"))
+	}
+
+	return fmt.Sprintf("export %s=%s", name, shellQuote(value))
+}
+
+type commandFileVariable struct {
+	Name  string
+	Value string
+}
+
+// The valid lines are returned even if some of them are invalid.
+// Only the line numbers are reported for the invalid ones, since they might have secrets.
+func parseCommandFile(content string) ([]commandFileVariable, error) {
+	variables := []commandFileVariable{}
+	invalid := []string{}
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		start := i
+		if name, delimiter, found := strings.Cut(line, "<<"); found && variableNameRegex.MatchString(name) && delimiter != "" {
+			valueLines := []string{}
+			closed := false
+			for i++; i < len(lines); i++ {
+				if lines[i] == delimiter {
+					closed = true
+					break
+				}
+
+				valueLines = append(valueLines, lines[i])
+			}
+
+			if !closed {
+				invalid = append(invalid, fmt.Sprintf("%d (missing closing delimiter)", start+1))
+				break
+			}
+
+			variables = append(variables, commandFileVariable{Name: name, Value: strings.Join(valueLines, "\n")})
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found || !variableNameRegex.MatchString(name) {
+			invalid = append(invalid, fmt.Sprint(i+1))
+			continue
+		}
+
+		variables = append(variables, commandFileVariable{Name: name, Value: value})
+	}
+
+	if len(invalid) > 0 {
+		return variables, fmt.Errorf("invalid lines: %s", strings.Join(invalid, ", "))
+	}
+
+	return variables, nil
+}
@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/debugsession"
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	log "github.com/sirupsen/logrus"
+)
+
+const DebugSessionDirective = "Paused for debugging"
+
+// Jobs can ask to be paused for debugging, or not, regardless of the agent configuration.
+const DebugOnFailureEnvVar = "SEMAPHORE_DEBUG_ON_FAILURE_TIMEOUT"
+
+/*
+ * After the first failed command, the job is paused, with the executor still running,
+ * so a shell in the job environment can be attached with 'agent attach <job-id>'.
+ * The job resumes when the shell is detached, when the timeout is over, or when the job is stopped.
+ */
+func (job *Job) pauseForDebugging() {
+	timeout := job.debugOnFailureTimeout()
+	if timeout <= 0 {
+		return
+	}
+
+	if runtime.GOOS == "windows" {
+		log.Warn("Debug sessions are not supported on Windows - not pausing the job")
+		return
+	}
+
+	provider, ok := job.Executor.(executors.DebugShellProvider)
+	if !ok {
+		log.Infof("Executor %s does not support debug sessions", job.Request.Executor)
+		return
+	}
+
+	job.timePhase(PhaseDebugSession, func() { job.runDebugSession(provider, timeout) })
+}
+
+func (job *Job) runDebugSession(provider executors.DebugShellProvider, timeout time.Duration) {
+	jobID := job.Request.JobID
+	if jobID == "" {
+		jobID = debugsession.DefaultJobID
+	}
+
+	startedAt := int(time.Now().Unix())
+	job.Logger.LogCommandStarted(DebugSessionDirective)
+	job.Logger.LogCommandOutput(fmt.Sprintf(
+		"A command failed, so the job is paused for %v.\nRun 'agent attach %s' on the agent host for a shell in the job environment. The job resumes when the shell exits, or when you detach with %s.\n",
+		timeout,
+		jobID,
+		debugsession.DetachKeyName,
+	))
+
+	exitCode := 0
+	attached, err := debugsession.Serve(job.stopContext, debugsession.Options{
+		SocketPath: debugsession.SocketPath(jobID),
+		Timeout:    timeout,
+		NewShell:   provider.DebugShell,
+	})
+
+	switch {
+	case err != nil:
+		log.Errorf("Error in debug session: %v", err)
+		job.Logger.LogCommandOutput(fmt.Sprintf("Error in debug session: %v\n", err))
+		exitCode = 1
+	case job.Stopped:
+		job.Logger.LogCommandOutput("The job was stopped.\n")
+	case attached:
+		job.Logger.LogCommandOutput("The debug session is over - resuming the job.\n")
+	default:
+		job.Logger.LogCommandOutput(fmt.Sprintf("No debug session in %v - resuming the job.\n", timeout))
+	}
+
+	job.Logger.LogCommandFinished(DebugSessionDirective, exitCode, startedAt, int(time.Now().Unix()))
+}
+
+// The timeout from the job environment takes precedence, but it can't be longer than debugsession.MaxTimeout.
+func (job *Job) debugOnFailureTimeout() time.Duration {
+	value, ok := requestEnvVar(job.Request, DebugOnFailureEnvVar)
+	if !ok {
+		return job.DebugOnFailureTimeout
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		log.Warnf("Ignoring %s='%s' - it must be a number of seconds", DebugOnFailureEnvVar, value)
+		return job.DebugOnFailureTimeout
+	}
+
+	return min(time.Duration(seconds)*time.Second, debugsession.MaxTimeout)
+}
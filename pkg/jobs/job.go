@@ -2,6 +2,7 @@ package jobs
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net/http"
@@ -21,6 +22,9 @@ import (
 	"github.com/semaphoreci/agent/pkg/kubernetes"
 	"github.com/semaphoreci/agent/pkg/listener/selfhostedapi"
 	"github.com/semaphoreci/agent/pkg/retry"
+	shell "github.com/semaphoreci/agent/pkg/shell"
+	"github.com/semaphoreci/agent/pkg/tracing"
+	"github.com/semaphoreci/agent/pkg/webhooks"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -42,11 +46,45 @@ type Job struct {
 
 	Executor executors.Executor
 
-	JobLogArchived bool
-	Stopped        bool
-	Finished       bool
-	UploadJobLogs  string
-	UserAgent      string
+	JobLogArchived    bool
+	Stopped           bool
+	Finished          bool
+	UploadJobLogs     string
+	UserAgent         string
+	TestReportPaths   []string
+	UploadTestReports bool
+	Webhooks          *webhooks.Notifier
+	MaskSecrets       bool
+
+	// Zero means resource usage is not sampled.
+	ResourceSamplingInterval time.Duration
+
+	// Empty means no checkpoints are recorded.
+	CheckpointDir string
+
+	// Nil means the job is not traced.
+	Tracer *tracing.Tracer
+
+	// Zero means the job is not paused for debugging when a command fails,
+	// unless it asks for it, with DebugOnFailureEnvVar.
+	DebugOnFailureTimeout time.Duration
+
+	// Export NoColorEnvVars to the job commands.
+	NoColor bool
+
+	// Done when the job is stopped.
+	stopContext context.Context
+	cancelStop  context.CancelFunc
+
+	summary    jobSummary
+	resources  resourceTracking
+	jobFiles   []*jobFile
+	maskFile   *jobFile
+	envFile    *jobFile
+	outputFile *jobFile
+	span       *tracing.Span
+	phaseSpan  *tracing.Span
+	dialect    shell.Dialect
 }
 
 type JobOptions struct {
@@ -66,6 +104,22 @@ type JobOptions struct {
 	UploadJobLogs                    string
 	RefreshTokenFn                   func() (string, error)
 	UserAgent                        string
+	TestReportPaths                  []string
+	UploadTestReports                bool
+	Webhooks                         *webhooks.Notifier
+	MaskSecrets                      bool
+	ResourceSamplingInterval         time.Duration
+	CheckpointDir                    string
+	StopGracePeriod                  time.Duration
+	CompressLogs                     bool
+	LogSinks                         []string
+	Tracer                           *tracing.Tracer
+	Shell                            string
+	SeparateOutputStreams            bool
+	OutputLimits                     eventlogger.OutputLimits
+	DebugOnFailureTimeout            time.Duration
+	Terminal                         shell.Terminal
+	NoColor                          bool
 }
 
 func NewJob(request *api.JobRequest, client *http.Client) (*Job, error) {
@@ -92,12 +146,40 @@ func NewJobWithOptions(options *JobOptions) (*Job, error) {
 	}
 
 	job := &Job{
-		Client:         options.Client,
-		Request:        options.Request,
-		JobLogArchived: false,
-		Stopped:        false,
-		UploadJobLogs:  options.UploadJobLogs,
-		UserAgent:      options.UserAgent,
+		Client:            options.Client,
+		Request:           options.Request,
+		JobLogArchived:    false,
+		Stopped:           false,
+		UploadJobLogs:     options.UploadJobLogs,
+		UserAgent:         options.UserAgent,
+		TestReportPaths:   append(append([]string{}, options.TestReportPaths...), options.Request.TestReports.Paths...),
+		UploadTestReports: options.UploadTestReports || options.Request.TestReports.Upload,
+		Webhooks:          options.Webhooks,
+		MaskSecrets:       options.MaskSecrets,
+		Tracer:            options.Tracer,
+
+		ResourceSamplingInterval: options.ResourceSamplingInterval,
+		DebugOnFailureTimeout:    options.DebugOnFailureTimeout,
+	}
+
+	job.stopContext, job.cancelStop = context.WithCancel(context.Background())
+
+	executable, dialect, err := findShell(options)
+	if err != nil {
+		return nil, err
+	}
+
+	job.dialect = dialect
+	options.Shell = executable
+	options.Terminal = findTerminal(options)
+	job.NoColor = findNoColor(options)
+
+	if options.CheckpointDir != "" {
+		if checkpointsSupported(options.Request, dialect) {
+			job.CheckpointDir = options.CheckpointDir
+		} else {
+			log.Warnf("Checkpoints are only supported for the %s executor with a POSIX shell on non-Windows hosts - not recording them", executors.ExecutorTypeShell)
+		}
 	}
 
 	if options.Logger != nil {
@@ -107,6 +189,8 @@ func NewJobWithOptions(options *JobOptions) (*Job, error) {
 			Request:        options.Request,
 			RefreshTokenFn: options.RefreshTokenFn,
 			UserAgent:      options.UserAgent,
+			CompressLogs:   options.CompressLogs,
+			Sinks:          options.LogSinks,
 		})
 
 		if err != nil {
@@ -116,6 +200,12 @@ func NewJobWithOptions(options *JobOptions) (*Job, error) {
 		job.Logger = l
 	}
 
+	if job.MaskSecrets {
+		job.Logger.AddMaskedValues(secretValues(options.Request)...)
+	}
+
+	job.Logger.SetOutputLimits(options.OutputLimits)
+
 	executor, err := CreateExecutor(options.Request, job.Logger, *options)
 	if err != nil {
 		_ = job.Logger.Close()
@@ -126,6 +216,29 @@ func NewJobWithOptions(options *JobOptions) (*Job, error) {
 	return job, nil
 }
 
+/*
+ * The job request can choose the shell, and it takes precedence over the one configured in the agent.
+ * Only the shell executor uses it: the other executors always use bash, inside their containers.
+ */
+func findShell(options *JobOptions) (string, shell.Dialect, error) {
+	usesShellExecutor := !options.UseKubernetesExecutor && options.Request.Executor == executors.ExecutorTypeShell
+	if options.Request.Shell != "" {
+		if !usesShellExecutor || runtime.GOOS == "windows" {
+			return "", nil, fmt.Errorf("the shell can only be chosen for the %s executor on non-Windows hosts", executors.ExecutorTypeShell)
+		}
+
+		dialect, err := shell.FindDialect(options.Request.Shell)
+		return options.Request.Shell, dialect, err
+	}
+
+	if options.Shell == "" || !usesShellExecutor || runtime.GOOS == "windows" {
+		return "", shell.Bash, nil
+	}
+
+	dialect, err := shell.FindDialect(options.Shell)
+	return options.Shell, dialect, err
+}
+
 func CreateExecutor(request *api.JobRequest, logger *eventlogger.Logger, jobOptions JobOptions) (executors.Executor, error) {
 	if jobOptions.UseKubernetesExecutor {
 		// The downwards API allows the namespace to be exposed
@@ -144,17 +257,24 @@ func CreateExecutor(request *api.JobRequest, logger *eventlogger.Logger, jobOpti
 			Labels:                    jobOptions.KubernetesLabels,
 			PodPollingInterval:        time.Second,
 			DefaultImage:              jobOptions.KubernetesDefaultImage,
-		})
+		}, jobOptions.Terminal)
 	}
 
 	switch request.Executor {
 	case executors.ExecutorTypeShell:
-		return executors.NewShellExecutor(request, logger, jobOptions.SelfHosted), nil
+		return executors.NewShellExecutor(request, logger, executors.ShellExecutorOptions{
+			SelfHosted:            jobOptions.SelfHosted,
+			StopGracePeriod:       jobOptions.StopGracePeriod,
+			Shell:                 jobOptions.Shell,
+			SeparateOutputStreams: jobOptions.SeparateOutputStreams,
+			Terminal:              jobOptions.Terminal,
+		}), nil
 	case executors.ExecutorTypeDockerCompose:
 		executorOptions := executors.DockerComposeExecutorOptions{
 			ExposeKvmDevice:    jobOptions.ExposeKvmDevice,
 			FileInjections:     jobOptions.FileInjections,
 			FailOnMissingFiles: jobOptions.FailOnMissingFiles,
+			Terminal:           jobOptions.Terminal,
 		}
 
 		return executors.NewDockerComposeExecutor(request, logger, executorOptions), nil
@@ -185,16 +305,7 @@ func (o *RunOptions) GetPreJobHookWarning() string {
 	return `The agent is configured to proceed with the job even if the pre-job hook fails.`
 }
 
-func (o *RunOptions) GetPreJobHookCommand() string {
-
-	/*
-	 * If we are dealing with PowerShell, we make sure to just call the script directly,
-	 * without creating a new powershell process. If we did, people would need to set
-	 * $ErrorActionPreference to "STOP" in order for errors to propagate properly.
-	 */
-	if runtime.GOOS == "windows" {
-		return o.PreJobHookPath
-	}
+func (o *RunOptions) GetPreJobHookCommand(dialect shell.Dialect) string {
 
 	/*
 	 * This executes the pre-job hook without opening a new shell.
@@ -202,14 +313,19 @@ func (o *RunOptions) GetPreJobHookCommand() string {
 	 * (current directory, environment variables, export commands)
 	 * will be visible by the next job commands.
 	 */
-	if o.SourcePreJobHook {
-		return fmt.Sprintf("source %s", o.PreJobHookPath)
+	if o.SourcePreJobHook && runtime.GOOS != "windows" {
+		return dialect.Source(o.PreJobHookPath)
 	}
 
-	return fmt.Sprintf("bash %s", o.PreJobHookPath)
+	return hookCommand(o.PreJobHookPath, dialect)
 }
 
-func (o *RunOptions) GetPostJobHookCommand() string {
+func (o *RunOptions) GetPostJobHookCommand(dialect shell.Dialect) string {
+	return hookCommand(o.PostJobHookPath, dialect)
+}
+
+// Both hooks run with bash, like they always did, or, on images without it, with their shebang.
+func hookCommand(path string, dialect shell.Dialect) string {
 
 	/*
 	 * If we are dealing with PowerShell, we make sure to just call the script directly,
@@ -217,10 +333,10 @@ func (o *RunOptions) GetPostJobHookCommand() string {
 	 * $ErrorActionPreference to "STOP" in order for errors to propagate properly.
 	 */
 	if runtime.GOOS == "windows" {
-		return o.PostJobHookPath
+		return path
 	}
 
-	return fmt.Sprintf("bash %s", o.PostJobHookPath)
+	return dialect.RunScript(path)
 }
 
 func (job *Job) Run() {
@@ -239,22 +355,36 @@ func (job *Job) RunWithOptions(options RunOptions) {
 	epiloguesExecuted := false
 	result := JobFailed
 
+	job.startTrace()
 	job.Logger.LogJobStarted()
+	job.summary.startedAt = time.Now()
+	job.Webhooks.JobStarted(job.Request.JobID)
 
 	exitCode := job.PrepareEnvironment()
 	if exitCode == 0 {
 		executorRunning = true
+		job.startResourceSampling()
 	} else {
 		log.Error("Executor failed to boot up")
+		job.span.SetError("executor failed to boot up")
 	}
 
 	if executorRunning {
 		result = job.RunRegularCommands(options)
+
+		if result == JobFailed && !job.Stopped {
+			job.pauseForDebugging()
+		}
+
+		if !job.Stopped {
+			job.collectTestReports()
+		}
+
 		log.Debug("Exporting job result")
 
 		if !job.Stopped {
 			log.Debug("Handling epilogues")
-			job.handleEpilogues(result)
+			job.timePhase(PhaseEpilogues, func() { job.handleEpilogues(result) })
 			epiloguesExecuted = true
 		}
 	}
@@ -263,11 +393,18 @@ func (job *Job) RunWithOptions(options RunOptions) {
 	// so they do not influence the job's result, just like the epilogues.
 	job.runPostJobHook(options)
 
+	if executorRunning && !job.Stopped {
+		job.logOutputs()
+		job.removeJobFiles()
+	}
+
 	result, err := job.Teardown(result, epiloguesExecuted, options.CallbackRetryAttempts)
 	if err != nil {
 		log.Errorf("Error tearing down job: %v", err)
 	}
 
+	job.endTrace(result)
+
 	// the executor is already stopped when the job is stopped, so there's no need to stop it again
 	if !job.Stopped {
 		job.Executor.Stop()
@@ -280,13 +417,14 @@ func (job *Job) RunWithOptions(options RunOptions) {
 }
 
 func (job *Job) PrepareEnvironment() int {
-	exitCode := job.Executor.Prepare()
+	var exitCode int
+	job.timePhase(PhaseExecutorPrepare, func() { exitCode = job.Executor.Prepare() })
 	if exitCode != 0 {
 		log.Error("Failed to prepare executor")
 		return exitCode
 	}
 
-	exitCode = job.Executor.Start()
+	job.timeExecutorStart(func() { exitCode = job.Executor.Start() })
 	if exitCode != 0 {
 		log.Error("Failed to start executor")
 		return exitCode
@@ -304,18 +442,31 @@ func (job *Job) envVar(name string) string {
 }
 
 func (job *Job) RunRegularCommands(options RunOptions) string {
-	exitCode := job.Executor.ExportEnvVars(job.Request.EnvVars, options.EnvVars)
+	var exitCode int
+	job.timePhase(PhaseEnvVarsExport, func() {
+		exitCode = job.Executor.ExportEnvVars(job.Request.EnvVars, job.colorEnvVars(job.traceEnvVars(options.EnvVars)))
+	})
+
 	if exitCode != 0 {
 		log.Error("Failed to export env vars")
 		return JobFailed
 	}
 
-	exitCode = job.Executor.InjectFiles(job.Request.Files)
+	job.timePhase(PhaseFileInjection, func() {
+		exitCode = job.Executor.InjectFiles(job.Request.Files)
+	})
+
 	if exitCode != 0 {
 		log.Error("Failed to inject files")
 		return JobFailed
 	}
 
+	if job.MaskSecrets {
+		job.exportMaskFile()
+	}
+
+	job.exportCommandFiles()
+
 	shouldProceed := job.runPreJobHook(options)
 	if !shouldProceed {
 		return JobFailed
@@ -324,7 +475,13 @@ func (job *Job) RunRegularCommands(options RunOptions) string {
 	if len(job.Request.Commands) == 0 {
 		exitCode = 0
 	} else {
-		exitCode = job.RunCommandsUntilFirstFailure(job.Request.Commands)
+		job.timePhase(PhaseCommands, func() {
+			if job.CheckpointDir != "" {
+				exitCode = job.runCommandsWithCheckpoints(job.Request.Commands)
+			} else {
+				exitCode = job.RunCommandsUntilFirstFailure(job.Request.Commands)
+			}
+		})
 	}
 
 	// Job was stopped from UI or API
@@ -381,14 +538,22 @@ func (job *Job) runPreJobHook(options RunOptions) bool {
 		return true
 	}
 
+	startedAt := time.Now()
+	span := job.startSpan(PhasePreJobHook)
+	defer func() {
+		job.summary.addPhase(PhasePreJobHook, time.Since(startedAt))
+	}()
+
 	log.Infof("Executing pre-job hook at %s", options.PreJobHookPath)
 	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
-		Command: options.GetPreJobHookCommand(),
+		Command: options.GetPreJobHookCommand(job.dialect),
 		Silent:  false,
 		Alias:   "Running the pre-job hook configured in the agent",
 		Warning: options.GetPreJobHookWarning(),
 	})
 
+	endCommandSpan(span, exitCode)
+
 	if exitCode == 0 {
 		log.Info("Pre-job hook executed successfully.")
 		return true
@@ -409,13 +574,21 @@ func (job *Job) runPostJobHook(options RunOptions) {
 		return
 	}
 
+	startedAt := time.Now()
+	span := job.startSpan(PhasePostJobHook)
+	defer func() {
+		job.summary.addPhase(PhasePostJobHook, time.Since(startedAt))
+	}()
+
 	log.Infof("Executing post-job hook at %s", options.PostJobHookPath)
 	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
-		Command: options.GetPostJobHookCommand(),
+		Command: options.GetPostJobHookCommand(job.dialect),
 		Silent:  false,
 		Alias:   "Running the post-job hook configured in the agent",
 	})
 
+	endCommandSpan(span, exitCode)
+
 	if exitCode == 0 {
 		log.Info("Post-job hook executed successfully.")
 		return
@@ -458,18 +631,40 @@ func (job *Job) executeIfNotStopped(callback func()) {
 
 // returns exit code of last executed command
 func (job *Job) RunCommandsUntilFirstFailure(commands []api.Command) int {
+	return job.runCommandsUntilFirstFailure(commands, nil)
+}
+
+// onCommandPassed receives the index of each command that passes.
+func (job *Job) runCommandsUntilFirstFailure(commands []api.Command, onCommandPassed func(int)) int {
 	lastExitCode := 1
 
-	for _, c := range commands {
+	for i, c := range commands {
 		if job.Stopped {
 			return 1
 		}
 
+		startedAt := time.Now()
+		span := job.startCommandSpan(c)
 		lastExitCode = job.Executor.RunCommand(c.Directive, false, c.Alias)
+		duration := time.Since(startedAt)
+		endCommandSpan(span, lastExitCode)
+		job.refreshMaskedValues()
+		job.applyEnvFile()
+		job.checkOOMKills(commandName(c))
+		job.summary.addCommand(commandName(c), lastExitCode, duration)
+		job.Webhooks.CommandFinished(job.Request.JobID, webhooks.CommandResult{
+			Directive:  commandName(c),
+			ExitCode:   lastExitCode,
+			DurationMs: duration.Milliseconds(),
+		})
 
 		if lastExitCode != 0 {
 			break
 		}
+
+		if onCommandPassed != nil {
+			onCommandPassed(i)
+		}
 	}
 
 	return lastExitCode
@@ -481,6 +676,10 @@ func (job *Job) Teardown(result string, epiloguesExecuted bool, callbackRetryAtt
 		result = JobStopped
 	}
 
+	job.stopResourceSampling()
+	job.logSummary()
+	job.Webhooks.JobFinished(job.Request.JobID, result, job.summary.timings())
+
 	if job.Request.Logger.Method == eventlogger.LoggerMethodPull {
 		return result, job.teardownWithCallbacks(result, callbackRetryAttempts)
 	}
@@ -517,13 +716,7 @@ func (job *Job) teardownWithCallbacks(result string, callbackRetryAttempts int)
 	// The job already finished, but executor is still open.
 	// We use the open executor to upload the job logs as
 	// an artifact, in case it is above the acceptable limit.
-	err = job.Logger.CloseWithOptions(eventlogger.CloseOptions{
-		OnClose: job.uploadLogsAsArtifact,
-	})
-
-	if err != nil {
-		log.Errorf("Error closing logger: %+v", err)
-	}
+	job.closeLogger()
 
 	err = job.SendTeardownFinishedCallback(callbackRetryAttempts)
 	if err != nil {
@@ -545,16 +738,25 @@ func (job *Job) teardownWithNoCallbacks(result string) error {
 	// The job already finished, but executor is still open.
 	// We use the open executor to upload the job logs as an artifact,
 	// in case it has been trimmed during streaming.
+	job.closeLogger()
+
+	log.Info("Job teardown finished")
+	return nil
+}
+
+// Closing the logger flushes the logs not sent yet.
+func (job *Job) closeLogger() {
+	span := job.startSpan(SpanLogFlush)
+	defer span.End()
+
 	err := job.Logger.CloseWithOptions(eventlogger.CloseOptions{
 		OnClose: job.uploadLogsAsArtifact,
 	})
 
 	if err != nil {
 		log.Errorf("Error closing logger: %+v", err)
+		span.SetError(err.Error())
 	}
-
-	log.Info("Job teardown finished")
-	return nil
 }
 
 func (job *Job) minSizeForCompression() int64 {
@@ -691,29 +893,41 @@ func (job *Job) uploadLogsAsArtifact(trimmed bool) {
 		artifactPath = artifactPath + ".gz"
 	}
 
-	args := []string{"push", "job", file, "-d", artifactPath}
+	log.Info("Uploading job logs as artifact...")
+	err = job.pushArtifact(path, token, orgURL, file, artifactPath)
+	if err != nil {
+		log.Errorf("Error uploading job logs as artifact: %v", err)
+		return
+	}
+
+	log.Info("Successfully uploaded job logs as artifact")
+}
+
+func (job *Job) pushArtifact(artifactCLI, token, orgURL, file, destination string) error {
+	args := []string{"push", "job", file, "-d", destination}
 
 	// #nosec
-	cmd := exec.Command(path, args...)
+	cmd := exec.Command(artifactCLI, args...)
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", "SEMAPHORE_ARTIFACT_TOKEN", token))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", "SEMAPHORE_JOB_ID", job.Request.JobID))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", "SEMAPHORE_ORGANIZATION_URL", orgURL))
 
-	log.Info("Uploading job logs as artifact...")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Errorf("Error uploading job logs as artifact: %v, %s", err, output)
-		return
+		return fmt.Errorf("%v, %s", err, output)
 	}
 
-	log.Info("Successfully uploaded job logs as artifact")
+	return nil
 }
 
 func (job *Job) Stop() {
 	log.Info("Stopping job")
 
 	job.Stopped = true
+	if job.cancelStop != nil {
+		job.cancelStop()
+	}
 
 	log.Debug("Invoking process stopping")
 
@@ -725,7 +939,8 @@ func (job *Job) Stop() {
 func (job *Job) SendFinishedCallback(result string, retries int) error {
 	payload := fmt.Sprintf(`{"result": "%s"}`, result)
 	log.Infof("Sending finished callback: %+v", payload)
-	return retry.RetryWithConstantWait(retry.RetryOptions{
+	span := job.startCallbackSpan(SpanFinishedCallback)
+	err := retry.RetryWithConstantWait(retry.RetryOptions{
 		Task:                 "Send finished callback",
 		MaxAttempts:          retries,
 		DelayBetweenAttempts: time.Second,
@@ -733,11 +948,15 @@ func (job *Job) SendFinishedCallback(result string, retries int) error {
 			return job.SendCallback(job.Request.Callbacks.Finished, payload)
 		},
 	})
+
+	endCallbackSpan(span, err)
+	return err
 }
 
 func (job *Job) SendTeardownFinishedCallback(retries int) error {
 	log.Info("Sending teardown finished callback")
-	return retry.RetryWithConstantWait(retry.RetryOptions{
+	span := job.startCallbackSpan(SpanTeardownFinishedCallback)
+	err := retry.RetryWithConstantWait(retry.RetryOptions{
 		Task:                 "Send teardown finished callback",
 		MaxAttempts:          retries,
 		DelayBetweenAttempts: time.Second,
@@ -745,6 +964,9 @@ func (job *Job) SendTeardownFinishedCallback(retries int) error {
 			return job.SendCallback(job.Request.Callbacks.TeardownFinished, "{}")
 		},
 	})
+
+	endCallbackSpan(span, err)
+	return err
 }
 
 func (job *Job) SendCallback(url string, payload string) error {
@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ * A file the agent creates inside the job environment,
+ * which the job commands can write to in order to communicate with the agent.
+ * Its path is exposed to the job through an environment variable.
+ *
+ * The file is read through the executor, since for some executors,
+ * like docker-compose and kubernetes, it is not available to the agent process.
+ */
+type jobFile struct {
+	EnvVar string
+	Path   string
+}
+
+func newJobFile(envVar, name string) *jobFile {
+	fileName := fmt.Sprintf(".semaphore-%s-%d", name, time.Now().UnixNano())
+	if runtime.GOOS == "windows" {
+		return &jobFile{EnvVar: envVar, Path: filepath.Join(os.TempDir(), fileName)}
+	}
+
+	return &jobFile{EnvVar: envVar, Path: "/tmp/" + fileName}
+}
+
+// Creates the file and exports its path to the job.
+func (job *Job) exportJobFile(file *jobFile) int {
+	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
+		Command: job.exportJobFileCommand(file),
+		Silent:  true,
+	})
+
+	if exitCode == 0 {
+		job.jobFiles = append(job.jobFiles, file)
+	}
+
+	return exitCode
+}
+
+func (job *Job) exportJobFileCommand(file *jobFile) string {
+	if runtime.GOOS == "windows" {
+		path := strings.ReplaceAll(file.Path, "'", "''")
+		return fmt.Sprintf(
+			"New-Item -ItemType File -Force -Path '%s' | Out-Null; $env:%s = '%s'",
+			path, file.EnvVar, path,
+		)
+	}
+
+	return fmt.Sprintf("touch %s && export %s=%s", file.Path, file.EnvVar, file.Path)
+}
+
+func (job *Job) readJobFile(file *jobFile) (string, error) {
+	output, exitCode := job.Executor.GetOutputFromCommand(job.readFileCommand(file.Path))
+	if exitCode != 0 {
+		return "", fmt.Errorf("error reading %s: exit code %d", file.Path, exitCode)
+	}
+
+	return output, nil
+}
+
+// Reads the file and empties it, so the next read only has what was written after this one.
+func (job *Job) consumeJobFile(file *jobFile) (string, error) {
+	command := fmt.Sprintf("cat %s && : > %s", file.Path, file.Path)
+	if runtime.GOOS == "windows" {
+		path := strings.ReplaceAll(file.Path, "'", "''")
+		command = fmt.Sprintf("Get-Content -Raw -Path '%s'; Clear-Content -Path '%s'", path, path)
+	}
+
+	output, exitCode := job.Executor.GetOutputFromCommand(command)
+	if exitCode != 0 {
+		return "", fmt.Errorf("error reading %s: exit code %d", file.Path, exitCode)
+	}
+
+	return output, nil
+}
+
+func (job *Job) removeJobFiles() {
+	for _, file := range job.jobFiles {
+		job.removeJobFile(file)
+	}
+}
+
+func (job *Job) removeJobFile(file *jobFile) {
+	command := fmt.Sprintf("rm -f %s", file.Path)
+	if runtime.GOOS == "windows" {
+		command = fmt.Sprintf("Remove-Item -Force -ErrorAction SilentlyContinue -Path '%s'", strings.ReplaceAll(file.Path, "'", "''"))
+	}
+
+	exitCode := job.Executor.RunCommandWithOptions(executors.CommandOptions{
+		Command: command,
+		Silent:  true,
+	})
+
+	if exitCode != 0 {
+		log.Errorf("Error removing %s: exit code %d", file.Path, exitCode)
+	}
+}
+
+// Splits the file contents into non-empty lines.
+func jobFileLines(content string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
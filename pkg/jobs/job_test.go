@@ -1,20 +1,29 @@
 package jobs
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	api "github.com/semaphoreci/agent/pkg/api"
 	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/debugsession"
 	eventlogger "github.com/semaphoreci/agent/pkg/eventlogger"
+	"github.com/semaphoreci/agent/pkg/shell"
+	"github.com/semaphoreci/agent/pkg/tracing"
+	"github.com/semaphoreci/agent/pkg/webhooks"
 	testsupport "github.com/semaphoreci/agent/test/support"
 	"github.com/stretchr/testify/assert"
 )
@@ -1189,6 +1198,20 @@ func Test__UsePostJobHook(t *testing.T) {
 	os.Remove(hook)
 }
 
+func Test__HooksRunWithBashWhenItIsInstalled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	options := RunOptions{PreJobHookPath: "/opt/pre.sh", PostJobHookPath: "/opt/post.sh"}
+	assert.Equal(t, shell.POSIXShell.RunScript("/opt/pre.sh"), options.GetPreJobHookCommand(shell.POSIXShell))
+	assert.Equal(t, shell.Fish.RunScript("/opt/post.sh"), options.GetPostJobHookCommand(shell.Fish))
+	assert.Equal(t, "bash /opt/post.sh", options.GetPostJobHookCommand(shell.Bash))
+
+	options.SourcePreJobHook = true
+	assert.Equal(t, shell.POSIXShell.Source("/opt/pre.sh"), options.GetPreJobHookCommand(shell.POSIXShell))
+}
+
 func Test__PreJobHookHasAccessToEnvVars(t *testing.T) {
 	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
 	request := &api.JobRequest{
@@ -1381,3 +1404,1050 @@ func Test__UsePreJobHookAndFailOnError(t *testing.T) {
 
 	os.Remove(hook)
 }
+
+func Test__TestReportDestination(t *testing.T) {
+	job := &Job{}
+	assert.Equal(t, "agent/test_reports/a/junit.xml", job.testReportDestination("/a/junit.xml"))
+	assert.Equal(t, "agent/test_reports/b/junit.xml", job.testReportDestination("/b/junit.xml"))
+	assert.Equal(t, "agent/test_reports/reports/junit.xml", job.testReportDestination("./reports/junit.xml"))
+	assert.Equal(t, "agent/test_reports/junit.xml", job.testReportDestination("../junit.xml"))
+}
+
+func Test__CollectTestReports(t *testing.T) {
+	reportsDir, err := os.MkdirTemp("", "reports-*")
+	assert.Nil(t, err)
+	defer os.RemoveAll(reportsDir)
+
+	report := `<testsuite name="suite">
+  <testcase name="passes" classname="A" time="0.5"/>
+  <testcase name="fails" classname="A" time="1.5"><failure message="expected true"/></testcase>
+</testsuite>`
+
+	assert.Nil(t, os.WriteFile(filepath.Join(reportsDir, "junit.xml"), []byte(report), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(reportsDir, "other.txt"), []byte("not a report"), 0600))
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{},
+		Commands: []api.Command{
+			{Directive: testsupport.Output("hello")},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:         request,
+		Client:          http.DefaultClient,
+		Logger:          testLogger,
+		TestReportPaths: []string{filepath.ToSlash(filepath.Join(reportsDir, "*.xml"))},
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, false)
+	assert.Nil(t, err)
+
+	testsupport.AssertSimplifiedJobLogs(t, simplifiedEvents, []string{
+		"job_started",
+
+		"directive: Exporting environment variables",
+		"Exit Code: 0",
+
+		"directive: Injecting Files",
+		"Exit Code: 0",
+
+		fmt.Sprintf("directive: %s", testsupport.Output("hello")),
+		"hello",
+		"Exit Code: 0",
+
+		"directive: Collecting test reports",
+		"*** IGNORE SINGLE LINE ***", // the report path depends on the temp directory
+		"2 tests, 1 passed, 1 failed, 0 errors, 0 skipped.\n",
+		"test_summary: 2 total, 1 failed, 0 errors",
+		"Exit Code: 0",
+
+		"directive: Exporting environment variables",
+		"Exporting SEMAPHORE_JOB_RESULT\n",
+		"Exit Code: 0",
+
+		"job_finished: passed",
+	})
+}
+
+func Test__JobSummary(t *testing.T) {
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{},
+		Commands: []api.Command{
+			{Directive: testsupport.Output("hello")},
+			{Directive: testsupport.Output("hello again"), Alias: "Say hello again"},
+		},
+		EpilogueAlwaysCommands: []api.Command{
+			{Directive: testsupport.Output("bye")},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{Request: request, Client: http.DefaultClient, Logger: testLogger})
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	// The summary is the last event before job_finished.
+	events := testLoggerBackend.Events
+	if !assert.GreaterOrEqual(t, len(events), 2) {
+		return
+	}
+
+	summary, ok := events[len(events)-2].(*eventlogger.JobSummaryEvent)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	phases := []string{}
+	for _, phase := range summary.Phases {
+		phases = append(phases, phase.Name)
+	}
+
+	assert.Equal(t, []string{
+		PhaseExecutorPrepare,
+		PhaseExecutorStart,
+		PhaseEnvVarsExport,
+		PhaseFileInjection,
+		PhaseCommands,
+		PhaseEpilogues,
+	}, phases)
+
+	commands := []string{}
+	for _, command := range summary.Commands {
+		commands = append(commands, fmt.Sprintf("%s: %d", command.Directive, command.ExitCode))
+	}
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("%s: 0", testsupport.Output("hello")),
+		"Say hello again: 0",
+		fmt.Sprintf("%s: 0", testsupport.Output("bye")),
+	}, commands)
+}
+
+func Test__JobSendsWebhooks(t *testing.T) {
+	mutex := sync.Mutex{}
+	received := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, r.Header.Get(webhooks.EventHeader))
+	}))
+
+	defer server.Close()
+
+	notifier := webhooks.NewNotifier(webhooks.Config{
+		URLs:                 []string{server.URL},
+		Secret:               "my-secret",
+		IncludeCommandEvents: true,
+	})
+
+	testLogger, _ := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{},
+		Commands: []api.Command{
+			{Directive: testsupport.Output("hello")},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:  request,
+		Client:   http.DefaultClient,
+		Logger:   testLogger,
+		Webhooks: notifier,
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+	assert.True(t, notifier.Flush(5*time.Second))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{
+		webhooks.EventJobStarted,
+		webhooks.EventCommandFinished,
+		webhooks.EventJobFinished,
+	}, received)
+}
+
+func Test__JobIsTraced(t *testing.T) {
+	collector := testsupport.NewCollectorMockServer()
+	collector.Init()
+	defer collector.Close()
+
+	tracer := tracing.NewTracer(tracing.Config{Endpoint: collector.URL()})
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		JobID:   "job-1",
+		EnvVars: []api.EnvVar{},
+		Commands: []api.Command{
+			{Directive: testsupport.EchoEnvVar(tracing.TraceparentEnvVar), Alias: "Print traceparent"},
+			{Directive: "false"},
+		},
+		EpilogueAlwaysCommands: []api.Command{
+			{Directive: testsupport.Output("cleanup")},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request: request,
+		Client:  http.DefaultClient,
+		Logger:  testLogger,
+		Tracer:  tracer,
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+	assert.True(t, tracer.Flush(5*time.Second))
+
+	jobSpan := collector.FindSpan(SpanJob)
+	if !assert.NotNil(t, jobSpan) {
+		return
+	}
+
+	assert.Equal(t, tracing.StatusCodeError, jobSpan.Status.Code)
+	assert.Contains(t, jobSpan.Attributes, tracing.KeyValue{Key: "job.result", Value: stringValue(JobFailed)})
+
+	spansByID := map[string]tracing.SpanData{}
+	for _, span := range collector.Spans() {
+		assert.Equal(t, jobSpan.TraceID, span.TraceID)
+		assert.Contains(t, span.Attributes, tracing.KeyValue{Key: "job.id", Value: stringValue("job-1")})
+		spansByID[span.SpanID] = span
+	}
+
+	for _, phase := range []string{PhaseExecutorPrepare, PhaseExecutorStart, PhaseEnvVarsExport, PhaseCommands, PhaseEpilogues, SpanLogFlush} {
+		span := collector.FindSpan(phase)
+		if assert.NotNil(t, span, phase) {
+			assert.Equal(t, jobSpan.SpanID, span.ParentSpanID, phase)
+		}
+	}
+
+	// commands are recorded under the phase they run in
+	commands := collector.FindSpans(SpanCommand)
+	if assert.Len(t, commands, 3) {
+		parentNames := []string{}
+		exitCodes := []string{}
+		for _, command := range commands {
+			parentNames = append(parentNames, spansByID[command.ParentSpanID].Name)
+			for _, attribute := range command.Attributes {
+				if attribute.Key == "command.exit_code" {
+					exitCodes = append(exitCodes, attribute.Value.String())
+				}
+			}
+		}
+
+		assert.Equal(t, []string{PhaseCommands, PhaseCommands, PhaseEpilogues}, parentNames)
+		assert.Equal(t, []string{"0", "1", "0"}, exitCodes)
+		assert.Contains(t, commands[0].Attributes, tracing.KeyValue{Key: "command.alias", Value: stringValue("Print traceparent")})
+		assert.Equal(t, tracing.StatusCodeError, commands[1].Status.Code)
+	}
+
+	// commands can continue the trace through TRACEPARENT
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, false)
+	assert.Nil(t, err)
+	assert.Contains(t, simplifiedEvents, fmt.Sprintf("00-%s-%s-01", jobSpan.TraceID, jobSpan.SpanID))
+}
+
+func stringValue(value string) tracing.AnyValue {
+	return tracing.AnyValue{StringValue: &value}
+}
+
+func Test__MaskSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{
+			{Name: "MY_SECRET", Value: base64.StdEncoding.EncodeToString([]byte("super-secret-value"))},
+			{Name: "SEMAPHORE_GIT_BRANCH", Value: base64.StdEncoding.EncodeToString([]byte("feature-branch"))},
+		},
+		Files: []api.File{
+			{Path: "/tmp/masked-file", Content: base64.StdEncoding.EncodeToString([]byte("file-secret-content\n")), Mode: "0600"},
+		},
+		Commands: []api.Command{
+			{Directive: "echo $MY_SECRET $SEMAPHORE_GIT_BRANCH"},
+			{Directive: "cat /tmp/masked-file"},
+			{Directive: "echo runtime-secret >> $SEMAPHORE_MASK_FILE"},
+			{Directive: "echo runtime-secret"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:     request,
+		Client:      http.DefaultClient,
+		Logger:      testLogger,
+		MaskSecrets: true,
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, true)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"job_started",
+
+		"directive: Exporting environment variables",
+		"Exporting MY_SECRET\nExporting SEMAPHORE_GIT_BRANCH\n",
+		"Exit Code: 0",
+
+		"directive: Injecting Files",
+		"Injecting /tmp/masked-file with file mode 0600\n",
+		"Exit Code: 0",
+
+		"directive: echo $MY_SECRET $SEMAPHORE_GIT_BRANCH",
+		"*** feature-branch\n",
+		"Exit Code: 0",
+
+		"directive: cat /tmp/masked-file",
+		"***\n",
+		"Exit Code: 0",
+
+		"directive: echo runtime-secret >> $SEMAPHORE_MASK_FILE",
+		"Exit Code: 0",
+
+		"directive: echo runtime-secret",
+		"***\n",
+		"Exit Code: 0",
+
+		"directive: Exporting environment variables",
+		"Exporting SEMAPHORE_JOB_RESULT\n",
+		"Exit Code: 0",
+
+		"job_finished: passed",
+	}, simplifiedEvents)
+
+	os.Remove("/tmp/masked-file")
+}
+
+func Test__ResourceUsageSampling(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{},
+		Commands: []api.Command{
+			{Directive: "sleep 0.3"},
+			{Directive: "echo hello"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:                  request,
+		Client:                   http.DefaultClient,
+		Logger:                   testLogger,
+		ResourceSamplingInterval: 100 * time.Millisecond,
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	var resourcesEvent *eventlogger.JobResourcesEvent
+	var summaryEvent *eventlogger.JobSummaryEvent
+	for _, event := range testLoggerBackend.Events {
+		switch e := event.(type) {
+		case *eventlogger.JobResourcesEvent:
+			resourcesEvent = e
+		case *eventlogger.JobSummaryEvent:
+			summaryEvent = e
+		}
+	}
+
+	if !assert.NotNil(t, resourcesEvent) || !assert.NotNil(t, summaryEvent) {
+		return
+	}
+
+	assert.Equal(t, "job_resources", resourcesEvent.Event)
+	assert.GreaterOrEqual(t, resourcesEvent.Samples, 3)
+	assert.GreaterOrEqual(t, resourcesEvent.PeakPIDs, 1)
+	assert.Greater(t, resourcesEvent.PeakMemoryBytes, int64(0))
+	assert.Equal(t, 0, resourcesEvent.OOMKills)
+	assert.Equal(t, resourcesEvent.Usage, *summaryEvent.Resources)
+
+	// the resource usage events do not change the job log itself
+	simplifiedEvents, err := eventlogger.SimplifyLogEvents(testLoggerBackend.Events, eventlogger.SimplifyOptions{IncludeOutput: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"job_started",
+
+		"directive: Exporting environment variables",
+		"Exit Code: 0",
+
+		"directive: Injecting Files",
+		"Exit Code: 0",
+
+		"directive: sleep 0.3",
+		"Exit Code: 0",
+
+		"directive: echo hello",
+		"hello\n",
+		"Exit Code: 0",
+
+		"directive: Exporting environment variables",
+		"Exporting SEMAPHORE_JOB_RESULT\n",
+		"Exit Code: 0",
+
+		"job_finished: passed",
+	}, simplifiedEvents)
+}
+
+func Test__ResumeFromCheckpoint(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	checkpointDir, err := CheckpointDirFor(filepath.Join(t.TempDir(), "job.yml"))
+	assert.Nil(t, err)
+
+	workDir := t.TempDir()
+	runJob := func(commands []api.Command) []string {
+		testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+		request := &api.JobRequest{
+			EnvVars:  []api.EnvVar{},
+			Commands: commands,
+			Logger: api.Logger{
+				Method: eventlogger.LoggerMethodPush,
+			},
+		}
+
+		job, err := NewJobWithOptions(&JobOptions{
+			Request:       request,
+			Client:        http.DefaultClient,
+			Logger:        testLogger,
+			CheckpointDir: checkpointDir,
+		})
+
+		assert.Nil(t, err)
+		job.Run()
+		assert.True(t, job.Finished)
+
+		simplifiedEvents, err := eventlogger.SimplifyLogEvents(testLoggerBackend.Events, eventlogger.SimplifyOptions{IncludeOutput: true})
+		assert.Nil(t, err)
+		return simplifiedEvents
+	}
+
+	commands := []api.Command{
+		{Directive: "export CHECKPOINT_VAR=hello"},
+		{Directive: "cd " + workDir},
+		{Directive: "false"},
+	}
+
+	// first run fails on the last command, and records a checkpoint for the ones before it
+	runJob(commands)
+	assert.FileExists(t, filepath.Join(checkpointDir, "state.json"))
+
+	// second run resumes from the failed command, with the previous environment and working directory
+	commands[2] = api.Command{Directive: "echo $CHECKPOINT_VAR $(pwd)"}
+	assert.Equal(t, []string{
+		"job_started",
+
+		"directive: Exporting environment variables",
+		"Exit Code: 0",
+
+		"directive: Injecting Files",
+		"Exit Code: 0",
+
+		"directive: Resuming from checkpoint",
+		"Skipping 2 command(s) that passed in a previous run:\n",
+		"  export CHECKPOINT_VAR=hello\n",
+		fmt.Sprintf("  cd %s\n", workDir),
+		"Exit Code: 0",
+
+		"directive: echo $CHECKPOINT_VAR $(pwd)",
+		fmt.Sprintf("hello %s\n", workDir),
+		"Exit Code: 0",
+
+		"directive: Exporting environment variables",
+		"Exporting SEMAPHORE_JOB_RESULT\n",
+		"Exit Code: 0",
+
+		"job_finished: passed",
+	}, runJob(commands))
+
+	// the job passed, so there is nothing to resume anymore
+	assert.NoDirExists(t, checkpointDir)
+}
+
+func Test__ResumedJobDoesNotGetThePreviousRunAgentEnvVars(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	collector := testsupport.NewCollectorMockServer()
+	collector.Init()
+	defer collector.Close()
+
+	checkpointDir, err := CheckpointDirFor(filepath.Join(t.TempDir(), "job.yml"))
+	assert.Nil(t, err)
+
+	vars := "$" + shell.ProcessMarkerEnvVar + " $" + tracing.TraceparentEnvVar
+	runJob := func(commands []api.Command, printVars string) string {
+		testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+		job, err := NewJobWithOptions(&JobOptions{
+			Request: &api.JobRequest{
+				EnvVars:  []api.EnvVar{},
+				Commands: commands,
+				Logger:   api.Logger{Method: eventlogger.LoggerMethodPush},
+			},
+			Client:        http.DefaultClient,
+			Logger:        testLogger,
+			CheckpointDir: checkpointDir,
+			Tracer:        tracing.NewTracer(tracing.Config{Endpoint: collector.URL()}),
+		})
+
+		assert.Nil(t, err)
+		job.Run()
+
+		simplifiedEvents, err := eventlogger.SimplifyLogEvents(testLoggerBackend.Events, eventlogger.SimplifyOptions{IncludeOutput: true})
+		assert.Nil(t, err)
+		for i, event := range simplifiedEvents {
+			if event == "directive: "+printVars && i+1 < len(simplifiedEvents) {
+				return simplifiedEvents[i+1]
+			}
+		}
+
+		return ""
+	}
+
+	first := runJob([]api.Command{{Directive: "echo " + vars}, {Directive: "false"}}, "echo "+vars)
+	assert.Len(t, strings.Fields(first), 2)
+
+	// The first command is skipped, and the resumed one gets the values from the new run.
+	second := runJob([]api.Command{{Directive: "echo " + vars}, {Directive: "echo resumed " + vars}}, "echo resumed "+vars)
+	secondVars := strings.Fields(second)
+	if assert.Len(t, secondVars, 3) {
+		assert.Equal(t, "resumed", secondVars[0])
+		assert.NotEqual(t, strings.Fields(first)[0], secondVars[1])
+		assert.NotEqual(t, strings.Fields(first)[1], secondVars[2])
+	}
+}
+
+func Test__ResumedJobRestoresMultilineValues(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	// bash writes values with newlines on a single line, but other shells don't.
+	for _, jobShell := range []string{"bash", "sh"} {
+		t.Run(jobShell, func(t *testing.T) {
+			checkpointDir, err := CheckpointDirFor(filepath.Join(t.TempDir(), "job.yml"))
+			assert.Nil(t, err)
+
+			runJob := func(certificate string, commands []api.Command) []string {
+				testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+				job, err := NewJobWithOptions(&JobOptions{
+					Request: &api.JobRequest{
+						EnvVars: []api.EnvVar{
+							{Name: "CERTIFICATE", Value: base64.StdEncoding.EncodeToString([]byte(certificate))},
+						},
+						Commands: commands,
+						Shell:    jobShell,
+						Logger:   api.Logger{Method: eventlogger.LoggerMethodPush},
+					},
+					Client:        http.DefaultClient,
+					Logger:        testLogger,
+					CheckpointDir: checkpointDir,
+				})
+
+				assert.Nil(t, err)
+				job.Run()
+
+				simplifiedEvents, err := eventlogger.SimplifyLogEvents(testLoggerBackend.Events, eventlogger.SimplifyOptions{IncludeOutput: true})
+				assert.Nil(t, err)
+				return simplifiedEvents
+			}
+
+			// The request variable is left out of the snapshot, and sorts before the exported one.
+			export := api.Command{Directive: `export CHECKPOINT_MULTILINE="$(printf 'one\ntwo "quoted" it'"'"'s\nthree')"`}
+			runJob("-----BEGIN CERTIFICATE-----\nfirst 'run\n-----END CERTIFICATE-----", []api.Command{export, {Directive: "false"}})
+
+			echo := `echo "$CERTIFICATE|$CHECKPOINT_MULTILINE"`
+			events := runJob("second\nrun", []api.Command{export, {Directive: echo}})
+			assert.Contains(t, events, "Skipping 1 command(s) that passed in a previous run:\n")
+			assert.Contains(t, events, "second\nrun|one\ntwo \"quoted\" it's\nthree\n")
+			assert.NotContains(t, events, "job_finished: failed")
+		})
+	}
+}
+
+func Test__CheckpointIsIgnoredIfCommandsChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	checkpointDir := t.TempDir()
+	job := &Job{CheckpointDir: checkpointDir}
+	commands := []api.Command{
+		{Directive: "echo 1"},
+		{Directive: "echo 2"},
+		{Directive: "false"},
+	}
+
+	content := fmt.Sprintf(`{"command_index": 2, "commands_hash": "%s"}`, hashCommands(commands[:2]))
+	assert.Nil(t, os.WriteFile(filepath.Join(checkpointDir, "state.json"), []byte(content), 0600))
+
+	checkpoint := job.loadCheckpoint(commands)
+	if assert.NotNil(t, checkpoint) {
+		assert.Equal(t, 2, checkpoint.CommandIndex)
+	}
+
+	// the failed command can change
+	commands[2] = api.Command{Directive: "true"}
+	assert.NotNil(t, job.loadCheckpoint(commands))
+
+	// but the ones that passed cannot
+	commands[1] = api.Command{Directive: "echo 2", Alias: "Second"}
+	assert.Nil(t, job.loadCheckpoint(commands))
+
+	// and the checkpoint must point to a command in the job
+	assert.Nil(t, job.loadCheckpoint(commands[:2]))
+}
+
+func Test__EnvAndOutputFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{},
+		Commands: []api.Command{
+			{Directive: "echo FOO=bar >> $SEMAPHORE_ENV_FILE"},
+			{Directive: "printf 'MULTI<<EOF\\nline 1\\nline 2\\nEOF\\n' >> $SEMAPHORE_ENV_FILE"},
+			{Directive: "echo $FOO"},
+			{Directive: "echo \"$MULTI\""},
+			{Directive: "echo result=42 >> $SEMAPHORE_OUTPUT_FILE"},
+		},
+		EpilogueAlwaysCommands: []api.Command{
+			{Directive: "echo epilogue=$FOO >> $SEMAPHORE_OUTPUT_FILE"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{Request: request, Client: http.DefaultClient, Logger: testLogger})
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	simplifiedEvents, err := eventlogger.SimplifyLogEvents(testLoggerBackend.Events, eventlogger.SimplifyOptions{IncludeOutput: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"job_started",
+
+		"directive: Exporting environment variables",
+		"Exit Code: 0",
+
+		"directive: Injecting Files",
+		"Exit Code: 0",
+
+		"directive: echo FOO=bar >> $SEMAPHORE_ENV_FILE",
+		"Exit Code: 0",
+
+		"directive: printf 'MULTI<<EOF\\nline 1\\nline 2\\nEOF\\n' >> $SEMAPHORE_ENV_FILE",
+		"Exit Code: 0",
+
+		"directive: echo $FOO",
+		"bar\n",
+		"Exit Code: 0",
+
+		"directive: echo \"$MULTI\"",
+		"line 1\nline 2\n",
+		"Exit Code: 0",
+
+		"directive: echo result=42 >> $SEMAPHORE_OUTPUT_FILE",
+		"Exit Code: 0",
+
+		"directive: Exporting environment variables",
+		"Exporting SEMAPHORE_JOB_RESULT\n",
+		"Exit Code: 0",
+
+		"directive: echo epilogue=$FOO >> $SEMAPHORE_OUTPUT_FILE",
+		"Exit Code: 0",
+
+		"job_outputs: epilogue=bar, result=42",
+		"job_finished: passed",
+	}, simplifiedEvents)
+}
+
+func Test__ParseCommandFile(t *testing.T) {
+	variables, err := parseCommandFile("A=1\r\nB=with=equals\n\nC<<END\nfirst\nsecond\nEND\nD=\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []commandFileVariable{
+		{Name: "A", Value: "1"},
+		{Name: "B", Value: "with=equals"},
+		{Name: "C", Value: "first\nsecond"},
+		{Name: "D", Value: ""},
+	}, variables)
+
+	// valid lines are still returned
+	variables, err = parseCommandFile("A=1\nnot a variable\n1B=2\nC<<END\nnever closed\n")
+	assert.ErrorContains(t, err, "invalid lines: 2, 3, 4 (missing closing delimiter)")
+	assert.Equal(t, []commandFileVariable{{Name: "A", Value: "1"}}, variables)
+}
+
+func Test__JobWithPOSIXShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		Shell: "sh",
+		Commands: []api.Command{
+			{Directive: "echo \"$A\""},
+			{Directive: "cd /tmp"},
+			{Directive: "pwd"},
+		},
+		EnvVars: []api.EnvVar{
+			{Name: "A", Value: base64.StdEncoding.EncodeToString([]byte("it's sh"))},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request: request,
+		Client:  http.DefaultClient,
+		Logger:  testLogger,
+
+		// The shell from the job request takes precedence.
+		Shell: "zsh",
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, false)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"job_started",
+
+		"directive: Exporting environment variables",
+		"Exporting A\n",
+		"Exit Code: 0",
+
+		"directive: Injecting Files",
+		"Exit Code: 0",
+
+		"directive: echo \"$A\"",
+		"it's sh\n",
+		"Exit Code: 0",
+
+		"directive: cd /tmp",
+		"Exit Code: 0",
+
+		"directive: pwd",
+		"/tmp\n",
+		"Exit Code: 0",
+
+		"directive: Exporting environment variables",
+		"Exporting SEMAPHORE_JOB_RESULT\n",
+		"Exit Code: 0",
+
+		"job_finished: passed",
+	}, simplifiedEvents)
+}
+
+func Test__UnsupportedShell(t *testing.T) {
+	testLogger, _ := eventlogger.DefaultTestLogger()
+
+	_, err := NewJobWithOptions(&JobOptions{
+		Request: &api.JobRequest{Shell: "tcsh"},
+		Client:  http.DefaultClient,
+		Logger:  testLogger,
+	})
+
+	if runtime.GOOS == "windows" {
+		assert.ErrorContains(t, err, "the shell can only be chosen")
+	} else {
+		assert.ErrorContains(t, err, "unsupported shell 'tcsh'")
+	}
+
+	_, err = NewJobWithOptions(&JobOptions{
+		Request: &api.JobRequest{Shell: "sh", Executor: "dockercompose"},
+		Client:  http.DefaultClient,
+		Logger:  testLogger,
+	})
+
+	assert.ErrorContains(t, err, "the shell can only be chosen for the shell executor")
+}
+
+func Test__SeparateOutputStreams(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("separate output streams are only supported on Linux")
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		Commands: []api.Command{
+			{Directive: "echo building; sleep 0.2; echo 'warning: deprecated' >&2"},
+			{Directive: "sleep 60"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:               request,
+		Client:                http.DefaultClient,
+		Logger:                testLogger,
+		SeparateOutputStreams: true,
+	})
+
+	assert.Nil(t, err)
+
+	go job.Run()
+
+	// The command runs in its own process, which is killed when the job is stopped.
+	time.Sleep(2 * time.Second)
+	job.Stop()
+	assert.Eventually(t, func() bool { return job.Finished }, 5*time.Second, 100*time.Millisecond)
+
+	outputs := []string{}
+	for _, event := range testLoggerBackend.Events {
+		if output, ok := event.(*eventlogger.CommandOutputEvent); ok {
+			outputs = append(outputs, fmt.Sprintf("%s: %s", output.Stream, output.Output))
+		}
+	}
+
+	assert.Equal(t, []string{
+		"stdout: building\n",
+		"stderr: warning: deprecated\n",
+	}, outputs)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(false, false)
+	assert.Nil(t, err)
+	assert.Contains(t, simplifiedEvents, "directive: sleep 60")
+	assert.Equal(t, "job_finished: stopped", simplifiedEvents[len(simplifiedEvents)-1])
+}
+
+func Test__JobWithOutputLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		Commands: []api.Command{
+			{Directive: "seq 1 100000"},
+			{Directive: "echo done"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:      request,
+		Client:       http.DefaultClient,
+		Logger:       testLogger,
+		OutputLimits: eventlogger.OutputLimits{CommandBytes: 100},
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, true)
+	assert.Nil(t, err)
+	output := strings.Join(simplifiedEvents, "")
+	assert.Contains(t, output, "35\n36\n3\n[agent] Output limit of 100B per command reached - dropping the rest of the output\n")
+	assert.Contains(t, output, "(99964 lines) of output over the limits were dropped\n")
+	assert.NotContains(t, output, "\n37\n")
+	assert.Contains(t, simplifiedEvents, "done\n")
+
+	// The command output is still read, so the command finishes.
+	throttled := []string{}
+	for _, event := range testLoggerBackend.Events {
+		if finished, ok := event.(*eventlogger.CommandFinishedEvent); ok && finished.OutputThrottling != nil {
+			assert.True(t, finished.OutputThrottling.CommandLimitReached)
+			assert.Equal(t, 0, finished.ExitCode)
+			throttled = append(throttled, finished.Directive)
+		}
+	}
+
+	assert.Equal(t, []string{"seq 1 100000"}, throttled)
+}
+
+func Test__JobPausesForDebuggingOnFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		JobID: "debug-" + strconv.Itoa(int(time.Now().UnixNano())),
+		Commands: []api.Command{
+			{Directive: "export DEBUG_VAR=hello; cd /tmp; false"},
+			{Directive: "echo not executed"},
+		},
+		EnvVars: []api.EnvVar{
+			{Name: DebugOnFailureEnvVar, Value: base64.StdEncoding.EncodeToString([]byte("10"))},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request: request,
+		Client:  http.DefaultClient,
+		Logger:  testLogger,
+	})
+
+	assert.Nil(t, err)
+	go job.Run()
+
+	socketPath := debugsession.SocketPath(request.JobID)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	reader, writer, err := os.Pipe()
+	assert.NoError(t, err)
+	_, _ = writer.WriteString("echo \"$DEBUG_VAR from $PWD\" | tr a-z A-Z\nexit\n")
+
+	var output bytes.Buffer
+	assert.NoError(t, debugsession.Attach(socketPath, reader, &output))
+	_ = writer.Close()
+	_ = reader.Close()
+	assert.Contains(t, output.String(), "HELLO FROM /TMP")
+
+	assert.Eventually(t, func() bool { return job.Finished }, 10*time.Second, 50*time.Millisecond)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, true)
+	assert.Nil(t, err)
+	assert.Contains(t, simplifiedEvents, "directive: "+DebugSessionDirective)
+	assert.Contains(t, strings.Join(simplifiedEvents, ""), "The debug session is over - resuming the job.\n")
+	assert.NotContains(t, simplifiedEvents, "not executed\n")
+	assert.Equal(t, "job_finished: failed", simplifiedEvents[len(simplifiedEvents)-1])
+}
+
+func Test__JobIsNotPausedForDebuggingWhenItPasses(t *testing.T) {
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		Commands: []api.Command{
+			{Directive: "echo hello"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:               request,
+		Client:                http.DefaultClient,
+		Logger:                testLogger,
+		DebugOnFailureTimeout: time.Hour,
+	})
+
+	assert.Nil(t, err)
+	job.Run()
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, true)
+	assert.Nil(t, err)
+	assert.NotContains(t, simplifiedEvents, "directive: "+DebugSessionDirective)
+	assert.Equal(t, "job_finished: passed", simplifiedEvents[len(simplifiedEvents)-1])
+}
+
+func Test__JobWithTerminalAndNoColor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	testLogger, testLoggerBackend := eventlogger.DefaultTestLogger()
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{
+			{Name: TerminalColumnsEnvVar, Value: base64.StdEncoding.EncodeToString([]byte("132"))},
+		},
+		Commands: []api.Command{
+			{Directive: "stty size"},
+			{Directive: "echo $TERM $NO_COLOR $CARGO_TERM_COLOR"},
+		},
+		Logger: api.Logger{
+			Method: eventlogger.LoggerMethodPush,
+		},
+	}
+
+	job, err := NewJobWithOptions(&JobOptions{
+		Request:  request,
+		Client:   http.DefaultClient,
+		Logger:   testLogger,
+		Terminal: shell.Terminal{Columns: 100, Rows: 50, Type: "xterm-256color"},
+		NoColor:  true,
+	})
+
+	assert.Nil(t, err)
+
+	job.Run()
+	assert.True(t, job.Finished)
+
+	simplifiedEvents, err := testLoggerBackend.SimplifiedEvents(true, false)
+	assert.Nil(t, err)
+
+	// The job environment takes precedence over the agent configuration.
+	assert.Contains(t, simplifiedEvents, "50 132\n")
+	assert.Contains(t, simplifiedEvents, "xterm-256color 1 never\n")
+}
+
+func Test__JobCanTurnOffNoColor(t *testing.T) {
+	request := &api.JobRequest{
+		EnvVars: []api.EnvVar{
+			{Name: NoColorEnvVar, Value: base64.StdEncoding.EncodeToString([]byte("false"))},
+			{Name: TerminalRowsEnvVar, Value: base64.StdEncoding.EncodeToString([]byte("not-a-number"))},
+		},
+	}
+
+	options := &JobOptions{Request: request, NoColor: true, Terminal: shell.Terminal{Rows: 40}}
+	assert.False(t, findNoColor(options))
+	assert.Equal(t, shell.Terminal{Rows: 40}, findTerminal(options))
+}
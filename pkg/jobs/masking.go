@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"strings"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Jobs can add values to be masked at runtime by writing them,
+// one per line, into the file pointed to by this environment variable.
+const MaskFileEnvVar = "SEMAPHORE_MASK_FILE"
+
+// SEMAPHORE_* variables hold information about the job itself, like the branch name,
+// so we do not mask them, unless their names indicate they hold credentials.
+var secretNameParts = []string{"TOKEN", "PASSWORD", "SECRET", "KEY"}
+
+func isSecretEnvVar(name string) bool {
+	if !strings.HasPrefix(name, "SEMAPHORE_") {
+		return true
+	}
+
+	for _, part := range secretNameParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Multi-line values are also masked line by line,
+// since commands usually print them that way.
+func maskableValues(value string) []string {
+	value = strings.TrimRight(value, "\r\n")
+	values := []string{value}
+	if strings.Contains(value, "\n") {
+		for _, line := range strings.Split(value, "\n") {
+			values = append(values, strings.TrimSpace(line))
+		}
+	}
+
+	return values
+}
+
+func secretValues(request *api.JobRequest) []string {
+	values := []string{}
+	for _, envVar := range request.EnvVars {
+		if !isSecretEnvVar(envVar.Name) {
+			continue
+		}
+
+		value, err := envVar.Decode()
+		if err != nil {
+			continue
+		}
+
+		values = append(values, maskableValues(string(value))...)
+	}
+
+	for _, file := range request.Files {
+		content, err := file.Decode()
+		if err != nil {
+			continue
+		}
+
+		values = append(values, maskableValues(string(content))...)
+	}
+
+	return values
+}
+
+func (job *Job) exportMaskFile() {
+	file := newJobFile(MaskFileEnvVar, "mask")
+	if exitCode := job.exportJobFile(file); exitCode != 0 {
+		log.Errorf("Error exporting %s: exit code %d", MaskFileEnvVar, exitCode)
+		return
+	}
+
+	job.maskFile = file
+}
+
+func (job *Job) refreshMaskedValues() {
+	if job.maskFile == nil {
+		return
+	}
+
+	content, err := job.readJobFile(job.maskFile)
+	if err != nil {
+		log.Errorf("Error reading values to mask: %v", err)
+		return
+	}
+
+	job.Logger.AddMaskedValues(jobFileLines(content)...)
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	"github.com/semaphoreci/agent/pkg/resources"
+	log "github.com/sirupsen/logrus"
+)
+
+const ResourceUsageDirective = "Checking resource usage"
+
+type resourceTracking struct {
+	sampler     *resources.Sampler
+	oomKills    int
+	oomCommands []string
+}
+
+/*
+ * Samples the resources used by the job processes in the background,
+ * while the executor is running. Only the shell and docker-compose
+ * executors on Linux support it; for everything else, this is a no-op.
+ */
+func (job *Job) startResourceSampling() {
+	if job.ResourceSamplingInterval <= 0 {
+		return
+	}
+
+	provider, ok := job.Executor.(executors.ResourceSourceProvider)
+	if !ok {
+		log.Infof("Executor %s does not support resource sampling", job.Request.Executor)
+		return
+	}
+
+	source, err := provider.ResourceSource()
+	if err != nil {
+		log.Warnf("Not sampling resource usage: %v", err)
+		return
+	}
+
+	job.resources.sampler = resources.NewSampler(source, job.ResourceSamplingInterval)
+	job.resources.sampler.Start()
+}
+
+// We sample right after every command, so we know which commands the OOM killer fired during.
+func (job *Job) checkOOMKills(command string) {
+	if job.resources.sampler == nil {
+		return
+	}
+
+	oomKills := job.resources.sampler.SampleNow()
+	if oomKills > job.resources.oomKills {
+		job.resources.oomCommands = append(job.resources.oomCommands, command)
+		job.resources.oomKills = oomKills
+	}
+}
+
+func (job *Job) stopResourceSampling() {
+	if job.resources.sampler == nil {
+		return
+	}
+
+	usage := job.resources.sampler.Stop()
+	job.resources.sampler = nil
+	job.summary.resources = &usage
+	job.Logger.LogJobResources(usage)
+
+	if usage.OOMKills > 0 {
+		job.logOOMKillsWarning(usage.OOMKills)
+	}
+}
+
+func (job *Job) logOOMKillsWarning(oomKills int) {
+	startedAt := int(time.Now().Unix())
+	job.Logger.LogCommandStarted(ResourceUsageDirective)
+	job.Logger.LogCommandOutput(fmt.Sprintf(
+		"Warning: the OOM killer terminated %d process(es) in this job, because it ran out of memory.\n",
+		oomKills,
+	))
+
+	for _, command := range job.resources.oomCommands {
+		job.Logger.LogCommandOutput(fmt.Sprintf("It happened while running: %s\n", command))
+	}
+
+	job.Logger.LogCommandFinished(ResourceUsageDirective, 0, startedAt, int(time.Now().Unix()))
+}
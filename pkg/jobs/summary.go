@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"time"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	eventlogger "github.com/semaphoreci/agent/pkg/eventlogger"
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	"github.com/semaphoreci/agent/pkg/resources"
+	"github.com/semaphoreci/agent/pkg/webhooks"
+)
+
+const (
+	PhaseExecutorPrepare = "executor_prepare"
+	PhaseExecutorStart   = "executor_start"
+	PhaseImagePull       = "image_pull"
+	PhaseEnvVarsExport   = "env_vars_export"
+	PhaseFileInjection   = "file_injection"
+	PhasePreJobHook      = "pre_job_hook"
+	PhaseCommands        = "commands"
+	PhaseTestReports     = "test_reports"
+	PhaseEpilogues       = "epilogues"
+	PhasePostJobHook     = "post_job_hook"
+	PhaseDebugSession    = "debug_session"
+)
+
+/*
+ * Keeps track of where the time in a job goes,
+ * so we can emit a job_summary event before the job_finished one.
+ */
+type jobSummary struct {
+	startedAt time.Time
+	phases    []eventlogger.PhaseSummary
+	commands  []eventlogger.CommandSummary
+	resources *resources.Usage
+}
+
+func (s *jobSummary) addPhase(name string, duration time.Duration) {
+	s.phases = append(s.phases, eventlogger.PhaseSummary{
+		Name:       name,
+		DurationMs: duration.Milliseconds(),
+	})
+}
+
+func (s *jobSummary) addCommand(directive string, exitCode int, duration time.Duration) {
+	s.commands = append(s.commands, eventlogger.CommandSummary{
+		Directive:  directive,
+		ExitCode:   exitCode,
+		DurationMs: duration.Milliseconds(),
+	})
+}
+
+func (s *jobSummary) timings() webhooks.JobTimings {
+	finishedAt := time.Now()
+	phases := []webhooks.PhaseTiming{}
+	for _, phase := range s.phases {
+		phases = append(phases, webhooks.PhaseTiming{Name: phase.Name, DurationMs: phase.DurationMs})
+	}
+
+	return webhooks.JobTimings{
+		StartedAt:  s.startedAt.Unix(),
+		FinishedAt: finishedAt.Unix(),
+		DurationMs: finishedAt.Sub(s.startedAt).Milliseconds(),
+		Phases:     phases,
+	}
+}
+
+func commandName(command api.Command) string {
+	if command.Alias != "" {
+		return command.Alias
+	}
+
+	return command.Directive
+}
+
+// Each phase is also traced, and the spans started in it are its children.
+func (job *Job) timePhase(name string, fn func()) {
+	startedAt := time.Now()
+	job.phaseSpan = job.startSpan(name)
+	fn()
+	job.phaseSpan.End()
+	job.phaseSpan = nil
+	job.summary.addPhase(name, time.Since(startedAt))
+}
+
+// The image pull happens while the executor starts,
+// so we take it out of the executor start phase.
+func (job *Job) timeExecutorStart(fn func()) {
+	startedAt := time.Now()
+	span := job.startSpan(PhaseExecutorStart)
+	fn()
+	span.End()
+	duration := time.Since(startedAt)
+
+	if timer, ok := job.Executor.(executors.ImagePullTimer); ok && timer.ImagePullDuration() > 0 {
+		pullDuration := timer.ImagePullDuration()
+		pullStartedAt := timer.ImagePullStartedAt()
+		span.StartChildAt(PhaseImagePull, pullStartedAt).EndAt(pullStartedAt.Add(pullDuration))
+		job.summary.addPhase(PhaseExecutorStart, duration-pullDuration)
+		job.summary.addPhase(PhaseImagePull, pullDuration)
+		return
+	}
+
+	job.summary.addPhase(PhaseExecutorStart, duration)
+}
+
+func (job *Job) logSummary() {
+	phases := job.summary.phases
+	if phases == nil {
+		phases = []eventlogger.PhaseSummary{}
+	}
+
+	commands := job.summary.commands
+	if commands == nil {
+		commands = []eventlogger.CommandSummary{}
+	}
+
+	job.Logger.LogJobSummary(phases, commands, job.summary.resources)
+}
@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"strconv"
+	"strings"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/shell"
+	log "github.com/sirupsen/logrus"
+)
+
+// Jobs can change the terminal through their environment,
+// and it takes precedence over the agent configuration.
+const TerminalColumnsEnvVar = "SEMAPHORE_TERMINAL_COLUMNS"
+const TerminalRowsEnvVar = "SEMAPHORE_TERMINAL_ROWS"
+const TerminalTypeEnvVar = "TERM"
+const NoColorEnvVar = "SEMAPHORE_NO_COLOR"
+
+/*
+ * Exported to the job commands when colors are disabled.
+ * FORCE_COLOR=0 is left out on purpose: some tools force colors
+ * whenever it is set, whatever the value.
+ */
+var NoColorEnvVars = []config.HostEnvVar{
+	{Name: "NO_COLOR", Value: "1"},
+	{Name: "CLICOLOR", Value: "0"},
+	{Name: "PY_COLORS", Value: "0"},
+	{Name: "CARGO_TERM_COLOR", Value: "never"},
+	{Name: "NPM_CONFIG_COLOR", Value: "false"},
+}
+
+func findTerminal(options *JobOptions) shell.Terminal {
+	terminal := options.Terminal
+	if columns, ok := terminalSizeFromEnv(options.Request, TerminalColumnsEnvVar); ok {
+		terminal.Columns = columns
+	}
+
+	if rows, ok := terminalSizeFromEnv(options.Request, TerminalRowsEnvVar); ok {
+		terminal.Rows = rows
+	}
+
+	if terminalType, ok := requestEnvVar(options.Request, TerminalTypeEnvVar); ok && terminalType != "" {
+		terminal.Type = terminalType
+	}
+
+	return terminal
+}
+
+func terminalSizeFromEnv(request *api.JobRequest, name string) (uint16, bool) {
+	value, ok := requestEnvVar(request, name)
+	if !ok {
+		return 0, false
+	}
+
+	size, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		log.Warnf("Ignoring %s='%s' - it must be a number between 0 and 65535", name, value)
+		return 0, false
+	}
+
+	return uint16(size), true
+}
+
+func findNoColor(options *JobOptions) bool {
+	value, ok := requestEnvVar(options.Request, NoColorEnvVar)
+	if !ok {
+		return options.NoColor
+	}
+
+	noColor, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("Ignoring %s='%s' - it must be true or false", NoColorEnvVar, value)
+		return options.NoColor
+	}
+
+	return noColor
+}
+
+func (job *Job) colorEnvVars(hostEnvVars []config.HostEnvVar) []config.HostEnvVar {
+	if !job.NoColor {
+		return hostEnvVars
+	}
+
+	return append(append([]config.HostEnvVar{}, hostEnvVars...), NoColorEnvVars...)
+}
+
+// The decoded value of a variable in the job environment, without surrounding whitespace.
+func requestEnvVar(request *api.JobRequest, name string) (string, bool) {
+	for _, envVar := range request.EnvVars {
+		if envVar.Name != name {
+			continue
+		}
+
+		value, err := envVar.Decode()
+		if err != nil {
+			log.Warnf("Ignoring %s: %v", name, err)
+			return "", false
+		}
+
+		return strings.TrimSpace(string(value)), true
+	}
+
+	return "", false
+}
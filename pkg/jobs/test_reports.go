@@ -0,0 +1,213 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	shell "github.com/semaphoreci/agent/pkg/shell"
+	"github.com/semaphoreci/agent/pkg/testreports"
+	log "github.com/sirupsen/logrus"
+)
+
+const TestReportsDirective = "Collecting test reports"
+
+type testReport struct {
+	Path    string
+	Content string
+}
+
+/*
+ * After the regular commands finish, we look for the JUnit XML reports
+ * matching the configured paths, summarize them in a test_summary event,
+ * and, if configured to do so, upload the raw reports as job artifacts.
+ *
+ * The reports are read through the executor, since for some executors,
+ * like docker-compose and kubernetes, they are not available to the agent process.
+ */
+func (job *Job) collectTestReports() {
+	if len(job.TestReportPaths) == 0 {
+		return
+	}
+
+	startedAt := time.Now()
+	defer func() {
+		job.summary.addPhase(PhaseTestReports, time.Since(startedAt))
+	}()
+
+	commandStartedAt := int(startedAt.Unix())
+	job.Logger.LogCommandStarted(TestReportsDirective)
+
+	exitCode := 0
+	defer func() {
+		commandFinishedAt := int(time.Now().Unix())
+		job.Logger.LogCommandFinished(TestReportsDirective, exitCode, commandStartedAt, commandFinishedAt)
+	}()
+
+	files := job.findTestReports()
+	if len(files) == 0 {
+		job.Logger.LogCommandOutput("No test reports found.\n")
+		return
+	}
+
+	collector := testreports.NewCollector()
+	reports := []testReport{}
+	for _, file := range files {
+		content, code := job.Executor.GetOutputFromCommand(job.readFileCommand(file))
+		if code != 0 {
+			job.Logger.LogCommandOutput(fmt.Sprintf("Failed to read %s.\n", file))
+			exitCode = 1
+			continue
+		}
+
+		err := collector.Add(file, []byte(content))
+		if err != nil {
+			job.Logger.LogCommandOutput(fmt.Sprintf("Failed to parse %s: %v\n", file, err))
+			exitCode = 1
+			continue
+		}
+
+		job.Logger.LogCommandOutput(fmt.Sprintf("Parsed %s.\n", file))
+		reports = append(reports, testReport{Path: file, Content: content})
+	}
+
+	summary := collector.Summary()
+	job.Logger.LogCommandOutput(fmt.Sprintf(
+		"%d tests, %d passed, %d failed, %d errors, %d skipped.\n",
+		summary.Total, summary.Passed, summary.Failed, summary.Errors, summary.Skipped,
+	))
+
+	job.Logger.LogTestSummary(summary)
+
+	if job.UploadTestReports {
+		job.uploadTestReports(reports)
+	}
+}
+
+func (job *Job) findTestReports() []string {
+	output, exitCode := job.Executor.GetOutputFromCommand(job.findTestReportsCommand())
+	if exitCode != 0 {
+		log.Errorf("Error finding test reports: exit code %d, output: %s", exitCode, output)
+		return []string{}
+	}
+
+	files := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		file := strings.TrimSpace(line)
+		if file == "" || seen[file] {
+			continue
+		}
+
+		seen[file] = true
+		files = append(files, file)
+	}
+
+	return files
+}
+
+func (job *Job) findTestReportsCommand() string {
+	if runtime.GOOS == "windows" {
+		paths := []string{}
+		for _, path := range job.TestReportPaths {
+			paths = append(paths, fmt.Sprintf("'%s'", strings.ReplaceAll(path, "'", "''")))
+		}
+
+		return fmt.Sprintf(
+			"Get-ChildItem -Path %s -File -ErrorAction SilentlyContinue | ForEach-Object { $_.FullName }",
+			strings.Join(paths, ","),
+		)
+	}
+
+	// The paths are not quoted, since we want the shell to expand the globs.
+	if job.dialect != shell.Bash {
+		// Only bash has globstar, so the other shells search with sh, where ** is the same as *.
+		return fmt.Sprintf("sh -c %s", shellQuote(fmt.Sprintf(
+			`for f in %s; do [ -f "$f" ] && echo "$f"; done; true`,
+			strings.Join(job.TestReportPaths, " "),
+		)))
+	}
+
+	return fmt.Sprintf(
+		`(shopt -s globstar nullglob; for f in %s; do [ -f "$f" ] && echo "$f"; done; true)`,
+		strings.Join(job.TestReportPaths, " "),
+	)
+}
+
+func (job *Job) readFileCommand(file string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("Get-Content -Raw -Path '%s'", strings.ReplaceAll(file, "'", "''"))
+	}
+
+	return fmt.Sprintf("cat '%s'", strings.ReplaceAll(file, "'", `'\''`))
+}
+
+/*
+ * The reports were read through the executor, so we write them
+ * to a local temporary directory before pushing them with the artifact CLI.
+ */
+func (job *Job) uploadTestReports(reports []testReport) {
+	if len(reports) == 0 {
+		return
+	}
+
+	token, err := job.Request.FindEnvVar("SEMAPHORE_ARTIFACT_TOKEN")
+	if err != nil {
+		log.Error("Error uploading test reports - no SEMAPHORE_ARTIFACT_TOKEN available")
+		return
+	}
+
+	orgURL, err := job.Request.FindEnvVar("SEMAPHORE_ORGANIZATION_URL")
+	if err != nil {
+		log.Error("Error uploading test reports - no SEMAPHORE_ORGANIZATION_URL available")
+		return
+	}
+
+	path, err := exec.LookPath("artifact")
+	if err != nil {
+		log.Error("Error uploading test reports - no artifact CLI available")
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-reports-*")
+	if err != nil {
+		log.Errorf("Error creating temporary directory for test reports: %v", err)
+		return
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	for i, report := range reports {
+		// Reports from different directories can have the same name.
+		localFile := filepath.Join(tmpDir, fmt.Sprintf("%d-%s", i, filepath.Base(report.Path)))
+		err := os.WriteFile(localFile, []byte(report.Content), 0600)
+		if err != nil {
+			log.Errorf("Error writing test report %s: %v", report.Path, err)
+			continue
+		}
+
+		destination := job.testReportDestination(report.Path)
+		err = job.pushArtifact(path, token, orgURL, localFile, destination)
+		if err != nil {
+			job.Logger.LogCommandOutput(fmt.Sprintf("Failed to upload %s.\n", report.Path))
+			log.Errorf("Error uploading test report %s: %v", report.Path, err)
+			continue
+		}
+
+		job.Logger.LogCommandOutput(fmt.Sprintf("Uploaded %s as %s.\n", report.Path, destination))
+	}
+}
+
+// The directory structure is kept, so reports with the same name don't collide.
+// Absolute paths go under the same directory as relative ones, without the root, or the volume, on Windows.
+func (job *Job) testReportDestination(path string) string {
+	clean := filepath.Clean(path)
+	clean = strings.TrimPrefix(clean, filepath.VolumeName(clean))
+	clean = strings.TrimPrefix(filepath.ToSlash(clean), "/")
+	clean = strings.ReplaceAll(clean, "../", "")
+	return fmt.Sprintf("agent/test_reports/%s", clean)
+}
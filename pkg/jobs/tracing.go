@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"fmt"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/tracing"
+)
+
+const (
+	SpanJob                      = "job"
+	SpanCommand                  = "command"
+	SpanLogFlush                 = "log_flush"
+	SpanFinishedCallback         = "finished_callback"
+	SpanTeardownFinishedCallback = "teardown_finished_callback"
+)
+
+/*
+ * The job is traced with a span for the whole job, with a child span for each phase.
+ * Commands are recorded under the phase they run in.
+ * If the job request has a TRACEPARENT environment variable, the job continues that trace.
+ */
+func (job *Job) startTrace() {
+	traceparent, _ := job.Request.FindEnvVar(tracing.TraceparentEnvVar)
+	job.span = job.Tracer.StartTrace(SpanJob, traceparent,
+		tracing.String("job.id", job.Request.JobID),
+		tracing.String("job.executor", job.Request.Executor),
+	)
+}
+
+func (job *Job) endTrace(result string) {
+	job.span.SetAttributes(tracing.String("job.result", result))
+	if result == JobFailed {
+		job.span.SetError("job failed")
+	}
+
+	job.span.End()
+}
+
+func (job *Job) startSpan(name string) *tracing.Span {
+	parent := job.span
+	if job.phaseSpan != nil {
+		parent = job.phaseSpan
+	}
+
+	return parent.StartChild(name, tracing.String("job.id", job.Request.JobID))
+}
+
+// Only the alias is recorded, since the directive could have secrets in it.
+func (job *Job) startCommandSpan(command api.Command) *tracing.Span {
+	span := job.startSpan(SpanCommand)
+	if command.Alias != "" {
+		span.SetAttributes(tracing.String("command.alias", command.Alias))
+	}
+
+	return span
+}
+
+func endCommandSpan(span *tracing.Span, exitCode int) {
+	span.SetAttributes(tracing.Int("command.exit_code", exitCode))
+	if exitCode != 0 {
+		span.SetError(fmt.Sprintf("exit code %d", exitCode))
+	}
+
+	span.End()
+}
+
+func (job *Job) startCallbackSpan(name string) *tracing.Span {
+	span := job.startSpan(name)
+	span.SetClientKind()
+	return span
+}
+
+func endCallbackSpan(span *tracing.Span, err error) {
+	if err != nil {
+		span.SetError(err.Error())
+	}
+
+	span.End()
+}
+
+// Tools used in the job commands can continue the job's trace through TRACEPARENT.
+func (job *Job) traceEnvVars(hostEnvVars []config.HostEnvVar) []config.HostEnvVar {
+	traceparent := job.span.Traceparent()
+	if traceparent == "" {
+		return hostEnvVars
+	}
+
+	return append(append([]config.HostEnvVar{}, hostEnvVars...), config.HostEnvVar{
+		Name:  tracing.TraceparentEnvVar,
+		Value: traceparent,
+	})
+}
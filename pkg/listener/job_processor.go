@@ -13,15 +13,21 @@ import (
 
 	api "github.com/semaphoreci/agent/pkg/api"
 	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/eventlogger"
 	jobs "github.com/semaphoreci/agent/pkg/jobs"
 	"github.com/semaphoreci/agent/pkg/kubernetes"
 	selfhostedapi "github.com/semaphoreci/agent/pkg/listener/selfhostedapi"
 	"github.com/semaphoreci/agent/pkg/random"
 	"github.com/semaphoreci/agent/pkg/retry"
 	"github.com/semaphoreci/agent/pkg/shell"
+	"github.com/semaphoreci/agent/pkg/tracing"
+	"github.com/semaphoreci/agent/pkg/webhooks"
 	log "github.com/sirupsen/logrus"
 )
 
+const WebhooksFlushTimeout = 30 * time.Second
+const TracesFlushTimeout = 30 * time.Second
+
 func StartJobProcessor(httpClient *http.Client, apiClient *selfhostedapi.API, config Config) (*JobProcessor, error) {
 	p := &JobProcessor{
 		HTTPClient:                       httpClient,
@@ -49,6 +55,40 @@ func StartJobProcessor(httpClient *http.Client, apiClient *selfhostedapi.API, co
 		KubernetesPodStartTimeoutSeconds: config.KubernetesPodStartTimeoutSeconds,
 		KubernetesLabels:                 config.KubernetesLabels,
 		KubernetesDefaultImage:           config.KubernetesDefaultImage,
+		TestReportPaths:                  config.TestReportPaths,
+		UploadTestReports:                config.UploadTestReports,
+		MaskSecrets:                      config.MaskSecrets,
+		ResourceSamplingInterval:         time.Duration(config.ResourceSamplingInterval) * time.Second,
+		StopGracePeriod:                  time.Duration(config.StopGracePeriod) * time.Second,
+		CompressLogs:                     config.CompressLogs,
+		LogSinks:                         config.LogSinks,
+		Shell:                            config.Shell,
+		SeparateOutputStreams:            config.SeparateOutputStreams,
+		OutputLimits:                     config.OutputLimits,
+		DebugOnFailureTimeout:            time.Duration(config.DebugOnFailureTimeout) * time.Second,
+		Terminal:                         config.Terminal,
+		NoColor:                          config.NoColor,
+	}
+
+	if len(config.WebhookURLs) > 0 {
+		p.Webhooks = webhooks.NewNotifier(webhooks.Config{
+			URLs:                 config.WebhookURLs,
+			Secret:               config.WebhookSecret,
+			IncludeCommandEvents: config.WebhookCommandEvents,
+			AgentName:            config.AgentName,
+			UserAgent:            config.UserAgent,
+		})
+	}
+
+	if config.TracingEndpoint != "" {
+		p.Tracer = tracing.NewTracer(tracing.Config{
+			Endpoint:  config.TracingEndpoint,
+			UserAgent: config.UserAgent,
+			Attributes: map[string]string{
+				"service.version": config.AgentVersion,
+				"agent.name":      config.AgentName,
+			},
+		})
 	}
 
 	go p.Start()
@@ -96,6 +136,21 @@ type JobProcessor struct {
 	KubernetesPodStartTimeoutSeconds int
 	KubernetesLabels                 map[string]string
 	KubernetesDefaultImage           string
+	TestReportPaths                  []string
+	UploadTestReports                bool
+	Webhooks                         *webhooks.Notifier
+	Tracer                           *tracing.Tracer
+	MaskSecrets                      bool
+	ResourceSamplingInterval         time.Duration
+	StopGracePeriod                  time.Duration
+	CompressLogs                     bool
+	LogSinks                         []string
+	Shell                            string
+	SeparateOutputStreams            bool
+	OutputLimits                     eventlogger.OutputLimits
+	DebugOnFailureTimeout            time.Duration
+	Terminal                         shell.Terminal
+	NoColor                          bool
 }
 
 func (p *JobProcessor) Start() {
@@ -217,6 +272,21 @@ func (p *JobProcessor) RunJob(jobID string) {
 		KubernetesDefaultImage:           p.KubernetesDefaultImage,
 		UploadJobLogs:                    p.UploadJobLogs,
 		UserAgent:                        p.UserAgent,
+		TestReportPaths:                  p.TestReportPaths,
+		UploadTestReports:                p.UploadTestReports,
+		Webhooks:                         p.Webhooks,
+		Tracer:                           p.Tracer,
+		MaskSecrets:                      p.MaskSecrets,
+		ResourceSamplingInterval:         p.ResourceSamplingInterval,
+		StopGracePeriod:                  p.StopGracePeriod,
+		CompressLogs:                     p.CompressLogs,
+		LogSinks:                         p.LogSinks,
+		Shell:                            p.Shell,
+		SeparateOutputStreams:            p.SeparateOutputStreams,
+		OutputLimits:                     p.OutputLimits,
+		DebugOnFailureTimeout:            p.DebugOnFailureTimeout,
+		Terminal:                         p.Terminal,
+		NoColor:                          p.NoColor,
 		RefreshTokenFn: func() (string, error) {
 			return p.APIClient.RefreshToken()
 		},
@@ -332,6 +402,10 @@ func (p *JobProcessor) disconnect() {
 func (p *JobProcessor) Shutdown(reason ShutdownReason, code int) {
 	p.ShutdownReason = reason
 
+	// Give pending webhooks a chance to be delivered before the agent goes away.
+	p.Webhooks.Flush(WebhooksFlushTimeout)
+	p.Tracer.Flush(TracesFlushTimeout)
+
 	p.disconnect()
 	p.executeShutdownHook(reason)
 	log.Infof("Agent shutting down due to: %s", reason)
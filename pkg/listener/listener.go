@@ -13,6 +13,7 @@ import (
 	selfhostedapi "github.com/semaphoreci/agent/pkg/listener/selfhostedapi"
 	osinfo "github.com/semaphoreci/agent/pkg/osinfo"
 	"github.com/semaphoreci/agent/pkg/retry"
+	"github.com/semaphoreci/agent/pkg/shell"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -52,6 +53,23 @@ type Config struct {
 	KubernetesPodStartTimeoutSeconds int
 	KubernetesLabels                 map[string]string
 	KubernetesDefaultImage           string
+	TestReportPaths                  []string
+	UploadTestReports                bool
+	WebhookURLs                      []string
+	WebhookSecret                    string
+	WebhookCommandEvents             bool
+	MaskSecrets                      bool
+	ResourceSamplingInterval         int
+	StopGracePeriod                  int
+	CompressLogs                     bool
+	LogSinks                         []string
+	TracingEndpoint                  string
+	Shell                            string
+	SeparateOutputStreams            bool
+	OutputLimits                     eventlogger.OutputLimits
+	DebugOnFailureTimeout            int
+	Terminal                         shell.Terminal
+	NoColor                          bool
 }
 
 func Start(httpClient *http.Client, config Config) (*Listener, error) {
@@ -0,0 +1,155 @@
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+/*
+ * Samples a cgroup v2, e.g. the one for a container.
+ * cgroup v1 is not supported.
+ */
+type CgroupSource struct {
+	Path string
+}
+
+func NewCgroupSourceForPID(pid int) (*CgroupSource, error) {
+	path, err := cgroupPathForPID(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2: %v", path, err)
+	}
+
+	return &CgroupSource{Path: path}, nil
+}
+
+func (s *CgroupSource) Sample() (*Sample, error) {
+	sample := &Sample{Timestamp: time.Now()}
+
+	cpuStat, err := readKeyValueFile(filepath.Join(s.Path, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	sample.CPUTime = time.Duration(cpuStat["usage_usec"]) * time.Microsecond
+
+	sample.MemoryBytes, err = readSingleValueFile(filepath.Join(s.Path, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+
+	// memory.peak is only available in newer kernels.
+	sample.MemoryPeak, _ = readSingleValueFile(filepath.Join(s.Path, "memory.peak"))
+
+	pids, _ := readSingleValueFile(filepath.Join(s.Path, "pids.current"))
+	sample.PIDs = int(pids)
+
+	sample.IOReadBytes, sample.IOWriteBytes, _ = readIOStat(filepath.Join(s.Path, "io.stat"))
+	sample.OOMKills, _ = readOOMKills(s.Path)
+
+	return sample, nil
+}
+
+// In cgroup v2, /proc/<pid>/cgroup has a single "0::<path>" line.
+func cgroupPathForPID(pid int) (string, error) {
+	// #nosec
+	content, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if path, found := strings.CutPrefix(line, "0::"); found {
+			return filepath.Join(cgroupMountPoint, path), nil
+		}
+	}
+
+	return "", fmt.Errorf("no cgroup v2 found for process %d", pid)
+}
+
+func readOOMKills(cgroupPath string) (int, error) {
+	events, err := readKeyValueFile(filepath.Join(cgroupPath, "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(events["oom_kill"]), nil
+}
+
+func readSingleValueFile(path string) (int64, error) {
+	// #nosec
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
+
+func readKeyValueFile(path string) (map[string]int64, error) {
+	// #nosec
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err == nil {
+			values[fields[0]] = value
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// Each line in io.stat is for a device: "<major>:<minor> rbytes=... wbytes=... ..."
+func readIOStat(path string) (int64, int64, error) {
+	// #nosec
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer file.Close()
+
+	var readBytes, writeBytes int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+
+			n, _ := strconv.ParseInt(value, 10, 64)
+			switch key {
+			case "rbytes":
+				readBytes += n
+			case "wbytes":
+				writeBytes += n
+			}
+		}
+	}
+
+	return readBytes, writeBytes, scanner.Err()
+}
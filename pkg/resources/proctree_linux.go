@@ -0,0 +1,182 @@
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kernel reports CPU times in /proc in clock ticks,
+// and USER_HZ is 100 on all the architectures we support.
+const clockTicksPerSecond = 100
+
+type processStat struct {
+	pid     int
+	ppid    int
+	cpuTime time.Duration
+	rss     int64
+}
+
+/*
+ * Samples all the processes descending from a root process, by reading /proc.
+ * Used for the shell executor, where the job processes share
+ * the cgroup with the agent itself.
+ */
+type ProcessTreeSource struct {
+	RootPID int
+}
+
+func NewProcessTreeSource(rootPID int) (*ProcessTreeSource, error) {
+	if _, err := os.Stat(fmt.Sprintf("/proc/%d/stat", rootPID)); err != nil {
+		return nil, fmt.Errorf("process %d not found: %v", rootPID, err)
+	}
+
+	return &ProcessTreeSource{RootPID: rootPID}, nil
+}
+
+func (s *ProcessTreeSource) Sample() (*Sample, error) {
+	stats, err := readAllProcessStats()
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[int][]int{}
+	for _, stat := range stats {
+		children[stat.ppid] = append(children[stat.ppid], stat.pid)
+	}
+
+	if _, ok := stats[s.RootPID]; !ok {
+		return nil, fmt.Errorf("process %d is gone", s.RootPID)
+	}
+
+	sample := &Sample{Timestamp: time.Now()}
+	queue := []int{s.RootPID}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		queue = append(queue, children[pid]...)
+
+		stat := stats[pid]
+		sample.PIDs++
+		sample.CPUTime += stat.cpuTime
+		sample.MemoryBytes += stat.rss
+
+		// Not all processes allow us to read their IO counters, so we ignore errors here.
+		readBytes, writeBytes, err := readProcessIO(pid)
+		if err == nil {
+			sample.IOReadBytes += readBytes
+			sample.IOWriteBytes += writeBytes
+		}
+	}
+
+	// The OOM kills are only available through the cgroup.
+	if cgroupPath, err := cgroupPathForPID(s.RootPID); err == nil {
+		sample.OOMKills, _ = readOOMKills(cgroupPath)
+	}
+
+	return sample, nil
+}
+
+func readAllProcessStats() (map[int]*processStat, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("error reading /proc: %v", err)
+	}
+
+	pageSize := int64(os.Getpagesize())
+	stats := map[int]*processStat{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		// Processes can exit while we iterate, so we ignore errors here.
+		stat, err := readProcessStat(pid, pageSize)
+		if err != nil {
+			continue
+		}
+
+		stats[pid] = stat
+	}
+
+	return stats, nil
+}
+
+/*
+ * See proc(5) for the format of /proc/<pid>/stat.
+ * The process name is in parenthesis and can contain spaces,
+ * so we only split the fields after the last closing parenthesis.
+ */
+func readProcessStat(pid int, pageSize int64) (*processStat, error) {
+	// #nosec
+	content, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProcessStat(pid, string(content), pageSize)
+}
+
+func parseProcessStat(pid int, content string, pageSize int64) (*processStat, error) {
+	index := strings.LastIndex(content, ")")
+	if index < 0 {
+		return nil, fmt.Errorf("bad stat format for process %d", pid)
+	}
+
+	// fields[0] is the state, which is field 3 in proc(5)
+	fields := strings.Fields(content[index+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("bad stat format for process %d", pid)
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+
+	// utime, stime, cutime and cstime
+	ticks := int64(0)
+	for _, field := range fields[11:15] {
+		value, _ := strconv.ParseInt(field, 10, 64)
+		ticks += value
+	}
+
+	rssPages, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	return &processStat{
+		pid:     pid,
+		ppid:    ppid,
+		cpuTime: time.Duration(ticks) * time.Second / clockTicksPerSecond,
+		rss:     rssPages * pageSize,
+	}, nil
+}
+
+func readProcessIO(pid int) (int64, int64, error) {
+	// #nosec
+	file, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "io"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer file.Close()
+
+	var readBytes, writeBytes int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "read_bytes":
+			readBytes, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		case "write_bytes":
+			writeBytes, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		}
+	}
+
+	return readBytes, writeBytes, scanner.Err()
+}
@@ -0,0 +1,45 @@
+package resources
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__ParseProcessStat(t *testing.T) {
+	content := "1234 (my (weird) process) S 1 1234 1234 0 -1 4194560 100 0 0 0 150 50 10 5 20 0 1 0 100 10000000 256 18446744073709551615"
+	stat, err := parseProcessStat(1234, content, 4096)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1234, stat.pid)
+	assert.Equal(t, 1, stat.ppid)
+	assert.Equal(t, 2150*time.Millisecond, stat.cpuTime)
+	assert.Equal(t, int64(256*4096), stat.rss)
+
+	_, err = parseProcessStat(1234, "1234 (truncated) S 1", 4096)
+	assert.Error(t, err)
+}
+
+func Test__ProcessTreeSourceIncludesChildren(t *testing.T) {
+	cmd := exec.Command("sleep", "5")
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	source, err := NewProcessTreeSource(os.Getpid())
+	require.NoError(t, err)
+
+	sample, err := source.Sample()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, sample.PIDs, 2)
+	assert.Greater(t, sample.MemoryBytes, int64(0))
+
+	_, err = NewProcessTreeSource(-1)
+	assert.Error(t, err)
+}
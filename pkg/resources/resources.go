@@ -0,0 +1,163 @@
+package resources
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultSamplingInterval = 5 * time.Second
+
+// A point-in-time reading of the resources used by the job.
+// CPU time, IO and OOM kills are cumulative.
+type Sample struct {
+	Timestamp    time.Time
+	CPUTime      time.Duration
+	MemoryBytes  int64
+	MemoryPeak   int64
+	IOReadBytes  int64
+	IOWriteBytes int64
+	PIDs         int
+	OOMKills     int
+}
+
+// Where the samples come from: the job's process tree or its container cgroup.
+type Source interface {
+	Sample() (*Sample, error)
+}
+
+type Usage struct {
+	Samples         int     `json:"samples"`
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	AvgCPUPercent   float64 `json:"avg_cpu_percent"`
+	PeakCPUPercent  float64 `json:"peak_cpu_percent"`
+	AvgMemoryBytes  int64   `json:"avg_memory_bytes"`
+	PeakMemoryBytes int64   `json:"peak_memory_bytes"`
+	IOReadBytes     int64   `json:"io_read_bytes"`
+	IOWriteBytes    int64   `json:"io_write_bytes"`
+	PeakPIDs        int     `json:"peak_pids"`
+	OOMKills        int     `json:"oom_kills"`
+}
+
+/*
+ * Samples a source at a fixed interval, in the background,
+ * keeping only the aggregated values, so memory usage stays
+ * constant no matter how long the job runs.
+ */
+type Sampler struct {
+	source   Source
+	interval time.Duration
+
+	mutex       sync.Mutex
+	first       *Sample
+	last        *Sample
+	usage       Usage
+	memoryTotal int64
+	stopCh      chan struct{}
+	doneCh      chan struct{}
+}
+
+func NewSampler(source Source, interval time.Duration) *Sampler {
+	if interval <= 0 {
+		interval = DefaultSamplingInterval
+	}
+
+	return &Sampler{
+		source:   source,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+func (s *Sampler) Start() {
+	s.SampleNow()
+
+	go func() {
+		defer close(s.doneCh)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+				s.SampleNow()
+			}
+		}
+	}()
+}
+
+// Takes one last sample, and returns the aggregated usage.
+func (s *Sampler) Stop() Usage {
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.SampleNow()
+	return s.Usage()
+}
+
+func (s *Sampler) Usage() Usage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.usage
+}
+
+// Takes a sample right away, and returns the number of OOM kills so far.
+func (s *Sampler) SampleNow() int {
+	sample, err := s.source.Sample()
+	if err != nil {
+		log.Debugf("Error sampling resource usage: %v", err)
+		return s.Usage().OOMKills
+	}
+
+	s.add(sample)
+	return s.Usage().OOMKills
+}
+
+func (s *Sampler) add(sample *Sample) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if sample.Timestamp.IsZero() {
+		sample.Timestamp = time.Now()
+	}
+
+	if s.first == nil {
+		s.first = sample
+	}
+
+	s.usage.Samples++
+	s.memoryTotal += sample.MemoryBytes
+	s.usage.AvgMemoryBytes = s.memoryTotal / int64(s.usage.Samples)
+	s.usage.PeakMemoryBytes = max(s.usage.PeakMemoryBytes, sample.MemoryBytes, sample.MemoryPeak)
+	s.usage.PeakPIDs = max(s.usage.PeakPIDs, sample.PIDs)
+
+	//
+	// When sampling a process tree, processes that exit take their counters with them,
+	// so cumulative values can go down between samples. We never let them go down here.
+	//
+	cpuTime := max(sample.CPUTime-s.first.CPUTime, 0)
+	s.usage.CPUSeconds = max(s.usage.CPUSeconds, cpuTime.Seconds())
+	s.usage.IOReadBytes = max(s.usage.IOReadBytes, sample.IOReadBytes-s.first.IOReadBytes)
+	s.usage.IOWriteBytes = max(s.usage.IOWriteBytes, sample.IOWriteBytes-s.first.IOWriteBytes)
+	s.usage.OOMKills = max(s.usage.OOMKills, sample.OOMKills-s.first.OOMKills)
+
+	elapsed := sample.Timestamp.Sub(s.first.Timestamp)
+	if elapsed > 0 {
+		s.usage.AvgCPUPercent = s.usage.CPUSeconds / elapsed.Seconds() * 100
+	}
+
+	if s.last != nil {
+		interval := sample.Timestamp.Sub(s.last.Timestamp)
+		cpuDelta := sample.CPUTime - s.last.CPUTime
+		if interval > 0 && cpuDelta > 0 {
+			s.usage.PeakCPUPercent = max(s.usage.PeakCPUPercent, cpuDelta.Seconds()/interval.Seconds()*100)
+		}
+	}
+
+	s.last = sample
+}
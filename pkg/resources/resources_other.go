@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package resources
+
+import "fmt"
+
+type ProcessTreeSource struct {
+	RootPID int
+}
+
+func NewProcessTreeSource(rootPID int) (*ProcessTreeSource, error) {
+	return nil, fmt.Errorf("resource sampling is only supported on Linux")
+}
+
+func (s *ProcessTreeSource) Sample() (*Sample, error) {
+	return nil, fmt.Errorf("resource sampling is only supported on Linux")
+}
+
+type CgroupSource struct {
+	Path string
+}
+
+func NewCgroupSourceForPID(pid int) (*CgroupSource, error) {
+	return nil, fmt.Errorf("resource sampling is only supported on Linux")
+}
+
+func (s *CgroupSource) Sample() (*Sample, error) {
+	return nil, fmt.Errorf("resource sampling is only supported on Linux")
+}
@@ -0,0 +1,70 @@
+package resources
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	samples []*Sample
+	index   int
+}
+
+func (s *fakeSource) Sample() (*Sample, error) {
+	if s.index >= len(s.samples) {
+		return nil, fmt.Errorf("no more samples")
+	}
+
+	sample := s.samples[s.index]
+	s.index++
+	return sample, nil
+}
+
+func Test__SamplerAggregatesSamples(t *testing.T) {
+	now := time.Now()
+	source := &fakeSource{samples: []*Sample{
+		{Timestamp: now, CPUTime: 10 * time.Second, MemoryBytes: 100, IOReadBytes: 1000, IOWriteBytes: 2000, PIDs: 1, OOMKills: 2},
+		{Timestamp: now.Add(time.Second), CPUTime: 11 * time.Second, MemoryBytes: 300, IOReadBytes: 1500, IOWriteBytes: 2000, PIDs: 5, OOMKills: 2},
+		{Timestamp: now.Add(2 * time.Second), CPUTime: 11500 * time.Millisecond, MemoryBytes: 200, MemoryPeak: 400, IOReadBytes: 1200, IOWriteBytes: 2500, PIDs: 3, OOMKills: 3},
+	}}
+
+	sampler := NewSampler(source, time.Hour)
+	assert.Equal(t, 0, sampler.SampleNow())
+	assert.Equal(t, 0, sampler.SampleNow())
+	assert.Equal(t, 1, sampler.SampleNow())
+
+	// errors are ignored
+	assert.Equal(t, 1, sampler.SampleNow())
+
+	usage := sampler.Usage()
+	assert.Equal(t, 3, usage.Samples)
+	assert.InDelta(t, 1.5, usage.CPUSeconds, 0.001)
+	assert.InDelta(t, 75, usage.AvgCPUPercent, 0.001)
+	assert.InDelta(t, 100, usage.PeakCPUPercent, 0.001)
+	assert.Equal(t, int64(200), usage.AvgMemoryBytes)
+	assert.Equal(t, int64(400), usage.PeakMemoryBytes)
+
+	// processes exiting do not make the cumulative values go down
+	assert.Equal(t, int64(500), usage.IOReadBytes)
+	assert.Equal(t, int64(500), usage.IOWriteBytes)
+	assert.Equal(t, 5, usage.PeakPIDs)
+	assert.Equal(t, 1, usage.OOMKills)
+}
+
+func Test__SamplerSamplesInTheBackground(t *testing.T) {
+	samples := []*Sample{}
+	for i := 0; i < 100; i++ {
+		samples = append(samples, &Sample{Timestamp: time.Now(), PIDs: 1})
+	}
+
+	sampler := NewSampler(&fakeSource{samples: samples}, 10*time.Millisecond)
+	sampler.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	usage := sampler.Stop()
+	assert.Greater(t, usage.Samples, 2)
+	assert.Equal(t, 1, usage.PeakPIDs)
+}
@@ -12,6 +12,7 @@ type RetryOptions struct {
 	Task                 string
 	MaxAttempts          int
 	DelayBetweenAttempts time.Duration
+	MaxDelay             time.Duration
 	Fn                   func() error
 	HideError            bool
 }
@@ -21,6 +22,34 @@ func RetryWithConstantWait(options RetryOptions) error {
 }
 
 func RetryWithConstantWaitAndContext(ctx context.Context, options RetryOptions) error {
+	return retry(ctx, options, func(attempt int) time.Duration {
+		return options.DelayBetweenAttempts
+	})
+}
+
+/*
+ * The delay between attempts starts at DelayBetweenAttempts
+ * and doubles after every failed attempt, up to MaxDelay, if set.
+ */
+func RetryWithExponentialBackoff(options RetryOptions) error {
+	return RetryWithExponentialBackoffAndContext(context.TODO(), options)
+}
+
+func RetryWithExponentialBackoffAndContext(ctx context.Context, options RetryOptions) error {
+	return retry(ctx, options, func(attempt int) time.Duration {
+		delay := options.DelayBetweenAttempts
+		for i := 1; i < attempt; i++ {
+			delay = delay * 2
+			if options.MaxDelay > 0 && delay >= options.MaxDelay {
+				return options.MaxDelay
+			}
+		}
+
+		return delay
+	})
+}
+
+func retry(ctx context.Context, options RetryOptions, delayFn func(attempt int) time.Duration) error {
 	if options.Fn == nil {
 		return fmt.Errorf("options.Fn cannot be nil")
 	}
@@ -39,18 +68,23 @@ func RetryWithConstantWaitAndContext(ctx context.Context, options RetryOptions)
 			return fmt.Errorf("[%s] failed after [%d] attempts - giving up: %v", options.Task, attempt, err)
 		}
 
+		delay := delayFn(attempt)
 		if !options.HideError {
 			log.Errorf(
 				"[%s] attempt [%d] failed with [%v] - retrying in %s",
 				options.Task,
 				attempt,
 				err,
-				options.DelayBetweenAttempts,
+				delay,
 			)
 		}
 
-		if options.DelayBetweenAttempts > 0 {
-			time.Sleep(options.DelayBetweenAttempts)
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
 		}
 	}
 }
@@ -60,3 +60,25 @@ func Test__GivesUpIfContextIsCancelled(t *testing.T) {
 
 	assert.ErrorContains(t, err, "context canceled")
 }
+
+func Test__ExponentialBackoff(t *testing.T) {
+	attemptTimes := []time.Time{}
+	err := RetryWithExponentialBackoff(RetryOptions{
+		Task:                 "test",
+		MaxAttempts:          4,
+		DelayBetweenAttempts: 50 * time.Millisecond,
+		MaxDelay:             150 * time.Millisecond,
+		Fn: func() error {
+			attemptTimes = append(attemptTimes, time.Now())
+			return errors.New("bad error")
+		},
+	})
+
+	assert.NotNil(t, err)
+	if assert.Len(t, attemptTimes, 4) {
+		// delays are 50ms, 100ms and 150ms (capped at MaxDelay)
+		assert.GreaterOrEqual(t, attemptTimes[1].Sub(attemptTimes[0]), 50*time.Millisecond)
+		assert.GreaterOrEqual(t, attemptTimes[2].Sub(attemptTimes[1]), 100*time.Millisecond)
+		assert.GreaterOrEqual(t, attemptTimes[3].Sub(attemptTimes[2]), 150*time.Millisecond)
+	}
+}
@@ -0,0 +1,229 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultRegion = "us-east-1"
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Uses the same environment variables as the AWS CLI.
+func CredentialsFromEnv() (Credentials, error) {
+	credentials := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return credentials, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	return credentials, nil
+}
+
+func RegionFromEnv() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+
+	return DefaultRegion
+}
+
+/*
+ * A minimal client for S3-compatible object storage,
+ * with only the operations needed to upload the job logs.
+ * Buckets are always addressed path-style, since that is
+ * what most S3-compatible servers support.
+ */
+type Client struct {
+	Endpoint    string
+	Bucket      string
+	Region      string
+	Credentials Credentials
+	HTTPClient  *http.Client
+}
+
+type Part struct {
+	Number int
+	ETag   string
+}
+
+/*
+ * Creates a client from a URL in the form <scheme>://<host>/<bucket>/<prefix>.
+ * The prefix is returned, without leading or trailing slashes.
+ */
+func NewClientFromURL(rawURL string, credentials Credentials, region string) (*Client, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid URL '%s': %v", rawURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", fmt.Errorf("invalid URL '%s': scheme must be http or https", rawURL)
+	}
+
+	bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if bucket == "" {
+		return nil, "", fmt.Errorf("invalid URL '%s': no bucket", rawURL)
+	}
+
+	return &Client{
+		Endpoint:    fmt.Sprintf("%s://%s", u.Scheme, u.Host),
+		Bucket:      bucket,
+		Region:      region,
+		Credentials: credentials,
+		HTTPClient:  &http.Client{Timeout: time.Minute},
+	}, strings.Trim(prefix, "/"), nil
+}
+
+func (c *Client) PutObject(key string, body []byte, contentType string) error {
+	_, _, err := c.do(http.MethodPut, key, url.Values{}, body, map[string]string{"Content-Type": contentType})
+	return err
+}
+
+// Returns the ID of the upload.
+func (c *Client) CreateMultipartUpload(key string, contentType string) (string, error) {
+	query := url.Values{"uploads": []string{""}}
+	responseBody, _, err := c.do(http.MethodPost, key, query, nil, map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return "", err
+	}
+
+	result := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+
+	if err := xml.Unmarshal(responseBody, &result); err != nil {
+		return "", fmt.Errorf("error parsing response: %v", err)
+	}
+
+	if result.UploadID == "" {
+		return "", errors.New("no upload ID in response")
+	}
+
+	return result.UploadID, nil
+}
+
+func (c *Client) UploadPart(key, uploadID string, number int, body []byte) (*Part, error) {
+	query := url.Values{
+		"partNumber": []string{strconv.Itoa(number)},
+		"uploadId":   []string{uploadID},
+	}
+
+	_, headers, err := c.do(http.MethodPut, key, query, body, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Part{Number: number, ETag: headers.Get("ETag")}, nil
+}
+
+func (c *Client) CompleteMultipartUpload(key, uploadID string, parts []Part) error {
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+
+	request := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{}
+
+	for _, part := range parts {
+		request.Parts = append(request.Parts, completedPart{PartNumber: part.Number, ETag: part.ETag})
+	}
+
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"uploadId": []string{uploadID}}
+	_, _, err = c.do(http.MethodPost, key, query, body, map[string]string{"Content-Type": "application/xml"})
+	return err
+}
+
+func (c *Client) AbortMultipartUpload(key, uploadID string) error {
+	query := url.Values{"uploadId": []string{uploadID}}
+	_, _, err := c.do(http.MethodDelete, key, query, nil, nil)
+	return err
+}
+
+func (c *Client) GetObject(key string) ([]byte, error) {
+	body, _, err := c.do(http.MethodGet, key, url.Values{}, nil, nil)
+	return body, err
+}
+
+type responseError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (c *Client) do(method, key string, query url.Values, body []byte, headers map[string]string) ([]byte, http.Header, error) {
+	u, err := url.Parse(fmt.Sprintf("%s/%s/%s", c.Endpoint, c.Bucket, key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u.RawQuery = query.Encode()
+	request, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	bodyHash := emptyPayloadHash
+	if len(body) > 0 {
+		bodyHash = payloadHash(body)
+	}
+
+	sign(request, c.Credentials, c.Region, bodyHash, time.Now())
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	// Some errors, like the ones for completing a multipart upload, come with a 200 status.
+	s3Error := responseError{}
+	isError := response.StatusCode < 200 || response.StatusCode > 299
+	if xml.Unmarshal(responseBody, &s3Error) == nil && s3Error.Code != "" {
+		isError = true
+	}
+
+	if isError {
+		return nil, nil, fmt.Errorf("%s %s failed: %s %s %s", method, key, response.Status, s3Error.Code, s3Error.Message)
+	}
+
+	return responseBody, response.Header, nil
+}
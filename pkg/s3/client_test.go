@@ -0,0 +1,67 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__NewClientFromURL(t *testing.T) {
+	t.Run("bucket and prefix", func(t *testing.T) {
+		client, prefix, err := NewClientFromURL("https://s3.example.com/my-bucket/job-logs/", Credentials{}, DefaultRegion)
+		require.NoError(t, err)
+		assert.Equal(t, "https://s3.example.com", client.Endpoint)
+		assert.Equal(t, "my-bucket", client.Bucket)
+		assert.Equal(t, "job-logs", prefix)
+	})
+
+	t.Run("no prefix", func(t *testing.T) {
+		client, prefix, err := NewClientFromURL("http://localhost:9000/my-bucket", Credentials{}, DefaultRegion)
+		require.NoError(t, err)
+		assert.Equal(t, "http://localhost:9000", client.Endpoint)
+		assert.Equal(t, "my-bucket", client.Bucket)
+		assert.Empty(t, prefix)
+	})
+
+	t.Run("no bucket", func(t *testing.T) {
+		_, _, err := NewClientFromURL("https://s3.example.com", Credentials{}, DefaultRegion)
+		assert.ErrorContains(t, err, "no bucket")
+	})
+
+	t.Run("bad scheme", func(t *testing.T) {
+		_, _, err := NewClientFromURL("s3://my-bucket/job-logs", Credentials{}, DefaultRegion)
+		assert.ErrorContains(t, err, "scheme must be http or https")
+	})
+}
+
+func Test__CredentialsFromEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err := CredentialsFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	credentials, err := CredentialsFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{AccessKeyID: "key", SecretAccessKey: "secret", SessionToken: "token"}, credentials)
+}
+
+func Test__ErrorsInSuccessfulResponsesAreDetected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+	}))
+
+	defer server.Close()
+
+	client, _, err := NewClientFromURL(server.URL+"/bucket", Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, DefaultRegion)
+	require.NoError(t, err)
+
+	err = client.CompleteMultipartUpload("events.jsonl", "upload-1", []Part{{Number: 1, ETag: "etag"}})
+	assert.ErrorContains(t, err, "InternalError")
+}
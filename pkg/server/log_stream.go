@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mux "github.com/gorilla/mux"
+	"github.com/semaphoreci/agent/pkg/eventlogger"
+	log "github.com/sirupsen/logrus"
+)
+
+const LogStreamPollInterval = 250 * time.Millisecond
+const LogStreamKeepAliveInterval = 15 * time.Second
+
+// The number of log events read from the backend at once.
+const logStreamBatchSize = 1000
+
+/*
+ * Streams the job log as Server-Sent Events: first, the events already written,
+ * and then, the new ones, as they are written. The ID of each event is its line number,
+ * so clients resume from where they stopped with the Last-Event-ID header.
+ *
+ * The stream ends after the job_finished event, with an "end" event.
+ * If a client reconnects after that, we respond with a 204, which tells it to stop.
+ */
+func (s *Server) JobLogStream(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["job_id"]
+	job := s.findActiveJob(w, jobID)
+	if job == nil {
+		return
+	}
+
+	startFrom, err := logStreamStart(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backend := job.Logger.Backend
+	if startFrom > 0 && isJobFinishedLine(backend, startFrom-1) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Streams last much longer than the server's write timeout.
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		log.Warnf("Could not remove the write deadline for the log stream of %s: %v", jobID, err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_ = controller.Flush()
+
+	log.Infof("Streaming logs for %s from line %d", jobID, startFrom)
+
+	poll := time.NewTicker(LogStreamPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(LogStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	next := startFrom
+	for {
+		lineNumber, finished, err := writeLogEvents(w, backend, next)
+		if err != nil {
+			log.Errorf("Error streaming logs for %s: %v", jobID, err)
+			return
+		}
+
+		if lineNumber > next {
+			next = lineNumber
+			if err := controller.Flush(); err != nil {
+				log.Infof("Log stream for %s closed at line %d: %v", jobID, next, err)
+				return
+			}
+		}
+
+		if finished {
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			_ = controller.Flush()
+			log.Infof("Log stream for %s finished at line %d", jobID, next)
+
+			if r.Header.Get("X-Client-Name") == "archivator" {
+				job.JobLogArchived = true
+			}
+
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			log.Infof("Log stream for %s closed at line %d", jobID, next)
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			_ = controller.Flush()
+		case <-poll.C:
+		}
+	}
+}
+
+// Last-Event-ID has the last line the client got, and start_from, the first line it wants.
+func logStreamStart(r *http.Request) (int, error) {
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		lineNumber, err := strconv.Atoi(lastEventID)
+		if err != nil || lineNumber < 0 {
+			return 0, fmt.Errorf("invalid Last-Event-ID '%s'", lastEventID)
+		}
+
+		return lineNumber + 1, nil
+	}
+
+	startFrom, err := strconv.Atoi(r.URL.Query().Get("start_from"))
+	if err != nil || startFrom < 0 {
+		return 0, nil
+	}
+
+	return startFrom, nil
+}
+
+// Returns the line number after the last event written, and if the job_finished event was written.
+func writeLogEvents(w io.Writer, backend eventlogger.Backend, startFrom int) (int, bool, error) {
+	buffer := bytes.NewBuffer([]byte{})
+	if _, err := backend.Read(startFrom, logStreamBatchSize, buffer); err != nil {
+		return startFrom, false, err
+	}
+
+	lineNumber := startFrom
+	for {
+		line, err := buffer.ReadString('\n')
+		if err != nil {
+			return lineNumber, false, nil
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", lineNumber, line); err != nil {
+			return lineNumber, false, err
+		}
+
+		lineNumber++
+		if isJobFinished(line) {
+			return lineNumber, true, nil
+		}
+	}
+}
+
+func isJobFinishedLine(backend eventlogger.Backend, lineNumber int) bool {
+	buffer := bytes.NewBuffer([]byte{})
+	if _, err := backend.Read(lineNumber, 1, buffer); err != nil {
+		return false
+	}
+
+	return isJobFinished(strings.TrimSpace(buffer.String()))
+}
+
+func isJobFinished(line string) bool {
+	event := struct {
+		Event string `json:"event"`
+	}{}
+
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		return false
+	}
+
+	return event.Event == "job_finished"
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+func Test__JobLogStream(t *testing.T) {
+	dummyKey := "dummykey"
+	testServer := NewServer(ServerConfig{
+		HTTPClient: http.DefaultClient,
+		JWTSecret:  []byte(dummyKey),
+	})
+
+	httpServer := httptest.NewServer(testServer.handler())
+	defer httpServer.Close()
+
+	token, err := generateToken(dummyKey)
+	require.NoError(t, err)
+
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	defer callbackServer.Close()
+
+	t.Run("no token -> 401", func(t *testing.T) {
+		code, _ := streamLogs(t, httpServer.URL, "job-0", "", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("no active job -> 404", func(t *testing.T) {
+		code, _ := streamLogs(t, httpServer.URL, "job-0", token, "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	code, _ := postJob(t, testServer, &api.JobRequest{
+		JobID: "job-0",
+		Commands: []api.Command{
+			{Directive: "echo hello"},
+			{Directive: "sleep 1"},
+			{Directive: "echo bye"},
+		},
+		Callbacks: api.Callbacks{
+			Finished:         callbackServer.URL,
+			TeardownFinished: callbackServer.URL,
+		},
+	}, token, 0, callbackServer.URL)
+
+	require.Equal(t, http.StatusOK, code)
+
+	t.Run("job running and job on request do not match -> 403", func(t *testing.T) {
+		code, _ := streamLogs(t, httpServer.URL, "job-1", token, "")
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+
+	var events []sseEvent
+	t.Run("events are streamed until the job finishes", func(t *testing.T) {
+		code, events = streamLogs(t, httpServer.URL, "job-0", token, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Greater(t, len(events), 2)
+
+		for i, event := range events[:len(events)-1] {
+			assert.Equal(t, strconv.Itoa(i), event.ID)
+			assert.Empty(t, event.Event)
+		}
+
+		assert.Contains(t, events[0].Data, `"event":"job_started"`)
+		assert.Contains(t, events[len(events)-2].Data, `"event":"job_finished"`)
+		assert.Equal(t, sseEvent{Event: "end", Data: "{}"}, events[len(events)-1])
+	})
+
+	t.Run("streams are resumed from Last-Event-ID", func(t *testing.T) {
+		code, resumed := streamLogs(t, httpServer.URL, "job-0", token, "2")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, events[3:], resumed)
+	})
+
+	t.Run("streams resumed after the job finished -> 204", func(t *testing.T) {
+		lastEventID := events[len(events)-2].ID
+		code, _ := streamLogs(t, httpServer.URL, "job-0", token, lastEventID)
+		assert.Equal(t, http.StatusNoContent, code)
+	})
+
+	t.Run("invalid Last-Event-ID -> 400", func(t *testing.T) {
+		code, _ := streamLogs(t, httpServer.URL, "job-0", token, "not-a-number")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+func streamLogs(t *testing.T, url, jobID, token, lastEventID string) (int, []sseEvent) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/jobs/%s/log/stream", url, jobID), nil)
+	if token != "" {
+		req.Header.Add("Authorization", "Token "+token)
+	}
+
+	if lastEventID != "" {
+		req.Header.Add("Last-Event-ID", lastEventID)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := []sseEvent{}
+	event := sseEvent{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event != (sseEvent{}) {
+				events = append(events, event)
+			}
+
+			event = sseEvent{}
+		case strings.HasPrefix(line, ":"):
+			// comments keep the connection alive
+		case strings.HasPrefix(line, "id: "):
+			event.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	require.NoError(t, scanner.Err())
+	return resp.StatusCode, events
+}
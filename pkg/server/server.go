@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,8 +19,11 @@ import (
 
 	api "github.com/semaphoreci/agent/pkg/api"
 	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/eventlogger"
 	jobs "github.com/semaphoreci/agent/pkg/jobs"
+	shell "github.com/semaphoreci/agent/pkg/shell"
 	slices "github.com/semaphoreci/agent/pkg/slices"
+	"github.com/semaphoreci/agent/pkg/tracing"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -49,6 +53,35 @@ type ServerConfig struct {
 	FileInjections        []config.FileInjection
 	CallbackRetryAttempts int
 	ExposeKvmDevice       bool
+	MaskSecrets           bool
+
+	// Zero means resource usage is not sampled.
+	ResourceSamplingInterval time.Duration
+
+	// Zero means the job processes are killed right away when the job is stopped.
+	StopGracePeriod time.Duration
+
+	// Extra destinations for the job logs, see eventlogger.Sink.
+	LogSinks []string
+
+	// Nil means the jobs are not traced.
+	Tracer *tracing.Tracer
+
+	// Empty means bash. Jobs can also choose it, in their request.
+	Shell string
+
+	// Only supported on Linux.
+	SeparateOutputStreams bool
+
+	// The command output over the limits is dropped.
+	OutputLimits eventlogger.OutputLimits
+
+	// Zero means failed jobs are not paused for debugging, unless they ask for it.
+	DebugOnFailureTimeout time.Duration
+
+	// Jobs can also change them, through their environment.
+	Terminal shell.Terminal
+	NoColor  bool
 
 	// A way to execute some code before handling a POST /jobs request.
 	// Currently, only used to make tests that assert race condition scenarios more reproducible.
@@ -84,6 +117,7 @@ func NewServer(config ServerConfig) *Server {
 	router.HandleFunc("/status", jwtMiddleware(server.Status)).Methods("GET")
 	router.HandleFunc("/jobs", jwtMiddleware(server.Run)).Methods("POST")
 	router.HandleFunc("/jobs/{job_id}/log", jwtMiddleware(server.JobLogs)).Methods("GET")
+	router.HandleFunc("/jobs/{job_id}/log/stream", jwtMiddleware(server.JobLogStream)).Methods("GET")
 
 	// The path /stop is the new standard, /jobs/terminate is here to support the legacy system.
 	router.HandleFunc("/stop", jwtMiddleware(server.Stop)).Methods("POST")
@@ -100,15 +134,13 @@ func (s *Server) Serve() {
 
 	log.Infof("Agent %s listening on https://%s\n", s.Config.Version, address)
 
-	loggedRouter := handlers.LoggingHandler(s.Logfile, s.router)
-
 	server := &http.Server{
 		Addr:              address,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      5 * time.Second,
 		ReadTimeout:       10 * time.Second,
 		IdleTimeout:       30 * time.Second,
-		Handler:           loggedRouter,
+		Handler:           s.handler(),
 	}
 
 	err := server.ListenAndServeTLS(s.Config.TLSCertPath, s.Config.TLSKeyPath)
@@ -117,6 +149,22 @@ func (s *Server) Serve() {
 	}
 }
 
+/*
+ * The logging handler hides the write deadline of the response,
+ * which log streams need to remove, so they log their start and end themselves.
+ */
+func (s *Server) handler() http.Handler {
+	loggedRouter := handlers.LoggingHandler(s.Logfile, s.router)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/log/stream") {
+			s.router.ServeHTTP(w, r)
+			return
+		}
+
+		loggedRouter.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	m := make(map[string]interface{})
@@ -144,22 +192,7 @@ func (s *Server) JobLogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain")
 
 	jobID := mux.Vars(r)["job_id"]
-
-	// If no jobs have been received yet, we have no logs.
-	if s.ActiveJob == nil {
-		log.Warnf("Attempt to fetch logs for '%s' before any job is received", jobID)
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, `{"message": "job %s is not running"}`, jobID)
-		return
-	}
-
-	// Here, we know that a job was scheduled.
-	// We need to ensure the ID in the request matches the one executing.
-	runningJobID := s.ActiveJob.Request.JobID
-	if runningJobID != jobID {
-		log.Warnf("Attempt to fetch logs for '%s', but job '%s' is the one running", jobID, runningJobID)
-		w.WriteHeader(http.StatusForbidden)
-		fmt.Fprintf(w, `{"message": "job %s is not running"}`, jobID)
+	if s.findActiveJob(w, jobID) == nil {
 		return
 	}
 
@@ -181,6 +214,29 @@ func (s *Server) JobLogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Responds with a 404 or 403, and returns nil, if the job is not the one running.
+func (s *Server) findActiveJob(w http.ResponseWriter, jobID string) *jobs.Job {
+	// If no jobs have been received yet, we have no logs.
+	if s.ActiveJob == nil {
+		log.Warnf("Attempt to fetch logs for '%s' before any job is received", jobID)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": "job %s is not running"}`, jobID)
+		return nil
+	}
+
+	// Here, we know that a job was scheduled.
+	// We need to ensure the ID in the request matches the one executing.
+	runningJobID := s.ActiveJob.Request.JobID
+	if runningJobID != jobID {
+		log.Warnf("Attempt to fetch logs for '%s', but job '%s' is the one running", jobID, runningJobID)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"message": "job %s is not running"}`, jobID)
+		return nil
+	}
+
+	return s.ActiveJob
+}
+
 func (s *Server) AgentLogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain")
 
@@ -262,6 +318,18 @@ func (s *Server) Run(w http.ResponseWriter, r *http.Request) {
 		RefreshTokenFn:  nil,
 		UploadJobLogs:   s.resolveUploadJobsConfig(request),
 		UserAgent:       s.Config.UserAgent,
+		MaskSecrets:     s.Config.MaskSecrets,
+
+		ResourceSamplingInterval: s.Config.ResourceSamplingInterval,
+		StopGracePeriod:          s.Config.StopGracePeriod,
+		LogSinks:                 s.Config.LogSinks,
+		Tracer:                   s.Config.Tracer,
+		Shell:                    s.Config.Shell,
+		SeparateOutputStreams:    s.Config.SeparateOutputStreams,
+		OutputLimits:             s.Config.OutputLimits,
+		DebugOnFailureTimeout:    s.Config.DebugOnFailureTimeout,
+		Terminal:                 s.Config.Terminal,
+		NoColor:                  s.Config.NoColor,
 	})
 
 	if err != nil {
@@ -0,0 +1,163 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ * The shell reports the exit code of each command through a FIFO, not through its output,
+ * and only prints the end of the output after the agent tells it to, through another FIFO.
+ * The FIFOs are opened by a small relay, which runs next to the shell,
+ * so it also works when the shell is in a container which doesn't share a folder with the agent:
+ *
+ *   - the relay creates the FIFOs, and keeps both open for reading and writing,
+ *     so the shell can open them at any time, and never sees their end.
+ *   - every exit code the shell writes to the status FIFO is a line on the relay's stdout.
+ *   - every line the agent writes to the relay's stdin goes to the end FIFO.
+ */
+const channelRelayScript = `set -e
+status=%s
+end=%s
+rm -f "$status" "$end"
+mkfifo -m 600 "$status" "$end"
+exec 3<>"$status" 4<>"$end"
+echo ready
+cat <&3 &
+cat >&4
+kill $!
+rm -f "$status" "$end"
+`
+
+// How long the relay has to clean up after the agent is done with it.
+const channelCloseTimeout = 5 * time.Second
+
+type channel struct {
+	StatusPath string
+	EndPath    string
+
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stderr   *bytes.Buffer
+	statuses chan string
+	exited   chan struct{}
+	closed   chan struct{}
+
+	closeOnce sync.Once
+}
+
+/*
+ * The relay runs with the command prefix, e.g. `docker exec -i <container>`,
+ * and creates the FIFOs in the directory, which must be one the shell sees with the same path.
+ */
+func startChannel(prefix []string, directory, id string) (*channel, error) {
+	c := &channel{
+		StatusPath: filepath.Join(directory, fmt.Sprintf("semaphore-agent-%s.status", id)),
+		EndPath:    filepath.Join(directory, fmt.Sprintf("semaphore-agent-%s.end", id)),
+		stderr:     &bytes.Buffer{},
+		statuses:   make(chan string, 1),
+		exited:     make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+
+	script := fmt.Sprintf(channelRelayScript, shellQuote(c.StatusPath), shellQuote(c.EndPath))
+	args := append(append([]string{}, prefix...), "sh", "-c", script)
+
+	// #nosec
+	c.cmd = exec.Command(args[0], args[1:]...)
+	c.cmd.Stderr = c.stderr
+
+	stdin, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	c.stdin = stdin
+	if err := c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting the status channel: %v", err)
+	}
+
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ready" {
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+		return nil, fmt.Errorf("error starting the status channel: %s", strings.TrimSpace(c.stderr.String()))
+	}
+
+	go c.readStatuses(reader)
+	return c, nil
+}
+
+func (c *channel) readStatuses(reader *bufio.Reader) {
+	defer close(c.exited)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			log.Debugf("The status channel is closed: %v", err)
+			return
+		}
+
+		log.Debugf("Status received: %s", strings.TrimSpace(line))
+		select {
+		case c.statuses <- strings.TrimSpace(line):
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Waits for the exit code of the command, until the command is abandoned.
+func (c *channel) WaitForStatus(abandoned <-chan struct{}) (string, bool) {
+	select {
+	case status := <-c.statuses:
+		return status, true
+	case <-c.exited:
+		select {
+		case status := <-c.statuses:
+			return status, true
+		default:
+			return "", false
+		}
+	case <-abandoned:
+		return "", false
+	}
+}
+
+// The shell prints the end marker once it reads it from the end FIFO.
+func (c *channel) SendEnd(marker string) error {
+	_, err := c.stdin.Write([]byte(marker + "\n"))
+	return err
+}
+
+// Without its stdin, the relay removes the FIFOs and exits.
+func (c *channel) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.stdin.Close()
+
+		select {
+		case <-c.exited:
+		case <-time.After(channelCloseTimeout):
+			log.Warnf("The status channel did not exit in %v - killing it", channelCloseTimeout)
+			_ = c.cmd.Process.Kill()
+		}
+
+		_ = c.cmd.Wait()
+	})
+}
@@ -0,0 +1,291 @@
+package shell
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * The shell-specific syntax of the commands the agent sends to the job shell:
+ * the wrapper which prints the fences and reports the exit code around each command,
+ * the environment file, and how files are sourced.
+ */
+type Dialect interface {
+	Name() string
+
+	// The arguments used to start a login shell.
+	LoginArgs() []string
+
+	// The commands which silence the prompt and disable echoing,
+	// before the shell is used for the job commands.
+	SetupCommands() []string
+
+	Export(name, value string) string
+	Source(path string) string
+
+	// Runs a script in a new process: with bash, if it is installed, since that is how
+	// scripts like the job hooks always ran, or on its own otherwise, so its shebang decides.
+	RunScript(path string) string
+
+	// Sources the command file, with the start fence printed before it.
+	// After it, the exit code is written to the status FIFO, and the end fence
+	// is read from the end FIFO and printed. See channel.go.
+	// The exit code is kept in $?, so the next command can still see it.
+	Wrap(startFence, path, statusPath, endPath string) string
+
+	// Same as Wrap, but for a command encoded in base64, which is decoded by the shell itself.
+	WrapBase64(startFence, encodedCommand, statusPath, endPath string) string
+
+	// Without a PTY, each command runs in a new shell process, so the script runs the command,
+	// and saves the environment and the working directory for the next one in the dump file.
+	PipeScript(command, envDumpPath string) string
+
+	// Some job features, like checkpoints, rely on POSIX syntax.
+	POSIX() bool
+}
+
+var Bash Dialect = bashDialect{}
+var Zsh Dialect = zshDialect{}
+var POSIXShell Dialect = posixDialect{}
+var Fish Dialect = fishDialect{}
+
+var SupportedShells = []string{"bash", "zsh", "sh", "fish"}
+
+// The shell can be a name, or the path to the executable.
+// Shells compatible with sh use the POSIX dialect.
+func FindDialect(shell string) (Dialect, error) {
+	switch filepath.Base(shell) {
+	case "bash":
+		return Bash, nil
+	case "zsh":
+		return Zsh, nil
+	case "sh", "dash", "ash", "ksh", "mksh":
+		return POSIXShell, nil
+	case "fish":
+		return Fish, nil
+	default:
+		return nil, fmt.Errorf("unsupported shell '%s' - use one of %v, or a path to one of them", shell, SupportedShells)
+	}
+}
+
+type bashDialect struct{}
+
+func (d bashDialect) Name() string {
+	return "bash"
+}
+
+func (d bashDialect) LoginArgs() []string {
+	return []string{"--login"}
+}
+
+func (d bashDialect) SetupCommands() []string {
+	return []string{
+		"export PS1=''",
+		"stty -echo",
+		"echo stty `stty -g` > /tmp/restore-tty",
+	}
+}
+
+func (d bashDialect) Export(name, value string) string {
+	return fmt.Sprintf("export %s=%s", name, shellQuote(value))
+}
+
+func (d bashDialect) Source(path string) string {
+	return fmt.Sprintf("source %s", path)
+}
+
+func (d bashDialect) RunScript(path string) string {
+	return fmt.Sprintf("bash %s", path)
+}
+
+func (d bashDialect) Wrap(startFence, path, statusPath, endPath string) string {
+	//
+	// The instruction does the following:
+	//
+	//   1. display the start fence
+	//   2. execute the command file by sourcing it
+	//   3. save the original exit status, in a variable and in the status FIFO
+	//   4. wait for the end fence from the agent, and display it
+	//   5. return the original exit status to the caller
+	//
+	return fmt.Sprintf(`echo %s; source %s; %s`, startFence, path, posixCompletion(statusPath, endPath))
+}
+
+func (d bashDialect) WrapBase64(startFence, encodedCommand, statusPath, endPath string) string {
+	return fmt.Sprintf(
+		`echo %s; source <(echo %s | base64 -d); %s`,
+		startFence,
+		encodedCommand,
+		posixCompletion(statusPath, endPath),
+	)
+}
+
+func (d bashDialect) PipeScript(command, envDumpPath string) string {
+	return posixPipeScript(command, envDumpPath)
+}
+
+func (d bashDialect) POSIX() bool {
+	return true
+}
+
+// Zsh understands the same syntax as bash for everything we use.
+type zshDialect struct {
+	bashDialect
+}
+
+func (d zshDialect) Name() string {
+	return "zsh"
+}
+
+func (d zshDialect) LoginArgs() []string {
+	return []string{"-l"}
+}
+
+// Unlike the job shell, bash may not be installed.
+func (d zshDialect) RunScript(path string) string {
+	return POSIXShell.RunScript(path)
+}
+
+// Without PROMPT_SP, zsh does not mark output which doesn't end with a newline.
+func (d zshDialect) SetupCommands() []string {
+	return append([]string{"unsetopt PROMPT_SP", "export RPROMPT=''"}, d.bashDialect.SetupCommands()...)
+}
+
+// For sh, dash and friends: no `source`, no `echo -e`, and no process substitution.
+type posixDialect struct{}
+
+func (d posixDialect) Name() string {
+	return "sh"
+}
+
+func (d posixDialect) LoginArgs() []string {
+	return []string{"-l"}
+}
+
+func (d posixDialect) SetupCommands() []string {
+	return Bash.SetupCommands()
+}
+
+func (d posixDialect) Export(name, value string) string {
+	return Bash.Export(name, value)
+}
+
+func (d posixDialect) Source(path string) string {
+	return fmt.Sprintf(". %s", path)
+}
+
+func (d posixDialect) RunScript(path string) string {
+	return fmt.Sprintf("if command -v bash >/dev/null 2>&1; then bash %s; else %s; fi", path, path)
+}
+
+func (d posixDialect) Wrap(startFence, path, statusPath, endPath string) string {
+	return fmt.Sprintf(`echo %s; %s; %s`, startFence, d.Source(path), posixCompletion(statusPath, endPath))
+}
+
+func (d posixDialect) WrapBase64(startFence, encodedCommand, statusPath, endPath string) string {
+	return fmt.Sprintf(
+		`echo %s; eval "$(echo %s | base64 -d)"; %s`,
+		startFence,
+		encodedCommand,
+		posixCompletion(statusPath, endPath),
+	)
+}
+
+func (d posixDialect) PipeScript(command, envDumpPath string) string {
+	return posixPipeScript(command, envDumpPath)
+}
+
+func (d posixDialect) POSIX() bool {
+	return true
+}
+
+// Runs right after the command, so $? is still its exit code.
+func posixCompletion(statusPath, endPath string) string {
+	return fmt.Sprintf(
+		`AGENT_CMD_RESULT=$?; echo $AGENT_CMD_RESULT > %s; read -r AGENT_CMD_END < %s; echo "$AGENT_CMD_END"; echo "exit $AGENT_CMD_RESULT" | sh`,
+		statusPath,
+		endPath,
+	)
+}
+
+// The state is saved on exit, so commands which call exit, or fail with set -e, still save it.
+func posixPipeScript(command, envDumpPath string) string {
+	return fmt.Sprintf(
+		"AGENT_ENV_DUMP=%s\ntrap 'AGENT_CMD_RESULT=$?; export %s=\"$PWD\"; env -0 > \"$AGENT_ENV_DUMP\"; exit $AGENT_CMD_RESULT' EXIT\n%s\n",
+		shellQuote(envDumpPath),
+		CurrentDirEnvVar,
+		command,
+	)
+}
+
+type fishDialect struct{}
+
+func (d fishDialect) Name() string {
+	return "fish"
+}
+
+func (d fishDialect) LoginArgs() []string {
+	return []string{"--login"}
+}
+
+// The fish prompts are functions, and command substitution doesn't use backticks.
+func (d fishDialect) SetupCommands() []string {
+	return []string{
+		"function fish_prompt; end",
+		"function fish_right_prompt; end",
+		"stty -echo",
+		"echo stty (stty -g) > /tmp/restore-tty",
+	}
+}
+
+func (d fishDialect) Export(name, value string) string {
+	return fmt.Sprintf("set -gx %s %s", name, fishQuote(value))
+}
+
+func (d fishDialect) Source(path string) string {
+	return fmt.Sprintf("source %s", path)
+}
+
+func (d fishDialect) RunScript(path string) string {
+	return fmt.Sprintf("if type -q bash; bash %s; else; %s; end", path, path)
+}
+
+func (d fishDialect) Wrap(startFence, path, statusPath, endPath string) string {
+	return fmt.Sprintf(`echo %s; %s; %s`, startFence, d.Source(path), fishCompletion(statusPath, endPath))
+}
+
+func (d fishDialect) WrapBase64(startFence, encodedCommand, statusPath, endPath string) string {
+	return fmt.Sprintf(
+		`echo %s; echo %s | base64 -d | source; %s`,
+		startFence,
+		encodedCommand,
+		fishCompletion(statusPath, endPath),
+	)
+}
+
+func fishCompletion(statusPath, endPath string) string {
+	return fmt.Sprintf(
+		`set AGENT_CMD_RESULT $status; echo $AGENT_CMD_RESULT > %s; read AGENT_CMD_END < %s; echo $AGENT_CMD_END; sh -c "exit $AGENT_CMD_RESULT"`,
+		statusPath,
+		endPath,
+	)
+}
+
+func (d fishDialect) PipeScript(command, envDumpPath string) string {
+	return fmt.Sprintf(
+		"%s\nset AGENT_CMD_RESULT $status\nset -gx %s $PWD\nenv -0 > %s\nexit $AGENT_CMD_RESULT\n",
+		command,
+		CurrentDirEnvVar,
+		fishQuote(envDumpPath),
+	)
+}
+
+func (d fishDialect) POSIX() bool {
+	return false
+}
+
+// Inside single quotes, fish only treats backslashes and single quotes specially.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
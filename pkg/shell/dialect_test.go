@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test__FindDialect(t *testing.T) {
+	testCases := map[string]Dialect{
+		"bash":               Bash,
+		"/usr/local/bin/zsh": Zsh,
+		"sh":                 POSIXShell,
+		"/bin/dash":          POSIXShell,
+		"ash":                POSIXShell,
+		"fish":               Fish,
+	}
+
+	for shell, expected := range testCases {
+		dialect, err := FindDialect(shell)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, dialect, shell)
+	}
+
+	_, err := FindDialect("/bin/tcsh")
+	assert.ErrorContains(t, err, "unsupported shell '/bin/tcsh'")
+}
+
+func Test__Dialect__Export(t *testing.T) {
+	assert.Equal(t, "export A='hello world'", Bash.Export("A", "hello world"))
+	assert.Equal(t, "export A='it'\"'\"'s'", POSIXShell.Export("A", "it's"))
+	assert.Equal(t, `set -gx A 'it\'s \\o/'`, Fish.Export("A", `it's \o/`))
+	assert.Equal(t, "set -gx A ''", Fish.Export("A", ""))
+}
+
+func Test__Dialect__RunScript(t *testing.T) {
+	assert.Equal(t, "bash /opt/hook.sh", Bash.RunScript("/opt/hook.sh"))
+	assert.Equal(t, "if command -v bash >/dev/null 2>&1; then bash /opt/hook.sh; else /opt/hook.sh; fi", Zsh.RunScript("/opt/hook.sh"))
+	assert.Equal(t, "if type -q bash; bash /opt/hook.sh; else; /opt/hook.sh; end", Fish.RunScript("/opt/hook.sh"))
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// Scripts written for bash still run with it, even if they are not executable.
+	script := filepath.Join(t.TempDir(), "hook.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("[[ -n \"$BASH_VERSION\" ]] && echo bash\n"), 0600))
+	output, err := exec.Command("/bin/sh", "-c", POSIXShell.RunScript(script)).CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "bash\n", string(output))
+
+	// Without bash, the shebang decides.
+	script = filepath.Join(t.TempDir(), "hook.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho shebang\n"), 0700))
+	cmd := exec.Command("/bin/sh", "-c", POSIXShell.RunScript(script))
+	cmd.Env = []string{"PATH=" + t.TempDir()}
+	output, err = cmd.CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "shebang\n", string(output))
+}
+
+func Test__Shell__NewShellFor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		_, err := NewShellFor("sh", os.TempDir())
+		assert.ErrorContains(t, err, "cannot be changed on Windows")
+		return
+	}
+
+	shell, err := NewShellFor("sh", os.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, POSIXShell, shell.Dialect)
+	assert.Equal(t, []string{"-l"}, shell.Args)
+	assert.True(t, filepath.IsAbs(shell.Executable))
+
+	_, err = NewShellFor("tcsh", os.TempDir())
+	assert.ErrorContains(t, err, "unsupported shell 'tcsh'")
+
+	_, err = NewShellFor("/does/not/exist/zsh", os.TempDir())
+	assert.ErrorContains(t, err, "shell '/does/not/exist/zsh' not found")
+}
+
+func Test__Shell__POSIXDialect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	shell, err := NewShellFor("sh", t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, shell.Start())
+	defer shell.Close()
+
+	env := &Environment{}
+	env.Set("GREETING", "hello 'sh'")
+	envFile := filepath.Join(shell.StoragePath, ".env")
+	assert.NoError(t, env.ToFileFor(shell.Dialect, envFile, nil))
+
+	var output bytes.Buffer
+	p := shell.NewProcessWithOutput(shell.Dialect.Source(envFile), func(s string) { output.WriteString(s) })
+	p.Run()
+	assert.Equal(t, 0, p.ExitCode)
+
+	output.Reset()
+	p = shell.NewProcessWithOutput("echo $GREETING; false", func(s string) { output.WriteString(s) })
+	p.Run()
+	assert.Equal(t, 1, p.ExitCode)
+	assert.Equal(t, "hello 'sh'\n", output.String())
+
+	// Multiline commands can be sent without a file, too.
+	output.Reset()
+	p = shell.NewProcessWithConfig(Config{
+		Shell:             shell,
+		StoragePath:       shell.StoragePath,
+		Command:           "greeting='base64'\necho \"$greeting\"\n(exit 3)",
+		OnOutput:          func(s string) { output.WriteString(s) },
+		UseBase64Encoding: true,
+	})
+
+	p.Run()
+	assert.Equal(t, 3, p.ExitCode)
+	assert.Equal(t, "base64\n", output.String())
+}
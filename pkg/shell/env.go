@@ -62,6 +62,40 @@ func CreateEnvironmentFromFile(fileName string) (*Environment, error) {
 	return &environment, nil
 }
 
+/*
+ * Create an environment by reading a file created with `env -0`,
+ * by the commands run without a PTY in Linux. Since the values can have newlines,
+ * the variables are separated by NUL characters.
+ */
+func CreateEnvironmentFromDump(fileName string) (*Environment, error) {
+	// #nosec
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	environment := EnvironmentFromSlice(strings.Split(string(bytes), "\x00"))
+
+	// Set by the shell itself, for every process.
+	environment.Remove("_")
+	environment.Remove("SHLVL")
+
+	return environment, nil
+}
+
+// From NAME=value entries, like the ones from os.Environ().
+func EnvironmentFromSlice(entries []string) *Environment {
+	environment := Environment{env: map[string]string{}}
+	for _, entry := range entries {
+		nameAndValue := strings.SplitN(entry, "=", 2)
+		if len(nameAndValue) == 2 && nameAndValue[0] != "" {
+			environment.Set(nameAndValue[0], nameAndValue[1])
+		}
+	}
+
+	return &environment
+}
+
 func (e *Environment) Set(name, value string) {
 	if e.env == nil {
 		e.env = map[string]string{}
@@ -123,13 +157,18 @@ func (e *Environment) ToCommands() []string {
 }
 
 func (e *Environment) ToFile(fileName string, callback func(name string)) error {
+	return e.ToFileFor(Bash, fileName, callback)
+}
+
+// On Windows, the file is always a PowerShell script.
+func (e *Environment) ToFileFor(dialect Dialect, fileName string, callback func(name string)) error {
 	fileContent := ""
 	for _, name := range e.Keys() {
 		value, _ := e.Get(name)
 		if runtime.GOOS == "windows" {
 			fileContent += fmt.Sprintf("$env:%s = \"%s\"\n", name, escapePowershellQuotes(value))
 		} else {
-			fileContent += fmt.Sprintf("export %s=%s\n", name, shellQuote(value))
+			fileContent += dialect.Export(name, value) + "\n"
 		}
 
 		if callback != nil {
@@ -0,0 +1,124 @@
+package shell
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ * A random nonce the shell prints around a command, so we know where its output is in the PTY output.
+ * The start fence is in the line typed into the shell, so a command can print it too,
+ * but that is only output, since the scanner looks for it before the command runs.
+ * The end fence is only created once the command reported its exit code through the status channel,
+ * and given to the shell through the end channel, so the command can't know it. See channel.go.
+ */
+func newFence() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		// Not as strong, but still unique per process.
+		return fmt.Sprintf("fence-%d", time.Now().UnixNano())
+	}
+
+	return "fence-" + hex.EncodeToString(nonce)
+}
+
+/*
+ * Separates the command output from what the shell prints around it.
+ * Everything before the start fence is dropped, and everything after it is output,
+ * until the end fence, which is only known once the command is done.
+ * From then on, a possible beginning of the end fence at the end of the data
+ * is held back until we know if it is the fence or not.
+ *
+ * The data is fed by the goroutine reading the PTY, and the end fence is set
+ * by the one waiting for the exit code, so the scanner can be used by both.
+ */
+type fenceScanner struct {
+	start []byte
+	end   []byte
+
+	buffer   []byte
+	started  bool
+	finished bool
+	mu       sync.Mutex
+}
+
+func newFenceScanner(start string) *fenceScanner {
+	return &fenceScanner{
+		start: []byte(start + "\r\n"),
+	}
+}
+
+// The end fence must be set before the shell can print it.
+func (s *fenceScanner) SetEnd(end string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.end = []byte(end)
+}
+
+// Returns the command output found in the data, if any.
+func (s *fenceScanner) Feed(data []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return nil
+	}
+
+	s.buffer = append(s.buffer, data...)
+
+	if !s.started {
+		index := bytes.Index(s.buffer, s.start)
+		if index < 0 {
+			s.buffer = s.buffer[len(s.buffer)-heldBackLength(s.buffer, s.start):]
+			return nil
+		}
+
+		s.buffer = s.buffer[index+len(s.start):]
+		s.started = true
+	}
+
+	if s.end == nil {
+		return s.cut(len(s.buffer))
+	}
+
+	index := bytes.Index(s.buffer, s.end)
+	if index < 0 {
+		return s.cut(len(s.buffer) - heldBackLength(s.buffer, s.end))
+	}
+
+	// What the shell prints after the end fence is not output.
+	output := s.cut(index)
+	s.buffer = nil
+	s.finished = true
+	return output
+}
+
+func (s *fenceScanner) Finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.finished
+}
+
+func (s *fenceScanner) cut(index int) []byte {
+	output := make([]byte, index)
+	copy(output, s.buffer[:index])
+	s.buffer = s.buffer[index:]
+	return output
+}
+
+// The length of the longest suffix of the data which is a beginning of the fence.
+func heldBackLength(data, fence []byte) int {
+	for length := min(len(data), len(fence)-1); length > 0; length-- {
+		if bytes.HasPrefix(fence, data[len(data)-length:]) {
+			return length
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,104 @@
+package shell
+
+import (
+	"bytes"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test__NewFence(t *testing.T) {
+	start := newFence()
+	end := newFence()
+	assert.NotEqual(t, start, end)
+	assert.Regexp(t, `^fence-[0-9a-f]{32}$`, start)
+}
+
+func Test__FenceScanner(t *testing.T) {
+	start := newFence()
+	output := "\001 949556c7-1700000000-end 0\r\n" +
+		"fence-0123 is not the fence\r\n" +
+		start + "\r\n"
+	stream := "stty -echo\r\n" + start + "\r\n" + output
+
+	// The exit code can be received at any point after the command printed its output,
+	// and before the shell prints the end fence.
+	for i := 0; i <= len(stream); i++ {
+		scanner := newFenceScanner(start)
+		result := string(scanner.Feed([]byte(stream[:i])))
+		assert.False(t, scanner.Finished())
+
+		end := newFence()
+		scanner.SetEnd(end)
+		result += string(scanner.Feed([]byte(stream[i:] + end + "\r\nprompt $ ")))
+
+		assert.Equal(t, output, result)
+		assert.True(t, scanner.Finished())
+		assert.Empty(t, scanner.Feed([]byte("more")))
+	}
+}
+
+func Test__FenceScanner__HoldsBackTheBeginningOfTheEndFence(t *testing.T) {
+	scanner := newFenceScanner("start")
+	assert.Equal(t, "hello\r\nen", string(scanner.Feed([]byte("start\r\nhello\r\nen"))))
+
+	scanner.SetEnd("end")
+	assert.Equal(t, "d\r\n", string(scanner.Feed([]byte("d\r\nen"))))
+	assert.Equal(t, "enx", string(scanner.Feed([]byte("x"))))
+	assert.Equal(t, "", string(scanner.Feed([]byte("en"))))
+	assert.False(t, scanner.Finished())
+	assert.Equal(t, "", string(scanner.Feed([]byte("d\r\n"))))
+	assert.True(t, scanner.Finished())
+}
+
+func FuzzFenceScanner(f *testing.F) {
+	f.Add([]byte("login banner"), []byte("hello\r\n"), uint16(3), uint8(1))
+	f.Add([]byte(""), []byte("\001 949556c7-1700000000-end 0\r\n"), uint16(0), uint8(3))
+	f.Add([]byte("\001\001"), []byte("fence-\001fence-fence-0123"), uint16(12), uint8(7))
+	f.Add([]byte("fence-"), []byte("\r\n\r\n\x00\xff"), uint16(500), uint8(0))
+
+	start := newFence()
+	previousEnd := newFence()
+
+	f.Fuzz(func(t *testing.T, before, output []byte, statusAt uint16, chunkSize uint8) {
+		// Only the shell prints before the start fence, and it doesn't print the fence.
+		if bytes.Contains(before, []byte(start)) {
+			t.Skip()
+		}
+
+		// The command knows its start fence, and the end fence of the previous command.
+		middle := len(output) / 2
+		output = append(append(append([]byte{}, output[:middle]...), start+"\r\n"+previousEnd+" 0\r\n"...), output[middle:]...)
+
+		var stream []byte
+		stream = append(stream, before...)
+		stream = append(stream, start+"\r\n"...)
+		stream = append(stream, output...)
+
+		scanner := newFenceScanner(start)
+		size := int(chunkSize%16) + 1
+		feed := func(data []byte) []byte {
+			var result []byte
+			for i := 0; i < len(data); i += size {
+				result = append(result, scanner.Feed(data[i:min(i+size, len(data))])...)
+			}
+
+			return result
+		}
+
+		// The end fence is created once the command is done, so it is never in its output.
+		split := int(statusAt) % (len(stream) + 1)
+		result := feed(stream[:split])
+		end := newFence()
+		scanner.SetEnd(end)
+		result = append(result, feed(append(append([]byte{}, stream[split:]...), end+"\r\n"+string(before)...))...)
+
+		if !bytes.Equal(output, result) {
+			t.Fatalf("expected output %q, got %q", output, result)
+		}
+
+		if !scanner.Finished() {
+			t.Fatalf("expected the scanner to find the end fence")
+		}
+	})
+}
@@ -180,6 +180,28 @@ func (b *OutputBuffer) flush() {
 		}
 	}
 
+	b.flushTill(cutLength)
+}
+
+/*
+ * Flushes everything in the buffer right away, no matter how recent it is,
+ * except for an incomplete UTF-8 sequence at the end, which is still coming.
+ */
+func (b *OutputBuffer) FlushNow() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cutLength := len(b.bytes)
+	for i := 0; i < 4 && cutLength > 0 && !utf8.Valid(b.bytes[0:cutLength]); i++ {
+		cutLength--
+	}
+
+	if cutLength > 0 {
+		b.flushTill(cutLength)
+	}
+}
+
+func (b *OutputBuffer) flushTill(cutLength int) {
 	bytes := make([]byte, cutLength)
 	copy(bytes, b.bytes[0:cutLength])
 	b.bytes = b.bytes[cutLength:]
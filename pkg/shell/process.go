@@ -8,10 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -34,12 +33,24 @@ Get-ChildItem Env: | Foreach-Object {"$($_.Name)=$($_.Value)"} | Set-Content "%s
 exit $Env:SEMAPHORE_AGENT_CURRENT_CMD_EXIT_STATUS
 `
 
+// Set by the commands without a PTY, to keep track of the working directory.
+const CurrentDirEnvVar = "SEMAPHORE_AGENT_CURRENT_DIR"
+
+// The streams given to OnStreamOutput.
+// With a PTY, stdout and stderr are the same.
+const StreamStdout = "stdout"
+const StreamStderr = "stderr"
+
 type Config struct {
 	Shell             *Shell
 	StoragePath       string
 	Command           string
 	OnOutput          func(string)
 	UseBase64Encoding bool
+
+	// Only used when the shell uses pipes. Without it, both streams go to OnOutput.
+	// The stream is StreamStdout or StreamStderr.
+	OnStreamOutput func(stream string, output string)
 }
 
 type Process struct {
@@ -50,34 +61,31 @@ type Process struct {
 	FinishedAt        int
 	ExitCode          int
 	Pid               int
-	startMark         string
-	endMark           string
-	commandEndRegex   *regexp.Regexp
+	startFence        string
 	inputBuffer       []byte
 	outputBuffer      *OutputBuffer
+	errorBuffer       *OutputBuffer
+	streamMu          sync.Mutex
 	SysProcAttr       *syscall.SysProcAttr
 	UseBase64Encoding bool
 }
 
-func randomMagicMark() string {
-	return fmt.Sprintf("949556c7-%d", time.Now().Unix())
-}
-
 func NewProcess(config Config) *Process {
-	startMark := randomMagicMark() + "-start"
-	endMark := randomMagicMark() + "-end"
-	commandEndRegex := regexp.MustCompile(endMark + " " + `(\d+)` + "[\r\n]+")
-	outputBuffer, _ := NewOutputBuffer(config.OnOutput)
+	var outputBuffer, errorBuffer *OutputBuffer
+	if config.Shell != nil && config.Shell.UsePipes {
+		outputBuffer, errorBuffer = newStreamBuffers(config)
+	} else {
+		outputBuffer, _ = NewOutputBuffer(config.OnOutput)
+	}
 
 	return &Process{
 		Shell:             config.Shell,
 		StoragePath:       config.StoragePath,
 		Command:           config.Command,
 		ExitCode:          1,
-		startMark:         startMark,
-		endMark:           endMark,
-		commandEndRegex:   commandEndRegex,
+		startFence:        newFence(),
 		outputBuffer:      outputBuffer,
+		errorBuffer:       errorBuffer,
 		UseBase64Encoding: config.UseBase64Encoding,
 	}
 }
@@ -112,6 +120,12 @@ func (p *Process) Run() {
 		return
 	}
 
+	usePTY := runtime.GOOS != "windows" && !p.Shell.UsePipes
+	if usePTY && p.Shell.channel == nil {
+		log.Errorf("The shell has no status channel - was it started?")
+		return
+	}
+
 	instruction := p.constructShellInstruction()
 	p.StartedAt = int(time.Now().Unix())
 	defer func() {
@@ -120,23 +134,30 @@ func (p *Process) Run() {
 
 	/*
 	 * If the agent is running in an non-windows environment,
-	 * we use a PTY session to run commands.
+	 * we use a PTY session to run commands, unless the shell uses pipes.
 	 */
-	if runtime.GOOS != "windows" {
+	if usePTY {
 		p.runWithPTY(instruction)
 		return
 	}
 
-	// In windows, so no PTY support.
-	p.setup()
-	p.runWithoutPTY(instruction)
-
 	/*
 	 * If we are not using a PTY, we need to keep track of shell "state" ourselves.
 	 * We use a file with all the environment variables available after the command
 	 * is executed. From that file, we can update our shell "state".
 	 */
-	after, err := CreateEnvironmentFromFile(p.EnvironmentFilePath())
+	var after *Environment
+	if p.Shell.UsePipes {
+		_ = os.Remove(p.EnvironmentFilePath())
+		p.runWithPipes(instruction)
+		after, err = CreateEnvironmentFromDump(p.EnvironmentFilePath())
+	} else {
+		// In windows, so no PTY support.
+		p.setup()
+		p.runWithoutPTY(instruction)
+		after, err = CreateEnvironmentFromFile(p.EnvironmentFilePath())
+	}
+
 	if err != nil {
 		log.Errorf("Error creating environment from file %s: %v\n", p.EnvironmentFilePath(), err)
 		return
@@ -147,7 +168,7 @@ func (p *Process) Run() {
 	 * so we use a custom one to get the current working directory
 	 * after a command is executed.
 	 */
-	newCwd, exists := after.Get("SEMAPHORE_AGENT_CURRENT_DIR")
+	newCwd, exists := after.Get(CurrentDirEnvVar)
 	if exists {
 		p.Shell.Chdir(newCwd)
 	}
@@ -157,7 +178,7 @@ func (p *Process) Run() {
 	 * things we need, but we don't want to mess the environment
 	 * so we remove them before updating our shell state.
 	 */
-	after.Remove("SEMAPHORE_AGENT_CURRENT_DIR")
+	after.Remove(CurrentDirEnvVar)
 	after.Remove("SEMAPHORE_AGENT_CURRENT_CMD_EXIT_STATUS")
 	p.Shell.UpdateEnvironment(after)
 }
@@ -205,12 +226,15 @@ func (p *Process) runWithoutPTY(instruction string) {
 	log.Debug("Waiting for reading to finish")
 	<-done
 
+	p.ExitCode = exitCodeFrom(waitResult)
+}
+
+func exitCodeFrom(waitResult error) int {
 	/*
 	 * The command was successful, so we just return.
 	 */
 	if waitResult == nil {
-		p.ExitCode = 0
-		return
+		return 0
 	}
 
 	/*
@@ -219,15 +243,15 @@ func (p *Process) runWithoutPTY(instruction string) {
 	 */
 	if err, ok := waitResult.(*exec.ExitError); ok {
 		if s, ok := err.Sys().(syscall.WaitStatus); ok {
-			p.ExitCode = s.ExitStatus()
-		} else {
-			log.Errorf("Could not cast *exec.ExitError to syscall.WaitStatus: %v\n", err)
-			p.ExitCode = 1
+			return s.ExitStatus()
 		}
-	} else {
-		log.Errorf("Unexpected %T returned after Wait(): %v", waitResult, waitResult)
-		p.ExitCode = 1
+
+		log.Errorf("Could not cast *exec.ExitError to syscall.WaitStatus: %v\n", err)
+		return 1
 	}
+
+	log.Errorf("Unexpected %T returned after Wait(): %v", waitResult, waitResult)
+	return 1
 }
 
 func (p *Process) buildNonPTYCommand(instruction string) (*exec.Cmd, *io.PipeReader, *io.PipeWriter) {
@@ -268,35 +292,25 @@ func (p *Process) constructShellInstruction() string {
 	 */
 	if p.UseBase64Encoding {
 		base64EncodedCommand := base64.StdEncoding.EncodeToString([]byte(p.Command))
-		return fmt.Sprintf(
-			`echo -e "\001 %s"; source <(echo %s | base64 -d); AGENT_CMD_RESULT=$?; echo -e "\001 %s $AGENT_CMD_RESULT"; echo "exit $AGENT_CMD_RESULT" | sh`,
-			p.startMark,
-			base64EncodedCommand,
-			p.endMark,
-		)
+		return p.Shell.Dialect.WrapBase64(p.startFence, base64EncodedCommand, p.Shell.channel.StatusPath, p.Shell.channel.EndPath)
 	}
 
 	if runtime.GOOS == "windows" {
 		return fmt.Sprintf(`%s.ps1`, p.CmdFilePath())
 	}
 
-	//
-	// A process is sending a complex instruction to the shell. The instruction
-	// does the following:
-	//
-	//   1. display magic-header and the START marker
-	//   2. execute the command file by sourcing it
-	//   3. save the original exit status
-	//   4. display magic-header, the end marker, and the command's exit status
-	//   5. return the original exit status to the caller
-	//
-	template := `echo -e "\001 %s"; source %s; AGENT_CMD_RESULT=$?; echo -e "\001 %s $AGENT_CMD_RESULT"; echo "exit $AGENT_CMD_RESULT" | sh`
+	// The script is run by a new shell process.
+	if p.Shell.UsePipes {
+		return p.CmdFilePath()
+	}
 
-	return fmt.Sprintf(template, p.startMark, p.CmdFilePath(), p.endMark)
+	// The dialect of the shell knows how to source the command file,
+	// and how to print the fences and report the exit code around it.
+	return p.Shell.Dialect.Wrap(p.startFence, p.CmdFilePath(), p.Shell.channel.StatusPath, p.Shell.channel.EndPath)
 }
 
 /*
- * Multiline commands don't work very well with the start/finish marker.
+ * Multiline commands don't work very well with the start/end fence
  * scheme. To circumvent this, we are storing the command in a file.
  */
 func (p *Process) loadCommand() error {
@@ -307,6 +321,10 @@ func (p *Process) loadCommand() error {
 		return nil
 	}
 
+	if p.Shell.UsePipes {
+		return p.writeCommandToFile(p.CmdFilePath(), p.Shell.Dialect.PipeScript(p.Command, p.EnvironmentFilePath()))
+	}
+
 	if runtime.GOOS != "windows" {
 		return p.writeCommandToFile(p.CmdFilePath(), p.Command)
 	}
@@ -385,104 +403,37 @@ func (p *Process) readNonPTY(reader *io.PipeReader, done chan bool) {
 	done <- true
 }
 
-// Read state from shell into the inputBuffer
-func (p *Process) read() error {
+// Reads the next chunk of output from the shell.
+func (p *Process) read() ([]byte, error) {
 	buffer := make([]byte, p.readBufferSize())
 
 	log.Debug("Reading started")
 	n, err := p.Shell.Read(&buffer)
 	if err != nil {
 		log.Errorf("Error while reading from the tty. Error: '%s'.", err.Error())
-		return err
-	}
-
-	p.inputBuffer = append(p.inputBuffer, buffer[0:n]...)
-	log.Debugf("reading data (%d bytes) from shell. Input buffer: %#v", n, string(p.inputBuffer))
-
-	return nil
-}
-
-func (p *Process) waitForStartMarker() error {
-	log.Debugf("Waiting for start marker %s", p.startMark)
-
-	//
-	// Fill the output buffer, until the start marker appears
-	//
-	for {
-		err := p.read()
-		if err != nil {
-			return err
-		}
-
-		//
-		// If the inputBuffer has a start marker, the wait is done
-		//
-		index := strings.Index(string(p.inputBuffer), p.startMark+"\r\n")
-
-		if index >= 0 {
-			//
-			// Cut everything from the buffer before the marker
-			// Example:
-			//
-			// buffer before: some test <***marker**> rest of the test
-			// buffer after :  rest of the test
-			//
-
-			p.inputBuffer = p.inputBuffer[index+len(p.startMark)+2 : len(p.inputBuffer)]
-
-			break
-		}
+		return nil, err
 	}
 
-	log.Debugf("Start marker found %s", p.startMark)
-
-	return nil
-}
-
-func (p *Process) endMarkerHeaderIndex() int {
-	return strings.Index(string(p.inputBuffer), "\001")
+	log.Debugf("reading data (%d bytes) from shell: %#v", n, string(buffer[0:n]))
+	return buffer[0:n], nil
 }
 
+/*
+ * Only what the shell prints between the fences is command output.
+ * The exit code comes through the status channel, and only then the end fence is created,
+ * and sent to the shell, so nothing the command prints can end its output, or be parsed.
+ */
 func (p *Process) scan() error {
 	log.Debug("Scan started")
 
-	err := p.waitForStartMarker()
-	if err != nil {
-		return err
-	}
-
-	exitCode := ""
-
-	for {
-		if index := p.endMarkerHeaderIndex(); index >= 0 {
-			if index > 0 {
-				// publish everything until the end mark
-				p.flushInputBufferTill(index)
-			}
-
-			log.Debug("Start of end marker detected, entering buffering mode.")
-
-			if match := p.commandEndRegex.FindStringSubmatch(string(p.inputBuffer)); len(match) == 2 {
-				log.Debug("End marker detected. Exit code: ", match[1])
-
-				exitCode = match[1]
-				break
-			}
-
-			//
-			// The buffer is much longer than the end mark, at least by 10
-			// characters.
-			//
-			// If it is not matching the full end mark, it is safe to dump.
-			//
-			if len(p.inputBuffer) >= len(p.endMark)+10 {
-				p.flushInputAll()
-			}
-		} else {
-			p.flushInputAll()
-		}
+	scanner := newFenceScanner(p.startFence)
+	statuses := make(chan string, 1)
+	abandoned := make(chan struct{})
+	defer close(abandoned)
+	go p.waitForStatus(scanner, statuses, abandoned)
 
-		err := p.read()
+	for !scanner.Finished() {
+		data, err := p.read()
 		if err != nil {
 			// Reading failed. The most likely cause is that the bash process
 			// died. For example, running an `exit 1` command has killed it.
@@ -493,6 +444,10 @@ func (p *Process) scan() error {
 
 			return err
 		}
+
+		if output := scanner.Feed(data); len(output) > 0 {
+			p.outputBuffer.Append(output)
+		}
 	}
 
 	if err := p.outputBuffer.Close(); err != nil {
@@ -500,9 +455,9 @@ func (p *Process) scan() error {
 	}
 
 	log.Debug("Command output finished")
-	log.Debugf("Parsing exit code %s", exitCode)
 
-	code, err := strconv.Atoi(exitCode)
+	// The status is received before the end fence exists, so it is already here.
+	code, err := strconv.Atoi(<-statuses)
 	if err != nil {
 		log.Errorf("Error while parsing exit code, err: %v", err)
 
@@ -514,3 +469,19 @@ func (p *Process) scan() error {
 
 	return nil
 }
+
+// The end fence is only set once the exit code is received, and before the shell gets it.
+func (p *Process) waitForStatus(scanner *fenceScanner, statuses chan string, abandoned chan struct{}) {
+	status, ok := p.Shell.channel.WaitForStatus(abandoned)
+	if !ok {
+		return
+	}
+
+	statuses <- status
+	endFence := newFence()
+	scanner.SetEnd(endFence)
+
+	if err := p.Shell.channel.SendEnd(endFence); err != nil {
+		log.Errorf("Error sending the end fence: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package shell
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Processes started in the background by a command may keep the pipes open,
+// so we only wait this long for the rest of the output after the command exits.
+const PipeOutputTimeout = 100 * time.Millisecond
+
+// Each buffer flushes on its own, so the consumers are called one at a time.
+func newStreamBuffers(config Config) (*OutputBuffer, *OutputBuffer) {
+	var mu sync.Mutex
+	consumer := func(stream string) func(string) {
+		return func(output string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if config.OnStreamOutput != nil {
+				config.OnStreamOutput(stream, output)
+			} else if config.OnOutput != nil {
+				config.OnOutput(output)
+			}
+		}
+	}
+
+	outputBuffer, _ := NewOutputBuffer(consumer(StreamStdout))
+	errorBuffer, _ := NewOutputBuffer(consumer(StreamStderr))
+	return outputBuffer, errorBuffer
+}
+
+/*
+ * Runs the command in a new shell process, with stdout and stderr in separate pipes,
+ * so the output of each one can be told apart. Since nothing survives the process,
+ * the environment and the working directory are carried over to the next command
+ * through the dump file written by the script. See Dialect.PipeScript.
+ */
+func (p *Process) runWithPipes(instruction string) {
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		log.Errorf("Error creating stdout pipe: %v", err)
+		p.ExitCode = 1
+		return
+	}
+
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		log.Errorf("Error creating stderr pipe: %v", err)
+		_ = stdoutReader.Close()
+		_ = stdoutWriter.Close()
+		p.ExitCode = 1
+		return
+	}
+
+	// #nosec
+	cmd := exec.Command(p.Shell.Executable, instruction)
+	cmd.Dir = p.Shell.Cwd
+	cmd.Env = p.Shell.Env.ToSlice()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	err = cmd.Start()
+
+	// The command has its own copies of the write ends now.
+	_ = stdoutWriter.Close()
+	_ = stderrWriter.Close()
+
+	if err != nil {
+		log.Errorf("Error starting command: %v", err)
+		_ = stdoutReader.Close()
+		_ = stderrReader.Close()
+		p.ExitCode = 1
+		return
+	}
+
+	p.Pid = cmd.Process.Pid
+	p.Shell.setAgentProcess(p.Pid, true)
+	defer p.Shell.setAgentProcess(p.Pid, false)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go p.readPipe(stdoutReader, p.outputBuffer, p.errorBuffer, &wg)
+	go p.readPipe(stderrReader, p.errorBuffer, p.outputBuffer, &wg)
+
+	p.ExitCode = exitCodeFrom(cmd.Wait())
+
+	_ = stdoutReader.SetReadDeadline(time.Now().Add(PipeOutputTimeout))
+	_ = stderrReader.SetReadDeadline(time.Now().Add(PipeOutputTimeout))
+	wg.Wait()
+
+	_ = stdoutReader.Close()
+	_ = stderrReader.Close()
+
+	if err := p.outputBuffer.Close(); err != nil {
+		log.Error("Could not flush all the stdout output in the buffer")
+	}
+
+	if err := p.errorBuffer.Close(); err != nil {
+		log.Error("Could not flush all the stderr output in the buffer")
+	}
+}
+
+func (p *Process) readPipe(reader *os.File, buffer, otherBuffer *OutputBuffer, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		data := make([]byte, p.readBufferSize())
+		n, err := reader.Read(data)
+		if n > 0 {
+			p.appendStreamOutput(buffer, otherBuffer, data[:n])
+		}
+
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Errorf("Error reading command output: %v", err)
+			}
+
+			return
+		}
+	}
+}
+
+// The output of the other stream which came in before is flushed first, to keep the order.
+func (p *Process) appendStreamOutput(buffer, otherBuffer *OutputBuffer, data []byte) {
+	p.streamMu.Lock()
+	defer p.streamMu.Unlock()
+
+	otherBuffer.FlushNow()
+	buffer.Append(data)
+}
@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+)
+
+type streamOutput struct {
+	mu      sync.Mutex
+	outputs []string
+}
+
+func (o *streamOutput) consume(stream, output string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Consecutive output from the same stream is merged, since the chunks depend on timing.
+	if len(o.outputs) > 0 && strings.HasPrefix(o.outputs[len(o.outputs)-1], stream+": ") {
+		o.outputs[len(o.outputs)-1] += output
+		return
+	}
+
+	o.outputs = append(o.outputs, stream+": "+output)
+}
+
+func runWithPipes(t *testing.T, shell *Shell, command string) (int, []string) {
+	output := &streamOutput{}
+	p := shell.NewProcessWithConfig(Config{
+		Shell:          shell,
+		StoragePath:    shell.StoragePath,
+		Command:        command,
+		OnStreamOutput: output.consume,
+	})
+
+	p.Run()
+	return p.ExitCode, output.outputs
+}
+
+func Test__Shell__UsePipes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("separate output streams are only supported on Linux")
+	}
+
+	shell, err := NewShell(t.TempDir())
+	assert.NoError(t, err)
+	shell.UsePipes = true
+	assert.NoError(t, shell.Start())
+	defer shell.Close()
+
+	homeDir, _ := os.UserHomeDir()
+	assert.Equal(t, homeDir, shell.Cwd)
+
+	exitCode, outputs := runWithPipes(t, shell, "echo out; sleep 0.2; echo err >&2; sleep 0.2; echo out again; cd /; exit 3")
+	assert.Equal(t, 3, exitCode)
+	assert.Equal(t, "/", shell.Cwd)
+	assert.Equal(t, []string{
+		StreamStdout + ": out\n",
+		StreamStderr + ": err\n",
+		StreamStdout + ": out again\n",
+	}, outputs)
+
+	// The environment and the working directory carry over to the next commands.
+	exitCode, _ = runWithPipes(t, shell, "export MULTILINE=\"a\nb\"\nunset HOME\ncd /tmp")
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, "/tmp", shell.Cwd)
+
+	exitCode, outputs = runWithPipes(t, shell, "echo \"$MULTILINE\"; echo \"home=$HOME\"; pwd")
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{StreamStdout + ": a\nb\nhome=\n/tmp\n"}, outputs)
+
+	_, exists := shell.Env.Get(CurrentDirEnvVar)
+	assert.False(t, exists)
+}
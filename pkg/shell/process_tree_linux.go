@@ -0,0 +1,224 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+var subreaperOnce sync.Once
+
+// How often the job processes are looked for while the job runs.
+const orphanReapingInterval = time.Second
+
+/*
+ * Processes that escape the bash session with setsid, or by double-forking,
+ * are reparented to the closest subreaper when their parent exits.
+ * Making the agent a subreaper keeps them in the agent's process tree,
+ * instead of being adopted by init, where we would lose track of them.
+ */
+func becomeSubreaper() {
+	subreaperOnce.Do(func() {
+		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			log.Warnf("Error making the agent a child subreaper: %v", err)
+		}
+	})
+}
+
+/*
+ * Finds all the processes started by the job. These are the processes
+ * in the agent's process tree which are either descendants of the bash session,
+ * or carry the process marker in their environment, meaning they escaped the session.
+ *
+ * The processes we cannot read the environment from run as another user, e.g. with sudo,
+ * so they are only job processes if we found them before, while they were still in the session.
+ * Zombies have no environment anymore either, so we remember the processes we found.
+ */
+func (s *Shell) jobProcesses() []jobProcess {
+	processes, err := readProcesses()
+	if err != nil {
+		log.Errorf("Error listing job processes: %v", err)
+		return nil
+	}
+
+	return s.findJobProcesses(processes)
+}
+
+func (s *Shell) findJobProcesses(processes []jobProcess) []jobProcess {
+	s.processesMu.Lock()
+	defer s.processesMu.Unlock()
+
+	// Without a PTY, there is no bash session, so the processes are only found by their marker.
+	shellPID := -1
+	if s.BootCommand != nil && s.BootCommand.Process != nil {
+		shellPID = s.BootCommand.Process.Pid
+	} else if !s.UsePipes {
+		return nil
+	}
+
+	children := map[int][]jobProcess{}
+	for _, process := range processes {
+		children[process.ppid] = append(children[process.ppid], process)
+	}
+
+	result := []jobProcess{}
+
+	type entry struct {
+		pid         int
+		insideShell bool
+	}
+
+	queue := []entry{{pid: os.Getpid()}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, child := range children[current.pid] {
+			insideShell := current.insideShell || child.pid == shellPID
+			queue = append(queue, entry{pid: child.pid, insideShell: insideShell})
+
+			if child.pid == shellPID {
+				continue
+			}
+
+			if insideShell || s.knownJobProcesses[child.pid] || s.hasProcessMarker(child.pid) {
+				s.knownJobProcesses[child.pid] = true
+				result = append(result, child)
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *Shell) hasProcessMarker(pid int) bool {
+	// #nosec
+	environ, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return false
+	}
+
+	marker := []byte(ProcessMarkerEnvVar + "=" + s.processMarker)
+	for _, variable := range bytes.Split(environ, []byte{0}) {
+		if bytes.Equal(variable, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Orphaned job processes are adopted by the agent, since it is a subreaper,
+ * but nothing else waits for them, so we reap them here, to avoid leaving zombies behind.
+ * The bash session is not included, and neither are the processes the agent started itself,
+ * since their exec.Cmd already waits for them.
+ */
+func (s *Shell) reapAdoptedProcesses(processes []jobProcess) {
+	s.processesMu.Lock()
+	defer s.processesMu.Unlock()
+
+	agentPID := os.Getpid()
+	for _, process := range processes {
+		if !process.zombie || process.ppid != agentPID || s.agentProcesses[process.pid] {
+			continue
+		}
+
+		var status syscall.WaitStatus
+		_, _ = syscall.Wait4(process.pid, &status, syscall.WNOHANG, nil)
+	}
+}
+
+/*
+ * Looks for the job processes while the job runs, so the ones which leave the session
+ * are remembered, and reaps the job processes the agent adopts, instead of leaving them
+ * as zombies until the job is over. The processes the agent starts itself are never
+ * reaped here, since their exec.Cmd waits for them. Orphans which exit before we find them
+ * are not known to be job processes, so they are only reaped when the job is stopped.
+ */
+func (s *Shell) reapOrphans() {
+	ticker := time.NewTicker(orphanReapingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		processes, err := readProcesses()
+		if err != nil {
+			log.Debugf("Error listing job processes: %v", err)
+			continue
+		}
+
+		s.reapAdoptedProcesses(s.findJobProcesses(processes))
+	}
+}
+
+func readProcesses() ([]jobProcess, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("error reading /proc: %v", err)
+	}
+
+	processes := []jobProcess{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		// Processes can exit while we iterate, so we ignore errors here.
+		// #nosec
+		content, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+
+		process, err := parseJobProcess(pid, string(content))
+		if err != nil {
+			continue
+		}
+
+		processes = append(processes, *process)
+	}
+
+	return processes, nil
+}
+
+// See proc(5) for the format of /proc/<pid>/stat.
+func parseJobProcess(pid int, content string) (*jobProcess, error) {
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("bad stat format for process %d", pid)
+	}
+
+	// fields[0] is the state, which is field 3 in proc(5)
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("bad stat format for process %d", pid)
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+	pgid, _ := strconv.Atoi(fields[2])
+
+	return &jobProcess{
+		pid:    pid,
+		ppid:   ppid,
+		pgid:   pgid,
+		name:   content[start+1 : end],
+		zombie: fields[0] == "Z",
+	}, nil
+}
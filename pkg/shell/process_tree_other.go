@@ -0,0 +1,17 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package shell
+
+// Finding the processes that escaped the bash session relies on /proc,
+// so, outside of Linux, we only close the TTY and kill the bash session.
+
+func becomeSubreaper() {}
+
+func (s *Shell) jobProcesses() []jobProcess {
+	return nil
+}
+
+func (s *Shell) reapAdoptedProcesses(processes []jobProcess) {}
+
+func (s *Shell) reapOrphans() {}
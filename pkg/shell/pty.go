@@ -12,3 +12,9 @@ import (
 func StartPTY(command *exec.Cmd) (*os.File, error) {
 	return pty.Start(command)
 }
+
+// The size is set with pty.Setsize before the command starts,
+// so it never sees the default size.
+func StartPTYWithSize(command *exec.Cmd, columns, rows uint16) (*os.File, error) {
+	return pty.StartWithSize(command, &pty.Winsize{Cols: columns, Rows: rows})
+}
@@ -1,11 +1,14 @@
 package shell
 
 import (
+	"io"
 	"os/exec"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test__PTYIsNotSupportedOnWindows(t *testing.T) {
@@ -26,3 +29,25 @@ func Test__PTYIsSupportedOnNonWindows(t *testing.T) {
 	assert.Nil(t, err)
 	tty.Close()
 }
+
+func Test__PTYWithSize(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	tty, err := StartPTYWithSize(exec.Command("stty", "size"), 132, 50)
+	require.NoError(t, err)
+	defer tty.Close()
+
+	output, _ := io.ReadAll(tty)
+	assert.Equal(t, "50 132", strings.TrimSpace(string(output)))
+}
+
+func Test__TerminalSize(t *testing.T) {
+	assert.False(t, Terminal{Type: "xterm"}.HasSize())
+	assert.True(t, Terminal{Columns: 120}.HasSize())
+
+	columns, rows := Terminal{Columns: 120}.Size()
+	assert.Equal(t, uint16(120), columns)
+	assert.Equal(t, uint16(DefaultTerminalRows), rows)
+}
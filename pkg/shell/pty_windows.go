@@ -11,3 +11,7 @@ import (
 func StartPTY(c *exec.Cmd) (*os.File, error) {
 	return nil, errors.New("PTY is not supported on Windows")
 }
+
+func StartPTYWithSize(c *exec.Cmd, columns, rows uint16) (*os.File, error) {
+	return nil, errors.New("PTY is not supported on Windows")
+}
@@ -8,11 +8,16 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Exported into the bash session, so we can find the processes
+// which escaped it when the job is stopped. See shell_stop.go.
+const ProcessMarkerEnvVar = "SEMAPHORE_AGENT_SHELL_ID"
+
 type Shell struct {
 	Executable  string
 	Args        []string
@@ -22,18 +27,75 @@ type Shell struct {
 	ExitSignal  chan string
 	Env         *Environment
 	Cwd         string
+	Dialect     Dialect
+
+	// Commands run in a new shell process each, with pipes instead of a PTY,
+	// so stdout and stderr can be told apart. Only supported on Linux.
+	UsePipes bool
+
+	// The size and type of the PTY. Zero values keep the defaults.
+	Terminal Terminal
+
+	// When the shell runs in a container, the status channel runs in it too,
+	// with this command, e.g. `docker exec -i <container>`, and its FIFOs are in /tmp.
+	// Otherwise, the channel runs on the host, and its FIFOs are in the storage path.
+	ChannelCommand []string
+
+	// Reports the exit code of the commands, and when their output ends. See channel.go.
+	channel *channel
 
 	/*
 	 * A job object handle used to interrupt the command
 	 * process in case of a stop request.
 	 */
 	windowsJobObject uintptr
+
+	processMarker     string
+	knownJobProcesses map[int]bool
+	processesMu       sync.Mutex
+
+	// The processes the agent starts itself, and waits for, even if they carry the marker.
+	agentProcesses map[int]bool
+
+	// Closed when the shell is closed, to stop reaping orphans.
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func NewShell(storagePath string) (*Shell, error) {
 	return NewShellFromExecAndArgs(Executable(), Args(), storagePath)
 }
 
+// The shell can be a name found in the PATH, or a path to its executable.
+// Empty means the default shell: bash, or PowerShell, on Windows.
+func NewShellFor(executable string, storagePath string) (*Shell, error) {
+	if executable == "" {
+		return NewShell(storagePath)
+	}
+
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("the shell cannot be changed on Windows - PowerShell is always used")
+	}
+
+	dialect, err := FindDialect(executable)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return nil, fmt.Errorf("shell '%s' not found: %v", executable, err)
+	}
+
+	shell, err := NewShellFromExecAndArgs(path, dialect.LoginArgs(), storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	shell.Dialect = dialect
+	return shell, nil
+}
+
 func NewShellFromExecAndArgs(executable string, args []string, storagePath string) (*Shell, error) {
 	exitChannel := make(chan string, 1)
 
@@ -49,6 +111,12 @@ func NewShellFromExecAndArgs(executable string, args []string, storagePath strin
 		ExitSignal:  exitChannel,
 		Env:         &Environment{},
 		Cwd:         cwd,
+		Dialect:     Bash,
+
+		processMarker:     fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano()),
+		knownJobProcesses: map[int]bool{},
+		agentProcesses:    map[int]bool{},
+		closed:            make(chan struct{}),
 	}, nil
 }
 
@@ -63,11 +131,22 @@ func (s *Shell) Start() error {
 		return nil
 	}
 
+	if s.UsePipes {
+		return s.startWithPipes()
+	}
+
 	log.Debug("Starting stateful shell")
 
+	becomeSubreaper()
+
 	// #nosec
 	s.BootCommand = exec.Command(s.Executable, s.Args...)
-	tty, err := StartPTY(s.BootCommand)
+	s.BootCommand.Env = append(os.Environ(), ProcessMarkerEnvVar+"="+s.processMarker)
+	if s.Terminal.Type != "" {
+		s.BootCommand.Env = append(s.BootCommand.Env, "TERM="+s.Terminal.Type)
+	}
+
+	tty, err := s.startPTY()
 	if err != nil {
 		log.Errorf("Failed to start stateful shell: %v", err)
 		return err
@@ -76,10 +155,68 @@ func (s *Shell) Start() error {
 	s.TTY = tty
 
 	s.handleAbruptShellCloses()
+	go s.reapOrphans()
 
 	time.Sleep(1000)
 
-	return s.silencePromptAndDisablePS1()
+	err = s.silencePromptAndDisablePS1()
+	if err != nil {
+		return err
+	}
+
+	return s.startChannel()
+}
+
+// The channel can only start once the container of the shell is running.
+func (s *Shell) startChannel() error {
+	directory := s.StoragePath
+	if len(s.ChannelCommand) > 0 {
+		directory = "/tmp"
+	}
+
+	channel, err := startChannel(s.ChannelCommand, directory, s.processMarker)
+	if err != nil {
+		log.Errorf("Failed to start the status channel: %v", err)
+		return err
+	}
+
+	s.channel = channel
+	return nil
+}
+
+/*
+ * There is no session to start, so we only set up the state the commands start from,
+ * which is the same one a PTY session would start with: the agent's environment,
+ * and the home directory. The marker finds the job processes when the job is stopped.
+ */
+func (s *Shell) startWithPipes() error {
+	log.Debug("Using pipes for the job commands")
+
+	becomeSubreaper()
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("error finding home directory: %v", err)
+	}
+
+	s.Cwd = homeDir
+	s.Env = EnvironmentFromSlice(os.Environ())
+	s.Env.Set(ProcessMarkerEnvVar, s.processMarker)
+	if s.Terminal.Type != "" {
+		s.Env.Set("TERM", s.Terminal.Type)
+	}
+
+	go s.reapOrphans()
+	return nil
+}
+
+func (s *Shell) startPTY() (*os.File, error) {
+	if !s.Terminal.HasSize() {
+		return StartPTY(s.BootCommand)
+	}
+
+	columns, rows := s.Terminal.Size()
+	return StartPTYWithSize(s.BootCommand, columns, rows)
 }
 
 func (s *Shell) handleAbruptShellCloses() {
@@ -153,22 +290,14 @@ func (s *Shell) Write(instruction string) (int, error) {
 func (s *Shell) silencePromptAndDisablePS1() error {
 	everythingIsReadyMark := "87d140552e404df69f6472729d2b2c3"
 
-	_, err := s.TTY.Write([]byte("export PS1=''\n"))
-	if err != nil {
-		return err
-	}
-
-	_, err = s.TTY.Write([]byte("stty -echo\n"))
-	if err != nil {
-		return err
-	}
-
-	_, err = s.TTY.Write([]byte("echo stty `stty -g` > /tmp/restore-tty\n"))
-	if err != nil {
-		return err
+	for _, command := range s.Dialect.SetupCommands() {
+		_, err := s.TTY.Write([]byte(command + "\n"))
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = s.TTY.Write([]byte("cd ~\n"))
+	_, err := s.TTY.Write([]byte("cd ~\n"))
 	if err != nil {
 		return err
 	}
@@ -243,6 +372,12 @@ func (s *Shell) NewProcessWithConfig(config Config) *Process {
 }
 
 func (s *Shell) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+
+	if s.channel != nil {
+		s.channel.Close()
+	}
+
 	if s.TTY != nil {
 		err := s.TTY.Close()
 		if err != nil {
@@ -262,6 +397,18 @@ func (s *Shell) Close() error {
 	return nil
 }
 
+// The processes the agent waits for are never reaped with the orphans.
+func (s *Shell) setAgentProcess(pid int, started bool) {
+	s.processesMu.Lock()
+	defer s.processesMu.Unlock()
+
+	if started {
+		s.agentProcesses[pid] = true
+	} else {
+		delete(s.agentProcesses, pid)
+	}
+}
+
 func (s *Shell) Chdir(newCwd string) {
 	if newCwd != s.Cwd {
 		s.Cwd = newCwd
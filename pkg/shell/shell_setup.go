@@ -4,14 +4,11 @@ package shell
 
 /*
  * For non-windows agents, we handle job termination
- * by closing the TTY associated with the job.
- * Therefore, no special handling here is necessary.
+ * by closing the TTY associated with the job,
+ * and by signaling the processes started by the job.
+ * See shell_stop.go.
  */
 
 func (s *Shell) Setup() {
 
 }
-
-func (s *Shell) Terminate() error {
-	return nil
-}
@@ -3,6 +3,7 @@
 package shell
 
 import (
+	"time"
 	"unsafe"
 
 	log "github.com/sirupsen/logrus"
@@ -42,3 +43,12 @@ func (s *Shell) Terminate() error {
 	log.Debugf("Terminating all processes assigned to job object %v", s.windowsJobObject)
 	return windows.CloseHandle(windows.Handle(s.windowsJobObject))
 }
+
+// The job object already takes care of the processes that escape the shell.
+func becomeSubreaper() {}
+
+func (s *Shell) reapOrphans() {}
+
+// Windows processes have no equivalent to SIGTERM,
+// so they are all killed when the job object is closed, in Terminate().
+func (s *Shell) Interrupt(gracePeriod time.Duration) {}
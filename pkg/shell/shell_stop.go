@@ -0,0 +1,123 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const jobProcessPollingInterval = 100 * time.Millisecond
+
+// How long we wait for the killed processes to be gone, before reporting them as leftovers.
+const jobProcessKillTimeout = time.Second
+
+type jobProcess struct {
+	pid    int
+	ppid   int
+	pgid   int
+	name   string
+	zombie bool
+}
+
+func (p jobProcess) String() string {
+	return fmt.Sprintf("%d (%s)", p.pid, p.name)
+}
+
+/*
+ * Gives the job processes a chance to clean up before the shell is closed,
+ * by sending SIGTERM to them, and to their process groups,
+ * and waiting for them to exit, for up to the grace period.
+ * The ones still running are killed by Terminate(), after the shell is closed.
+ */
+func (s *Shell) Interrupt(gracePeriod time.Duration) {
+	if gracePeriod <= 0 {
+		return
+	}
+
+	processes := runningProcesses(s.jobProcesses())
+	if len(processes) == 0 {
+		return
+	}
+
+	log.Infof("Sending SIGTERM to job processes: %s", formatProcesses(processes))
+	signalProcesses(processes, syscall.SIGTERM)
+
+	remaining := s.waitForJobProcesses(gracePeriod)
+	if len(remaining) > 0 {
+		log.Infof("Job processes still running after the %v grace period: %s", gracePeriod, formatProcesses(remaining))
+	}
+}
+
+// Kills all the job processes still running, including the ones that escaped the bash session.
+func (s *Shell) Terminate() error {
+	processes := runningProcesses(s.jobProcesses())
+	if len(processes) > 0 {
+		log.Debugf("Sending SIGKILL to job processes: %s", formatProcesses(processes))
+		signalProcesses(processes, syscall.SIGKILL)
+	}
+
+	remaining := s.waitForJobProcesses(jobProcessKillTimeout)
+	if len(remaining) > 0 {
+		log.Warnf("Processes left behind by the job: %s", formatProcesses(remaining))
+	}
+
+	return nil
+}
+
+// Returns the job processes still running after the timeout.
+func (s *Shell) waitForJobProcesses(timeout time.Duration) []jobProcess {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		processes := s.jobProcesses()
+		s.reapAdoptedProcesses(processes)
+
+		running := runningProcesses(processes)
+		if len(running) == 0 || time.Now().After(deadline) {
+			return running
+		}
+
+		time.Sleep(jobProcessPollingInterval)
+	}
+}
+
+// We never signal the agent's own process group.
+func signalProcesses(processes []jobProcess, signal syscall.Signal) {
+	agentGroup := syscall.Getpgrp()
+	signaledGroups := map[int]bool{}
+
+	for _, process := range processes {
+		if process.pgid > 1 && process.pgid != agentGroup && !signaledGroups[process.pgid] {
+			signaledGroups[process.pgid] = true
+			_ = syscall.Kill(-process.pgid, signal)
+		}
+
+		_ = syscall.Kill(process.pid, signal)
+	}
+}
+
+func runningProcesses(processes []jobProcess) []jobProcess {
+	running := []jobProcess{}
+	for _, process := range processes {
+		if !process.zombie {
+			running = append(running, process)
+		}
+	}
+
+	return running
+}
+
+func formatProcesses(processes []jobProcess) string {
+	names := []string{}
+	for _, process := range processes {
+		names = append(names, process.String())
+	}
+
+	return strings.Join(names, ", ")
+}
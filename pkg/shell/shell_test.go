@@ -2,9 +2,16 @@ package shell
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	assert "github.com/stretchr/testify/assert"
 )
@@ -86,6 +93,77 @@ func Test__Shell__SimpleHelloWorldUsingBase64Encoding(t *testing.T) {
 	assert.NoError(t, shell.Close())
 }
 
+func Test__Shell__OutputThatLooksLikeMarkers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	shell, _ := NewShell(t.TempDir())
+	assert.NoError(t, shell.Start())
+	defer shell.Close()
+
+	// The command can find every fence in the line typed into the shell, in the history.
+	command := `printf '\001 949556c7-1700000000-end 0\n'; printf 'fence-\001'; history 1 | grep -o 'fence-[0-9a-f]*'; exit_code=7; (exit $exit_code)`
+	for _, useBase64 := range []bool{false, true} {
+		var output bytes.Buffer
+		p := shell.NewProcessWithConfig(Config{
+			Command:           command,
+			UseBase64Encoding: useBase64,
+			Shell:             shell,
+			StoragePath:       shell.StoragePath,
+			OnOutput: func(line string) {
+				output.WriteString(line)
+			},
+		})
+
+		p.Run()
+		assert.Equal(t, 7, p.ExitCode)
+		assert.Equal(t, "\001 949556c7-1700000000-end 0\nfence-\001"+p.startFence+"\n", output.String())
+	}
+}
+
+// Like `docker exec -i <container>`, the command runs the channel next to the shell.
+func Test__Shell__ChannelRunsWithTheChannelCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	shell, _ := NewShell(t.TempDir())
+	shell.ChannelCommand = []string{"env", "SEMAPHORE_AGENT_TEST=1"}
+	assert.NoError(t, shell.Start())
+	assert.True(t, strings.HasPrefix(shell.channel.StatusPath, "/tmp/"))
+
+	var output bytes.Buffer
+	p := shell.NewProcessWithConfig(Config{
+		Command:           "echo Hello; (exit 3)",
+		UseBase64Encoding: true,
+		Shell:             shell,
+		OnOutput: func(line string) {
+			output.WriteString(line)
+		},
+	})
+
+	p.Run()
+	assert.Equal(t, 3, p.ExitCode)
+	assert.Equal(t, "Hello\n", output.String())
+
+	// The channel removes its FIFOs when the shell is closed.
+	assert.NoError(t, shell.Close())
+	assert.NoFileExists(t, shell.channel.StatusPath)
+	assert.NoFileExists(t, shell.channel.EndPath)
+}
+
+func Test__Shell__StartFailsWithoutTheChannel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	shell, _ := NewShell(t.TempDir())
+	shell.ChannelCommand = []string{"false"}
+	assert.Error(t, shell.Start())
+	assert.NoError(t, shell.Close())
+}
+
 func Test__Shell__HandlingBashProcessKill(t *testing.T) {
 	var output bytes.Buffer
 
@@ -141,3 +219,131 @@ func Test__Shell__HandlingBashProcessKillThatHasBackgroundJobs(t *testing.T) {
 
 	assert.Equal(t, output.String(), "Hello\n")
 }
+
+func Test__Shell__InterruptGivesProcessesAChanceToCleanUp(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip()
+	}
+
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	cleanupFile := filepath.Join(t.TempDir(), "cleanup")
+
+	shell, _ := NewShell(os.TempDir())
+	shell.Start()
+
+	cmd := fmt.Sprintf(`bash -c 'trap "echo cleaned up > %s; exit 0" TERM; touch %s; while true; do sleep 0.1; done' > /dev/null 2>&1 &`, cleanupFile, readyFile)
+	p1 := shell.NewProcessWithOutput(cmd, func(string) {})
+	p1.Run()
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(readyFile)
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	shell.Interrupt(5 * time.Second)
+	assert.NoError(t, shell.Close())
+	assert.NoError(t, shell.Terminate())
+
+	content, err := os.ReadFile(cleanupFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "cleaned up\n", string(content))
+}
+
+func Test__Shell__TerminateKillsProcessesThatEscapedTheSession(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip()
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	shell, _ := NewShell(os.TempDir())
+	shell.Start()
+
+	// The process ignores SIGTERM, and leaves the bash session, so closing the TTY does not reach it.
+	cmd := fmt.Sprintf(`setsid bash -c 'trap "" TERM; echo $$ > %s; while true; do sleep 0.1; done' > /dev/null 2>&1 &`, pidFile)
+	p1 := shell.NewProcessWithOutput(cmd, func(string) {})
+	p1.Run()
+
+	pid := 0
+	assert.Eventually(t, func() bool {
+		content, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+
+		pid, err = strconv.Atoi(strings.TrimSpace(string(content)))
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	shell.Interrupt(500 * time.Millisecond)
+	assert.NoError(t, syscall.Kill(pid, 0))
+
+	assert.NoError(t, shell.Close())
+	assert.NoError(t, shell.Terminate())
+	assert.ErrorIs(t, syscall.Kill(pid, 0), syscall.ESRCH)
+}
+
+func Test__Shell__OrphansAreReapedWhileTheJobRuns(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip()
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	shell, _ := NewShell(os.TempDir())
+	shell.Start()
+
+	// The parent exits right away, so the agent adopts the process, and nothing else waits for it.
+	// It runs for longer than the reaping interval, so it is found as a job process.
+	cmd := fmt.Sprintf(`bash -c 'sleep 1.5 & echo $! > %s'`, pidFile)
+	p1 := shell.NewProcessWithOutput(cmd, func(string) {})
+	p1.Run()
+
+	content, err := os.ReadFile(pidFile)
+	assert.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.NoError(t, shell.Close())
+	assert.NoError(t, shell.Terminate())
+}
+
+func Test__Shell__ProcessesStartedByTheAgentAreNotReaped(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip()
+	}
+
+	shell, _ := NewShell(os.TempDir())
+	shell.UsePipes = true
+	assert.NoError(t, shell.Start())
+
+	// It carries the process marker, like the commands run with pipes.
+	cmd := exec.Command("sh", "-c", "sleep 0.2; exit 3")
+	cmd.Env = shell.Env.ToSlice()
+	assert.NoError(t, cmd.Start())
+	shell.setAgentProcess(cmd.Process.Pid, true)
+
+	assert.Eventually(t, func() bool {
+		for _, process := range shell.jobProcesses() {
+			if process.pid == cmd.Process.Pid {
+				return process.zombie
+			}
+		}
+
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	shell.reapAdoptedProcesses(shell.jobProcesses())
+
+	err := cmd.Wait()
+	if assert.Error(t, err) {
+		assert.Equal(t, 3, cmd.ProcessState.ExitCode())
+	}
+
+	assert.NoError(t, shell.Close())
+	assert.NoError(t, shell.Terminate())
+}
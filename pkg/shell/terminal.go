@@ -0,0 +1,33 @@
+package shell
+
+// Used for the size not given, when only one of them is.
+const DefaultTerminalColumns = 80
+const DefaultTerminalRows = 24
+
+/*
+ * The terminal the job commands run in. Tools which format their output
+ * by the terminal width, or which only use colors for some terminals, look at it.
+ * Zero values keep the defaults: the size the PTY starts with, and the agent's TERM.
+ */
+type Terminal struct {
+	Columns uint16
+	Rows    uint16
+	Type    string
+}
+
+func (t Terminal) HasSize() bool {
+	return t.Columns > 0 || t.Rows > 0
+}
+
+func (t Terminal) Size() (uint16, uint16) {
+	columns, rows := t.Columns, t.Rows
+	if columns == 0 {
+		columns = DefaultTerminalColumns
+	}
+
+	if rows == 0 {
+		rows = DefaultTerminalRows
+	}
+
+	return columns, rows
+}
@@ -0,0 +1,230 @@
+package testreports
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How many of the slowest tests and of the failures we keep in a summary.
+// Test suites can have thousands of test cases, and the summary
+// ends up in the job log, so we need to keep it small.
+const DefaultMaxSlowestTests = 5
+const DefaultMaxFailures = 20
+
+// Failure messages can be huge (full stack traces, diffs),
+// so we only keep the beginning of them in the summary.
+const MaxFailureMessageLength = 1000
+
+type TestCase struct {
+	Name      string  `json:"name"`
+	Classname string  `json:"classname,omitempty"`
+	Duration  float64 `json:"duration"`
+}
+
+type TestFailure struct {
+	Name      string `json:"name"`
+	Classname string `json:"classname,omitempty"`
+	Message   string `json:"message"`
+}
+
+type Summary struct {
+	Reports  []string      `json:"reports"`
+	Total    int           `json:"total"`
+	Passed   int           `json:"passed"`
+	Failed   int           `json:"failed"`
+	Errors   int           `json:"errors"`
+	Skipped  int           `json:"skipped"`
+	Duration float64       `json:"duration"`
+	Slowest  []TestCase    `json:"slowest"`
+	Failures []TestFailure `json:"failures"`
+}
+
+type junitTestSuites struct {
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name   string           `xml:"name,attr"`
+	Suites []junitTestSuite `xml:"testsuite"`
+	Cases  []junitTestCase  `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failures  []junitResult `xml:"failure"`
+	Errors    []junitResult `xml:"error"`
+	Skipped   *junitResult  `xml:"skipped"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// Collects the results of multiple JUnit XML reports into a single summary.
+type Collector struct {
+	MaxSlowestTests int
+	MaxFailures     int
+
+	summary Summary
+	cases   []TestCase
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		MaxSlowestTests: DefaultMaxSlowestTests,
+		MaxFailures:     DefaultMaxFailures,
+		summary: Summary{
+			Reports:  []string{},
+			Slowest:  []TestCase{},
+			Failures: []TestFailure{},
+		},
+	}
+}
+
+func (c *Collector) Add(name string, content []byte) error {
+	suites, err := parse(content)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", name, err)
+	}
+
+	for _, suite := range suites {
+		c.addSuite(suite)
+	}
+
+	c.summary.Reports = append(c.summary.Reports, name)
+	return nil
+}
+
+func (c *Collector) Summary() Summary {
+	summary := c.summary
+
+	sort.SliceStable(c.cases, func(i, j int) bool {
+		return c.cases[i].Duration > c.cases[j].Duration
+	})
+
+	slowest := c.cases
+	if len(slowest) > c.MaxSlowestTests {
+		slowest = slowest[:c.MaxSlowestTests]
+	}
+
+	summary.Slowest = append([]TestCase{}, slowest...)
+	return summary
+}
+
+func (c *Collector) addSuite(suite junitTestSuite) {
+	for _, nested := range suite.Suites {
+		c.addSuite(nested)
+	}
+
+	for _, testCase := range suite.Cases {
+		c.addCase(testCase)
+	}
+}
+
+func (c *Collector) addCase(testCase junitTestCase) {
+	duration := parseDuration(testCase.Time)
+	c.summary.Total++
+	c.summary.Duration += duration
+	c.cases = append(c.cases, TestCase{
+		Name:      testCase.Name,
+		Classname: testCase.Classname,
+		Duration:  duration,
+	})
+
+	switch {
+	case len(testCase.Errors) > 0:
+		c.summary.Errors++
+		c.addFailure(testCase, testCase.Errors[0])
+	case len(testCase.Failures) > 0:
+		c.summary.Failed++
+		c.addFailure(testCase, testCase.Failures[0])
+	case testCase.Skipped != nil:
+		c.summary.Skipped++
+	default:
+		c.summary.Passed++
+	}
+}
+
+func (c *Collector) addFailure(testCase junitTestCase, result junitResult) {
+	if len(c.summary.Failures) >= c.MaxFailures {
+		return
+	}
+
+	c.summary.Failures = append(c.summary.Failures, TestFailure{
+		Name:      testCase.Name,
+		Classname: testCase.Classname,
+		Message:   failureMessage(result),
+	})
+}
+
+/*
+ * JUnit reports come in two flavors: a single <testsuite> element as the root,
+ * or a <testsuites> root element wrapping multiple <testsuite> elements.
+ */
+func parse(content []byte) ([]junitTestSuite, error) {
+	root := struct {
+		XMLName xml.Name
+	}{}
+
+	if err := xml.Unmarshal(content, &root); err != nil {
+		return nil, err
+	}
+
+	switch root.XMLName.Local {
+	case "testsuites":
+		suites := junitTestSuites{}
+		if err := xml.Unmarshal(content, &suites); err != nil {
+			return nil, err
+		}
+
+		return suites.Suites, nil
+
+	case "testsuite":
+		suite := junitTestSuite{}
+		if err := xml.Unmarshal(content, &suite); err != nil {
+			return nil, err
+		}
+
+		return []junitTestSuite{suite}, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected root element <%s>", root.XMLName.Local)
+	}
+}
+
+// Some tools use commas as thousand separators in the time attribute.
+func parseDuration(value string) float64 {
+	duration, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(value), ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+
+	return duration
+}
+
+func failureMessage(result junitResult) string {
+	message := strings.TrimSpace(result.Message)
+	if message == "" {
+		message = strings.TrimSpace(result.Body)
+		if index := strings.Index(message, "\n"); index >= 0 {
+			message = message[:index]
+		}
+	}
+
+	if message == "" {
+		message = result.Type
+	}
+
+	if len(message) > MaxFailureMessageLength {
+		return message[:MaxFailureMessageLength] + "..."
+	}
+
+	return message
+}
@@ -0,0 +1,109 @@
+package testreports
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reportWithSuites = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="models">
+    <testcase name="creates user" classname="UserTest" time="0.5"/>
+    <testcase name="deletes user" classname="UserTest" time="2.25">
+      <failure message="expected 1, got 2" type="AssertionError">stack trace</failure>
+    </testcase>
+    <testcase name="updates user" classname="UserTest" time="0.1">
+      <skipped/>
+    </testcase>
+  </testsuite>
+  <testsuite name="api">
+    <testsuite name="nested">
+      <testcase name="responds" classname="ApiTest" time="1,000.5">
+        <error type="Timeout">connection timed out
+at api.go:10</error>
+      </testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>
+`
+
+const reportWithSingleSuite = `<testsuite name="single">
+  <testcase name="a" time="0.3"/>
+  <testcase name="b" time="0.1"/>
+</testsuite>
+`
+
+func Test__Collector(t *testing.T) {
+	t.Run("summarizes reports with multiple suites", func(t *testing.T) {
+		collector := NewCollector()
+		require.NoError(t, collector.Add("report.xml", []byte(reportWithSuites)))
+
+		summary := collector.Summary()
+		assert.Equal(t, []string{"report.xml"}, summary.Reports)
+		assert.Equal(t, 4, summary.Total)
+		assert.Equal(t, 1, summary.Passed)
+		assert.Equal(t, 1, summary.Failed)
+		assert.Equal(t, 1, summary.Errors)
+		assert.Equal(t, 1, summary.Skipped)
+		assert.InDelta(t, 1003.35, summary.Duration, 0.001)
+
+		assert.Equal(t, []TestFailure{
+			{Name: "deletes user", Classname: "UserTest", Message: "expected 1, got 2"},
+			{Name: "responds", Classname: "ApiTest", Message: "connection timed out"},
+		}, summary.Failures)
+	})
+
+	t.Run("summarizes report with a single suite", func(t *testing.T) {
+		collector := NewCollector()
+		require.NoError(t, collector.Add("single.xml", []byte(reportWithSingleSuite)))
+
+		summary := collector.Summary()
+		assert.Equal(t, 2, summary.Total)
+		assert.Equal(t, 2, summary.Passed)
+		assert.Equal(t, []TestFailure{}, summary.Failures)
+	})
+
+	t.Run("combines multiple reports and keeps only the slowest tests", func(t *testing.T) {
+		collector := NewCollector()
+		collector.MaxSlowestTests = 2
+		require.NoError(t, collector.Add("report.xml", []byte(reportWithSuites)))
+		require.NoError(t, collector.Add("single.xml", []byte(reportWithSingleSuite)))
+
+		summary := collector.Summary()
+		assert.Equal(t, []string{"report.xml", "single.xml"}, summary.Reports)
+		assert.Equal(t, 6, summary.Total)
+		assert.Equal(t, []TestCase{
+			{Name: "responds", Classname: "ApiTest", Duration: 1000.5},
+			{Name: "deletes user", Classname: "UserTest", Duration: 2.25},
+		}, summary.Slowest)
+	})
+
+	t.Run("limits the number of failures and the message size", func(t *testing.T) {
+		collector := NewCollector()
+		collector.MaxFailures = 1
+
+		longMessage := strings.Repeat("a", MaxFailureMessageLength+10)
+		report := `<testsuite>
+			<testcase name="a"><failure message="` + longMessage + `"/></testcase>
+			<testcase name="b"><failure message="b failed"/></testcase>
+		</testsuite>`
+
+		require.NoError(t, collector.Add("report.xml", []byte(report)))
+		summary := collector.Summary()
+		assert.Equal(t, 2, summary.Failed)
+		if assert.Len(t, summary.Failures, 1) {
+			assert.Equal(t, strings.Repeat("a", MaxFailureMessageLength)+"...", summary.Failures[0].Message)
+		}
+	})
+
+	t.Run("bad reports are rejected", func(t *testing.T) {
+		collector := NewCollector()
+		assert.ErrorContains(t, collector.Add("bad.xml", []byte("not xml")), "error parsing bad.xml")
+		assert.ErrorContains(t, collector.Add("html.xml", []byte("<html></html>")), "unexpected root element <html>")
+		assert.Equal(t, 0, collector.Summary().Total)
+		assert.Equal(t, []string{}, collector.Summary().Reports)
+	})
+}
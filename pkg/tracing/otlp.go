@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"sort"
+	"strconv"
+)
+
+/*
+ * The JSON encoding of the OTLP ExportTraceServiceRequest message. See
+ * https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto.
+ * IDs are hex-encoded, and 64-bit integers are strings, as the OTLP/JSON mapping requires.
+ */
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []SpanData `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type SpanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes"`
+	Status            Status     `json:"status"`
+}
+
+const (
+	StatusCodeUnset = 0
+	StatusCodeError = 2
+)
+
+type Status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+// Returns the attribute value as a string, whatever its type.
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	default:
+		return ""
+	}
+}
+
+const scopeName = "github.com/semaphoreci/agent"
+
+func (t *Tracer) request(spans []*Span) ExportRequest {
+	resourceAttributes := []Attribute{String("service.name", t.config.ServiceName)}
+	keys := []string{}
+	for key := range t.config.Attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		resourceAttributes = append(resourceAttributes, String(key, t.config.Attributes[key]))
+	}
+
+	data := []SpanData{}
+	for _, span := range spans {
+		data = append(data, span.data())
+	}
+
+	return ExportRequest{
+		ResourceSpans: []ResourceSpans{
+			{
+				Resource:   Resource{Attributes: keyValues(resourceAttributes)},
+				ScopeSpans: []ScopeSpans{{Scope: Scope{Name: scopeName}, Spans: data}},
+			},
+		},
+	}
+}
+
+func (s *Span) data() SpanData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := Status{Code: StatusCodeUnset}
+	if s.errMessage != "" {
+		status = Status{Code: StatusCodeError, Message: s.errMessage}
+	}
+
+	return SpanData{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentSpanID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: formatNanos(s.startedAt),
+		EndTimeUnixNano:   formatNanos(s.finishedAt),
+		Attributes:        keyValues(s.attributes),
+		Status:            status,
+	}
+}
+
+func keyValues(attributes []Attribute) []KeyValue {
+	result := []KeyValue{}
+	for _, attribute := range attributes {
+		value := AnyValue{}
+		switch v := attribute.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case int:
+			s := strconv.Itoa(v)
+			value.IntValue = &s
+		case bool:
+			value.BoolValue = &v
+		default:
+			continue
+		}
+
+		result = append(result, KeyValue{Key: attribute.Key, Value: value})
+	}
+
+	return result
+}
@@ -0,0 +1,361 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/httputils"
+	"github.com/semaphoreci/agent/pkg/retry"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultServiceName = "semaphore-agent"
+const DefaultMaxAttempts = 5
+const DefaultInitialDelay = time.Second
+const DefaultMaxDelay = 30 * time.Second
+const DefaultRequestTimeout = 10 * time.Second
+
+// The path for traces in the OTLP/HTTP protocol.
+const TracesPath = "/v1/traces"
+
+// The environment variable used to propagate the trace context,
+// in the W3C Trace Context format: https://www.w3.org/TR/trace-context/.
+const TraceparentEnvVar = "TRACEPARENT"
+
+type Config struct {
+	// The OTLP/HTTP endpoint, e.g. http://localhost:4318.
+	// The spans are sent to <Endpoint>/v1/traces.
+	Endpoint     string
+	ServiceName  string
+	Attributes   map[string]string
+	UserAgent    string
+	Client       *http.Client
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+/*
+ * Records spans and exports them to an OpenTelemetry collector,
+ * using the OTLP/HTTP protocol with JSON encoding.
+ * The spans of a trace are exported together, in the background, when its root span ends,
+ * so a slow or unavailable collector never holds up the job.
+ *
+ * A nil *Tracer records nothing, so callers do not need to check if tracing is enabled.
+ */
+type Tracer struct {
+	config  Config
+	url     string
+	mutex   sync.Mutex
+	ended   map[string][]*Span
+	pending sync.WaitGroup
+}
+
+type Span struct {
+	tracer       *Tracer
+	traceID      string
+	spanID       string
+	parentSpanID string
+	root         bool
+	name         string
+	kind         int
+	startedAt    time.Time
+
+	mutex      sync.Mutex
+	attributes []Attribute
+	errMessage string
+	ended      bool
+	finishedAt time.Time
+}
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+const (
+	SpanKindInternal = 1
+	SpanKindClient   = 3
+)
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func NewTracer(config Config) *Tracer {
+	if config.ServiceName == "" {
+		config.ServiceName = DefaultServiceName
+	}
+
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: DefaultRequestTimeout}
+	}
+
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+
+	if config.InitialDelay == 0 {
+		config.InitialDelay = DefaultInitialDelay
+	}
+
+	if config.MaxDelay == 0 {
+		config.MaxDelay = DefaultMaxDelay
+	}
+
+	url := strings.TrimSuffix(config.Endpoint, "/")
+	if !strings.HasSuffix(url, TracesPath) {
+		url += TracesPath
+	}
+
+	return &Tracer{config: config, url: url, ended: map[string][]*Span{}}
+}
+
+/*
+ * Starts the root span of a trace. If a traceparent is given, e.g. from the job environment,
+ * the span continues that trace, instead of starting a new one.
+ */
+func (t *Tracer) StartTrace(name, traceparent string, attributes ...Attribute) *Span {
+	if t == nil {
+		return nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		traceID:    randomHex(16),
+		spanID:     randomHex(8),
+		root:       true,
+		name:       name,
+		kind:       SpanKindInternal,
+		startedAt:  time.Now(),
+		attributes: attributes,
+	}
+
+	if traceID, parentSpanID, ok := ParseTraceparent(traceparent); ok {
+		span.traceID = traceID
+		span.parentSpanID = parentSpanID
+	}
+
+	return span
+}
+
+func (s *Span) StartChild(name string, attributes ...Attribute) *Span {
+	return s.StartChildAt(name, time.Now(), attributes...)
+}
+
+// For operations we only learn about after they started.
+func (s *Span) StartChildAt(name string, startedAt time.Time, attributes ...Attribute) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return &Span{
+		tracer:       s.tracer,
+		traceID:      s.traceID,
+		spanID:       randomHex(8),
+		parentSpanID: s.spanID,
+		name:         name,
+		kind:         SpanKindInternal,
+		startedAt:    startedAt,
+		attributes:   attributes,
+	}
+}
+
+// Used for the spans of requests to other services.
+func (s *Span) SetClientKind() {
+	if s == nil {
+		return
+	}
+
+	s.kind = SpanKindClient
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errMessage = message
+}
+
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+func (s *Span) EndAt(finishedAt time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.finishedAt = finishedAt
+	s.mutex.Unlock()
+
+	s.tracer.spanEnded(s)
+}
+
+// e.g. 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", s.traceID, s.spanID)
+}
+
+func ParseTraceparent(traceparent string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+
+	traceID, spanID := strings.ToLower(parts[1]), strings.ToLower(parts[2])
+	if !isHex(traceID, 32) || !isHex(spanID, 16) {
+		return "", "", false
+	}
+
+	return traceID, spanID, true
+}
+
+/*
+ * Waits until all the traces are exported, or until the timeout is reached.
+ * Used before the agent shuts down, so the last job's trace is not lost.
+ */
+func (t *Tracer) Flush(timeout time.Duration) bool {
+	if t == nil {
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Warnf("Timed out exporting traces after %v", timeout)
+		return false
+	}
+}
+
+func (t *Tracer) spanEnded(span *Span) {
+	t.mutex.Lock()
+	spans := append(t.ended[span.traceID], span)
+	if !span.root {
+		t.ended[span.traceID] = spans
+		t.mutex.Unlock()
+		return
+	}
+
+	delete(t.ended, span.traceID)
+	t.mutex.Unlock()
+
+	t.pending.Add(1)
+	go func() {
+		defer t.pending.Done()
+		t.export(spans)
+	}()
+}
+
+func (t *Tracer) export(spans []*Span) {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		log.Errorf("Error marshaling spans: %v", err)
+		return
+	}
+
+	err = retry.RetryWithExponentialBackoff(retry.RetryOptions{
+		Task:                 fmt.Sprintf("Export %d spans to %s", len(spans), t.url),
+		MaxAttempts:          t.config.MaxAttempts,
+		DelayBetweenAttempts: t.config.InitialDelay,
+		MaxDelay:             t.config.MaxDelay,
+		Fn: func() error {
+			return t.post(body)
+		},
+	})
+
+	if err != nil {
+		log.Errorf("Error exporting spans: %v", err)
+	}
+}
+
+func (t *Tracer) post(body []byte) error {
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if t.config.UserAgent != "" {
+		req.Header.Set("User-Agent", t.config.UserAgent)
+	}
+
+	res, err := t.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if !httputils.IsSuccessfulCode(res.StatusCode) {
+		return fmt.Errorf("request to %s failed with status %d", t.url, res.StatusCode)
+	}
+
+	return nil
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length || strings.Trim(s, "0") == "" {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func formatNanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,141 @@
+package tracing_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/tracing"
+	testsupport "github.com/semaphoreci/agent/test/support"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__SpansAreExportedWhenTheRootSpanEnds(t *testing.T) {
+	collector := testsupport.NewCollectorMockServer()
+	collector.Init()
+	defer collector.Close()
+
+	tracer := tracing.NewTracer(tracing.Config{
+		Endpoint:   collector.URL(),
+		Attributes: map[string]string{"agent.name": "my-agent"},
+	})
+
+	root := tracer.StartTrace("job", "", tracing.String("job.id", "job-1"))
+	child := root.StartChild("command", tracing.Int("command.exit_code", 1), tracing.Bool("command.cached", false))
+	child.SetError("exit code 1")
+	child.End()
+
+	// nothing is exported until the root span ends
+	require.True(t, tracer.Flush(time.Second))
+	assert.Zero(t, collector.RequestsCount())
+
+	root.End()
+	require.True(t, tracer.Flush(5*time.Second))
+	assert.Equal(t, 1, collector.RequestsCount())
+
+	job := collector.FindSpan("job")
+	require.NotNil(t, job)
+	assert.Len(t, job.TraceID, 32)
+	assert.Len(t, job.SpanID, 16)
+	assert.Empty(t, job.ParentSpanID)
+	assert.Equal(t, tracing.StatusCodeUnset, job.Status.Code)
+	assert.Equal(t, map[string]string{"job.id": "job-1"}, attributes(job.Attributes))
+
+	command := collector.FindSpan("command")
+	require.NotNil(t, command)
+	assert.Equal(t, job.TraceID, command.TraceID)
+	assert.Equal(t, job.SpanID, command.ParentSpanID)
+	assert.Equal(t, tracing.Status{Code: tracing.StatusCodeError, Message: "exit code 1"}, command.Status)
+	assert.Equal(t, map[string]string{"command.exit_code": "1", "command.cached": "false"}, attributes(command.Attributes))
+
+	resources := collector.Resources()
+	require.Len(t, resources, 1)
+	assert.Equal(t, map[string]string{
+		"service.name": tracing.DefaultServiceName,
+		"agent.name":   "my-agent",
+	}, attributes(resources[0].Attributes))
+}
+
+func Test__TraceIsContinuedFromTraceparent(t *testing.T) {
+	collector := testsupport.NewCollectorMockServer()
+	collector.Init()
+	defer collector.Close()
+
+	tracer := tracing.NewTracer(tracing.Config{Endpoint: collector.URL() + tracing.TracesPath})
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	root := tracer.StartTrace("job", traceparent)
+	assert.Regexp(t, "^00-0af7651916cd43dd8448eb211c80319c-[0-9a-f]{16}-01$", root.Traceparent())
+	root.End()
+
+	span, err := collector.WaitForSpan("job")
+	require.NoError(t, err)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.TraceID)
+	assert.Equal(t, "b7ad6b7169203331", span.ParentSpanID)
+}
+
+func Test__ParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		traceparent string
+		traceID     string
+		spanID      string
+		ok          bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", true},
+		{"00-0AF7651916CD43DD8448EB211C80319C-B7AD6B7169203331-00", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", true},
+		{"", "", "", false},
+		{"not-a-traceparent", "", "", false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "", "", false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", "", "", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", "", "", false},
+		{"00-0af7651916cd43dd-b7ad6b7169203331-01", "", "", false},
+	}
+
+	for _, testCase := range testCases {
+		traceID, spanID, ok := tracing.ParseTraceparent(testCase.traceparent)
+		assert.Equal(t, testCase.ok, ok, testCase.traceparent)
+		assert.Equal(t, testCase.traceID, traceID, testCase.traceparent)
+		assert.Equal(t, testCase.spanID, spanID, testCase.traceparent)
+	}
+}
+
+func Test__ExportIsRetried(t *testing.T) {
+	collector := testsupport.NewCollectorMockServer()
+	collector.Init()
+	defer collector.Close()
+	collector.FailNextRequests(2)
+
+	tracer := tracing.NewTracer(tracing.Config{
+		Endpoint:     collector.URL(),
+		InitialDelay: 10 * time.Millisecond,
+	})
+
+	tracer.StartTrace("job", "").End()
+	require.True(t, tracer.Flush(5*time.Second))
+	assert.Equal(t, 3, collector.RequestsCount())
+	assert.Len(t, collector.Spans(), 1)
+}
+
+func Test__NilTracerRecordsNothing(t *testing.T) {
+	var tracer *tracing.Tracer
+	span := tracer.StartTrace("job", "")
+	assert.Nil(t, span)
+
+	child := span.StartChild("command")
+	child.SetClientKind()
+	child.SetAttributes(tracing.String("a", "b"))
+	child.SetError("failed")
+	child.End()
+	span.End()
+
+	assert.Empty(t, span.Traceparent())
+	assert.True(t, tracer.Flush(time.Second))
+}
+
+func attributes(keyValues []tracing.KeyValue) map[string]string {
+	result := map[string]string{}
+	for _, keyValue := range keyValues {
+		result[keyValue.Key] = keyValue.Value.String()
+	}
+
+	return result
+}
@@ -0,0 +1,94 @@
+package validation
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/semaphoreci/agent/pkg/config"
+	"github.com/semaphoreci/agent/pkg/eventlogger"
+	slices "github.com/semaphoreci/agent/pkg/slices"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Checks an agent configuration file, and returns all the problems found in it.
+func ValidateConfig(content []byte) []Problem {
+	v := newValidator(FormatYAML)
+
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return []Problem{parseProblem(err)}
+	}
+
+	// empty file
+	if len(root.Content) == 0 {
+		return []Problem{}
+	}
+
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		v.addProblemAt(mapping, "", "expected a map")
+		return v.sortedProblems()
+	}
+
+	values := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if !slices.Contains(config.ValidConfigKeys, key.Value) {
+			v.addProblemAt(key, key.Value, "unknown option%s", configKeySuggestion(key.Value))
+			continue
+		}
+
+		values[key.Value] = value
+	}
+
+	if value, ok := values[config.UploadJobLogs]; ok && !slices.Contains(config.ValidUploadJobLogsCondition, value.Value) {
+		v.addProblemAt(value, config.UploadJobLogs,
+			"unsupported value '%s' - use one of %s", value.Value, strings.Join(config.ValidUploadJobLogsCondition, ", "),
+		)
+	}
+
+	if value, ok := values[config.JobID]; ok && value.Value != "" {
+		disconnect, ok := values[config.DisconnectAfterJob]
+		if !ok || disconnect.Value != "true" {
+			v.addProblemAt(value, config.JobID, "can only be used if %s is also used", config.DisconnectAfterJob)
+		}
+	}
+
+	if value, ok := values[config.KubernetesAllowedImages]; ok {
+		if value.Kind != yaml.SequenceNode {
+			v.addProblemAt(value, config.KubernetesAllowedImages, "expected a list")
+		} else {
+			for i, expression := range value.Content {
+				if _, err := regexp.Compile(expression.Value); err != nil {
+					v.addProblemAt(expression, indexPath(config.KubernetesAllowedImages, i), "bad regular expression: %v", err)
+				}
+			}
+		}
+	}
+
+	if value, ok := values[config.LogSinks]; ok {
+		if value.Kind != yaml.SequenceNode {
+			v.addProblemAt(value, config.LogSinks, "expected a list")
+		} else {
+			for i, sink := range value.Content {
+				if _, err := eventlogger.ParseSink(sink.Value); err != nil {
+					v.addProblemAt(sink, indexPath(config.LogSinks, i), "%v", err)
+				}
+			}
+		}
+	}
+
+	return v.sortedProblems()
+}
+
+// e.g. "upload_job_logs" or "upload-jb-logs" instead of "upload-job-logs"
+func configKeySuggestion(key string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+	for _, validKey := range config.ValidConfigKeys {
+		if editDistance(validKey, normalized) <= maxSuggestionDistance {
+			return " - did you mean '" + validKey + "'?"
+		}
+	}
+
+	return ""
+}
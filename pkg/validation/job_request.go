@@ -0,0 +1,181 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	api "github.com/semaphoreci/agent/pkg/api"
+	executors "github.com/semaphoreci/agent/pkg/executors"
+	"github.com/semaphoreci/agent/pkg/kubernetes"
+	slices "github.com/semaphoreci/agent/pkg/slices"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var validExecutors = []string{
+	executors.ExecutorTypeShell,
+	executors.ExecutorTypeDockerCompose,
+	executors.ExecutorKubernetes,
+}
+
+var validCredentialTypes = []string{
+	api.ImagePullCredentialsStrategyDockerHub,
+	api.ImagePullCredentialsStrategyGenericDocker,
+	api.ImagePullCredentialsStrategyECR,
+	api.ImagePullCredentialsStrategyGCR,
+}
+
+type JobRequestOptions struct {
+	// Used to detect the format; JSON files are detected by their content as well.
+	FileName string
+
+	// Nil means all images are allowed.
+	ImageValidator *kubernetes.ImageValidator
+}
+
+// Checks a job request file, in YAML or JSON, and returns all the problems found in it.
+func ValidateJobRequest(content []byte, options JobRequestOptions) []Problem {
+	v := newValidator(detectFormat(options.FileName, content))
+
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return []Problem{parseProblem(err)}
+	}
+
+	v.walk(&root, reflect.TypeOf(api.JobRequest{}), "")
+
+	request := api.JobRequest{}
+	var err error
+	if v.format == FormatJSON {
+		err = json.Unmarshal(content, &request)
+	} else {
+		err = yaml.Unmarshal(content, &request)
+	}
+
+	// The type problems were already reported when walking the tree,
+	// and both decoders still decode all the other fields when they find one.
+	if err != nil && !isTypeError(err) {
+		v.problems = append(v.problems, parseProblem(err))
+		return v.sortedProblems()
+	}
+
+	v.validateJobRequest(&request, options)
+	return v.sortedProblems()
+}
+
+func isTypeError(err error) bool {
+	var jsonError *json.UnmarshalTypeError
+	var yamlError *yaml.TypeError
+	return errors.As(err, &jsonError) || errors.As(err, &yamlError)
+}
+
+func (v *validator) validateJobRequest(request *api.JobRequest, options JobRequestOptions) {
+	t := reflect.TypeOf(*request)
+
+	if request.Executor != "" && !slices.Contains(validExecutors, request.Executor) {
+		v.addProblem(v.fieldPath("", t, "Executor"), "unknown executor '%s' - use one of %s", request.Executor, strings.Join(validExecutors, ", "))
+	}
+
+	v.validateEnvVars(request.EnvVars, v.fieldPath("", t, "EnvVars"))
+	v.validateFiles(request.Files, v.fieldPath("", t, "Files"), true)
+	v.validateCompose(request, v.fieldPath("", t, "Compose"), options)
+}
+
+func (v *validator) validateCompose(request *api.JobRequest, path string, options JobRequestOptions) {
+	t := reflect.TypeOf(request.Compose)
+	containersPath := v.fieldPath(path, t, "Containers")
+
+	if request.Executor == executors.ExecutorTypeDockerCompose && len(request.Compose.Containers) == 0 {
+		if _, ok := v.nodes[containersPath]; ok {
+			v.addProblem(containersPath, "the %s executor needs at least one container", request.Executor)
+		} else {
+			v.addProblem(v.fieldPath("", reflect.TypeOf(*request), "Executor"), "the %s executor needs at least one container", request.Executor)
+		}
+	}
+
+	for i, container := range request.Compose.Containers {
+		containerPath := indexPath(containersPath, i)
+		containerType := reflect.TypeOf(container)
+		v.validateEnvVars(container.EnvVars, v.fieldPath(containerPath, containerType, "EnvVars"))
+
+		// Jobs for the Kubernetes executor come with the dockercompose executor too,
+		// since the agent decides where they run, so the images are always checked.
+		if options.ImageValidator != nil {
+			if err := options.ImageValidator.Validate([]api.Container{container}); err != nil {
+				v.addProblem(v.fieldPath(containerPath, containerType, "Image"), "%v", err)
+			}
+		}
+	}
+
+	credentialsPath := v.fieldPath(path, t, "ImagePullCredentials")
+	for i, credentials := range request.Compose.ImagePullCredentials {
+		v.validateImagePullCredentials(credentials, indexPath(credentialsPath, i))
+	}
+}
+
+func (v *validator) validateImagePullCredentials(credentials api.ImagePullCredentials, path string) {
+	t := reflect.TypeOf(credentials)
+	envVarsPath := v.fieldPath(path, t, "EnvVars")
+	v.validateEnvVars(credentials.EnvVars, envVarsPath)
+
+	// The credential files are only read by the agent, so their mode does not matter.
+	v.validateFiles(credentials.Files, v.fieldPath(path, t, "Files"), false)
+
+	for i, envVar := range credentials.EnvVars {
+		if envVar.Name != "DOCKER_CREDENTIAL_TYPE" {
+			continue
+		}
+
+		value, err := envVar.Decode()
+		if err != nil {
+			// already reported
+			return
+		}
+
+		if !slices.Contains(validCredentialTypes, string(value)) {
+			v.addProblem(
+				v.fieldPath(indexPath(envVarsPath, i), reflect.TypeOf(envVar), "Value"),
+				"unknown DOCKER_CREDENTIAL_TYPE '%s' - use one of %s", string(value), strings.Join(validCredentialTypes, ", "),
+			)
+		}
+
+		return
+	}
+
+	v.addProblem(path, "DOCKER_CREDENTIAL_TYPE not set")
+}
+
+func (v *validator) validateEnvVars(envVars []api.EnvVar, path string) {
+	for i, envVar := range envVars {
+		envVarPath := indexPath(path, i)
+		t := reflect.TypeOf(envVar)
+
+		if envVar.Name == "" {
+			v.addProblem(envVarPath, "environment variable without a name")
+		}
+
+		if _, err := envVar.Decode(); err != nil {
+			v.addProblem(v.fieldPath(envVarPath, t, "Value"), "value is not valid base64: %v", err)
+		}
+	}
+}
+
+func (v *validator) validateFiles(files []api.File, path string, checkMode bool) {
+	for i, file := range files {
+		filePath := indexPath(path, i)
+		t := reflect.TypeOf(file)
+
+		if file.Path == "" {
+			v.addProblem(filePath, "file without a path")
+		}
+
+		if _, err := file.Decode(); err != nil {
+			v.addProblem(v.fieldPath(filePath, t, "Content"), "content is not valid base64: %v", err)
+		}
+
+		if _, err := file.ParseMode(); checkMode && err != nil {
+			v.addProblem(v.fieldPath(filePath, t, "Mode"), "%v", err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// A problem found while validating a file, and where in the file it is.
+// Line and Column are zero when the location is not known.
+type Problem struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	location := ""
+	if p.Line > 0 {
+		location = fmt.Sprintf("%d:%d: ", p.Line, p.Column)
+	}
+
+	if p.Path == "" {
+		return location + p.Message
+	}
+
+	return fmt.Sprintf("%s%s: %s", location, p.Path, p.Message)
+}
+
+/*
+ * JSON is also valid YAML, so we parse both formats into a yaml.Node tree,
+ * which keeps the location of every key and value. That way, we can report
+ * where each problem is, and also spot the fields Go's decoders silently ignore.
+ */
+type validator struct {
+	format   string
+	problems []Problem
+	nodes    map[string]*yaml.Node
+}
+
+func newValidator(format string) *validator {
+	return &validator{
+		format: format,
+		nodes:  map[string]*yaml.Node{},
+	}
+}
+
+func (v *validator) addProblem(path string, message string, args ...interface{}) {
+	problem := Problem{Path: path, Message: fmt.Sprintf(message, args...)}
+	if node, ok := v.nodes[path]; ok {
+		problem.Line = node.Line
+		problem.Column = node.Column
+	}
+
+	v.problems = append(v.problems, problem)
+}
+
+func (v *validator) addProblemAt(node *yaml.Node, path string, message string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Path:    path,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(message, args...),
+	})
+}
+
+func (v *validator) sortedProblems() []Problem {
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Line != v.problems[j].Line {
+			return v.problems[i].Line < v.problems[j].Line
+		}
+
+		return v.problems[i].Column < v.problems[j].Column
+	})
+
+	return v.problems
+}
+
+// The name a struct field has in the file, according to its format.
+func (v *validator) fieldName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get(v.format), ",")[0]
+}
+
+// The path to a struct field, using the name it has in the file.
+func (v *validator) fieldPath(parent string, t reflect.Type, goName string) string {
+	field, ok := t.FieldByName(goName)
+	if !ok {
+		panic(fmt.Sprintf("no field %s in %s", goName, t.Name()))
+	}
+
+	return joinPath(parent, v.fieldName(field))
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
+
+func indexPath(parent string, index int) string {
+	return fmt.Sprintf("%s[%d]", parent, index)
+}
+
+// Walks the node tree, checking it against the Go type it will be decoded into.
+func (v *validator) walk(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) > 0 {
+			v.walk(node.Content[0], t, path)
+		}
+
+		return
+	}
+
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	v.nodes[path] = node
+
+	// null is fine for everything
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		v.walkStruct(node, t, path)
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.addProblemAt(node, path, "expected a list")
+			return
+		}
+
+		for i, item := range node.Content {
+			v.walk(item, t.Elem(), indexPath(path, i))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.addProblemAt(node, path, "expected a map")
+		}
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			v.addProblemAt(node, path, "expected a boolean")
+		}
+	case reflect.Int, reflect.Int64:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			v.addProblemAt(node, path, "expected an integer")
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			v.addProblemAt(node, path, "expected a string")
+		}
+	}
+}
+
+func (v *validator) walkStruct(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind != yaml.MappingNode {
+		v.addProblemAt(node, path, "expected a map")
+		return
+	}
+
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		fields[v.fieldName(t.Field(i))] = t.Field(i)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field, ok := fields[key.Value]
+		if !ok {
+			v.addProblemAt(key, joinPath(path, key.Value), "unknown field%s", v.suggestion(key.Value, t))
+			continue
+		}
+
+		v.walk(value, field.Type, joinPath(path, key.Value))
+	}
+}
+
+// Finds the field the user most likely meant to use.
+func (v *validator) suggestion(name string, t reflect.Type) string {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldName := v.fieldName(field)
+
+		// e.g. using the JSON name in a YAML file
+		otherNames := []string{
+			strings.Split(field.Tag.Get(FormatJSON), ",")[0],
+			strings.Split(field.Tag.Get(FormatYAML), ",")[0],
+			field.Name,
+		}
+
+		for _, other := range otherNames {
+			if strings.EqualFold(other, name) {
+				return fmt.Sprintf(" - did you mean '%s'?", fieldName)
+			}
+		}
+	}
+
+	// e.g. "alais" instead of "alias"
+	for i := 0; i < t.NumField(); i++ {
+		fieldName := v.fieldName(t.Field(i))
+		if editDistance(fieldName, name) <= maxSuggestionDistance {
+			return fmt.Sprintf(" - did you mean '%s'?", fieldName)
+		}
+	}
+
+	return ""
+}
+
+const maxSuggestionDistance = 2
+
+// Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func detectFormat(fileName string, content []byte) string {
+	if strings.HasSuffix(strings.ToLower(fileName), ".json") {
+		return FormatJSON
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(content)), "{") {
+		return FormatJSON
+	}
+
+	return FormatYAML
+}
+
+func parseProblem(err error) Problem {
+	return Problem{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+}
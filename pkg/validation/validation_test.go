@@ -0,0 +1,163 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/semaphoreci/agent/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func problemStrings(problems []Problem) []string {
+	result := []string{}
+	for _, problem := range problems {
+		result = append(result, problem.String())
+	}
+
+	return result
+}
+
+func Test__ValidJobRequest(t *testing.T) {
+	content := `
+executor: shell
+commands:
+  - directive: echo hello
+    alias: Say hello
+env_vars:
+  - name: A
+    value: aGVsbG8=
+file:
+  - path: /tmp/a
+    content: aGVsbG8=
+    mode: "0644"
+`
+
+	assert.Empty(t, ValidateJobRequest([]byte(content), JobRequestOptions{FileName: "job.yml"}))
+}
+
+func Test__JobRequestProblems(t *testing.T) {
+	content := `
+executor: docker
+commands:
+  - directive: echo hello
+    alais: Say hello
+files:
+  - path: /tmp/a
+env_vars:
+  - name: A
+    value: "not base64!"
+file:
+  - path: /tmp/a
+    content: aGVsbG8=
+    mode: "999"
+`
+
+	assert.Equal(t, []string{
+		"2:11: executor: unknown executor 'docker' - use one of shell, dockercompose, kubernetes",
+		"5:5: commands[0].alais: unknown field - did you mean 'alias'?",
+		"6:1: files: unknown field - did you mean 'file'?",
+		"10:12: env_vars[0].value: value is not valid base64: illegal base64 data at input byte 3",
+		"14:11: file[0].mode: bad file permission '999'",
+	}, problemStrings(ValidateJobRequest([]byte(content), JobRequestOptions{FileName: "job.yml"})))
+}
+
+func Test__JobRequestProblemsInJSON(t *testing.T) {
+	content := `{
+  "executor": "shell",
+  "file": [],
+  "files": [{"path": "/tmp/a", "content": "aGVsbG8=", "mode": "abc"}],
+  "commands": {"directive": "echo hello"}
+}`
+
+	assert.Equal(t, []string{
+		"3:3: file: unknown field - did you mean 'files'?",
+		"4:63: files[0].mode: bad file permission 'abc'",
+		"5:15: commands: expected a list",
+	}, problemStrings(ValidateJobRequest([]byte(content), JobRequestOptions{FileName: "job.json"})))
+}
+
+func Test__DockerComposeProblems(t *testing.T) {
+	content := `
+executor: dockercompose
+compose:
+  containers: []
+  image_pull_credentials:
+    - env_vars:
+        - name: DOCKER_CREDENTIAL_TYPE
+          value: Rm9v
+    - env_vars: []
+`
+
+	assert.Equal(t, []string{
+		"4:15: compose.containers: the dockercompose executor needs at least one container",
+		"8:18: compose.image_pull_credentials[0].env_vars[0].value: unknown DOCKER_CREDENTIAL_TYPE 'Foo' - use one of DockerHub, GenericDocker, AWS_ECR, GCR",
+		"9:7: compose.image_pull_credentials[1]: DOCKER_CREDENTIAL_TYPE not set",
+	}, problemStrings(ValidateJobRequest([]byte(content), JobRequestOptions{FileName: "job.yml"})))
+}
+
+func Test__KubernetesAllowedImages(t *testing.T) {
+	imageValidator, err := kubernetes.NewImageValidator([]string{"^ubuntu:.*"})
+	require.NoError(t, err)
+
+	for _, executor := range []string{"kubernetes", "dockercompose"} {
+		content := `
+executor: ` + executor + `
+compose:
+  containers:
+    - name: main
+      image: ubuntu:22.04
+    - name: db
+      image: postgres:14
+`
+
+		assert.Equal(t, []string{
+			"8:14: compose.containers[1].image: image 'postgres:14' is not allowed",
+		}, problemStrings(ValidateJobRequest([]byte(content), JobRequestOptions{FileName: "job.yml", ImageValidator: imageValidator})), executor)
+	}
+}
+
+func Test__JobRequestWithBadSyntax(t *testing.T) {
+	problems := ValidateJobRequest([]byte("executor: shell\ncommands: [\n"), JobRequestOptions{FileName: "job.yml"})
+	require.Len(t, problems, 1)
+	assert.Equal(t, "line 2: did not find expected node content", problems[0].Message)
+}
+
+func Test__ConfigProblems(t *testing.T) {
+	content := `
+endpoint: semaphore.example.com
+upload_job_logs: always
+upload-job-logs: sometimes
+job-id: abc
+kubernetes-allowed-images:
+  - "^ubuntu"
+  - "(unclosed"
+`
+
+	assert.Equal(t, []string{
+		"3:1: upload_job_logs: unknown option - did you mean 'upload-job-logs'?",
+		"4:18: upload-job-logs: unsupported value 'sometimes' - use one of never, always, when-trimmed",
+		"5:9: job-id: can only be used if disconnect-after-job is also used",
+		"8:5: kubernetes-allowed-images[1]: bad regular expression: error parsing regexp: missing closing ): `(unclosed`",
+	}, problemStrings(ValidateConfig([]byte(content))))
+
+	assert.Empty(t, ValidateConfig([]byte("endpoint: semaphore.example.com\ntoken: abc\n")))
+	assert.Empty(t, ValidateConfig([]byte("")))
+}
+
+func Test__LogSinkProblems(t *testing.T) {
+	content := `
+log-sinks:
+  - file:///var/log/jobs
+  - file://relative/path
+  - ftp://logs.example.com
+  - s3+https://s3.example.com
+`
+
+	assert.Equal(t, []string{
+		"4:5: log-sinks[1]: invalid log sink 'file://relative/path': file sinks need an absolute path",
+		"5:5: log-sinks[2]: invalid log sink 'ftp://logs.example.com': scheme must be file, http, https, s3+http or s3+https",
+		"6:5: log-sinks[3]: invalid log sink 's3+https://s3.example.com': invalid URL 'https://s3.example.com': no bucket",
+	}, problemStrings(ValidateConfig([]byte(content))))
+
+	assert.Equal(t, []string{"2:12: log-sinks: expected a list"}, problemStrings(ValidateConfig([]byte("\nlog-sinks: file:///var/log/jobs\n"))))
+}
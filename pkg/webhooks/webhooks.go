@@ -0,0 +1,235 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/httputils"
+	"github.com/semaphoreci/agent/pkg/retry"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EventJobStarted      = "job_started"
+	EventCommandFinished = "command_finished"
+	EventJobFinished     = "job_finished"
+)
+
+const SignatureHeader = "X-Semaphore-Signature"
+const EventHeader = "X-Semaphore-Event"
+
+// Deliveries are queued, so a slow or unavailable endpoint never holds up the job.
+// If the queue is full, new deliveries are dropped.
+const DefaultQueueSize = 1000
+const DefaultMaxAttempts = 5
+const DefaultInitialDelay = time.Second
+const DefaultMaxDelay = 30 * time.Second
+const DefaultRequestTimeout = 10 * time.Second
+
+type Config struct {
+	URLs                 []string
+	Secret               string
+	IncludeCommandEvents bool
+	AgentName            string
+	UserAgent            string
+	Client               *http.Client
+	MaxAttempts          int
+	InitialDelay         time.Duration
+	MaxDelay             time.Duration
+}
+
+type Payload struct {
+	Event     string         `json:"event"`
+	Timestamp int64          `json:"timestamp"`
+	JobID     string         `json:"job_id"`
+	AgentName string         `json:"agent_name,omitempty"`
+	Command   *CommandResult `json:"command,omitempty"`
+	Result    string         `json:"result,omitempty"`
+	Timings   *JobTimings    `json:"timings,omitempty"`
+}
+
+type CommandResult struct {
+	Directive  string `json:"directive"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type JobTimings struct {
+	StartedAt  int64         `json:"started_at"`
+	FinishedAt int64         `json:"finished_at"`
+	DurationMs int64         `json:"duration_ms"`
+	Phases     []PhaseTiming `json:"phases"`
+}
+
+type PhaseTiming struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type delivery struct {
+	url     string
+	event   string
+	payload []byte
+}
+
+type Notifier struct {
+	config  Config
+	queue   chan delivery
+	pending sync.WaitGroup
+}
+
+func NewNotifier(config Config) *Notifier {
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: DefaultRequestTimeout}
+	}
+
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+
+	if config.InitialDelay == 0 {
+		config.InitialDelay = DefaultInitialDelay
+	}
+
+	if config.MaxDelay == 0 {
+		config.MaxDelay = DefaultMaxDelay
+	}
+
+	n := &Notifier{
+		config: config,
+		queue:  make(chan delivery, DefaultQueueSize),
+	}
+
+	go n.deliver()
+
+	return n
+}
+
+func (n *Notifier) JobStarted(jobID string) {
+	n.send(Payload{Event: EventJobStarted, JobID: jobID})
+}
+
+func (n *Notifier) CommandFinished(jobID string, command CommandResult) {
+	if n == nil || !n.config.IncludeCommandEvents {
+		return
+	}
+
+	n.send(Payload{Event: EventCommandFinished, JobID: jobID, Command: &command})
+}
+
+func (n *Notifier) JobFinished(jobID, result string, timings JobTimings) {
+	n.send(Payload{Event: EventJobFinished, JobID: jobID, Result: result, Timings: &timings})
+}
+
+/*
+ * Waits until all the queued deliveries are done, or until the timeout is reached.
+ * Used before the agent shuts down, so the last job_finished webhook is not lost.
+ */
+func (n *Notifier) Flush(timeout time.Duration) bool {
+	if n == nil {
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Warnf("Timed out waiting for webhook deliveries after %v", timeout)
+		return false
+	}
+}
+
+// Returns the signature for the payload, sent in the X-Semaphore-Signature header.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) send(payload Payload) {
+	if n == nil {
+		return
+	}
+
+	payload.Timestamp = time.Now().Unix()
+	payload.AgentName = n.config.AgentName
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("Error marshaling %s webhook payload: %v", payload.Event, err)
+		return
+	}
+
+	for _, url := range n.config.URLs {
+		n.pending.Add(1)
+		select {
+		case n.queue <- delivery{url: url, event: payload.Event, payload: body}:
+		default:
+			n.pending.Done()
+			log.Errorf("Webhook queue is full - dropping %s webhook for %s", payload.Event, url)
+		}
+	}
+}
+
+// Deliveries are made one at a time, so the endpoints receive the events in order.
+func (n *Notifier) deliver() {
+	for d := range n.queue {
+		err := retry.RetryWithExponentialBackoff(retry.RetryOptions{
+			Task:                 fmt.Sprintf("Deliver %s webhook to %s", d.event, d.url),
+			MaxAttempts:          n.config.MaxAttempts,
+			DelayBetweenAttempts: n.config.InitialDelay,
+			MaxDelay:             n.config.MaxDelay,
+			Fn: func() error {
+				return n.post(d)
+			},
+		})
+
+		if err != nil {
+			log.Errorf("Error delivering webhook: %v", err)
+		}
+
+		n.pending.Done()
+	}
+}
+
+func (n *Notifier) post(d delivery) error {
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.event)
+	if n.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.config.Secret, d.payload))
+	}
+
+	if n.config.UserAgent != "" {
+		req.Header.Set("User-Agent", n.config.UserAgent)
+	}
+
+	res, err := n.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if !httputils.IsSuccessfulCode(res.StatusCode) {
+		return fmt.Errorf("request to %s failed with status %d", d.url, res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedWebhook struct {
+	Event     string
+	Signature string
+	Body      []byte
+}
+
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	received []receivedWebhook
+	failures int
+}
+
+func newWebhookServer(failures int) *webhookServer {
+	s := &webhookServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		s.received = append(s.received, receivedWebhook{
+			Event:     r.Header.Get(EventHeader),
+			Signature: r.Header.Get(SignatureHeader),
+			Body:      body,
+		})
+	}))
+
+	return s
+}
+
+func (s *webhookServer) Received() []receivedWebhook {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]receivedWebhook{}, s.received...)
+}
+
+func Test__Notifier(t *testing.T) {
+	t.Run("delivers signed payloads in order", func(t *testing.T) {
+		server := newWebhookServer(0)
+		defer server.Close()
+
+		notifier := NewNotifier(Config{
+			URLs:                 []string{server.URL},
+			Secret:               "my-secret",
+			IncludeCommandEvents: true,
+			AgentName:            "agent-1",
+		})
+
+		notifier.JobStarted("job-1")
+		notifier.CommandFinished("job-1", CommandResult{Directive: "make test", ExitCode: 0, DurationMs: 1500})
+		notifier.JobFinished("job-1", "passed", JobTimings{StartedAt: 1, FinishedAt: 3, DurationMs: 2000})
+		require.True(t, notifier.Flush(5*time.Second))
+
+		received := server.Received()
+		if !assert.Len(t, received, 3) {
+			return
+		}
+
+		assert.Equal(t, EventJobStarted, received[0].Event)
+		assert.Equal(t, EventCommandFinished, received[1].Event)
+		assert.Equal(t, EventJobFinished, received[2].Event)
+
+		for _, webhook := range received {
+			assert.Equal(t, Sign("my-secret", webhook.Body), webhook.Signature)
+		}
+
+		payload := Payload{}
+		require.NoError(t, json.Unmarshal(received[2].Body, &payload))
+		assert.Equal(t, "job-1", payload.JobID)
+		assert.Equal(t, "agent-1", payload.AgentName)
+		assert.Equal(t, "passed", payload.Result)
+		assert.Equal(t, int64(2000), payload.Timings.DurationMs)
+	})
+
+	t.Run("command events are not sent if not enabled", func(t *testing.T) {
+		server := newWebhookServer(0)
+		defer server.Close()
+
+		notifier := NewNotifier(Config{URLs: []string{server.URL}, Secret: "my-secret"})
+		notifier.JobStarted("job-1")
+		notifier.CommandFinished("job-1", CommandResult{Directive: "make test"})
+		notifier.JobFinished("job-1", "failed", JobTimings{})
+		require.True(t, notifier.Flush(5*time.Second))
+
+		received := server.Received()
+		if assert.Len(t, received, 2) {
+			assert.Equal(t, EventJobStarted, received[0].Event)
+			assert.Equal(t, EventJobFinished, received[1].Event)
+		}
+	})
+
+	t.Run("failed deliveries are retried", func(t *testing.T) {
+		server := newWebhookServer(2)
+		defer server.Close()
+
+		notifier := NewNotifier(Config{
+			URLs:         []string{server.URL},
+			Secret:       "my-secret",
+			InitialDelay: 10 * time.Millisecond,
+		})
+
+		notifier.JobStarted("job-1")
+		require.True(t, notifier.Flush(5*time.Second))
+		assert.Len(t, server.Received(), 1)
+	})
+
+	t.Run("nil notifier does nothing", func(t *testing.T) {
+		var notifier *Notifier
+		notifier.JobStarted("job-1")
+		notifier.CommandFinished("job-1", CommandResult{})
+		notifier.JobFinished("job-1", "passed", JobTimings{})
+		assert.True(t, notifier.Flush(time.Second))
+	})
+}
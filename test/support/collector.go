@@ -0,0 +1,153 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/semaphoreci/agent/pkg/retry"
+	"github.com/semaphoreci/agent/pkg/tracing"
+)
+
+// Stands in for an OpenTelemetry collector receiving OTLP/HTTP with JSON encoding.
+type CollectorMockServer struct {
+	Server *httptest.Server
+
+	mutex         sync.Mutex
+	spans         []tracing.SpanData
+	resources     []tracing.Resource
+	failures      int
+	requestsCount int
+}
+
+func NewCollectorMockServer() *CollectorMockServer {
+	return &CollectorMockServer{}
+}
+
+func (m *CollectorMockServer) Init() {
+	m.Server = httptest.NewServer(http.HandlerFunc(m.handler))
+}
+
+func (m *CollectorMockServer) URL() string {
+	return m.Server.URL
+}
+
+func (m *CollectorMockServer) Close() {
+	m.Server.Close()
+}
+
+// The next requests are rejected with a 503.
+func (m *CollectorMockServer) FailNextRequests(count int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failures = count
+}
+
+func (m *CollectorMockServer) RequestsCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.requestsCount
+}
+
+func (m *CollectorMockServer) Spans() []tracing.SpanData {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]tracing.SpanData{}, m.spans...)
+}
+
+func (m *CollectorMockServer) Resources() []tracing.Resource {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]tracing.Resource{}, m.resources...)
+}
+
+func (m *CollectorMockServer) FindSpan(name string) *tracing.SpanData {
+	for _, span := range m.Spans() {
+		if span.Name == name {
+			return &span
+		}
+	}
+
+	return nil
+}
+
+func (m *CollectorMockServer) FindSpans(name string) []tracing.SpanData {
+	spans := []tracing.SpanData{}
+	for _, span := range m.Spans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+func (m *CollectorMockServer) WaitForSpan(name string) (*tracing.SpanData, error) {
+	var span *tracing.SpanData
+	err := retry.RetryWithConstantWait(retry.RetryOptions{
+		Task:                 fmt.Sprintf("wait for span %s", name),
+		MaxAttempts:          50,
+		DelayBetweenAttempts: 100 * time.Millisecond,
+		HideError:            true,
+		Fn: func() error {
+			span = m.FindSpan(name)
+			if span == nil {
+				return fmt.Errorf("span %s not found", name)
+			}
+
+			return nil
+		},
+	})
+
+	return span, err
+}
+
+func (m *CollectorMockServer) handler(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requestsCount++
+
+	if r.Method != http.MethodPost || r.URL.Path != tracing.TracesPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if m.failures > 0 {
+		m.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	request := tracing.ExportRequest{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, resourceSpans := range request.ResourceSpans {
+		m.resources = append(m.resources, resourceSpans.Resource)
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			m.spans = append(m.spans, scopeSpans.Spans...)
+		}
+	}
+
+	fmt.Printf("[COLLECTOR MOCK] Received %d spans\n", len(m.spans))
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
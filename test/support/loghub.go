@@ -1,7 +1,9 @@
 package testsupport
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -17,6 +19,15 @@ type LoghubMockServer struct {
 	Server            *httptest.Server
 	Handler           http.Handler
 	ExpectedUserAgent string
+
+	// Requests with a compressed body are rejected with a 415 if this is set.
+	RejectCompression  bool
+	CompressedRequests int
+	BatchBytesUsed     []int
+
+	// Batches which would take the logs over MaxSizeForLogs are rejected too, with a 422,
+	// instead of only the ones sent after it is reached.
+	RejectBatchesOverMaxSize bool
 }
 
 func NewLoghubMockServer() *LoghubMockServer {
@@ -59,7 +70,24 @@ func (m *LoghubMockServer) handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := ioutil.ReadAll(r.Body)
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if m.RejectCompression {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m.CompressedRequests++
+		reader = gzipReader
+	}
+
+	body, err := ioutil.ReadAll(reader)
 	if err != nil {
 		fmt.Printf("[LOGHUB MOCK] Error reading body: %v\n", err)
 	}
@@ -67,7 +95,13 @@ func (m *LoghubMockServer) handler(w http.ResponseWriter, r *http.Request) {
 	logs := FilterEmpty(strings.Split(string(body), "\n"))
 	fmt.Printf("[LOGHUB MOCK] Received %d log events\n", len(logs))
 
+	if m.RejectBatchesOverMaxSize && m.MaxSizeForLogs > 0 && len(m.Logs)+len(logs) > m.MaxSizeForLogs {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
 	m.BatchSizesUsed = append(m.BatchSizesUsed, len(logs))
+	m.BatchBytesUsed = append(m.BatchBytesUsed, len(body))
 	m.Logs = append(m.Logs, logs...)
 
 	w.WriteHeader(200)
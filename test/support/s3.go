@@ -0,0 +1,274 @@
+package testsupport
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const S3MockAccessKeyID = "s3-mock-access-key"
+
+/*
+ * A minimal S3-compatible server, like MinIO,
+ * which keeps the objects and multipart uploads in memory.
+ * Buckets are addressed path-style: /<bucket>/<key>.
+ */
+type S3MockServer struct {
+	Server *httptest.Server
+
+	// All parts but the last one must be at least this big. S3 uses 5MiB.
+	MinPartSize int
+
+	mutex            sync.Mutex
+	unavailable      bool
+	objects          map[string][]byte
+	uploads          map[string]map[int][]byte
+	uploadKeys       map[string]string
+	nextUploadID     int
+	PartsUploaded    int
+	UploadsAborted   int
+	UploadsCompleted int
+}
+
+func NewS3MockServer() *S3MockServer {
+	return &S3MockServer{
+		objects:    map[string][]byte{},
+		uploads:    map[string]map[int][]byte{},
+		uploadKeys: map[string]string{},
+	}
+}
+
+func (m *S3MockServer) Init() {
+	m.Server = httptest.NewServer(http.HandlerFunc(m.handler))
+}
+
+func (m *S3MockServer) URL() string {
+	return m.Server.URL
+}
+
+func (m *S3MockServer) Close() {
+	m.Server.Close()
+}
+
+// All requests fail with a 503 while the server is unavailable.
+func (m *S3MockServer) SetUnavailable(unavailable bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.unavailable = unavailable
+}
+
+// The path is <bucket>/<key>.
+func (m *S3MockServer) GetObject(path string) ([]byte, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	object, ok := m.objects[path]
+	return object, ok
+}
+
+func (m *S3MockServer) ObjectPaths() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	paths := []string{}
+	for path := range m.objects {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+func (m *S3MockServer) PendingUploads() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.uploads)
+}
+
+func (m *S3MockServer) handler(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.unavailable {
+		m.writeError(w, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+S3MockAccessKeyID+"/") {
+		m.writeError(w, http.StatusForbidden, "InvalidAccessKeyId", "The access key ID does not exist.")
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+		m.writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The content hash does not match.")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		m.createMultipartUpload(w, path)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		m.uploadPart(w, query.Get("uploadId"), query.Get("partNumber"), body)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		m.completeMultipartUpload(w, path, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		m.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		m.objects[path] = body
+		w.Header().Set("ETag", etag(body))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet:
+		object, ok := m.objects[path]
+		if !ok {
+			m.writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(object)
+	default:
+		m.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed.")
+	}
+}
+
+func (m *S3MockServer) createMultipartUpload(w http.ResponseWriter, path string) {
+	m.nextUploadID++
+	uploadID := fmt.Sprintf("upload-%d", m.nextUploadID)
+	m.uploads[uploadID] = map[int][]byte{}
+	m.uploadKeys[uploadID] = path
+
+	fmt.Printf("[S3 MOCK] Created upload %s for %s\n", uploadID, path)
+	m.writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		UploadID string   `xml:"UploadId"`
+	}{UploadID: uploadID})
+}
+
+func (m *S3MockServer) uploadPart(w http.ResponseWriter, uploadID, partNumber string, body []byte) {
+	parts, ok := m.uploads[uploadID]
+	if !ok {
+		m.writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 || number > 10000 {
+		m.writeError(w, http.StatusBadRequest, "InvalidArgument", "Part number must be between 1 and 10000.")
+		return
+	}
+
+	parts[number] = body
+	m.PartsUploaded++
+
+	fmt.Printf("[S3 MOCK] Received part %d for upload %s with %d bytes\n", number, uploadID, len(body))
+	w.Header().Set("ETag", etag(body))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *S3MockServer) completeMultipartUpload(w http.ResponseWriter, path, uploadID string, body []byte) {
+	parts, ok := m.uploads[uploadID]
+	if !ok || m.uploadKeys[uploadID] != path {
+		m.writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+
+	request := struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}{}
+
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		m.writeError(w, http.StatusBadRequest, "MalformedXML", "The XML is not well-formed.")
+		return
+	}
+
+	object := []byte{}
+	for i, part := range request.Parts {
+		content, ok := parts[part.PartNumber]
+		if !ok || etag(content) != part.ETag {
+			m.writeError(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+			return
+		}
+
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			m.writeError(w, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+			return
+		}
+
+		if i < len(request.Parts)-1 && len(content) < m.MinPartSize {
+			m.writeError(w, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed size.")
+			return
+		}
+
+		object = append(object, content...)
+	}
+
+	m.objects[path] = object
+	delete(m.uploads, uploadID)
+	delete(m.uploadKeys, uploadID)
+	m.UploadsCompleted++
+
+	fmt.Printf("[S3 MOCK] Completed upload %s for %s with %d bytes\n", uploadID, path, len(object))
+
+	m.writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string   `xml:"Key"`
+	}{Key: path})
+}
+
+func (m *S3MockServer) abortMultipartUpload(w http.ResponseWriter, uploadID string) {
+	delete(m.uploads, uploadID)
+	delete(m.uploadKeys, uploadID)
+	m.UploadsAborted++
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *S3MockServer) writeXML(w http.ResponseWriter, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func (m *S3MockServer) writeError(w http.ResponseWriter, status int, code, message string) {
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// #nosec
+func etag(content []byte) string {
+	hash := md5.Sum(content)
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(hash[:]))
+}